@@ -10,6 +10,8 @@ import (
 type Account struct {
 	client    *Client
 	accountId int64
+	// pool 非空时请求发往账户在 ClientPool 中当前所在的连接
+	pool *ClientPool
 }
 
 func NewAccount(client *Client, accountId int64) *Account {
@@ -19,13 +21,34 @@ func NewAccount(client *Client, accountId int64) *Account {
 	}
 }
 
+// conn 返回发送请求的连接
+func (a *Account) conn() *Client {
+	if a.pool != nil {
+		return a.pool.home(a.accountId)
+	}
+	return a.client
+}
+
+// watchEvent 注册可注销的事件回调；账户来自 ClientPool 时在池的所有连接上注册，故障转移后依然有效
+func (a *Account) watchEvent(payloadType uint32, handler ResponseHandler) (stop func()) {
+	if a.pool != nil {
+		return a.pool.watchEvent(payloadType, handler)
+	}
+	return a.client.WatchEvent(payloadType, handler)
+}
+
 // 示例：账户登录
 func (a *Account) Auth(ctx context.Context) (*openapi.ProtoOAAccountAuthRes, error) {
+	client := a.conn()
+	accessToken, err := client.currentAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
 	req := &openapi.ProtoOAAccountAuthReq{
 		CtidTraderAccountId: proto.Int64(a.accountId),
-		AccessToken:         proto.String(a.client.accessToken),
+		AccessToken:         proto.String(accessToken),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ), req)
+	respMsg, err := client.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -33,9 +56,41 @@ func (a *Account) Auth(ctx context.Context) (*openapi.ProtoOAAccountAuthRes, err
 	if err := proto.Unmarshal(respMsg.Payload, res); err != nil {
 		return nil, err
 	}
+	if respMsg.GetPayloadType() == uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES) {
+		client.setAccountAuthorized(a.accountId, true)
+		if a.pool != nil {
+			a.pool.recordAuth(client, a.accountId)
+		}
+	}
+	return res, nil
+}
+
+// Logout 注销账户会话，服务端随后会推送 ProtoOAAccountDisconnectEvent
+func (a *Account) Logout(ctx context.Context) (*openapi.ProtoOAAccountLogoutRes, error) {
+	req := &openapi.ProtoOAAccountLogoutReq{
+		CtidTraderAccountId: proto.Int64(a.accountId),
+	}
+	client := a.conn()
+	respMsg, err := client.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_LOGOUT_REQ), req)
+	if err != nil {
+		return nil, err
+	}
+	res := &openapi.ProtoOAAccountLogoutRes{}
+	if err := proto.Unmarshal(respMsg.Payload, res); err != nil {
+		return nil, err
+	}
+	client.setAccountAuthorized(a.accountId, false)
+	if a.pool != nil {
+		a.pool.recordLogout(client, a.accountId)
+	}
 	return res, nil
 }
 
+// AccountId 返回账户ID
+func (a *Account) AccountId() int64 {
+	return a.accountId
+}
+
 // 便于聚合各类账户操作
 func (a *Account) Order() *AccountOrder {
 	return &AccountOrder{Account: a}
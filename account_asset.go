@@ -16,7 +16,7 @@ func (a *AccountAsset) AssetList(ctx context.Context) (*openapi.ProtoOAAssetClas
 	req := &openapi.ProtoOAAssetClassListReq{
 		CtidTraderAccountId: proto.Int64(a.accountId),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_CLASS_LIST_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_CLASS_LIST_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -26,3 +26,19 @@ func (a *AccountAsset) AssetList(ctx context.Context) (*openapi.ProtoOAAssetClas
 	}
 	return res, nil
 }
+
+// Assets 获取账户可用的资产（货币）列表
+func (a *AccountAsset) Assets(ctx context.Context) (*openapi.ProtoOAAssetListRes, error) {
+	req := &openapi.ProtoOAAssetListReq{
+		CtidTraderAccountId: proto.Int64(a.accountId),
+	}
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_LIST_REQ), req)
+	if err != nil {
+		return nil, err
+	}
+	res := &openapi.ProtoOAAssetListRes{}
+	if err := proto.Unmarshal(respMsg.Payload, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
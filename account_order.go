@@ -76,7 +76,11 @@ func (a *AccountOrder) NewOrder(ctx context.Context, symbolId int64, orderType o
 			req.StopTriggerMethod = &orderOption.stopTriggerMethod
 		}
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ), req)
+	client := a.conn()
+	if err := client.checkRisk(ctx, a.Account, req); err != nil {
+		return nil, err
+	}
+	respMsg, err := client.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -93,7 +97,7 @@ func (a *AccountOrder) CancelOrder(ctx context.Context, orderId int64) (*openapi
 		CtidTraderAccountId: proto.Int64(a.accountId),
 		OrderId:             proto.Int64(orderId),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_CANCEL_ORDER_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_CANCEL_ORDER_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +108,7 @@ func (a *AccountOrder) CancelOrder(ctx context.Context, orderId int64) (*openapi
 	return res, nil
 }
 
-// AmendOrder 修改订单
+// AmendOrder 修改订单，发送前以修改后的参数执行风控规则
 func (a *AccountOrder) AmendOrder(ctx context.Context, orderId int64, orderOption *AmendOrderOption) (*openapi.ProtoOAExecutionEvent, error) {
 	req := &openapi.ProtoOAAmendOrderReq{
 		CtidTraderAccountId: proto.Int64(a.accountId),
@@ -149,7 +153,11 @@ func (a *AccountOrder) AmendOrder(ctx context.Context, orderId int64, orderOptio
 		}
 	}
 
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_AMEND_ORDER_REQ), req)
+	client := a.conn()
+	if err := client.checkAmendRisk(ctx, a.Account, req); err != nil {
+		return nil, err
+	}
+	respMsg, err := client.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_AMEND_ORDER_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +195,7 @@ func (a *AccountOrder) AmendOrderPositionSltp(ctx context.Context, positionId in
 		}
 	}
 
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_AMEND_POSITION_SLTP_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_AMEND_POSITION_SLTP_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -211,7 +219,7 @@ func (a *AccountOrder) ClosePosition(ctx context.Context, positionId, volume int
 		PositionId:          proto.Int64(positionId),
 		Volume:              proto.Int64(volume),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_CLOSE_POSITION_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_CLOSE_POSITION_REQ), req)
 	if err != nil {
 		return nil, err
 	}
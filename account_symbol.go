@@ -16,7 +16,7 @@ func (a *AccountSymbol) AssetsList(ctx context.Context) (*openapi.ProtoOAAssetCl
 	req := &openapi.ProtoOAAssetClassListReq{
 		CtidTraderAccountId: proto.Int64(a.accountId),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_CLASS_LIST_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_CLASS_LIST_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +35,7 @@ func (a *AccountSymbol) SymbolList(ctx context.Context, includeArchivedSymbols b
 		CtidTraderAccountId:    proto.Int64(a.accountId),
 		IncludeArchivedSymbols: proto.Bool(includeArchivedSymbols),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_LIST_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_LIST_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +58,7 @@ func (a *AccountSymbol) SymbolById(ctx context.Context, symbolIds []int64) (*ope
 		CtidTraderAccountId: proto.Int64(a.accountId),
 		SymbolId:            symbolIds,
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +83,7 @@ func (a *AccountSymbol) SymbolsForConversion(ctx context.Context, firstAssetId,
 		FirstAssetId:        proto.Int64(firstAssetId),
 		LastAssetId:         proto.Int64(lastAssetId),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_FOR_CONVERSION_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_FOR_CONVERSION_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -93,3 +93,60 @@ func (a *AccountSymbol) SymbolsForConversion(ctx context.Context, firstAssetId,
 	}
 	return res, nil
 }
+
+// GetTrendbars 获取历史 K 线
+//
+// fromTimestamp、toTimestamp 为毫秒时间戳，count 大于 0 时限制从 toTimestamp 往前返回的数量
+// 单次请求的时间跨度受服务端限制，超出部分通过 HasMore 提示；Trendbars 会自动分段并使用本地缓存
+func (a *AccountSymbol) GetTrendbars(ctx context.Context, symbolId int64, period openapi.ProtoOATrendbarPeriod, fromTimestamp, toTimestamp int64, count uint32) (*openapi.ProtoOAGetTrendbarsRes, error) {
+	if symbolId == 0 {
+		return nil, ErrSymbolIdRequired
+	}
+	req := &openapi.ProtoOAGetTrendbarsReq{
+		CtidTraderAccountId: proto.Int64(a.accountId),
+		SymbolId:            proto.Int64(symbolId),
+		Period:              period.Enum(),
+		FromTimestamp:       proto.Int64(fromTimestamp),
+		ToTimestamp:         proto.Int64(toTimestamp),
+	}
+	if count > 0 {
+		req.Count = proto.Uint32(count)
+	}
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_GET_TRENDBARS_REQ), req)
+	if err != nil {
+		return nil, err
+	}
+	res := &openapi.ProtoOAGetTrendbarsRes{}
+	if err := proto.Unmarshal(respMsg.Payload, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetTickData 获取历史 tick
+//
+// fromTimestamp、toTimestamp 为毫秒时间戳，跨度不能超过 7 天；结果按由新到旧的差值编码，用 DecodeTicks 解码
+func (a *AccountSymbol) GetTickData(ctx context.Context, symbolId int64, quoteType openapi.ProtoOAQuoteType, fromTimestamp, toTimestamp int64) (*openapi.ProtoOAGetTickDataRes, error) {
+	if symbolId == 0 {
+		return nil, ErrSymbolIdRequired
+	}
+	if toTimestamp <= fromTimestamp || toTimestamp-fromTimestamp > 604800000 {
+		return nil, ErrTimestampRange
+	}
+	req := &openapi.ProtoOAGetTickDataReq{
+		CtidTraderAccountId: proto.Int64(a.accountId),
+		SymbolId:            proto.Int64(symbolId),
+		Type:                quoteType.Enum(),
+		FromTimestamp:       proto.Int64(fromTimestamp),
+		ToTimestamp:         proto.Int64(toTimestamp),
+	}
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_GET_TICKDATA_REQ), req)
+	if err != nil {
+		return nil, err
+	}
+	res := &openapi.ProtoOAGetTickDataRes{}
+	if err := proto.Unmarshal(respMsg.Payload, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
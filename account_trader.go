@@ -13,7 +13,7 @@ type AccountTrader struct {
 
 // Trader 获取账户信息
 func (a *AccountTrader) Trader(ctx context.Context) (*openapi.ProtoOATraderRes, error) {
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ), &openapi.ProtoOATraderReq{
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ), &openapi.ProtoOATraderReq{
 		CtidTraderAccountId: &a.accountId,
 	})
 	if err != nil {
@@ -31,9 +31,9 @@ func (a *AccountTrader) Trader(ctx context.Context) (*openapi.ProtoOATraderRes,
 // returnProtectionOrders 是否返回保护单
 func (a *AccountTrader) Reconcile(ctx context.Context, returnProtectionOrders bool) (*openapi.ProtoOAReconcileRes, error) {
 	// 设置账户ID
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_REQ), &openapi.ProtoOAReconcileReq{
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_REQ), &openapi.ProtoOAReconcileReq{
 		CtidTraderAccountId:    proto.Int64(a.accountId),
-		ReturnProtectionOrders: proto.Bool(true),
+		ReturnProtectionOrders: proto.Bool(returnProtectionOrders),
 	})
 	if err != nil {
 		return nil, err
@@ -60,7 +60,7 @@ func (a *AccountTrader) DealList(ctx context.Context, fromTimestamp, toTimestamp
 	if maxRows > 0 {
 		req.MaxRows = proto.Int32(maxRows)
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +83,7 @@ func (a *AccountTrader) OrderList(ctx context.Context, fromTimestamp, toTimestam
 		req.ToTimestamp = proto.Int64(toTimestamp)
 	}
 
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_LIST_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_LIST_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +107,7 @@ func (a *AccountTrader) ExpectedMargin(ctx context.Context, symbolId int64, volu
 		req.Volume = volumes
 	}
 
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXPECTED_MARGIN_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXPECTED_MARGIN_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +131,10 @@ func (a *AccountTrader) CashFlowHistoryList(ctx context.Context, fromTimestamp,
 	}
 	req := &openapi.ProtoOACashFlowHistoryListReq{
 		CtidTraderAccountId: proto.Int64(a.accountId),
+		FromTimestamp:       proto.Int64(fromTimestamp),
+		ToTimestamp:         proto.Int64(toTimestamp),
 	}
-	respMsg, err := a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_CASH_FLOW_HISTORY_LIST_REQ), req)
+	respMsg, err := a.conn().request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_CASH_FLOW_HISTORY_LIST_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,61 @@
+package ctrago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// traderTransport 记录收到的请求并以 response 应答
+func traderTransport(t *testing.T, requests chan<- *openapi.ProtoMessage, payloadType openapi.ProtoOAPayloadType, response proto.Message) *notifyingTransport {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			t.Fatal(err)
+		}
+		requests <- msg
+		go mock.push(payloadType, response, msg.GetClientMsgId())
+		return nil
+	}
+	return mock
+}
+
+func TestAccountTrader_ReconcileProtectionOrders(t *testing.T) {
+	requests := make(chan *openapi.ProtoMessage, 2)
+	client := NewClientWithTransport(traderTransport(t, requests, openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_RES, &openapi.ProtoOAReconcileRes{CtidTraderAccountId: proto.Int64(1)}), "id", "secret", "token")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, want := range []bool{false, true} {
+		if _, err := client.Account(1).Trader().Reconcile(ctx, want); err != nil {
+			t.Fatal(err)
+		}
+		req := &openapi.ProtoOAReconcileReq{}
+		proto.Unmarshal((<-requests).Payload, req)
+		if req.GetReturnProtectionOrders() != want {
+			t.Errorf("expected returnProtectionOrders %v, got %v", want, req.GetReturnProtectionOrders())
+		}
+	}
+}
+
+func TestAccountTrader_CashFlowHistoryListTimestamps(t *testing.T) {
+	requests := make(chan *openapi.ProtoMessage, 1)
+	client := NewClientWithTransport(traderTransport(t, requests, openapi.ProtoOAPayloadType_PROTO_OA_CASH_FLOW_HISTORY_LIST_RES, &openapi.ProtoOACashFlowHistoryListRes{CtidTraderAccountId: proto.Int64(1)}), "id", "secret", "token")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	const from, to = 1_760_000_000_000, 1_760_000_000_000 + 86400000
+	if _, err := client.Account(1).Trader().CashFlowHistoryList(ctx, from, to); err != nil {
+		t.Fatal(err)
+	}
+	req := &openapi.ProtoOACashFlowHistoryListReq{}
+	proto.Unmarshal((<-requests).Payload, req)
+	if req.GetFromTimestamp() != from || req.GetToTimestamp() != to {
+		t.Errorf("expected range [%d, %d], got [%d, %d]", from, to, req.GetFromTimestamp(), req.GetToTimestamp())
+	}
+	if _, err := client.Account(1).Trader().CashFlowHistoryList(ctx, from, from+8*86400000); err != ErrTimestampRange {
+		t.Errorf("expected ErrTimestampRange for a range over 7 days, got %v", err)
+	}
+}
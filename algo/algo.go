@@ -0,0 +1,537 @@
+// Package algo 在客户端运行算法单：TWAP/VWAP 分时拆单、冰山单、追踪入场单与定时单
+//
+// 算法单由 Engine 在本地执行，按计划通过 AccountOrder.NewOrder 发出子单，根据 ProtoOAExecutionEvent 统计成交；
+// 追踪入场单订阅品种报价，跟随价格移动触发价。进度通过 OnProgress 回调报告，可随时取消：
+//
+//	engine := algo.New(client, accountId)
+//	engine.OnProgress(func(p algo.Progress) { log.Println(p) })
+//	order, err := engine.Submit(ctx, algo.TWAP{SymbolId: 1, TradeSide: openapi.ProtoOATradeSide_BUY, Volume: 10_000_000, Duration: time.Hour, Slices: 12})
+//	<-order.Done()
+//
+// 算法单只存在于本进程中，进程退出后不会继续执行，已发出的子单保留在服务端
+package algo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// cancelTimeout 算法单结束时撤销未成交子单的超时
+const cancelTimeout = 30 * time.Second
+
+var ErrEngineClosed = errors.New("algo engine is closed")
+
+// Kind 算法单类型
+type Kind int
+
+const (
+	KindTWAP Kind = iota + 1
+	KindVWAP
+	KindIceberg
+	KindTrailingEntry
+	KindTimed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindTWAP:
+		return "twap"
+	case KindVWAP:
+		return "vwap"
+	case KindIceberg:
+		return "iceberg"
+	case KindTrailingEntry:
+		return "trailing-entry"
+	case KindTimed:
+		return "timed"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Status 算法单状态
+type Status int
+
+const (
+	StatusWorking Status = iota
+	StatusCompleted
+	StatusCancelled
+	StatusFailed
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusWorking:
+		return "working"
+	case StatusCompleted:
+		return "completed"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Progress 算法单进度，成交量单位与 NewOrder 的 volume 相同
+type Progress struct {
+	Id       int64
+	Kind     Kind
+	Status   Status
+	SymbolId int64
+	Volume   int64
+	// SentVolume 已发出子单的总量
+	SentVolume   int64
+	FilledVolume int64
+	// AveragePrice 成交均价
+	AveragePrice float64
+	// Children 已发出的子单数
+	Children int
+	// TriggerPrice 追踪入场单当前的触发价
+	TriggerPrice float64
+	Err          error
+}
+
+// Spec 算法单参数：TWAP、VWAP、Iceberg、TrailingEntry 或 Timed
+type Spec interface {
+	kind() Kind
+	symbolId() int64
+	volume() int64
+	validate() error
+	// quotes 运行时是否需要实时报价
+	quotes() bool
+	run(o *Order) error
+}
+
+type quote struct {
+	bid, ask float64
+}
+
+// Engine 算法单引擎，方法均可并发调用
+type Engine struct {
+	client    *ctrago.Client
+	account   *ctrago.Account
+	accountId int64
+	prefix    string
+	// stops 注销事件回调，Close 时调用
+	stops []func()
+
+	lock     sync.Mutex
+	closed   bool
+	nextId   int64
+	orders   map[int64]*Order
+	children map[string]*child
+	quotes   map[int64]quote
+	handlers []func(Progress)
+}
+
+// New 创建算法单引擎，账户需已完成鉴权
+func New(client *ctrago.Client, accountId int64) *Engine {
+	e := &Engine{
+		client:    client,
+		account:   client.Account(accountId),
+		accountId: accountId,
+		prefix:    fmt.Sprintf("algo-%x", time.Now().UnixNano()%0xffffffff),
+		orders:    make(map[int64]*Order),
+		children:  make(map[string]*child),
+		quotes:    make(map[int64]quote),
+	}
+	e.stops = []func(){
+		client.WatchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), e.handleExecution),
+		client.WatchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), e.handleSpot),
+	}
+	return e
+}
+
+// OnProgress 注册进度回调，回调可能在消息循环中执行，不能阻塞或发送同步请求
+func (e *Engine) OnProgress(handler func(Progress)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.handlers = append(e.handlers, handler)
+}
+
+// Submit 校验参数并开始执行算法单，ctx 只用于启动阶段（如订阅报价），算法单的生命周期由 Order.Cancel 控制
+func (e *Engine) Submit(ctx context.Context, spec Spec) (*Order, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return nil, ErrEngineClosed
+	}
+	e.nextId++
+	orderCtx, cancel := context.WithCancel(context.Background())
+	o := &Order{
+		engine: e,
+		spec:   spec,
+		ctx:    orderCtx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+		progress: Progress{
+			Id:       e.nextId,
+			Kind:     spec.kind(),
+			Status:   StatusWorking,
+			SymbolId: spec.symbolId(),
+			Volume:   spec.volume(),
+		},
+	}
+	e.orders[o.progress.Id] = o
+	e.lock.Unlock()
+
+	if spec.quotes() {
+		// 报价订阅与 Exposure 等组件共享引用计数，算法单结束时释放
+		release, err := e.account.AcquireSpots(ctx, spec.symbolId())
+		if err != nil {
+			cancel()
+			e.lock.Lock()
+			delete(e.orders, o.progress.Id)
+			e.lock.Unlock()
+			return nil, err
+		}
+		o.releaseSpots = release
+	}
+	e.emit(o.Progress())
+	go o.run()
+	return o, nil
+}
+
+// Orders 返回运行中的算法单
+func (e *Engine) Orders() []*Order {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	orders := make([]*Order, 0, len(e.orders))
+	for _, o := range e.orders {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// Close 取消所有算法单，等待其结束后注销事件回调
+func (e *Engine) Close() {
+	e.lock.Lock()
+	e.closed = true
+	orders := make([]*Order, 0, len(e.orders))
+	for _, o := range e.orders {
+		orders = append(orders, o)
+	}
+	e.lock.Unlock()
+	for _, o := range orders {
+		o.Cancel()
+	}
+	for _, o := range orders {
+		<-o.done
+	}
+	for _, stop := range e.stops {
+		stop()
+	}
+}
+
+func (e *Engine) emit(p Progress) {
+	e.lock.Lock()
+	handlers := e.handlers
+	e.lock.Unlock()
+	for _, handler := range handlers {
+		handler(p)
+	}
+}
+
+func (e *Engine) handleSpot(msg *openapi.ProtoMessage) {
+	ev := &openapi.ProtoOASpotEvent{}
+	if err := proto.Unmarshal(msg.Payload, ev); err != nil || ev.GetCtidTraderAccountId() != e.accountId {
+		return
+	}
+	e.lock.Lock()
+	q := e.quotes[ev.GetSymbolId()]
+	// 报价事件只携带变化的一侧
+	if ev.Bid != nil {
+		q.bid = float64(ev.GetBid()) / ctrago.PriceScale
+	}
+	if ev.Ask != nil {
+		q.ask = float64(ev.GetAsk()) / ctrago.PriceScale
+	}
+	e.quotes[ev.GetSymbolId()] = q
+	var woken []*Order
+	for _, o := range e.orders {
+		if o.spec.symbolId() == ev.GetSymbolId() && o.spec.quotes() {
+			woken = append(woken, o)
+		}
+	}
+	e.lock.Unlock()
+	for _, o := range woken {
+		o.notify()
+	}
+}
+
+func (e *Engine) handleExecution(msg *openapi.ProtoMessage) {
+	ev := &openapi.ProtoOAExecutionEvent{}
+	if err := proto.Unmarshal(msg.Payload, ev); err != nil || ev.GetCtidTraderAccountId() != e.accountId {
+		return
+	}
+	e.apply(ev)
+}
+
+// apply 根据执行事件或下单、撤单响应更新子单
+func (e *Engine) apply(ev *openapi.ProtoOAExecutionEvent) {
+	order := ev.GetOrder()
+	if order == nil {
+		return
+	}
+	e.lock.Lock()
+	c := e.children[order.GetClientOrderId()]
+	// 下单响应可能晚于成交事件到达，已结束的子单忽略后到的旧状态
+	if c == nil || c.done {
+		e.lock.Unlock()
+		return
+	}
+	if c.orderId == 0 {
+		c.orderId = order.GetOrderId()
+	}
+	o := c.order
+	switch ev.GetExecutionType() {
+	case openapi.ProtoOAExecutionType_ORDER_FILLED, openapi.ProtoOAExecutionType_ORDER_PARTIAL_FILL:
+		if deal := ev.GetDeal(); deal != nil {
+			filled := deal.GetFilledVolume()
+			c.filled += filled
+			p := &o.progress
+			p.AveragePrice = (p.AveragePrice*float64(p.FilledVolume) + deal.GetExecutionPrice()*float64(filled)) / float64(p.FilledVolume+filled)
+			p.FilledVolume += filled
+		}
+	}
+	if isFinal(order.GetOrderStatus()) {
+		c.done = true
+		c.status = order.GetOrderStatus()
+		delete(e.children, c.clientOrderId)
+	}
+	progress := o.progress
+	e.lock.Unlock()
+	o.notify()
+	e.emit(progress)
+}
+
+func isFinal(status openapi.ProtoOAOrderStatus) bool {
+	switch status {
+	case openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED, openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_EXPIRED, openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED:
+		return true
+	}
+	return false
+}
+
+// child 算法单发出的子单，字段由 Engine.lock 保护
+type child struct {
+	order         *Order
+	clientOrderId string
+	orderId       int64
+	volume        int64
+	filled        int64
+	done          bool
+	status        openapi.ProtoOAOrderStatus
+}
+
+// Order 运行中的算法单
+type Order struct {
+	engine *Engine
+	spec   Spec
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	wake   chan struct{}
+	// releaseSpots 释放报价订阅，不需要报价的算法单为 nil
+	releaseSpots func()
+
+	// 以下字段由 Engine.lock 保护
+	progress Progress
+	children []*child
+}
+
+// Id 算法单 ID，在引擎内唯一
+func (o *Order) Id() int64 {
+	return o.progress.Id
+}
+
+// Progress 返回当前进度
+func (o *Order) Progress() Progress {
+	o.engine.lock.Lock()
+	defer o.engine.lock.Unlock()
+	return o.progress
+}
+
+// Cancel 取消算法单，未成交的子单随之撤销，已成交部分保留
+func (o *Order) Cancel() {
+	o.cancel()
+}
+
+// Done 算法单结束（完成、取消或失败）后关闭
+func (o *Order) Done() <-chan struct{} {
+	return o.done
+}
+
+// Wait 等待算法单结束并返回最终进度
+func (o *Order) Wait(ctx context.Context) (Progress, error) {
+	select {
+	case <-o.done:
+		return o.Progress(), nil
+	case <-ctx.Done():
+		return o.Progress(), ctx.Err()
+	}
+}
+
+func (o *Order) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Order) run() {
+	err := o.spec.run(o)
+	status := StatusCompleted
+	switch {
+	case err != nil && o.ctx.Err() != nil:
+		status, err = StatusCancelled, nil
+	case err != nil:
+		status = StatusFailed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	if cancelErr := o.cancelOpen(ctx); cancelErr != nil {
+		err = errors.Join(err, cancelErr)
+	}
+	cancel()
+	o.cancel()
+	if o.releaseSpots != nil {
+		o.releaseSpots()
+	}
+
+	e := o.engine
+	e.lock.Lock()
+	delete(e.orders, o.progress.Id)
+	o.progress.Status = status
+	o.progress.Err = err
+	progress := o.progress
+	e.lock.Unlock()
+	e.emit(progress)
+	close(o.done)
+}
+
+// send 发出子单，clientOrderId 由引擎生成
+func (o *Order) send(orderType openapi.ProtoOAOrderType, tradeSide openapi.ProtoOATradeSide, volume int64, orderOption *ctrago.OrderOption) (*child, error) {
+	e := o.engine
+	opt := &ctrago.OrderOption{}
+	if orderOption != nil {
+		*opt = *orderOption
+	}
+	e.lock.Lock()
+	c := &child{
+		order:         o,
+		clientOrderId: fmt.Sprintf("%s-%d-%d", e.prefix, o.progress.Id, len(o.children)+1),
+		volume:        volume,
+	}
+	opt.WithClientOrderId(c.clientOrderId)
+	e.children[c.clientOrderId] = c
+	o.children = append(o.children, c)
+	o.progress.Children++
+	o.progress.SentVolume += volume
+	progress := o.progress
+	e.lock.Unlock()
+	e.emit(progress)
+
+	res, err := e.account.Order().NewOrder(o.ctx, o.spec.symbolId(), orderType, tradeSide, volume, opt)
+	if err != nil {
+		e.lock.Lock()
+		c.done = true
+		c.status = openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED
+		delete(e.children, c.clientOrderId)
+		o.progress.Children--
+		o.progress.SentVolume -= volume
+		e.lock.Unlock()
+		return nil, err
+	}
+	e.apply(res)
+	return c, nil
+}
+
+// cancelOpen 撤销尚未结束的子单
+func (o *Order) cancelOpen(ctx context.Context) error {
+	e := o.engine
+	e.lock.Lock()
+	var open []int64
+	for _, c := range o.children {
+		if !c.done && c.orderId != 0 {
+			open = append(open, c.orderId)
+		}
+	}
+	e.lock.Unlock()
+	var errs []error
+	for _, orderId := range open {
+		res, err := e.account.Order().CancelOrder(ctx, orderId)
+		if err != nil {
+			// 市价子单在撤单前已成交属于正常情况
+			if ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_ORDER_NOT_FOUND) {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("cancel child order %d: %w", orderId, err))
+			continue
+		}
+		e.apply(res)
+	}
+	return errors.Join(errs...)
+}
+
+// waitFor 等待 cond 成立，cond 在持有 Engine.lock 时调用
+func (o *Order) waitFor(cond func() bool) error {
+	for {
+		o.engine.lock.Lock()
+		ok := cond()
+		o.engine.lock.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-o.ctx.Done():
+			return o.ctx.Err()
+		case <-o.wake:
+		}
+	}
+}
+
+// waitChildren 等待所有子单结束
+func (o *Order) waitChildren() error {
+	return o.waitFor(func() bool {
+		for _, c := range o.children {
+			if !c.done {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// sleepUntil 等待到指定时间
+func (o *Order) sleepUntil(t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return o.ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-o.ctx.Done():
+		return o.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// quoteLocked 当前报价，Engine.lock 由调用方持有
+func (o *Order) quoteLocked() (quote, bool) {
+	q, ok := o.engine.quotes[o.spec.symbolId()]
+	return q, ok && q.bid > 0 && q.ask > 0
+}
@@ -0,0 +1,277 @@
+package algo_test
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/algo"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+)
+
+func newEngine(t *testing.T, ctx context.Context) (*ctragotest.Server, *algo.Engine) {
+	t.Helper()
+	srv := ctragotest.NewServer()
+	t.Cleanup(srv.Close)
+	client := ctrago.NewClientWithTransport(srv.NewTransport(), ctragotest.DefaultClientId, ctragotest.DefaultClientSecret, ctragotest.DefaultAccessToken)
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Account(ctragotest.DefaultAccountId).Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	engine := algo.New(client, ctragotest.DefaultAccountId)
+	t.Cleanup(engine.Close)
+	return srv, engine
+}
+
+func wait(t *testing.T, ctx context.Context, order *algo.Order) algo.Progress {
+	t.Helper()
+	p, err := order.Wait(ctx)
+	if err != nil {
+		t.Fatalf("%s: %v (progress %+v)", p.Kind, err, p)
+	}
+	return p
+}
+
+func TestTWAP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, engine := newEngine(t, ctx)
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+
+	var lock sync.Mutex
+	var updates []algo.Progress
+	engine.OnProgress(func(p algo.Progress) {
+		lock.Lock()
+		updates = append(updates, p)
+		lock.Unlock()
+	})
+	order, err := engine.Submit(ctx, algo.TWAP{
+		SymbolId:   ctragotest.DefaultSymbolId,
+		TradeSide:  openapi.ProtoOATradeSide_BUY,
+		Volume:     300000,
+		Duration:   60 * time.Millisecond,
+		Slices:     3,
+		StepVolume: 100000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := wait(t, ctx, order)
+	if p.Status != algo.StatusCompleted || p.Children != 3 || p.FilledVolume != 300000 || math.Abs(p.AveragePrice-1.1002) > 1e-9 {
+		t.Fatalf("progress = %+v", p)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(updates) < 4 || updates[0].Status != algo.StatusWorking || updates[len(updates)-1].Status != algo.StatusCompleted {
+		t.Fatalf("updates = %+v", updates)
+	}
+}
+
+func TestIceberg(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, engine := newEngine(t, ctx)
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.0998, 1.1000)
+
+	order, err := engine.Submit(ctx, algo.Iceberg{
+		SymbolId:      ctragotest.DefaultSymbolId,
+		TradeSide:     openapi.ProtoOATradeSide_BUY,
+		Volume:        300000,
+		DisplayVolume: 100000,
+		LimitPrice:    1.1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := wait(t, ctx, order)
+	if p.Status != algo.StatusCompleted || p.Children != 3 || p.FilledVolume != 300000 {
+		t.Fatalf("progress = %+v", p)
+	}
+}
+
+func TestTrailingEntry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, engine := newEngine(t, ctx)
+
+	order, err := engine.Submit(ctx, algo.TrailingEntry{
+		SymbolId:  ctragotest.DefaultSymbolId,
+		TradeSide: openapi.ProtoOATradeSide_SELL,
+		Volume:    100000,
+		Distance:  0.0010,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 买价上涨时触发价跟随上移，回落 10 点后卖出
+	for _, step := range []struct{ bid, trigger float64 }{{1.1000, 1.0990}, {1.1020, 1.1010}, {1.1015, 1.1010}} {
+		srv.SetPrice(ctragotest.DefaultSymbolId, step.bid, step.bid+0.0002)
+		for math.Abs(order.Progress().TriggerPrice-step.trigger) > 1e-9 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("trigger = %v, want %v", order.Progress().TriggerPrice, step.trigger)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	if order.Progress().Children != 0 {
+		t.Fatal("triggered early")
+	}
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1009, 1.1011)
+	p := wait(t, ctx, order)
+	if p.Status != algo.StatusCompleted || p.FilledVolume != 100000 || math.Abs(p.AveragePrice-1.1009) > 1e-9 {
+		t.Fatalf("progress = %+v", p)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, engine := newEngine(t, ctx)
+
+	order, err := engine.Submit(ctx, algo.Timed{
+		At:        time.Now().Add(time.Hour),
+		SymbolId:  ctragotest.DefaultSymbolId,
+		OrderType: openapi.ProtoOAOrderType_MARKET,
+		TradeSide: openapi.ProtoOATradeSide_BUY,
+		Volume:    100000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order.Cancel()
+	if p := wait(t, ctx, order); p.Status != algo.StatusCancelled || p.Children != 0 {
+		t.Fatalf("progress = %+v", p)
+	}
+	if _, err := engine.Submit(ctx, algo.TWAP{SymbolId: 1, Volume: 100000}); err != algo.ErrInvalidSchedule {
+		t.Fatalf("expected ErrInvalidSchedule, got %v", err)
+	}
+}
+
+func TestSplitVolume(t *testing.T) {
+	for _, tc := range []struct {
+		volume, step int64
+		weights      []float64
+		want         []int64
+	}{
+		{1000000, 100000, []float64{1, 2, 3}, []int64{200000, 300000, 500000}},
+		// 余下的步长分摊到前几份，而不是全部计入最后一份
+		{700000, 100000, []float64{1, 1, 1, 1, 1}, []int64{200000, 200000, 100000, 100000, 100000}},
+		// 不足一个步长的零头不分配，避免发出不合步长的子单
+		{1000050, 100000, []float64{1, 1}, []int64{500000, 500000}},
+	} {
+		if parts := algo.SplitVolume(tc.volume, tc.step, tc.weights); !slices.Equal(parts, tc.want) {
+			t.Errorf("SplitVolume(%d, %d, %v) = %v, want %v", tc.volume, tc.step, tc.weights, parts, tc.want)
+		}
+	}
+}
+
+func TestTWAP_TooManySlices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, engine := newEngine(t, ctx)
+	_, err := engine.Submit(ctx, algo.TWAP{
+		SymbolId:   ctragotest.DefaultSymbolId,
+		TradeSide:  openapi.ProtoOATradeSide_BUY,
+		Volume:     200000,
+		Duration:   time.Second,
+		Slices:     3,
+		StepVolume: 100000,
+	})
+	if err != algo.ErrTooManySlices {
+		t.Fatalf("expected ErrTooManySlices, got %v", err)
+	}
+}
+
+func TestSubmit_VolumeNotMultipleOfStep(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, engine := newEngine(t, ctx)
+	for _, spec := range []algo.Spec{
+		algo.TWAP{SymbolId: ctragotest.DefaultSymbolId, Volume: 250000, Duration: time.Second, Slices: 2, StepVolume: 100000},
+		algo.VWAP{SymbolId: ctragotest.DefaultSymbolId, Volume: 250000, Duration: time.Second, Profile: []float64{1, 1}, StepVolume: 100000},
+	} {
+		if _, err := engine.Submit(ctx, spec); err != algo.ErrVolumeStep {
+			t.Errorf("%T: expected ErrVolumeStep, got %v", spec, err)
+		}
+	}
+}
+
+func trailingEntry() algo.TrailingEntry {
+	return algo.TrailingEntry{
+		SymbolId:  ctragotest.DefaultSymbolId,
+		TradeSide: openapi.ProtoOATradeSide_SELL,
+		Volume:    100000,
+		Distance:  0.0010,
+	}
+}
+
+func TestSubscribe_AlreadySubscribedNotOwned(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, engine := newEngine(t, ctx)
+	// 品种已被其他组件订阅
+	srv.Fail(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ, openapi.ProtoOAErrorCode_ALREADY_SUBSCRIBED, "already subscribed")
+
+	order, err := engine.Submit(ctx, trailingEntry())
+	if err != nil {
+		t.Fatalf("expected ALREADY_SUBSCRIBED to be accepted, got %v", err)
+	}
+	order.Cancel()
+	wait(t, ctx, order)
+	for _, req := range srv.Requests() {
+		if req.PayloadType == uint32(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_REQ) {
+			t.Fatal("unsubscribed a symbol the engine did not subscribe")
+		}
+	}
+}
+
+func TestSubscribe_WaitersSeeFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, engine := newEngine(t, ctx)
+	received, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	srv.Handle(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ, func(*ctragotest.Session, *ctragotest.Request) *ctragotest.Response {
+		once.Do(func() {
+			close(received)
+			<-release
+		})
+		return ctragotest.Error(openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND.String(), "symbol not found")
+	})
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := engine.Submit(ctx, trailingEntry())
+		errs <- err
+	}()
+	<-received
+	go func() {
+		_, err := engine.Submit(ctx, trailingEntry())
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for range 2 {
+		if err := <-errs; !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND) {
+			t.Fatalf("expected SYMBOL_NOT_FOUND, got %v", err)
+		}
+	}
+
+	// 失败的订阅不会残留，之后的算法单重新订阅
+	srv.Handle(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ, nil)
+	order, err := engine.Submit(ctx, trailingEntry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	order.Cancel()
+	wait(t, ctx, order)
+}
@@ -0,0 +1,351 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+)
+
+var (
+	ErrSymbolIdRequired = errors.New("algo: symbolId is required")
+	ErrVolumeRequired   = errors.New("algo: volume must be positive")
+	ErrInvalidSchedule  = errors.New("algo: duration and slices must be positive")
+	ErrTooManySlices    = errors.New("algo: slices exceed volume / stepVolume")
+	ErrVolumeStep       = errors.New("algo: volume must be a multiple of stepVolume")
+)
+
+// TWAP 在 Duration 内按等间隔分 Slices 次发出市价子单，第一笔立即发出
+type TWAP struct {
+	SymbolId  int64
+	TradeSide openapi.ProtoOATradeSide
+	Volume    int64
+	Duration  time.Duration
+	Slices    int
+	// StepVolume 子单量取整的步长，通常为品种的 StepVolume，0 表示不取整
+	StepVolume int64
+}
+
+func (t TWAP) kind() Kind      { return KindTWAP }
+func (t TWAP) symbolId() int64 { return t.SymbolId }
+func (t TWAP) volume() int64   { return t.Volume }
+func (t TWAP) quotes() bool    { return false }
+
+func (t TWAP) validate() error {
+	if err := validateBase(t.SymbolId, t.Volume); err != nil {
+		return err
+	}
+	if t.Duration <= 0 || t.Slices <= 0 {
+		return ErrInvalidSchedule
+	}
+	if err := validateStep(t.Volume, t.StepVolume); err != nil {
+		return err
+	}
+	if t.StepVolume > 0 && int64(t.Slices) > t.Volume/t.StepVolume {
+		return ErrTooManySlices
+	}
+	return nil
+}
+
+func (t TWAP) run(o *Order) error {
+	weights := make([]float64, t.Slices)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return runSchedule(o, t.TradeSide, SplitVolume(t.Volume, t.StepVolume, weights), t.Duration)
+}
+
+// VWAP 在 Duration 内按成交量分布 Profile 分时发出市价子单，每个时段一笔
+//
+// cTrader 不提供实时成交量，Profile 通常来自历史 K 线的 tick 数，见 ProfileFromBars
+type VWAP struct {
+	SymbolId  int64
+	TradeSide openapi.ProtoOATradeSide
+	Volume    int64
+	Duration  time.Duration
+	// Profile 各时段的权重，时段数为 len(Profile)
+	Profile    []float64
+	StepVolume int64
+}
+
+func (v VWAP) kind() Kind      { return KindVWAP }
+func (v VWAP) symbolId() int64 { return v.SymbolId }
+func (v VWAP) volume() int64   { return v.Volume }
+func (v VWAP) quotes() bool    { return false }
+
+func (v VWAP) validate() error {
+	if err := validateBase(v.SymbolId, v.Volume); err != nil {
+		return err
+	}
+	if v.Duration <= 0 || len(v.Profile) == 0 {
+		return ErrInvalidSchedule
+	}
+	var total float64
+	for _, w := range v.Profile {
+		if w < 0 {
+			return fmt.Errorf("algo: profile weight %v is negative", w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.New("algo: profile weights sum to zero")
+	}
+	return validateStep(v.Volume, v.StepVolume)
+}
+
+func (v VWAP) run(o *Order) error {
+	return runSchedule(o, v.TradeSide, SplitVolume(v.Volume, v.StepVolume, v.Profile), v.Duration)
+}
+
+// ProfileFromBars 以 K 线的成交量（tick 数）作为 VWAP 各时段的权重
+func ProfileFromBars(bars []ctrago.Bar) []float64 {
+	profile := make([]float64, len(bars))
+	for i, bar := range bars {
+		profile[i] = float64(bar.Volume)
+	}
+	return profile
+}
+
+// SplitVolume 按权重拆分 volume，各份为 step 的整数倍
+//
+// 向下取整后剩余的步长按舍去部分从大到小逐份补齐；volume 不是 step 的整数倍时，不足一个 step 的零头不分配
+func SplitVolume(volume, step int64, weights []float64) []int64 {
+	if len(weights) == 0 {
+		return nil
+	}
+	if step <= 0 {
+		step = 1
+	}
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	units := volume / step
+	parts := make([]int64, len(weights))
+	fractions := make([]float64, len(weights))
+	left := units
+	for i, w := range weights {
+		exact := float64(units) * w / total
+		parts[i] = int64(exact)
+		fractions[i] = exact - float64(parts[i])
+		left -= parts[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fractions[order[a]] > fractions[order[b]] })
+	for i := 0; left > 0; i = (i + 1) % len(order) {
+		parts[order[i]]++
+		left--
+	}
+	for i := range parts {
+		parts[i] *= step
+	}
+	return parts
+}
+
+// runSchedule 按等间隔发出各份市价子单，跳过为 0 的份额，全部发出后等待成交
+func runSchedule(o *Order, side openapi.ProtoOATradeSide, parts []int64, duration time.Duration) error {
+	start := time.Now()
+	interval := duration / time.Duration(len(parts))
+	for i, volume := range parts {
+		if err := o.sleepUntil(start.Add(time.Duration(i) * interval)); err != nil {
+			return err
+		}
+		if volume <= 0 {
+			continue
+		}
+		if _, err := o.send(openapi.ProtoOAOrderType_MARKET, side, volume, nil); err != nil {
+			return fmt.Errorf("slice %d: %w", i+1, err)
+		}
+	}
+	return o.waitChildren()
+}
+
+// Iceberg 冰山单：以 LimitPrice 挂出 DisplayVolume 的限价子单，成交后挂出下一笔，直至成交 Volume
+type Iceberg struct {
+	SymbolId      int64
+	TradeSide     openapi.ProtoOATradeSide
+	Volume        int64
+	DisplayVolume int64
+	LimitPrice    float64
+}
+
+func (i Iceberg) kind() Kind      { return KindIceberg }
+func (i Iceberg) symbolId() int64 { return i.SymbolId }
+func (i Iceberg) volume() int64   { return i.Volume }
+func (i Iceberg) quotes() bool    { return false }
+
+func (i Iceberg) validate() error {
+	if err := validateBase(i.SymbolId, i.Volume); err != nil {
+		return err
+	}
+	if i.DisplayVolume <= 0 || i.DisplayVolume > i.Volume {
+		return errors.New("algo: display volume must be positive and not exceed the total volume")
+	}
+	if i.LimitPrice <= 0 {
+		return errors.New("algo: limit price is required")
+	}
+	return nil
+}
+
+func (i Iceberg) run(o *Order) error {
+	opt := &ctrago.OrderOption{}
+	opt.WithLimitPrice(i.LimitPrice)
+	for {
+		o.engine.lock.Lock()
+		remaining := i.Volume - o.progress.FilledVolume
+		o.engine.lock.Unlock()
+		if remaining <= 0 {
+			return nil
+		}
+		c, err := o.send(openapi.ProtoOAOrderType_LIMIT, i.TradeSide, min(i.DisplayVolume, remaining), opt)
+		if err != nil {
+			return err
+		}
+		if err := o.waitFor(func() bool { return c.done }); err != nil {
+			return err
+		}
+		o.engine.lock.Lock()
+		status := c.status
+		o.engine.lock.Unlock()
+		if status != openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED {
+			return fmt.Errorf("algo: iceberg child order %d ended with %s", c.orderId, status)
+		}
+	}
+}
+
+// TrailingEntry 追踪入场单：买单的触发价跟随卖价下移，保持在最低卖价之上 Distance；
+// 卖单的触发价跟随买价上移，保持在最高买价之下 Distance。价格反向到达触发价时发出市价单
+type TrailingEntry struct {
+	SymbolId  int64
+	TradeSide openapi.ProtoOATradeSide
+	Volume    int64
+	// Distance 触发价与最优价格的距离
+	Distance float64
+	// ActivationPrice 大于 0 时，买单在卖价不高于、卖单在买价不低于该价格后才开始追踪
+	ActivationPrice float64
+	// Option 市价单的其他参数，如相对止损止盈
+	Option *ctrago.OrderOption
+}
+
+func (t TrailingEntry) kind() Kind      { return KindTrailingEntry }
+func (t TrailingEntry) symbolId() int64 { return t.SymbolId }
+func (t TrailingEntry) volume() int64   { return t.Volume }
+func (t TrailingEntry) quotes() bool    { return true }
+
+func (t TrailingEntry) validate() error {
+	if err := validateBase(t.SymbolId, t.Volume); err != nil {
+		return err
+	}
+	if t.Distance <= 0 {
+		return errors.New("algo: trailing distance must be positive")
+	}
+	return nil
+}
+
+func (t TrailingEntry) run(o *Order) error {
+	buy := t.TradeSide == openapi.ProtoOATradeSide_BUY
+	active := t.ActivationPrice <= 0
+	var best float64
+	triggered := false
+	err := o.waitFor(func() bool {
+		q, ok := o.quoteLocked()
+		if !ok {
+			return false
+		}
+		price := q.bid
+		if buy {
+			price = q.ask
+		}
+		if !active {
+			if buy && price > t.ActivationPrice || !buy && price < t.ActivationPrice {
+				return false
+			}
+			active = true
+		}
+		if best == 0 || buy && price < best || !buy && price > best {
+			best = price
+		}
+		trigger := best - t.Distance
+		if buy {
+			trigger = best + t.Distance
+		}
+		o.progress.TriggerPrice = trigger
+		triggered = buy && price >= trigger || !buy && price <= trigger
+		return triggered
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := o.send(openapi.ProtoOAOrderType_MARKET, t.TradeSide, t.Volume, t.Option); err != nil {
+		return err
+	}
+	return o.waitChildren()
+}
+
+// Timed 定时单：到达 At 时发出订单；市价单等待成交，挂单在服务端接受后即完成
+type Timed struct {
+	At        time.Time
+	SymbolId  int64
+	OrderType openapi.ProtoOAOrderType
+	TradeSide openapi.ProtoOATradeSide
+	Volume    int64
+	Option    *ctrago.OrderOption
+}
+
+func (t Timed) kind() Kind      { return KindTimed }
+func (t Timed) symbolId() int64 { return t.SymbolId }
+func (t Timed) volume() int64   { return t.Volume }
+func (t Timed) quotes() bool    { return false }
+
+func (t Timed) validate() error {
+	if err := validateBase(t.SymbolId, t.Volume); err != nil {
+		return err
+	}
+	if t.At.IsZero() {
+		return errors.New("algo: trigger time is required")
+	}
+	return nil
+}
+
+func (t Timed) run(o *Order) error {
+	if err := o.sleepUntil(t.At); err != nil {
+		return err
+	}
+	c, err := o.send(t.OrderType, t.TradeSide, t.Volume, t.Option)
+	if err != nil {
+		return err
+	}
+	if t.OrderType != openapi.ProtoOAOrderType_MARKET && t.OrderType != openapi.ProtoOAOrderType_MARKET_RANGE {
+		// 挂单交给服务端管理，算法单结束时不撤销
+		o.engine.lock.Lock()
+		c.done = true
+		delete(o.engine.children, c.clientOrderId)
+		o.engine.lock.Unlock()
+		return nil
+	}
+	return o.waitChildren()
+}
+
+func validateBase(symbolId, volume int64) error {
+	if symbolId <= 0 {
+		return ErrSymbolIdRequired
+	}
+	if volume <= 0 {
+		return ErrVolumeRequired
+	}
+	return nil
+}
+
+// validateStep 子单量必须是步长的整数倍，否则服务端会拒绝最后一笔子单
+func validateStep(volume, step int64) error {
+	if step > 0 && volume%step != 0 {
+		return ErrVolumeStep
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package backtest 用历史 K 线与 tick 回放行情，在本地模拟成交，评估基于 ctrago.Client 编写的策略
+//
+// 策略与实盘使用相同的接口：通过 Client.OnEvent 接收 ProtoOASpotEvent 与 ProtoOAExecutionEvent，通过 AccountOrder 下单。
+// 成交由 paper.Engine 模拟，手续费、隔夜利息与保证金按 ProtoOASymbol 计算：
+//
+//	bt := backtest.New(backtest.Config{Account: paper.Config{AccountId: accountId, Balance: 10000_00}, Symbols: symbols})
+//	bt.AddTrendbars(symbolId, res.GetPeriod(), res.Trendbar, 0.0002)
+//	client := bt.Client()
+//	strategy.Start(client) // 注册回调、认证账户
+//	result, err := bt.Run(ctx)
+package backtest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"github.com/yockii/ctrago/paper"
+)
+
+// Config 回测配置
+type Config struct {
+	// Account 模拟账户，AccountId 为策略使用的账户
+	Account paper.Config
+	// Symbols 回测品种的参数（成交量限制、手续费、隔夜利息等）
+	Symbols []*openapi.ProtoOASymbol
+	// EquityInterval 净值曲线的采样间隔，0 表示每个报价采样一次
+	EquityInterval time.Duration
+}
+
+type quote struct {
+	symbolId int64
+	bid      float64
+	ask      float64
+	time     time.Time
+}
+
+// Backtest 回测，Add* 与 Run 不能并发调用
+type Backtest struct {
+	cfg       Config
+	engine    *paper.Engine
+	feed      *feed
+	transport *paper.Transport
+	quotes    []quote
+}
+
+// New 创建回测
+func New(cfg Config) *Backtest {
+	engine := paper.NewEngine(cfg.Account)
+	for _, symbol := range cfg.Symbols {
+		engine.SetSymbol(symbol)
+	}
+	f := newFeed(cfg.Account.AccountId, cfg.Symbols)
+	return &Backtest{
+		cfg:       cfg,
+		engine:    engine,
+		feed:      f,
+		transport: paper.NewTransport(f, engine),
+	}
+}
+
+// Engine 返回模拟成交引擎
+func (b *Backtest) Engine() *paper.Engine {
+	return b.engine
+}
+
+// Transport 返回回测传输层，可用于 ctrago.NewClientWithTransport 或 ctrago.WithTransport
+func (b *Backtest) Transport() ctrago.Transport {
+	return b.transport
+}
+
+// Client 创建连接到回测的 Client，认证请求总是成功
+func (b *Backtest) Client() *ctrago.Client {
+	return ctrago.NewClientWithTransport(b.transport, "backtest", "backtest", "backtest")
+}
+
+// AddBars 加入 bid K 线，ask = bid + spread
+//
+// 每根 K 线展开为开、高、低、收四个报价：阳线按开-低-高-收，阴线按开-高-低-收，在周期内均匀分布
+func (b *Backtest) AddBars(symbolId int64, bars []ctrago.Bar, spread float64) {
+	for _, bar := range bars {
+		period := ctrago.PeriodDuration(bar.Period)
+		path := []float64{bar.Open, bar.High, bar.Low, bar.Close}
+		if bar.Close >= bar.Open {
+			path[1], path[2] = bar.Low, bar.High
+		}
+		for i, price := range path {
+			offset := period * time.Duration(i) / 4
+			if i == len(path)-1 && period > 0 {
+				offset = period - time.Millisecond
+			}
+			b.quotes = append(b.quotes, quote{symbolId: symbolId, bid: price, ask: price + spread, time: bar.Time.Add(offset)})
+		}
+	}
+}
+
+// AddTrendbars 加入 ProtoOAGetTrendbarsRes 中的 K 线，period 为响应的 Period，见 AddBars
+func (b *Backtest) AddTrendbars(symbolId int64, period openapi.ProtoOATrendbarPeriod, trendbars []*openapi.ProtoOATrendbar, spread float64) {
+	b.AddBars(symbolId, ctrago.DecodeTrendbars(period, trendbars), spread)
+}
+
+// AddTicks 加入按时间升序的 bid 与 ask tick，两边都有报价后每个 tick 产生一个报价
+func (b *Backtest) AddTicks(symbolId int64, bids, asks []ctrago.Tick) {
+	var bid, ask float64
+	i, j := 0, 0
+	for i < len(bids) || j < len(asks) {
+		var ts time.Time
+		switch {
+		case j >= len(asks) || i < len(bids) && bids[i].Time.Before(asks[j].Time):
+			ts, bid = bids[i].Time, bids[i].Price
+			i++
+		case i >= len(bids) || asks[j].Time.Before(bids[i].Time):
+			ts, ask = asks[j].Time, asks[j].Price
+			j++
+		default:
+			ts, bid, ask = bids[i].Time, bids[i].Price, asks[j].Price
+			i++
+			j++
+		}
+		if bid > 0 && ask > 0 {
+			b.quotes = append(b.quotes, quote{symbolId: symbolId, bid: bid, ask: ask, time: ts})
+		}
+	}
+}
+
+// AddTickData 加入 ProtoOAGetTickDataRes 中的 bid 与 ask tick，见 AddTicks
+func (b *Backtest) AddTickData(symbolId int64, bids, asks []*openapi.ProtoOATickData) {
+	b.AddTicks(symbolId, ctrago.DecodeTicks(bids), ctrago.DecodeTicks(asks))
+}
+
+// Run 按时间顺序推送全部报价，每个报价的回调及其引起的执行事件处理完毕后才推送下一个
+//
+// 所有已加入品种的报价都会推送，无论是否订阅；ctx 取消时返回截至当时的结果
+func (b *Backtest) Run(ctx context.Context) (*Result, error) {
+	sort.SliceStable(b.quotes, func(i, j int) bool { return b.quotes[i].time.Before(b.quotes[j].time) })
+	res := &Result{InitialBalance: b.cfg.Account.Balance}
+	var next time.Time
+	var err error
+	for _, q := range b.quotes {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		b.feed.spot(q)
+		b.transport.Wait()
+		if q.time.Before(next) {
+			continue
+		}
+		res.EquityCurve = append(res.EquityCurve, EquityPoint{Time: q.time, Balance: b.engine.Balance(), Equity: b.engine.Equity()})
+		next = q.time.Add(b.cfg.EquityInterval)
+	}
+	res.finish(b.engine)
+	return res, err
+}
@@ -0,0 +1,99 @@
+package backtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/backtest"
+	"github.com/yockii/ctrago/openapi"
+	"github.com/yockii/ctrago/paper"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	accountId = 42
+	symbolId  = 1
+)
+
+func trendbar(ts time.Time, open, high, low, close int64) *openapi.ProtoOATrendbar {
+	return &openapi.ProtoOATrendbar{
+		Volume:                proto.Int64(100),
+		Low:                   proto.Int64(low),
+		DeltaOpen:             proto.Uint64(uint64(open - low)),
+		DeltaHigh:             proto.Uint64(uint64(high - low)),
+		DeltaClose:            proto.Uint64(uint64(close - low)),
+		UtcTimestampInMinutes: proto.Uint32(uint32(ts.Unix() / 60)),
+	}
+}
+
+func TestBacktest_Trendbars(t *testing.T) {
+	bt := backtest.New(backtest.Config{
+		Account: paper.Config{AccountId: accountId, Balance: 10000_00},
+		Symbols: []*openapi.ProtoOASymbol{{
+			SymbolId:    proto.Int64(symbolId),
+			Digits:      proto.Int32(5),
+			PipPosition: proto.Int32(4),
+		}},
+	})
+	start := time.Date(2026, 10, 13, 8, 0, 0, 0, time.UTC)
+	bt.AddTrendbars(symbolId, openapi.ProtoOATrendbarPeriod_H1, []*openapi.ProtoOATrendbar{
+		trendbar(start.Add(time.Hour), 110100, 110400, 110050, 110300),
+		trendbar(start, 110000, 110150, 109900, 110100),
+	}, 0.0002)
+
+	client := bt.Client()
+	defer client.Close()
+	ctx := context.Background()
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := client.Account(accountId)
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 策略：第一个报价买入，止盈 20 点
+	var spots, fills int
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) {
+		spots++
+		if spots > 1 {
+			return
+		}
+		opt := &ctrago.OrderOption{}
+		opt.WithRelativeTakeProfit(200)
+		if _, err := account.Order().NewOrder(ctx, symbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 10000000, opt); err != nil {
+			t.Error(err)
+		}
+	})
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOAExecutionEvent{}
+		proto.Unmarshal(msg.Payload, ev)
+		if ev.GetExecutionType() == openapi.ProtoOAExecutionType_ORDER_FILLED {
+			fills++
+		}
+	})
+
+	res, err := bt.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spots != 8 || fills != 2 {
+		t.Fatalf("expected 8 spots and 2 fills, got %d and %d", spots, fills)
+	}
+	if len(res.Trades) != 1 {
+		t.Fatalf("expected 1 trade, got %d", len(res.Trades))
+	}
+	// 开盘按 ask 1.1002 买入，第二根 K 线的最高价 1.1040 触发止盈 1.1022，按当时的 bid 成交
+	trade := res.Trades[0]
+	if trade.EntryPrice != 1.1002 || trade.ExitPrice != 1.1040 || !trade.EntryTime.Equal(start) {
+		t.Errorf("unexpected trade: %+v", trade)
+	}
+	if res.NetProfit() != trade.NetProfit() || res.NetProfit() != 380_00 {
+		t.Errorf("unexpected net profit %d", res.NetProfit())
+	}
+	if len(res.EquityCurve) != 8 || res.MaxDrawdown != 100_00 {
+		t.Errorf("unexpected equity curve: %d points, max drawdown %d", len(res.EquityCurve), res.MaxDrawdown)
+	}
+}
@@ -0,0 +1,159 @@
+package backtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// feed 回测使用的底层传输层：在本地响应认证、品种与订阅请求，并按回测进度推送 ProtoOASpotEvent
+//
+// 下单类请求由外层的 paper.Transport 处理，不会到达 feed；响应在 Send 中同步回调
+type feed struct {
+	accountId int64
+	symbols   []*openapi.ProtoOASymbol
+
+	lock      sync.Mutex
+	handlers  []ctrago.MessageHandler
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newFeed(accountId int64, symbols []*openapi.ProtoOASymbol) *feed {
+	return &feed{accountId: accountId, symbols: symbols, done: make(chan struct{})}
+}
+
+func (f *feed) Send(messageType int, data []byte) error {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return err
+	}
+	if msg.GetPayloadType() == uint32(openapi.ProtoPayloadType_HEARTBEAT_EVENT) {
+		return nil
+	}
+	payloadType, res := f.handle(msg)
+	f.reply(msg.GetClientMsgId(), payloadType, res)
+	return nil
+}
+
+func (f *feed) handle(msg *openapi.ProtoMessage) (openapi.ProtoOAPayloadType, proto.Message) {
+	accountId := proto.Int64(f.accountId)
+	switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+	case openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ:
+		return openapi.ProtoOAPayloadType_PROTO_OA_VERSION_RES, &openapi.ProtoOAVersionRes{Version: proto.String("backtest")}
+	case openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_REQ:
+		return openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_RES, &openapi.ProtoOAApplicationAuthRes{}
+	case openapi.ProtoOAPayloadType_PROTO_OA_GET_ACCOUNTS_BY_ACCESS_TOKEN_REQ:
+		req := &openapi.ProtoOAGetAccountListByAccessTokenReq{}
+		proto.Unmarshal(msg.Payload, req)
+		return openapi.ProtoOAPayloadType_PROTO_OA_GET_ACCOUNTS_BY_ACCESS_TOKEN_RES, &openapi.ProtoOAGetAccountListByAccessTokenRes{
+			AccessToken: proto.String(req.GetAccessToken()),
+			CtidTraderAccount: []*openapi.ProtoOACtidTraderAccount{{
+				CtidTraderAccountId: proto.Uint64(uint64(f.accountId)),
+				IsLive:              proto.Bool(false),
+			}},
+		}
+	case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ:
+		req := &openapi.ProtoOAAccountAuthReq{}
+		proto.Unmarshal(msg.Payload, req)
+		if req.GetCtidTraderAccountId() != f.accountId {
+			return errorRes(openapi.ProtoOAErrorCode_CH_CTID_TRADER_ACCOUNT_NOT_FOUND.String(), "account is not part of the backtest")
+		}
+		return openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{CtidTraderAccountId: accountId}
+	case openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_LIST_REQ:
+		res := &openapi.ProtoOASymbolsListRes{CtidTraderAccountId: accountId}
+		for _, symbol := range f.symbols {
+			res.Symbol = append(res.Symbol, &openapi.ProtoOALightSymbol{SymbolId: symbol.SymbolId, Enabled: proto.Bool(true)})
+		}
+		return openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_LIST_RES, res
+	case openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_REQ:
+		req := &openapi.ProtoOASymbolByIdReq{}
+		proto.Unmarshal(msg.Payload, req)
+		res := &openapi.ProtoOASymbolByIdRes{CtidTraderAccountId: accountId}
+		for _, id := range req.SymbolId {
+			for _, symbol := range f.symbols {
+				if symbol.GetSymbolId() == id {
+					res.Symbol = append(res.Symbol, symbol)
+				}
+			}
+		}
+		return openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_RES, res
+	case openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ:
+		return openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_RES, &openapi.ProtoOASubscribeSpotsRes{CtidTraderAccountId: accountId}
+	case openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_REQ:
+		return openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_RES, &openapi.ProtoOAUnsubscribeSpotsRes{CtidTraderAccountId: accountId}
+	}
+	return errorRes(openapi.ProtoErrorCode_UNSUPPORTED_MESSAGE.String(), "not available in backtest")
+}
+
+func errorRes(code, description string) (openapi.ProtoOAPayloadType, proto.Message) {
+	return openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{
+		ErrorCode:   proto.String(code),
+		Description: proto.String(description),
+	}
+}
+
+func (f *feed) reply(clientMsgId string, payloadType openapi.ProtoOAPayloadType, payload proto.Message) {
+	raw, err := proto.Marshal(payload)
+	if err != nil {
+		return
+	}
+	msg := &openapi.ProtoMessage{
+		PayloadType: proto.Uint32(uint32(payloadType)),
+		Payload:     raw,
+	}
+	if clientMsgId != "" {
+		msg.ClientMsgId = proto.String(clientMsgId)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	f.lock.Lock()
+	handlers := f.handlers
+	f.lock.Unlock()
+	for _, h := range handlers {
+		h(websocket.BinaryMessage, data)
+	}
+}
+
+// spot 推送一条报价
+func (f *feed) spot(q quote) {
+	f.reply("", openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{
+		CtidTraderAccountId: proto.Int64(f.accountId),
+		SymbolId:            proto.Int64(q.symbolId),
+		Bid:                 proto.Uint64(uint64(q.bid*ctrago.PriceScale + 0.5)),
+		Ask:                 proto.Uint64(uint64(q.ask*ctrago.PriceScale + 0.5)),
+		Timestamp:           proto.Int64(q.time.UnixMilli()),
+	})
+}
+
+func (f *feed) OnMessage(handler ctrago.MessageHandler) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.handlers = append(f.handlers, handler)
+}
+
+// OnTransportEvent 回测连接始终可用，立即回调 TransportConnected
+func (f *feed) OnTransportEvent(handler ctrago.TransportEventHandler) {
+	handler(ctrago.TransportConnected, nil)
+}
+
+func (f *feed) Listen() error {
+	<-f.done
+	return nil
+}
+
+func (f *feed) SetHeartbeat(time.Duration, func() (int, []byte)) {}
+
+func (f *feed) Close() error {
+	f.closeOnce.Do(func() { close(f.done) })
+	return nil
+}
+
+var _ ctrago.Transport = (*feed)(nil)
+var _ ctrago.TransportNotifier = (*feed)(nil)
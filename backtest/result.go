@@ -0,0 +1,110 @@
+package backtest
+
+import (
+	"math"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"github.com/yockii/ctrago/paper"
+)
+
+// Trade 一次平仓（含部分平仓）
+type Trade struct {
+	PositionId int64
+	SymbolId   int64
+	// TradeSide 持仓方向
+	TradeSide  openapi.ProtoOATradeSide
+	Volume     int64
+	EntryPrice float64
+	ExitPrice  float64
+	EntryTime  time.Time
+	ExitTime   time.Time
+	// GrossProfit、Swap、Commission 单位为 10^-MoneyDigits 存款货币，Commission 包含开平仓两边
+	GrossProfit int64
+	Swap        int64
+	Commission  int64
+}
+
+// NetProfit 净盈亏
+func (t Trade) NetProfit() int64 {
+	return t.GrossProfit + t.Swap + t.Commission
+}
+
+// EquityPoint 净值曲线上的一点
+type EquityPoint struct {
+	Time    time.Time
+	Balance int64
+	Equity  int64
+}
+
+// Result 回测结果
+type Result struct {
+	InitialBalance int64
+	Balance        int64
+	Equity         int64
+	Deals          []*openapi.ProtoOADeal
+	Trades         []Trade
+	EquityCurve    []EquityPoint
+	// MaxDrawdown 净值曲线的最大回撤
+	MaxDrawdown int64
+}
+
+// NetProfit 已实现的净盈亏
+func (r *Result) NetProfit() int64 {
+	return r.Balance - r.InitialBalance
+}
+
+// WinRate 盈利交易的比例，没有交易时返回 0
+func (r *Result) WinRate() float64 {
+	if len(r.Trades) == 0 {
+		return 0
+	}
+	var wins int
+	for _, t := range r.Trades {
+		if t.NetProfit() > 0 {
+			wins++
+		}
+	}
+	return float64(wins) / float64(len(r.Trades))
+}
+
+func (r *Result) finish(engine *paper.Engine) {
+	r.Balance = engine.Balance()
+	r.Equity = engine.Equity()
+	r.Deals, _ = engine.Deals(0, math.MaxInt64, 0)
+	opened := make(map[int64]time.Time)
+	for _, deal := range r.Deals {
+		ts := time.UnixMilli(deal.GetExecutionTimestamp()).UTC()
+		detail := deal.GetClosePositionDetail()
+		if detail == nil {
+			if _, ok := opened[deal.GetPositionId()]; !ok {
+				opened[deal.GetPositionId()] = ts
+			}
+			continue
+		}
+		side := openapi.ProtoOATradeSide_BUY
+		if deal.GetTradeSide() == openapi.ProtoOATradeSide_BUY {
+			side = openapi.ProtoOATradeSide_SELL
+		}
+		r.Trades = append(r.Trades, Trade{
+			PositionId:  deal.GetPositionId(),
+			SymbolId:    deal.GetSymbolId(),
+			TradeSide:   side,
+			Volume:      detail.GetClosedVolume(),
+			EntryPrice:  detail.GetEntryPrice(),
+			ExitPrice:   deal.GetExecutionPrice(),
+			EntryTime:   opened[deal.GetPositionId()],
+			ExitTime:    ts,
+			GrossProfit: detail.GetGrossProfit(),
+			Swap:        detail.GetSwap(),
+			Commission:  detail.GetCommission(),
+		})
+	}
+	var peak int64
+	for i, p := range r.EquityCurve {
+		if i == 0 || p.Equity > peak {
+			peak = p.Equity
+		}
+		r.MaxDrawdown = max(r.MaxDrawdown, peak-p.Equity)
+	}
+}
@@ -0,0 +1,563 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrScaleOutTargets   = errors.New("scale-out targets are required and their volume must not exceed the entry volume")
+	ErrOrderLinkerClosed = errors.New("order linker is closed")
+	ErrScaleOutVolume    = errors.New("scale-out volume must be a multiple of step and cover every price with at least one step")
+)
+
+// OrderLinker 客户端订单联动：根据执行事件维护带保护的入场单、OCO 订单对与分批止盈
+//
+// 联动在客户端完成，进程退出或连接中断期间发生的成交不会触发联动；订单以 clientOrderId 识别，
+// 因此成交事件早于下单响应到达时也能正确处理。异步执行的撤单、改单失败通过 OnError 回调报告
+type OrderLinker struct {
+	account *Account
+	prefix  string
+	seq     atomic.Int64
+
+	lock      sync.Mutex
+	closed    bool
+	byClient  map[string]*linkedOrder
+	byOrder   map[int64]*linkedOrder
+	positions map[int64]func(ev *openapi.ProtoOAExecutionEvent) []linkAction
+	onError   func(error)
+	// stop 注销执行事件回调
+	stop func()
+}
+
+// ScaleOutTarget 分批止盈的一档：在 Price 平掉 Volume
+type ScaleOutTarget struct {
+	Price  float64
+	Volume int64
+}
+
+// OrderSpec OCO 订单对中一侧订单的参数
+type OrderSpec struct {
+	SymbolId  int64
+	OrderType openapi.ProtoOAOrderType
+	TradeSide openapi.ProtoOATradeSide
+	Volume    int64
+	Option    *OrderOption
+}
+
+type linkAction func(ctx context.Context) error
+
+// linkedOrder 受联动管理的订单，字段由 OrderLinker.lock 保护
+type linkedOrder struct {
+	clientOrderId string
+	orderId       int64
+	// done 订单已成交、撤销、过期或被拒绝
+	done       bool
+	cancelling bool
+	handle     func(o *linkedOrder, ev *openapi.ProtoOAExecutionEvent) []linkAction
+}
+
+// NewOrderLinker 创建订单联动并注册执行事件，Close 时注销；账户来自 ClientPool 时在池的所有连接上注册
+func NewOrderLinker(account *Account) *OrderLinker {
+	l := &OrderLinker{
+		account:   account,
+		prefix:    fmt.Sprintf("ctrago-%x", time.Now().UnixNano()%0xffffffff),
+		byClient:  make(map[string]*linkedOrder),
+		byOrder:   make(map[int64]*linkedOrder),
+		positions: make(map[int64]func(ev *openapi.ProtoOAExecutionEvent) []linkAction),
+	}
+	l.stop = account.watchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), l.handleExecution)
+	return l
+}
+
+// OnError 设置异步联动操作失败时的回调
+func (l *OrderLinker) OnError(handler func(error)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onError = handler
+}
+
+// Close 停止联动，已挂出的订单保留在服务端
+func (l *OrderLinker) Close() {
+	l.stop()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	l.byClient = make(map[string]*linkedOrder)
+	l.byOrder = make(map[int64]*linkedOrder)
+	l.positions = make(map[int64]func(ev *openapi.ProtoOAExecutionEvent) []linkAction)
+}
+
+// Bracket 下入场单并附带止损止盈
+//
+// 限价、止损类入场单直接在订单上设置绝对价格的止损止盈；服务端不接受市价单的绝对止损止盈，
+// 市价入场单在成交后修改持仓的止损止盈
+func (l *OrderLinker) Bracket(ctx context.Context, symbolId int64, orderType openapi.ProtoOAOrderType, tradeSide openapi.ProtoOATradeSide, volume int64, stopLoss, takeProfit float64, orderOption *OrderOption) (*openapi.ProtoOAExecutionEvent, error) {
+	opt := l.option(orderOption)
+	if !isMarketOrder(orderType) {
+		opt.stopLoss, opt.takeProfit = stopLoss, takeProfit
+		return l.account.Order().NewOrder(ctx, symbolId, orderType, tradeSide, volume, opt)
+	}
+	entry := &linkedOrder{clientOrderId: opt.clientOrderId}
+	entry.handle = func(o *linkedOrder, ev *openapi.ProtoOAExecutionEvent) []linkAction {
+		if !o.done {
+			return nil
+		}
+		l.forgetLocked(o)
+		if ev.GetExecutionType() != openapi.ProtoOAExecutionType_ORDER_FILLED {
+			return nil
+		}
+		positionId := ev.GetPosition().GetPositionId()
+		if positionId == 0 || (stopLoss <= 0 && takeProfit <= 0) {
+			return nil
+		}
+		return []linkAction{l.protect(positionId, stopLoss, takeProfit)}
+	}
+	return l.place(ctx, entry, symbolId, orderType, tradeSide, volume, opt)
+}
+
+// OCOPair 一对互斥订单：一侧成交（含部分成交）、撤销、过期或被拒绝后撤销另一侧
+type OCOPair struct {
+	linker    *OrderLinker
+	legs      [2]*linkedOrder
+	triggered bool
+	filled    int64
+	done      chan struct{}
+}
+
+// OCO 挂出两张互斥的订单；第二张下单失败时撤销第一张
+func (l *OrderLinker) OCO(ctx context.Context, first, second OrderSpec) (*OCOPair, error) {
+	firstOpt, secondOpt := l.option(first.Option), l.option(second.Option)
+	p := l.newOCO(&linkedOrder{clientOrderId: firstOpt.clientOrderId}, &linkedOrder{clientOrderId: secondOpt.clientOrderId})
+	if _, err := l.place(ctx, p.legs[0], first.SymbolId, first.OrderType, first.TradeSide, first.Volume, firstOpt); err != nil {
+		l.forget(p.legs[:]...)
+		return nil, err
+	}
+	if _, err := l.place(ctx, p.legs[1], second.SymbolId, second.OrderType, second.TradeSide, second.Volume, secondOpt); err != nil {
+		l.forget(p.legs[:]...)
+		if orderId := p.OrderIds()[0]; orderId != 0 {
+			if _, cancelErr := l.account.Order().CancelOrder(ctx, orderId); cancelErr != nil {
+				err = errors.Join(err, fmt.Errorf("cancel first leg %d: %w", orderId, cancelErr))
+			}
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// LinkOCO 把两张已挂出的订单组成 OCO
+func (l *OrderLinker) LinkOCO(firstOrderId, secondOrderId int64) *OCOPair {
+	p := l.newOCO(&linkedOrder{orderId: firstOrderId}, &linkedOrder{orderId: secondOrderId})
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, leg := range p.legs {
+		l.byOrder[leg.orderId] = leg
+	}
+	return p
+}
+
+func (l *OrderLinker) newOCO(first, second *linkedOrder) *OCOPair {
+	p := &OCOPair{linker: l, legs: [2]*linkedOrder{first, second}, done: make(chan struct{})}
+	for _, leg := range p.legs {
+		leg.handle = func(o *linkedOrder, ev *openapi.ProtoOAExecutionEvent) []linkAction {
+			switch ev.GetExecutionType() {
+			case openapi.ProtoOAExecutionType_ORDER_FILLED, openapi.ProtoOAExecutionType_ORDER_PARTIAL_FILL:
+				if p.filled == 0 {
+					p.filled = o.orderId
+				}
+				p.triggered = true
+			}
+			if o.done {
+				p.triggered = true
+			}
+			return p.settle()
+		}
+	}
+	return p
+}
+
+// settle 触发后撤销另一侧，两侧均结束后释放
+func (p *OCOPair) settle() []linkAction {
+	if !p.triggered {
+		return nil
+	}
+	actions := p.linker.cancelRemaining(p.legs[:])
+	if p.legs[0].done && p.legs[1].done {
+		p.linker.forgetLocked(p.legs[:]...)
+		select {
+		case <-p.done:
+		default:
+			close(p.done)
+		}
+	}
+	return actions
+}
+
+// OrderIds 两侧订单 ID，尚未得知时为 0
+func (p *OCOPair) OrderIds() [2]int64 {
+	p.linker.lock.Lock()
+	defer p.linker.lock.Unlock()
+	return [2]int64{p.legs[0].orderId, p.legs[1].orderId}
+}
+
+// Filled 先成交一侧的订单 ID，未成交时为 0
+func (p *OCOPair) Filled() int64 {
+	p.linker.lock.Lock()
+	defer p.linker.lock.Unlock()
+	return p.filled
+}
+
+// Done 两侧订单均结束后关闭
+func (p *OCOPair) Done() <-chan struct{} {
+	return p.done
+}
+
+// ScaleOut 分批止盈：入场成交后按各档价格挂出平仓限价单，持仓平完后撤销剩余的止盈单
+type ScaleOut struct {
+	linker     *OrderLinker
+	entry      *linkedOrder
+	targets    []*linkedOrder
+	positionId int64
+	closed     bool
+	done       chan struct{}
+}
+
+// ScaleOutTargets 把 volume 按 step 的整数倍平均分到各档价格，除不尽的步长从第一档起逐档多分一个 step
+//
+// volume 不是 step 的整数倍，或不足以让每档至少分到一个 step 时返回 ErrScaleOutVolume
+func ScaleOutTargets(volume, step int64, prices ...float64) ([]ScaleOutTarget, error) {
+	if len(prices) == 0 {
+		return nil, ErrScaleOutTargets
+	}
+	if step <= 0 {
+		step = 1
+	}
+	units := volume / step
+	if volume%step != 0 || units < int64(len(prices)) {
+		return nil, ErrScaleOutVolume
+	}
+	part, extra := units/int64(len(prices)), units%int64(len(prices))
+	targets := make([]ScaleOutTarget, len(prices))
+	for i, price := range prices {
+		n := part
+		if int64(i) < extra {
+			n++
+		}
+		targets[i] = ScaleOutTarget{Price: price, Volume: n * step}
+	}
+	return targets, nil
+}
+
+// ScaleOut 下入场单，成交后挂出分批止盈的平仓限价单；stopLoss 大于 0 时为持仓设置止损
+//
+// 入场单部分成交时不挂止盈单，以完全成交的事件为准
+func (l *OrderLinker) ScaleOut(ctx context.Context, symbolId int64, orderType openapi.ProtoOAOrderType, tradeSide openapi.ProtoOATradeSide, volume int64, stopLoss float64, targets []ScaleOutTarget, orderOption *OrderOption) (*ScaleOut, error) {
+	var total int64
+	for _, t := range targets {
+		if t.Volume <= 0 || t.Price <= 0 {
+			return nil, ErrScaleOutTargets
+		}
+		total += t.Volume
+	}
+	if len(targets) == 0 || total > volume {
+		return nil, ErrScaleOutTargets
+	}
+	opt := l.option(orderOption)
+	market := isMarketOrder(orderType)
+	if !market && stopLoss > 0 {
+		opt.stopLoss = stopLoss
+	}
+	closeSide := openapi.ProtoOATradeSide_SELL
+	if tradeSide == openapi.ProtoOATradeSide_SELL {
+		closeSide = openapi.ProtoOATradeSide_BUY
+	}
+
+	s := &ScaleOut{linker: l, entry: &linkedOrder{clientOrderId: opt.clientOrderId}, done: make(chan struct{})}
+	for range targets {
+		target := &linkedOrder{clientOrderId: l.nextClientOrderId()}
+		target.handle = func(o *linkedOrder, ev *openapi.ProtoOAExecutionEvent) []linkAction { return s.settle() }
+		s.targets = append(s.targets, target)
+	}
+	s.entry.handle = func(o *linkedOrder, ev *openapi.ProtoOAExecutionEvent) []linkAction {
+		if !o.done {
+			return nil
+		}
+		if ev.GetExecutionType() != openapi.ProtoOAExecutionType_ORDER_FILLED || ev.GetPosition().GetPositionId() == 0 {
+			// 入场单未成交即结束
+			s.closed = true
+			for _, target := range s.targets {
+				target.done = true
+			}
+			return s.settle()
+		}
+		s.positionId = ev.GetPosition().GetPositionId()
+		l.positions[s.positionId] = s.handlePosition
+		var actions []linkAction
+		if market && stopLoss > 0 {
+			actions = append(actions, l.protect(s.positionId, stopLoss, 0))
+		}
+		for i, t := range targets {
+			actions = append(actions, s.placeTarget(s.targets[i], symbolId, closeSide, t))
+		}
+		return actions
+	}
+	if _, err := l.place(ctx, s.entry, symbolId, orderType, tradeSide, volume, opt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ScaleOut) placeTarget(target *linkedOrder, symbolId int64, side openapi.ProtoOATradeSide, t ScaleOutTarget) linkAction {
+	return func(ctx context.Context) error {
+		l := s.linker
+		l.lock.Lock()
+		if s.closed {
+			target.done = true
+			actions := s.settle()
+			l.lock.Unlock()
+			return l.runActions(ctx, actions)
+		}
+		positionId := s.positionId
+		l.lock.Unlock()
+		opt := &OrderOption{}
+		opt.clientOrderId = target.clientOrderId
+		opt.limitPrice = t.Price
+		opt.positionId = positionId
+		_, err := l.place(ctx, target, symbolId, openapi.ProtoOAOrderType_LIMIT, side, t.Volume, opt)
+		if err != nil {
+			l.lock.Lock()
+			target.done = true
+			actions := s.settle()
+			l.lock.Unlock()
+			return errors.Join(fmt.Errorf("place take-profit at %v: %w", t.Price, err), l.runActions(ctx, actions))
+		}
+		return nil
+	}
+}
+
+func (s *ScaleOut) handlePosition(ev *openapi.ProtoOAExecutionEvent) []linkAction {
+	pos := ev.GetPosition()
+	if pos.GetPositionStatus() == openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED || pos.GetTradeData().GetVolume() == 0 {
+		s.closed = true
+	}
+	return s.settle()
+}
+
+// settle 持仓平完后撤销剩余止盈单，全部结束后释放
+func (s *ScaleOut) settle() []linkAction {
+	var actions []linkAction
+	if s.closed {
+		actions = s.linker.cancelRemaining(s.targets)
+	}
+	if !s.entry.done {
+		return actions
+	}
+	for _, target := range s.targets {
+		if !target.done {
+			return actions
+		}
+	}
+	s.linker.forgetLocked(append([]*linkedOrder{s.entry}, s.targets...)...)
+	delete(s.linker.positions, s.positionId)
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return actions
+}
+
+// PositionId 入场成交后的持仓 ID，未成交时为 0
+func (s *ScaleOut) PositionId() int64 {
+	s.linker.lock.Lock()
+	defer s.linker.lock.Unlock()
+	return s.positionId
+}
+
+// EntryOrderId 入场单 ID
+func (s *ScaleOut) EntryOrderId() int64 {
+	s.linker.lock.Lock()
+	defer s.linker.lock.Unlock()
+	return s.entry.orderId
+}
+
+// TargetOrderIds 各档止盈单 ID，尚未挂出时为 0
+func (s *ScaleOut) TargetOrderIds() []int64 {
+	s.linker.lock.Lock()
+	defer s.linker.lock.Unlock()
+	ids := make([]int64, len(s.targets))
+	for i, target := range s.targets {
+		ids[i] = target.orderId
+	}
+	return ids
+}
+
+// Done 入场单与各档止盈单均结束后关闭
+func (s *ScaleOut) Done() <-chan struct{} {
+	return s.done
+}
+
+// option 复制下单参数并补充 clientOrderId
+func (l *OrderLinker) option(orderOption *OrderOption) *OrderOption {
+	opt := &OrderOption{}
+	if orderOption != nil {
+		*opt = *orderOption
+	}
+	if opt.clientOrderId == "" {
+		opt.clientOrderId = l.nextClientOrderId()
+	}
+	return opt
+}
+
+func (l *OrderLinker) nextClientOrderId() string {
+	return fmt.Sprintf("%s-%d", l.prefix, l.seq.Add(1))
+}
+
+// place 先登记再下单，下单响应与事件一样交给 apply 处理
+func (l *OrderLinker) place(ctx context.Context, o *linkedOrder, symbolId int64, orderType openapi.ProtoOAOrderType, tradeSide openapi.ProtoOATradeSide, volume int64, opt *OrderOption) (*openapi.ProtoOAExecutionEvent, error) {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil, ErrOrderLinkerClosed
+	}
+	l.byClient[o.clientOrderId] = o
+	l.lock.Unlock()
+
+	res, err := l.account.Order().NewOrder(ctx, symbolId, orderType, tradeSide, volume, opt)
+	if err != nil {
+		l.forget(o)
+		return nil, err
+	}
+	l.runAsync(l.apply(res))
+	return res, nil
+}
+
+func (l *OrderLinker) protect(positionId int64, stopLoss, takeProfit float64) linkAction {
+	return func(ctx context.Context) error {
+		opt := &AmendPositionSLTPOption{stopLoss: stopLoss, takeProfit: takeProfit}
+		if _, err := l.account.Order().AmendOrderPositionSltp(ctx, positionId, opt); err != nil {
+			return fmt.Errorf("protect position %d: %w", positionId, err)
+		}
+		return nil
+	}
+}
+
+// cancelRemaining 撤销尚未结束且已知订单 ID 的订单，订单 ID 未知的在得知后再撤
+func (l *OrderLinker) cancelRemaining(orders []*linkedOrder) []linkAction {
+	var actions []linkAction
+	for _, o := range orders {
+		if o.done || o.cancelling || o.orderId == 0 {
+			continue
+		}
+		o.cancelling = true
+		orderId := o.orderId
+		actions = append(actions, func(ctx context.Context) error {
+			res, err := l.account.Order().CancelOrder(ctx, orderId)
+			if err != nil {
+				return fmt.Errorf("cancel linked order %d: %w", orderId, err)
+			}
+			// 撤单结果只在响应中返回，不会再作为事件推送到本连接
+			return l.runActions(ctx, l.apply(res))
+		})
+	}
+	return actions
+}
+
+func (l *OrderLinker) forget(orders ...*linkedOrder) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.forgetLocked(orders...)
+}
+
+func (l *OrderLinker) forgetLocked(orders ...*linkedOrder) {
+	for _, o := range orders {
+		if o.clientOrderId != "" && l.byClient[o.clientOrderId] == o {
+			delete(l.byClient, o.clientOrderId)
+		}
+		if o.orderId != 0 && l.byOrder[o.orderId] == o {
+			delete(l.byOrder, o.orderId)
+		}
+	}
+}
+
+// handleExecution 在消息循环中执行，联动产生的请求放到单独的 goroutine 中发送
+func (l *OrderLinker) handleExecution(msg *openapi.ProtoMessage) {
+	ev := &openapi.ProtoOAExecutionEvent{}
+	if err := proto.Unmarshal(msg.Payload, ev); err != nil || ev.GetCtidTraderAccountId() != l.account.accountId {
+		return
+	}
+	l.runAsync(l.apply(ev))
+}
+
+// apply 根据执行事件更新联动订单，返回需要发送的请求
+func (l *OrderLinker) apply(ev *openapi.ProtoOAExecutionEvent) []linkAction {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var actions []linkAction
+	if order := ev.GetOrder(); order != nil {
+		o := l.byClient[order.GetClientOrderId()]
+		if o == nil {
+			o = l.byOrder[order.GetOrderId()]
+		}
+		// 下单响应可能晚于成交事件到达，已结束的订单忽略后到的旧状态
+		if o != nil && !o.done {
+			if o.orderId == 0 && order.GetOrderId() != 0 {
+				o.orderId = order.GetOrderId()
+				l.byOrder[o.orderId] = o
+			}
+			if isFinalOrderStatus(order.GetOrderStatus()) {
+				o.done = true
+			}
+			actions = append(actions, o.handle(o, ev)...)
+		}
+	}
+	if pos := ev.GetPosition(); pos != nil {
+		if handle := l.positions[pos.GetPositionId()]; handle != nil {
+			actions = append(actions, handle(ev)...)
+		}
+	}
+	return actions
+}
+
+func (l *OrderLinker) runAsync(actions []linkAction) {
+	if len(actions) == 0 {
+		return
+	}
+	go func() {
+		if err := l.runActions(context.Background(), actions); err != nil {
+			l.reportError(err)
+		}
+	}()
+}
+
+func (l *OrderLinker) runActions(ctx context.Context, actions []linkAction) error {
+	var errs []error
+	for _, action := range actions {
+		if err := action(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *OrderLinker) reportError(err error) {
+	l.lock.Lock()
+	handler := l.onError
+	l.lock.Unlock()
+	if handler != nil {
+		handler(err)
+		return
+	}
+	l.account.conn().log().Warn("ctrago order linker action failed", "accountId", l.account.accountId, "error", err)
+}
+
+func isMarketOrder(orderType openapi.ProtoOAOrderType) bool {
+	return orderType == openapi.ProtoOAOrderType_MARKET || orderType == openapi.ProtoOAOrderType_MARKET_RANGE
+}
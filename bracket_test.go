@@ -0,0 +1,172 @@
+package ctrago_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+)
+
+func newLinker(t *testing.T, srv *ctragotest.Server, ctx context.Context) (*ctrago.Account, *ctrago.OrderLinker) {
+	t.Helper()
+	client := ctrago.NewClientWithTransport(srv.NewTransport(), ctragotest.DefaultClientId, ctragotest.DefaultClientSecret, ctragotest.DefaultAccessToken)
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := client.Account(ctragotest.DefaultAccountId)
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	linker := ctrago.NewOrderLinker(account)
+	linker.OnError(func(err error) { t.Errorf("linker: %v", err) })
+	return account, linker
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for linked orders")
+	}
+}
+
+func TestOrderLinker_OCO(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account, linker := newLinker(t, srv, ctx)
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+
+	buyStop := &ctrago.OrderOption{}
+	buyStop.WithStopPrice(1.1010)
+	sellStop := &ctrago.OrderOption{}
+	sellStop.WithStopPrice(1.0990)
+	pair, err := linker.OCO(ctx,
+		ctrago.OrderSpec{SymbolId: ctragotest.DefaultSymbolId, OrderType: openapi.ProtoOAOrderType_STOP, TradeSide: openapi.ProtoOATradeSide_BUY, Volume: 100000, Option: buyStop},
+		ctrago.OrderSpec{SymbolId: ctragotest.DefaultSymbolId, OrderType: openapi.ProtoOAOrderType_STOP, TradeSide: openapi.ProtoOATradeSide_SELL, Volume: 100000, Option: sellStop},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 买入止损单触发后撤销卖出止损单
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1011, 1.1013)
+	waitDone(t, pair.Done())
+	if ids := pair.OrderIds(); pair.Filled() != ids[0] {
+		t.Fatalf("filled %d, legs %v", pair.Filled(), ids)
+	}
+	res, err := account.Trader().Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Order) != 0 || len(res.Position) != 1 {
+		t.Fatalf("orders = %d, positions = %d", len(res.Order), len(res.Position))
+	}
+}
+
+func TestOrderLinker_BracketAndScaleOut(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account, linker := newLinker(t, srv, ctx)
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+
+	targets, err := ctrago.ScaleOutTargets(300000, 100000, 1.1020, 1.1030, 1.1040)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scale, err := linker.ScaleOut(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 300000, 1.0950, targets, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for {
+		ids = scale.TargetOrderIds()
+		if ids[0] != 0 && ids[1] != 0 && ids[2] != 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("take-profit orders not placed: %v", ids)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	res, err := account.Trader().Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Order) != 3 || len(res.Position) != 1 || res.Position[0].GetStopLoss() != 1.0950 {
+		t.Fatalf("reconcile = %v", res)
+	}
+
+	// 第一档成交后手动平掉剩余持仓，其余两档被撤销
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1025, 1.1027)
+	for {
+		res, err = account.Trader().Reconcile(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Order) == 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if res.Position[0].GetTradeData().GetVolume() != 200000 {
+		t.Fatalf("remaining volume = %d", res.Position[0].GetTradeData().GetVolume())
+	}
+	if _, err := account.Order().ClosePosition(ctx, scale.PositionId(), 200000); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, scale.Done())
+	if res, err = account.Trader().Reconcile(ctx, false); err != nil || len(res.Order) != 0 || len(res.Position) != 0 {
+		t.Fatalf("reconcile after close = %v, %v", res, err)
+	}
+
+	// 市价入场成交后再设置持仓止损止盈
+	if _, err := linker.Bracket(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_SELL, 100000, 1.1100, 1.0900, nil); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		res, err = account.Trader().Reconcile(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Position) == 1 && res.Position[0].GetTakeProfit() == 1.0900 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("position not protected: %v", res.Position)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if res.Position[0].GetStopLoss() != 1.1100 {
+		t.Fatalf("stop loss = %v", res.Position[0].GetStopLoss())
+	}
+}
+
+func TestScaleOutTargets(t *testing.T) {
+	targets, err := ctrago.ScaleOutTargets(500000, 100000, 1.1020, 1.1030, 1.1040)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{200000, 200000, 100000}
+	for i, target := range targets {
+		if target.Volume != want[i] {
+			t.Fatalf("targets = %+v, want volumes %v", targets, want)
+		}
+	}
+	// 不足每档一个步长或不合步长时不生成目标
+	for _, volume := range []int64{200000, 350000} {
+		if _, err := ctrago.ScaleOutTargets(volume, 100000, 1.1020, 1.1030, 1.1040); err != ctrago.ErrScaleOutVolume {
+			t.Errorf("volume %d: expected ErrScaleOutVolume, got %v", volume, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+// ErrMarketClosed 品种在下单时间不在交易时段内或处于假期
+var ErrMarketClosed = errors.New("market is closed")
+
+const (
+	calendarWeek = 7 * 24 * time.Hour
+	// calendarHorizon NextOpen、NextClose 向后查找的范围
+	calendarHorizon = 366 * 24 * time.Hour
+	// calendarCacheTTL MarketOpen 缓存品种日历的时长
+	calendarCacheTTL = 6 * time.Hour
+)
+
+// Session 连续的可交易时段 [Open, Close)
+type Session struct {
+	Open  time.Time
+	Close time.Time
+}
+
+// Calendar 品种的交易日历，由 ProtoOASymbol 的 schedule、scheduleTimeZone 与 holiday 构建
+//
+// schedule 为空时视为全天候可交易，仅扣除假期
+type Calendar struct {
+	symbolId int64
+	loc      *time.Location
+	// schedule 每周的交易时段，从周日 00:00（scheduleTimeZone）起算的秒数
+	schedule []*openapi.ProtoOAInterval
+	holidays []calendarHoliday
+}
+
+type calendarHoliday struct {
+	loc *time.Location
+	// date 假期日期（取年月日），recurring 时每年同月同日
+	date      time.Time
+	recurring bool
+	// start、end 假期在当天的起止秒数
+	start, end int32
+}
+
+// NewCalendar 由品种定义创建交易日历，时区无法识别时返回错误
+func NewCalendar(symbol *openapi.ProtoOASymbol) (*Calendar, error) {
+	loc, err := loadScheduleLocation(symbol.GetScheduleTimeZone())
+	if err != nil {
+		return nil, fmt.Errorf("symbol %d: %w", symbol.GetSymbolId(), err)
+	}
+	c := &Calendar{symbolId: symbol.GetSymbolId(), loc: loc, schedule: symbol.GetSchedule()}
+	for _, h := range symbol.GetHoliday() {
+		hloc := loc
+		if h.GetScheduleTimeZone() != "" {
+			if hloc, err = loadScheduleLocation(h.GetScheduleTimeZone()); err != nil {
+				return nil, fmt.Errorf("symbol %d holiday %d: %w", symbol.GetSymbolId(), h.GetHolidayId(), err)
+			}
+		}
+		end := h.GetEndSecond()
+		if end <= h.GetStartSecond() {
+			end = 24 * 60 * 60
+		}
+		c.holidays = append(c.holidays, calendarHoliday{
+			loc:       hloc,
+			date:      time.UnixMilli(h.GetHolidayDate() * 86400000).UTC(),
+			recurring: h.GetIsRecurring(),
+			start:     h.GetStartSecond(),
+			end:       end,
+		})
+	}
+	return c, nil
+}
+
+func loadScheduleLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// SymbolId 返回日历所属的品种
+func (c *Calendar) SymbolId() int64 {
+	return c.symbolId
+}
+
+// TradableAt 判断 t 是否在交易时段内且不在假期
+func (c *Calendar) TradableAt(t time.Time) bool {
+	return len(c.Sessions(t, t.Add(time.Nanosecond))) > 0
+}
+
+// NextOpen 返回 t 及之后最早可交易的时间，t 可交易时返回 t；一年内没有交易时段时返回 false
+func (c *Calendar) NextOpen(t time.Time) (time.Time, bool) {
+	sessions := c.Sessions(t, t.Add(calendarHorizon))
+	if len(sessions) == 0 {
+		return time.Time{}, false
+	}
+	return sessions[0].Open, true
+}
+
+// NextClose 返回 t 之后最近的收盘时间（t 可交易时为当前时段的结束）；一年内不收盘时返回 false
+func (c *Calendar) NextClose(t time.Time) (time.Time, bool) {
+	end := t.Add(calendarHorizon)
+	for _, s := range c.Sessions(t, end) {
+		if s.Close.Before(end) {
+			return s.Close, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Sessions 返回 [from, to) 内的交易时段，首尾时段按范围截断，已扣除假期
+func (c *Calendar) Sessions(from, to time.Time) []Session {
+	if !from.Before(to) {
+		return nil
+	}
+	start := c.weekStart(from)
+	end := c.weekStart(to).Add(calendarWeek)
+	var sessions []Session
+	if len(c.schedule) == 0 {
+		sessions = []Session{{Open: start, Close: end}}
+	} else {
+		// 加半天再取周起点，避免夏令时切换使一周不足 168 小时
+		for ws := start; ws.Before(end); ws = c.weekStart(ws.Add(calendarWeek + 12*time.Hour)) {
+			y, m, d := ws.Date()
+			for _, interval := range c.schedule {
+				sessions = append(sessions, Session{
+					Open:  time.Date(y, m, d, 0, 0, int(interval.GetStartSecond()), 0, c.loc),
+					Close: time.Date(y, m, d, 0, 0, int(interval.GetEndSecond()), 0, c.loc),
+				})
+			}
+		}
+		sessions = mergeSessions(sessions)
+	}
+	for _, h := range c.holidayWindows(start, end) {
+		sessions = subtractSession(sessions, h)
+	}
+	result := sessions[:0]
+	for _, s := range sessions {
+		if s.Open.Before(from) {
+			s.Open = from
+		}
+		if s.Close.After(to) {
+			s.Close = to
+		}
+		if s.Open.Before(s.Close) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// weekStart t 所在周的周日 00:00（日历时区）
+func (c *Calendar) weekStart(t time.Time) time.Time {
+	t = t.In(c.loc)
+	y, m, d := t.Date()
+	return time.Date(y, m, d-int(t.Weekday()), 0, 0, 0, 0, c.loc)
+}
+
+// holidayWindows 与 [from, to) 相交的假期时段，重复假期按年展开
+func (c *Calendar) holidayWindows(from, to time.Time) []Session {
+	var windows []Session
+	add := func(h calendarHoliday, y int, m time.Month, d int) {
+		w := Session{
+			Open:  time.Date(y, m, d, 0, 0, int(h.start), 0, h.loc),
+			Close: time.Date(y, m, d, 0, 0, int(h.end), 0, h.loc),
+		}
+		if w.Open.Before(to) && w.Close.After(from) {
+			windows = append(windows, w)
+		}
+	}
+	for _, h := range c.holidays {
+		y, m, d := h.date.Date()
+		if !h.recurring {
+			add(h, y, m, d)
+			continue
+		}
+		for year := from.Year() - 1; year <= to.Year()+1; year++ {
+			add(h, year, m, d)
+		}
+	}
+	return windows
+}
+
+// mergeSessions 按开盘时间排序并合并重叠或首尾相接的时段
+func mergeSessions(sessions []Session) []Session {
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Open.Before(sessions[j].Open) })
+	var merged []Session
+	for _, s := range sessions {
+		if !s.Open.Before(s.Close) {
+			continue
+		}
+		if n := len(merged); n > 0 && !s.Open.After(merged[n-1].Close) {
+			if s.Close.After(merged[n-1].Close) {
+				merged[n-1].Close = s.Close
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// subtractSession 从各时段中扣除 cut
+func subtractSession(sessions []Session, cut Session) []Session {
+	var result []Session
+	for _, s := range sessions {
+		if !cut.Open.Before(s.Close) || !cut.Close.After(s.Open) {
+			result = append(result, s)
+			continue
+		}
+		if s.Open.Before(cut.Open) {
+			result = append(result, Session{Open: s.Open, Close: cut.Open})
+		}
+		if cut.Close.Before(s.Close) {
+			result = append(result, Session{Open: cut.Close, Close: s.Close})
+		}
+	}
+	return result
+}
+
+// Calendar 查询品种定义并创建交易日历
+func (a *AccountSymbol) Calendar(ctx context.Context, symbolId int64) (*Calendar, error) {
+	res, err := a.SymbolById(ctx, []int64{symbolId})
+	if err != nil {
+		return nil, err
+	}
+	for _, symbol := range res.GetSymbol() {
+		if symbol.GetSymbolId() == symbolId {
+			return NewCalendar(symbol)
+		}
+	}
+	return nil, fmt.Errorf("symbol %d not found", symbolId)
+}
+
+// MarketOpen 在品种休市（不在交易时段内或处于假期）时拒绝下单
+//
+// 各账户品种的日历在首次使用时查询，缓存 6 小时
+func MarketOpen() RiskRule {
+	type key struct{ accountId, symbolId int64 }
+	type entry struct {
+		calendar *Calendar
+		loaded   time.Time
+	}
+	var lock sync.Mutex
+	cache := make(map[key]entry)
+	return NewRiskRule("market-open", func(ctx context.Context, order *OrderCheck) error {
+		k := key{order.Account.AccountId(), order.Request.GetSymbolId()}
+		lock.Lock()
+		e, ok := cache[k]
+		lock.Unlock()
+		if !ok || order.Time.Sub(e.loaded) > calendarCacheTTL {
+			calendar, err := order.Account.Symbol().Calendar(ctx, k.symbolId)
+			if err != nil {
+				return err
+			}
+			e = entry{calendar: calendar, loaded: order.Time}
+			lock.Lock()
+			cache[k] = e
+			lock.Unlock()
+		}
+		if e.calendar.TradableAt(order.Time) {
+			return nil
+		}
+		if next, ok := e.calendar.NextOpen(order.Time); ok {
+			return fmt.Errorf("%w: symbol %d opens at %s", ErrMarketClosed, k.symbolId, next.Format(time.RFC3339))
+		}
+		return fmt.Errorf("%w: symbol %d has no upcoming session", ErrMarketClosed, k.symbolId)
+	})
+}
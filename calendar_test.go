@@ -0,0 +1,129 @@
+package ctrago_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func holiday(id int64, date time.Time, recurring bool, start, end int32) *openapi.ProtoOAHoliday {
+	return &openapi.ProtoOAHoliday{
+		HolidayId:        proto.Int64(id),
+		Name:             proto.String("holiday"),
+		ScheduleTimeZone: proto.String("America/New_York"),
+		HolidayDate:      proto.Int64(date.Unix() / 86400),
+		IsRecurring:      proto.Bool(recurring),
+		StartSecond:      proto.Int32(start),
+		EndSecond:        proto.Int32(end),
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, ny)
+	}
+	// 周日 17:00 至周五 17:00（纽约时间），感恩节 13:00 起休市，圣诞节每年全天休市
+	calendar, err := ctrago.NewCalendar(&openapi.ProtoOASymbol{
+		SymbolId:         proto.Int64(1),
+		ScheduleTimeZone: proto.String("America/New_York"),
+		Schedule: []*openapi.ProtoOAInterval{
+			{StartSecond: proto.Uint32(17 * 3600), EndSecond: proto.Uint32(5*86400 + 17*3600)},
+		},
+		Holiday: []*openapi.ProtoOAHoliday{
+			holiday(1, time.Date(2026, 11, 26, 0, 0, 0, 0, time.UTC), false, 13*3600, 0),
+			holiday(2, time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC), true, 0, 0),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tradable := map[time.Time]bool{
+		at(10, 19, 12): true,  // 周一
+		at(10, 23, 16): true,  // 周五收盘前
+		at(10, 23, 17): false, // 周五收盘
+		at(10, 24, 12): false, // 周六
+		at(11, 26, 12): true,  // 感恩节上午
+		at(11, 26, 14): false, // 感恩节下午
+		at(12, 25, 10): false, // 圣诞节
+	}
+	for ts, want := range tradable {
+		if got := calendar.TradableAt(ts); got != want {
+			t.Errorf("TradableAt(%s) = %v, want %v", ts, got, want)
+		}
+	}
+
+	expect := func(name string, got time.Time, ok bool, want time.Time) {
+		t.Helper()
+		if !ok || !got.Equal(want) {
+			t.Errorf("%s = %s (%v), want %s", name, got, ok, want)
+		}
+	}
+	open, ok := calendar.NextOpen(at(10, 24, 12))
+	expect("NextOpen(Saturday)", open, ok, at(10, 25, 17))
+	open, ok = calendar.NextOpen(at(10, 19, 12))
+	expect("NextOpen(open)", open, ok, at(10, 19, 12))
+	// 夏令时在 11 月 1 日结束，开盘仍为当地 17:00
+	open, ok = calendar.NextOpen(at(10, 31, 12))
+	expect("NextOpen(DST end)", open, ok, at(11, 1, 17))
+	open, ok = calendar.NextOpen(at(11, 26, 14))
+	expect("NextOpen(Thanksgiving)", open, ok, at(11, 27, 0))
+	closeAt, ok := calendar.NextClose(at(10, 19, 12))
+	expect("NextClose(Monday)", closeAt, ok, at(10, 23, 17))
+
+	sessions := calendar.Sessions(at(12, 20, 0), at(12, 27, 0))
+	if len(sessions) != 1 || !sessions[0].Open.Equal(at(12, 20, 17)) || !sessions[0].Close.Equal(at(12, 25, 0)) {
+		t.Errorf("unexpected Christmas week sessions %v", sessions)
+	}
+	if n := len(calendar.Sessions(at(10, 18, 0), at(11, 15, 0))); n != 4 {
+		t.Errorf("expected 4 weekly sessions, got %d", n)
+	}
+
+	always, err := ctrago.NewCalendar(&openapi.ProtoOASymbol{SymbolId: proto.Int64(2), Schedule: []*openapi.ProtoOAInterval{
+		{StartSecond: proto.Uint32(0), EndSecond: proto.Uint32(7 * 86400)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := always.NextClose(at(10, 19, 12)); ok || !always.TradableAt(at(10, 24, 12)) {
+		t.Error("24/7 schedule should never close")
+	}
+	if _, err := ctrago.NewCalendar(&openapi.ProtoOASymbol{ScheduleTimeZone: proto.String("Nowhere/Invalid")}); err == nil {
+		t.Error("expected error for unknown time zone")
+	}
+}
+
+func TestMarketOpen(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 品种 2 今明两天休市
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	srv.AddSymbol(ctragotest.Symbol{Id: 2, Name: "XAUUSD", Digits: 2, PipPosition: 1, Holidays: []*openapi.ProtoOAHoliday{
+		{HolidayId: proto.Int64(1), Name: proto.String("closed"), ScheduleTimeZone: proto.String("UTC"), HolidayDate: proto.Int64(today.Unix() / 86400), IsRecurring: proto.Bool(false)},
+		{HolidayId: proto.Int64(2), Name: proto.String("closed"), ScheduleTimeZone: proto.String("UTC"), HolidayDate: proto.Int64(today.Unix()/86400 + 1), IsRecurring: proto.Bool(false)},
+	}})
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	srv.SetPrice(2, 2400, 2400.5)
+	account := newRiskAccount(t, ctx, srv, ctrago.MarketOpen())
+
+	_, err := account.Order().NewOrder(ctx, 2, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil)
+	expectRejected(t, err, "market-open")
+	if !errors.Is(err, ctrago.ErrMarketClosed) {
+		t.Fatalf("expected ErrMarketClosed, got %v", err)
+	}
+	if _, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil); err != nil {
+		t.Fatalf("open market rejected: %v", err)
+	}
+}
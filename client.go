@@ -2,12 +2,15 @@ package ctrago
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/yockii/ctrago/openapi"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -24,6 +27,32 @@ type Transport interface {
 	SetHeartbeat(heartbeatInterval time.Duration, heartbeatFn func() (int, []byte))
 }
 
+// TransportEvent 传输层连接事件
+type TransportEvent int
+
+const (
+	// TransportConnected 连接已建立（包括重连成功）
+	TransportConnected TransportEvent = iota + 1
+	// TransportDisconnected 连接已断开
+	TransportDisconnected
+	// TransportReconnecting 开始重连
+	TransportReconnecting
+	// TransportClosed 连接已被主动关闭，不会再重连
+	TransportClosed
+)
+
+type TransportEventHandler func(event TransportEvent, err error)
+
+// TransportNotifier 可选接口，传输层实现后 Client 可感知连接的断开与重连
+//
+// 注册时如果连接已经建立，应立即以 TransportConnected 回调一次
+type TransportNotifier interface {
+	OnTransportEvent(handler TransportEventHandler)
+}
+
+// ErrTransportClosed 传输层已被 Close，不再建立连接
+var ErrTransportClosed = errors.New("transport is closed")
+
 type ResponseHandler func(*openapi.ProtoMessage)
 
 // 修改Client结构体，底层通信改为Transport接口
@@ -34,10 +63,32 @@ type Client struct {
 	lock          sync.Mutex
 	pending       map[string]chan *openapi.ProtoMessage
 	eventHandlers map[uint32][]ResponseHandler
+	// watchers 可注销的事件回调，见 WatchEvent
+	watchers  map[uint64]eventWatcher
+	watcherId uint64
+
+	state              ConnState
+	stateHandlers      []StateChangeHandler
+	disconnectHandlers []DisconnectHandler
+	authorized         map[int64]struct{}
 
 	clientId     string
 	clientSecret string
 	accessToken  string
+	// tokenSource 非空时 accessToken 为最近一次从中获取的令牌
+	tokenSource      TokenSource
+	tokenLoopStarted bool
+
+	requestTimeout  time.Duration
+	payloadTimeouts map[uint32]time.Duration
+	logger          *slog.Logger
+	metrics         Metrics
+	tracing         *orderTracer
+	marketData      MarketDataStore
+	brokers         map[int64]string
+	riskRules       []RiskRule
+	halts           haltRegistry
+	spots           spotRegistry
 }
 
 func NewClientWithTransport(transport Transport, clientId, clientSecret, accessToken string) *Client {
@@ -45,53 +96,45 @@ func NewClientWithTransport(transport Transport, clientId, clientSecret, accessT
 		transport:     transport,
 		pending:       make(map[string]chan *openapi.ProtoMessage),
 		eventHandlers: make(map[uint32][]ResponseHandler),
+		state:         StateConnected,
+		authorized:    make(map[int64]struct{}),
 		clientId:      clientId,
 		clientSecret:  clientSecret,
 		accessToken:   accessToken,
-	}
-	transport.OnMessage(c.handleMessage)
-	return c
-}
 
-// endpointByEnv 根据 isLive 和 isWebsocket 返回对应的 endpoint
-func endpointByEnv(isLive, isWebsocket bool) string {
-	host := "demo.ctraderapi.com:5035"
-	if isLive {
-		host = "live.ctraderapi.com:5035"
+		requestTimeout:  DefaultRequestTimeout,
+		payloadTimeouts: defaultPayloadTimeouts(),
+		logger:          discardLogger(),
+		metrics:         NopMetrics{},
+		tracing:         newOrderTracer(noop.NewTracerProvider()),
 	}
-	if isWebsocket {
-		return "wss://" + host
+	transport.OnMessage(c.handleMessage)
+	if notifier, ok := transport.(TransportNotifier); ok {
+		c.state = StateConnecting
+		notifier.OnTransportEvent(c.handleTransportEvent)
 	}
-	return host
+	return c
 }
 
 // NewClientWithWebsocket 使用 WebSocket 创建 Client，自动选择 endpoint
 func NewClientWithWebsocket(isLive bool, clientId, clientSecret, accessToken string, heartbeatInterval time.Duration) (*Client, error) {
-	addr := endpointByEnv(isLive, true)
-	ws, err := NewWsClientWithHeartbeat(addr, nil, 0, nil)
-	if err != nil {
-		return nil, err
-	}
-	client := NewClientWithTransport(ws, clientId, clientSecret, accessToken)
-	ws.SetHeartbeat(heartbeatInterval, func() (int, []byte) {
-		hb := &openapi.ProtoHeartbeatEvent{}
-		data, _ := proto.Marshal(hb)
-		return websocket.BinaryMessage, data
-	})
-	go ws.Listen()
-	return client, nil
+	return New(
+		WithLive(isLive),
+		WithTransportKind(TransportWebsocket),
+		WithCredentials(clientId, clientSecret),
+		WithAccessToken(accessToken),
+		WithHeartbeat(heartbeatInterval),
+	)
 }
 
 // NewClientWithTcp 使用 TCP 创建 Client，自动选择 endpoint
 func NewClientWithTcp(isLive bool, clientId, clientSecret, accessToken string) (*Client, error) {
-	addr := endpointByEnv(isLive, false)
-	tcp, err := NewTcpClient(addr)
-	if err != nil {
-		return nil, err
-	}
-	client := NewClientWithTransport(tcp, clientId, clientSecret, accessToken)
-	go tcp.Listen()
-	return client, nil
+	return New(
+		WithLive(isLive),
+		WithTransportKind(TransportTCP),
+		WithCredentials(clientId, clientSecret),
+		WithAccessToken(accessToken),
+	)
 }
 
 // NewClient 默认使用 WebSocket 方式创建 Client，自动选择 endpoint
@@ -106,8 +149,55 @@ func (c *Client) nextMsgId() string {
 	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), c.msgId)
 }
 
+// SendRequest 发送请求并等待响应；ProtoOAErrorRes、ProtoErrorRes 与 ProtoOAOrderErrorEvent 同样作为响应返回，
+// 需要以 error 处理时用 CheckResponse 包装
 func (c *Client) SendRequest(ctx context.Context, payloadType uint32, payload proto.Message) (*openapi.ProtoMessage, error) {
 	msgId := c.nextMsgId()
+	start := time.Now()
+	ctx, span := c.orderTracer().startRequestSpan(ctx, msgId, payloadType, payload)
+	resp, err := c.roundTrip(ctx, msgId, payloadType, payload)
+	// 指标与追踪按原始响应记录错误码
+	apiErr := responseError(resp)
+	c.endRequestSpan(span, resp, err, apiErr)
+	metrics := c.metricsRecorder()
+	name := PayloadTypeName(payloadType)
+	outcome := requestOutcome(err)
+	if apiErr != nil {
+		outcome = OutcomeError
+		metrics.IncErrorCode(name, apiErr.ErrorCode)
+	}
+	metrics.ObserveRequest(name, outcome, time.Since(start))
+	return resp, err
+}
+
+// CheckResponse 将 SendRequest 返回的错误类响应转换为 *APIError，用法：
+//
+//	resp, err := ctrago.CheckResponse(client.SendRequest(ctx, payloadType, req))
+func CheckResponse(resp *openapi.ProtoMessage, err error) (*openapi.ProtoMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+	if apiErr := responseError(resp); apiErr != nil {
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// request 发送请求，错误类响应以 *APIError 返回，供各类型化方法使用
+func (c *Client) request(ctx context.Context, payloadType uint32, payload proto.Message) (*openapi.ProtoMessage, error) {
+	return CheckResponse(c.SendRequest(ctx, payloadType, payload))
+}
+
+// roundTrip 发送请求并等待 clientMsgId 对应的响应，错误类响应原样返回
+func (c *Client) roundTrip(ctx context.Context, msgId string, payloadType uint32, payload proto.Message) (*openapi.ProtoMessage, error) {
+	var timeout time.Duration
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout = c.requestTimeoutFor(ctx, payloadType); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
 	data, err := proto.Marshal(payload)
 	if err != nil {
 		return nil, err
@@ -121,28 +211,61 @@ func (c *Client) SendRequest(ctx context.Context, payloadType uint32, payload pr
 	if err != nil {
 		return nil, err
 	}
+	logger := c.log().With("payloadType", PayloadTypeName(payloadType), "clientMsgId", msgId)
 	ch := make(chan *openapi.ProtoMessage, 1)
 	c.lock.Lock()
 	c.pending[msgId] = ch
+	pending := len(c.pending)
 	c.lock.Unlock()
+	c.metricsRecorder().SetPendingRequests(pending)
+	start := time.Now()
+	logger.Debug("ctrago request sent", "payload", redacted{payload})
 	err = c.transport.Send(websocket.BinaryMessage, raw)
 	if err != nil {
+		c.removePending(msgId)
+		logger.Warn("ctrago request send failed", "error", err)
 		return nil, err
 	}
 	select {
-	case resp := <-ch:
+	case resp, ok := <-ch:
+		if !ok {
+			logger.Warn("ctrago connection lost before response", "latency", time.Since(start))
+			return nil, ErrConnectionLost
+		}
+		if apiErr := responseError(resp); apiErr != nil {
+			logger.Warn("ctrago error response", "errorCode", apiErr.ErrorCode, "description", apiErr.Description, "latency", time.Since(start))
+			return resp, nil
+		}
+		logger.Debug("ctrago response received", "responseType", PayloadTypeName(resp.GetPayloadType()), "latency", time.Since(start))
 		return resp, nil
 	case <-ctx.Done():
+		c.removePending(msgId)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logger.Warn("ctrago request timed out", "timeout", timeout, "latency", time.Since(start))
+			return nil, &TimeoutError{PayloadType: payloadType, ClientMsgId: msgId, Timeout: timeout}
+		}
+		logger.Debug("ctrago request cancelled", "error", ctx.Err(), "latency", time.Since(start))
 		return nil, ctx.Err()
 	}
 }
 
+func (c *Client) removePending(msgId string) {
+	c.lock.Lock()
+	delete(c.pending, msgId)
+	pending := len(c.pending)
+	metrics := c.metrics
+	c.lock.Unlock()
+	metrics.SetPendingRequests(pending)
+}
+
 func (c *Client) handleMessage(messageType int, data []byte) {
 	if messageType != websocket.BinaryMessage {
+		c.log().Debug("ctrago dropped non-binary frame", "messageType", messageType, "size", len(data))
 		return
 	}
 	msg := &openapi.ProtoMessage{}
 	if err := proto.Unmarshal(data, msg); err != nil {
+		c.log().Warn("ctrago dropped unparseable frame", "size", len(data), "error", err)
 		return
 	}
 	if msg.ClientMsgId != nil && *msg.ClientMsgId != "" {
@@ -151,20 +274,44 @@ func (c *Client) handleMessage(messageType int, data []byte) {
 		if ok {
 			delete(c.pending, *msg.ClientMsgId)
 		}
+		pending := len(c.pending)
+		metrics := c.metrics
 		c.lock.Unlock()
 		if ok {
+			metrics.SetPendingRequests(pending)
 			ch <- msg
+		} else {
+			c.log().Debug("ctrago dropped response for unknown or expired request", "payloadType", PayloadTypeName(msg.GetPayloadType()), "clientMsgId", msg.GetClientMsgId())
 		}
 		return
 	}
 	// 事件推送
+	c.handleDisconnectEvent(msg)
+	metrics := c.metricsRecorder()
+	metrics.IncEvent(PayloadTypeName(msg.GetPayloadType()))
+	if code := eventErrorCode(msg); code != "" {
+		metrics.IncErrorCode(PayloadTypeName(msg.GetPayloadType()), code)
+	}
+	c.traceExecutionEvent(msg)
 	if msg.PayloadType != nil {
 		c.lock.Lock()
 		handlers := c.eventHandlers[*msg.PayloadType]
+		for _, w := range c.watchers {
+			if w.handler != nil && w.payloadType == *msg.PayloadType {
+				handlers = append(handlers[:len(handlers):len(handlers)], w.handler)
+			}
+		}
 		c.lock.Unlock()
 		for _, h := range handlers {
 			h(msg)
 		}
+		if len(handlers) == 0 && msg.GetPayloadType() != uint32(openapi.ProtoPayloadType_HEARTBEAT_EVENT) {
+			if _, known := openapi.ProtoOAPayloadType_name[int32(msg.GetPayloadType())]; known {
+				c.log().Debug("ctrago unhandled event", "payloadType", PayloadTypeName(msg.GetPayloadType()))
+			} else {
+				c.log().Warn("ctrago unknown payload type", "payloadType", msg.GetPayloadType(), "size", len(msg.Payload))
+			}
+		}
 	}
 }
 
@@ -174,8 +321,39 @@ func (c *Client) OnEvent(payloadType uint32, handler ResponseHandler) {
 	c.eventHandlers[payloadType] = append(c.eventHandlers[payloadType], handler)
 }
 
+// eventWatcher 可注销的回调，handler、state、disconnect 中只有一个非 nil
+type eventWatcher struct {
+	payloadType uint32
+	handler     ResponseHandler
+	state       StateChangeHandler
+	disconnect  DisconnectHandler
+}
+
+// WatchEvent 注册可注销的事件回调，返回注销函数；适合生命周期有限的组件，常驻回调使用 OnEvent
+func (c *Client) WatchEvent(payloadType uint32, handler ResponseHandler) (stop func()) {
+	return c.watch(eventWatcher{payloadType: payloadType, handler: handler})
+}
+
+func (c *Client) watch(w eventWatcher) (stop func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.watchers == nil {
+		c.watchers = make(map[uint64]eventWatcher)
+	}
+	c.watcherId++
+	id := c.watcherId
+	c.watchers[id] = w
+	return func() {
+		c.lock.Lock()
+		delete(c.watchers, id)
+		c.lock.Unlock()
+	}
+}
+
 func (c *Client) Close() error {
-	return c.transport.Close()
+	err := c.transport.Close()
+	c.setState(StateClosed)
+	return err
 }
 
 // ApplicationAuth 应用鉴权
@@ -184,7 +362,7 @@ func (c *Client) ApplicationAuth(ctx context.Context) (*openapi.ProtoOAApplicati
 		ClientId:     &c.clientId,
 		ClientSecret: &c.clientSecret,
 	}
-	respMsg, err := c.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_REQ), req)
+	respMsg, err := c.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -192,13 +370,16 @@ func (c *Client) ApplicationAuth(ctx context.Context) (*openapi.ProtoOAApplicati
 	if err := proto.Unmarshal(respMsg.Payload, res); err != nil {
 		return nil, err
 	}
+	if respMsg.GetPayloadType() == uint32(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_RES) {
+		c.setState(StateAppAuthorized)
+	}
 	return res, nil
 }
 
 // Version 获取OpenAPI版本
 func (c *Client) Version(ctx context.Context) (*openapi.ProtoOAVersionRes, error) {
 	req := &openapi.ProtoOAVersionReq{}
-	respMsg, err := c.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ), req)
+	respMsg, err := c.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -211,10 +392,14 @@ func (c *Client) Version(ctx context.Context) (*openapi.ProtoOAVersionRes, error
 
 // GetAccountList 获取账户列表
 func (c *Client) GetAccountList(ctx context.Context) (*openapi.ProtoOAGetAccountListByAccessTokenRes, error) {
+	accessToken, err := c.currentAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
 	req := &openapi.ProtoOAGetAccountListByAccessTokenReq{
-		AccessToken: &c.accessToken,
+		AccessToken: proto.String(accessToken),
 	}
-	respMsg, err := c.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_GET_ACCOUNTS_BY_ACCESS_TOKEN_REQ), req)
+	respMsg, err := c.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_GET_ACCOUNTS_BY_ACCESS_TOKEN_REQ), req)
 	if err != nil {
 		return nil, err
 	}
@@ -226,11 +411,28 @@ func (c *Client) GetAccountList(ctx context.Context) (*openapi.ProtoOAGetAccount
 }
 
 // RefreshToken 刷新token
+//
+// 未设置 TokenSource 时，成功后改用新的 accessToken 并重新鉴权已鉴权的账户；
+// 设置了 TokenSource 时令牌由 TokenSource 管理，需由调用方自行保存返回的令牌
 func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*openapi.ProtoOARefreshTokenRes, error) {
+	res, err := c.refreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	managed := c.tokenSource != nil
+	c.lock.Unlock()
+	if !managed && c.swapAccessToken(res.GetAccessToken()) {
+		c.reauthorizeAccounts(ctx, res.GetAccessToken())
+	}
+	return res, nil
+}
+
+func (c *Client) refreshToken(ctx context.Context, refreshToken string) (*openapi.ProtoOARefreshTokenRes, error) {
 	req := &openapi.ProtoOARefreshTokenReq{
 		RefreshToken: &refreshToken,
 	}
-	respMsg, err := c.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_REFRESH_TOKEN_REQ), req)
+	respMsg, err := c.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_REFRESH_TOKEN_REQ), req)
 	if err != nil {
 		return nil, err
 	}
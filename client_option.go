@@ -0,0 +1,359 @@
+package ctrago
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/ctrago/openapi"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+// Environment cTrader 服务环境
+type Environment int
+
+const (
+	EnvironmentDemo Environment = iota
+	EnvironmentLive
+)
+
+// Host 返回环境对应的 OpenAPI 地址
+func (e Environment) Host() string {
+	if e == EnvironmentLive {
+		return "live.ctraderapi.com:5035"
+	}
+	return "demo.ctraderapi.com:5035"
+}
+
+// TransportKind 内置传输层类型
+type TransportKind int
+
+const (
+	TransportWebsocket TransportKind = iota
+	TransportTCP
+)
+
+// DefaultHeartbeatInterval 默认心跳间隔，服务端在 30 秒无消息后会断开连接
+const DefaultHeartbeatInterval = 10 * time.Second
+
+// Option New 的配置项
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	environment Environment
+	endpoint    string
+	kind        TransportKind
+	transport   Transport
+	wrappers    []func(Transport) Transport
+
+	clientId     string
+	clientSecret string
+	accessToken  string
+	tokenSource  TokenSource
+	tokenStore   TokenStore
+
+	netDialer   *net.Dialer
+	wsDialer    *websocket.Dialer
+	tlsConfig   *tls.Config
+	plaintext   bool
+	proxy       ProxyFunc
+	dialTimeout time.Duration
+
+	requestTimeout    time.Duration
+	payloadTimeouts   map[uint32]time.Duration
+	heartbeatInterval time.Duration
+	reconnectPolicy   *ReconnectPolicy
+	logger            *slog.Logger
+	metrics           Metrics
+	pingInterval      time.Duration
+	tracerProvider    trace.TracerProvider
+	marketData        MarketDataStore
+	riskRules         []RiskRule
+}
+
+// WithEnvironment 选择 demo 或 live 环境，默认 demo
+func WithEnvironment(env Environment) Option {
+	return func(c *clientConfig) {
+		c.environment = env
+	}
+}
+
+// WithLive 等同于 WithEnvironment(EnvironmentLive)，isLive 为 false 时选择 demo
+func WithLive(isLive bool) Option {
+	return func(c *clientConfig) {
+		c.environment = EnvironmentDemo
+		if isLive {
+			c.environment = EnvironmentLive
+		}
+	}
+}
+
+// WithEndpoint 自定义服务地址，覆盖 WithEnvironment
+//
+// WebSocket 可传入完整 url（如 ws://127.0.0.1:8080）或 host:port；TCP 传入 host:port
+func WithEndpoint(endpoint string) Option {
+	return func(c *clientConfig) {
+		c.endpoint = endpoint
+	}
+}
+
+// WithTransportKind 选择内置传输层，默认 WebSocket
+func WithTransportKind(kind TransportKind) Option {
+	return func(c *clientConfig) {
+		c.kind = kind
+	}
+}
+
+// WithTransport 使用自定义传输层，此时与连接相关的配置项不生效
+func WithTransport(transport Transport) Option {
+	return func(c *clientConfig) {
+		c.transport = transport
+	}
+}
+
+// WithTransportWrapper 包装创建好的传输层（如录制、模拟成交），多次设置时按顺序由内向外包装
+func WithTransportWrapper(wrap func(Transport) Transport) Option {
+	return func(c *clientConfig) {
+		c.wrappers = append(c.wrappers, wrap)
+	}
+}
+
+// WithCredentials 设置应用的 clientId 和 clientSecret
+func WithCredentials(clientId, clientSecret string) Option {
+	return func(c *clientConfig) {
+		c.clientId = clientId
+		c.clientSecret = clientSecret
+	}
+}
+
+// WithAccessToken 设置账户的 accessToken
+func WithAccessToken(accessToken string) Option {
+	return func(c *clientConfig) {
+		c.accessToken = accessToken
+	}
+}
+
+// WithNetDialer 自定义底层 net.Dialer（本地地址、KeepAlive 等）
+func WithNetDialer(dialer *net.Dialer) Option {
+	return func(c *clientConfig) {
+		c.netDialer = dialer
+	}
+}
+
+// WithWebsocketDialer 自定义 websocket.Dialer，其中未设置的 TLS、代理、超时由其它配置项补充
+func WithWebsocketDialer(dialer *websocket.Dialer) Option {
+	return func(c *clientConfig) {
+		c.wsDialer = dialer
+	}
+}
+
+// WithTLSConfig 自定义 TLS 配置
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *clientConfig) {
+		c.tlsConfig = config
+	}
+}
+
+// WithoutTLS 不使用 TLS，仅用于连接本地测试服务
+func WithoutTLS() Option {
+	return func(c *clientConfig) {
+		c.plaintext = true
+	}
+}
+
+// WithProxy 通过 HTTP 代理（CONNECT）连接，WebSocket 和 TCP 均适用
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *clientConfig) {
+		c.proxy = func(*http.Request) (*url.URL, error) {
+			return proxyURL, nil
+		}
+	}
+}
+
+// WithProxyFunc 按请求选择代理，如 http.ProxyFromEnvironment
+func WithProxyFunc(proxy ProxyFunc) Option {
+	return func(c *clientConfig) {
+		c.proxy = proxy
+	}
+}
+
+// WithDialTimeout 建立连接（含 TLS 握手）的超时时间
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithRequestTimeout 默认请求超时，调用方传入的 context 没有截止时间时生效
+//
+// 默认 DefaultRequestTimeout，0 表示不设置超时
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.requestTimeout = timeout
+	}
+}
+
+// WithHeartbeat 心跳间隔，默认 DefaultHeartbeatInterval，0 表示不发送心跳
+func WithHeartbeat(interval time.Duration) Option {
+	return func(c *clientConfig) {
+		c.heartbeatInterval = interval
+	}
+}
+
+// WithReconnectPolicy 断线重连策略，默认 DefaultReconnectPolicy
+func WithReconnectPolicy(policy *ReconnectPolicy) Option {
+	return func(c *clientConfig) {
+		c.reconnectPolicy = policy
+	}
+}
+
+// WithLogger 设置日志输出，默认不输出日志
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *clientConfig) {
+		c.logger = logger
+	}
+}
+
+// New 按配置项创建 Client，建立连接并启动消息循环
+func New(opts ...Option) (*Client, error) {
+	cfg := &clientConfig{
+		requestTimeout:    DefaultRequestTimeout,
+		heartbeatInterval: DefaultHeartbeatInterval,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	transport := cfg.transport
+	if transport == nil {
+		var err error
+		switch cfg.kind {
+		case TransportWebsocket:
+			transport, err = cfg.newWebsocket()
+		case TransportTCP:
+			transport, err = cfg.newTcp()
+		default:
+			err = fmt.Errorf("unknown transport kind %d", cfg.kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, wrap := range cfg.wrappers {
+		transport = wrap(transport)
+	}
+	if cfg.reconnectPolicy != nil {
+		if t, ok := transport.(interface{ SetReconnectPolicy(*ReconnectPolicy) }); ok {
+			t.SetReconnectPolicy(cfg.reconnectPolicy)
+		}
+	}
+	client := NewClientWithTransport(transport, cfg.clientId, cfg.clientSecret, cfg.accessToken)
+	client.requestTimeout = cfg.requestTimeout
+	for pt, timeout := range cfg.payloadTimeouts {
+		client.payloadTimeouts[pt] = timeout
+	}
+	if cfg.logger != nil {
+		client.SetLogger(cfg.logger)
+		if t, ok := transport.(interface{ SetLogger(*slog.Logger) }); ok {
+			t.SetLogger(cfg.logger)
+		}
+	}
+	if cfg.metrics != nil {
+		client.SetMetrics(cfg.metrics)
+	}
+	if cfg.tracerProvider != nil {
+		client.SetTracerProvider(cfg.tracerProvider)
+	}
+	if cfg.marketData != nil {
+		client.SetMarketDataStore(cfg.marketData)
+	}
+	if len(cfg.riskRules) > 0 {
+		client.SetRiskRules(cfg.riskRules...)
+	}
+	switch {
+	case cfg.tokenSource != nil:
+		client.SetTokenSource(cfg.tokenSource)
+	case cfg.tokenStore != nil:
+		client.SetTokenSource(NewRefreshingTokenSource(cfg.tokenStore, client.TokenRefresher()))
+	}
+	if cfg.heartbeatInterval > 0 {
+		transport.SetHeartbeat(cfg.heartbeatInterval, func() (int, []byte) {
+			hb := &openapi.ProtoMessage{PayloadType: proto.Uint32(uint32(openapi.ProtoPayloadType_HEARTBEAT_EVENT))}
+			data, _ := proto.Marshal(hb)
+			return websocket.BinaryMessage, data
+		})
+	}
+	go transport.Listen()
+	if cfg.pingInterval > 0 {
+		go client.pingLoop(cfg.pingInterval)
+	}
+	return client, nil
+}
+
+func (cfg *clientConfig) dialer() DialFunc {
+	netDialer := cfg.netDialer
+	if netDialer == nil {
+		netDialer = &net.Dialer{Timeout: cfg.dialTimeout}
+	}
+	return proxyDial(netDialer.DialContext, cfg.proxy)
+}
+
+func (cfg *clientConfig) newWebsocket() (*WsClient, error) {
+	endpoint := cfg.endpoint
+	if endpoint == "" {
+		endpoint = cfg.environment.Host()
+	}
+	if !strings.Contains(endpoint, "://") {
+		scheme := "wss://"
+		if cfg.plaintext {
+			scheme = "ws://"
+		}
+		endpoint = scheme + endpoint
+	}
+	dialer := &websocket.Dialer{}
+	if cfg.wsDialer != nil {
+		*dialer = *cfg.wsDialer
+	}
+	if dialer.NetDialContext == nil && dialer.NetDial == nil {
+		// 代理由 dialer() 统一处理，WebSocket 与 TCP 行为一致
+		dialer.NetDialContext = cfg.dialer()
+	}
+	if dialer.TLSClientConfig == nil {
+		dialer.TLSClientConfig = cfg.tlsConfig
+	}
+	if dialer.HandshakeTimeout == 0 {
+		dialer.HandshakeTimeout = cfg.dialTimeout
+	}
+	return NewWsClientWithHeartbeat(endpoint, dialer, 0, nil)
+}
+
+func (cfg *clientConfig) newTcp() (*TcpClient, error) {
+	endpoint := cfg.endpoint
+	if endpoint == "" {
+		endpoint = cfg.environment.Host()
+	}
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		endpoint = u.Host
+	}
+	dial := cfg.dialer()
+	if !cfg.plaintext {
+		dial = tlsDial(dial, cfg.tlsConfig)
+	}
+	if cfg.dialTimeout > 0 {
+		timeout := cfg.dialTimeout
+		base := dial
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return base(ctx, network, addr)
+		}
+	}
+	return NewTcpClientWithDialer(endpoint, dial)
+}
@@ -0,0 +1,242 @@
+package ctrago
+
+import (
+	"sort"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// ConnState Client 的连接状态
+type ConnState int32
+
+const (
+	// StateConnecting 正在建立连接
+	StateConnecting ConnState = iota
+	// StateConnected 连接已建立，尚未完成应用鉴权
+	StateConnected
+	// StateAppAuthorized 应用鉴权已完成
+	StateAppAuthorized
+	// StateReconnecting 连接断开，传输层正在重连
+	StateReconnecting
+	// StateClosed 连接已关闭，不会再重连
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateAppAuthorized:
+		return "app-authorized"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateChangeHandler 连接状态变化回调
+type StateChangeHandler func(from, to ConnState)
+
+// DisconnectKind 服务端断开通知的类型
+type DisconnectKind int
+
+const (
+	// DisconnectClient 服务端断开了整个应用连接（ProtoOAClientDisconnectEvent）
+	DisconnectClient DisconnectKind = iota + 1
+	// DisconnectAccount 服务端断开了单个账户会话（ProtoOAAccountDisconnectEvent）
+	DisconnectAccount
+	// DisconnectTokenInvalidated 账户的 accessToken 已失效（ProtoOAAccountsTokenInvalidatedEvent）
+	DisconnectTokenInvalidated
+)
+
+func (k DisconnectKind) String() string {
+	switch k {
+	case DisconnectClient:
+		return "client-disconnect"
+	case DisconnectAccount:
+		return "account-disconnect"
+	case DisconnectTokenInvalidated:
+		return "token-invalidated"
+	}
+	return "unknown"
+}
+
+// DisconnectNotice 服务端断开通知
+//
+// AccountIds 为受影响的账户ID；DisconnectClient 时为断开前已鉴权的全部账户
+type DisconnectNotice struct {
+	Kind       DisconnectKind
+	AccountIds []int64
+	Reason     string
+}
+
+// DisconnectHandler 服务端断开通知回调
+type DisconnectHandler func(notice DisconnectNotice)
+
+// State 返回当前连接状态
+func (c *Client) State() ConnState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// OnStateChange 注册连接状态变化回调
+func (c *Client) OnStateChange(handler StateChangeHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stateHandlers = append(c.stateHandlers, handler)
+}
+
+// watchState 注册可注销的连接状态回调，见 WatchEvent
+func (c *Client) watchState(handler StateChangeHandler) (stop func()) {
+	return c.watch(eventWatcher{state: handler})
+}
+
+// watchDisconnect 注册可注销的断开通知回调，见 WatchEvent
+func (c *Client) watchDisconnect(handler DisconnectHandler) (stop func()) {
+	return c.watch(eventWatcher{disconnect: handler})
+}
+
+// OnDisconnect 注册服务端断开通知回调
+//
+// 包括应用连接断开、账户会话断开以及 accessToken 失效
+func (c *Client) OnDisconnect(handler DisconnectHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.disconnectHandlers = append(c.disconnectHandlers, handler)
+}
+
+// AuthorizedAccounts 返回当前连接上已鉴权的账户ID
+func (c *Client) AuthorizedAccounts() []int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.authorizedAccountIds()
+}
+
+// authorizedAccountIds 调用方需持有 c.lock
+func (c *Client) authorizedAccountIds() []int64 {
+	ids := make([]int64, 0, len(c.authorized))
+	for id := range c.authorized {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (c *Client) setAccountAuthorized(accountId int64, authorized bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if authorized {
+		c.authorized[accountId] = struct{}{}
+	} else {
+		delete(c.authorized, accountId)
+	}
+}
+
+// setState 切换连接状态并通知回调；关闭后不再变化
+func (c *Client) setState(to ConnState) {
+	c.lock.Lock()
+	from := c.state
+	if from == to || from == StateClosed {
+		c.lock.Unlock()
+		return
+	}
+	c.state = to
+	handlers := append([]StateChangeHandler(nil), c.stateHandlers...)
+	for _, w := range c.watchers {
+		if w.state != nil {
+			handlers = append(handlers, w.state)
+		}
+	}
+	c.lock.Unlock()
+	c.log().Info("ctrago connection state changed", "from", from.String(), "to", to.String())
+	for _, h := range handlers {
+		h(from, to)
+	}
+}
+
+// handleTransportEvent 处理传输层连接事件
+func (c *Client) handleTransportEvent(event TransportEvent, err error) {
+	switch event {
+	case TransportConnected:
+		if c.State() == StateReconnecting {
+			c.metricsRecorder().IncReconnect()
+		}
+		c.setState(StateConnected)
+	case TransportDisconnected:
+		c.log().Warn("ctrago transport disconnected", "error", err)
+		// 连接断开后服务端的会话全部失效，挂起的请求也不会再有响应
+		c.lock.Lock()
+		c.authorized = make(map[int64]struct{})
+		pending := c.pending
+		c.pending = make(map[string]chan *openapi.ProtoMessage)
+		metrics := c.metrics
+		c.lock.Unlock()
+		metrics.SetPendingRequests(0)
+		for _, ch := range pending {
+			close(ch)
+		}
+	case TransportReconnecting:
+		c.setState(StateReconnecting)
+	case TransportClosed:
+		if err != nil {
+			c.log().Error("ctrago transport gave up reconnecting", "error", err)
+		}
+		c.setState(StateClosed)
+	}
+}
+
+// handleDisconnectEvent 处理服务端推送的断开类事件，返回是否已处理
+func (c *Client) handleDisconnectEvent(msg *openapi.ProtoMessage) bool {
+	var notice DisconnectNotice
+	switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+	case openapi.ProtoOAPayloadType_PROTO_OA_CLIENT_DISCONNECT_EVENT:
+		ev := &openapi.ProtoOAClientDisconnectEvent{}
+		if err := proto.Unmarshal(msg.Payload, ev); err != nil {
+			return false
+		}
+		c.lock.Lock()
+		notice = DisconnectNotice{Kind: DisconnectClient, AccountIds: c.authorizedAccountIds(), Reason: ev.GetReason()}
+		c.authorized = make(map[int64]struct{})
+		c.lock.Unlock()
+		if c.State() == StateAppAuthorized {
+			c.setState(StateConnected)
+		}
+	case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_DISCONNECT_EVENT:
+		ev := &openapi.ProtoOAAccountDisconnectEvent{}
+		if err := proto.Unmarshal(msg.Payload, ev); err != nil {
+			return false
+		}
+		notice = DisconnectNotice{Kind: DisconnectAccount, AccountIds: []int64{ev.GetCtidTraderAccountId()}}
+		c.setAccountAuthorized(ev.GetCtidTraderAccountId(), false)
+	case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNTS_TOKEN_INVALIDATED_EVENT:
+		ev := &openapi.ProtoOAAccountsTokenInvalidatedEvent{}
+		if err := proto.Unmarshal(msg.Payload, ev); err != nil {
+			return false
+		}
+		notice = DisconnectNotice{Kind: DisconnectTokenInvalidated, AccountIds: ev.GetCtidTraderAccountIds(), Reason: ev.GetReason()}
+		for _, id := range ev.GetCtidTraderAccountIds() {
+			c.setAccountAuthorized(id, false)
+		}
+	default:
+		return false
+	}
+	c.log().Warn("ctrago server disconnect notice", "kind", notice.Kind.String(), "accountIds", notice.AccountIds, "reason", notice.Reason)
+	c.lock.Lock()
+	handlers := append([]DisconnectHandler(nil), c.disconnectHandlers...)
+	for _, w := range c.watchers {
+		if w.disconnect != nil {
+			handlers = append(handlers, w.disconnect)
+		}
+	}
+	c.lock.Unlock()
+	for _, h := range handlers {
+		h(notice)
+	}
+	return true
+}
@@ -1,9 +1,19 @@
 package ctrago
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
 )
 
 // 伪造的 Transport 用于单元测试
@@ -66,3 +76,266 @@ func TestClient_SendRequest(t *testing.T) {
 		t.Error("expected Send to be called")
 	}
 }
+
+// 支持连接事件通知的 mockTransport
+type notifyingTransport struct {
+	mockTransport
+	handler      MessageHandler
+	eventHandler TransportEventHandler
+}
+
+func (m *notifyingTransport) OnMessage(handler MessageHandler) {
+	m.handler = handler
+}
+
+func (m *notifyingTransport) OnTransportEvent(handler TransportEventHandler) {
+	m.eventHandler = handler
+	handler(TransportConnected, nil)
+}
+
+func (m *notifyingTransport) push(payloadType openapi.ProtoOAPayloadType, payload proto.Message, clientMsgId string) {
+	data, _ := proto.Marshal(payload)
+	msg := &openapi.ProtoMessage{PayloadType: proto.Uint32(uint32(payloadType)), Payload: data}
+	if clientMsgId != "" {
+		msg.ClientMsgId = proto.String(clientMsgId)
+	}
+	raw, _ := proto.Marshal(msg)
+	m.handler(websocket.BinaryMessage, raw)
+}
+
+func TestClient_StateTransitions(t *testing.T) {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		req := &openapi.ProtoMessage{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			t.Fatal(err)
+		}
+		go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_RES, &openapi.ProtoOAApplicationAuthRes{}, req.GetClientMsgId())
+		return nil
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	if client.State() != StateConnected {
+		t.Fatalf("expected connected, got %s", client.State())
+	}
+	var transitions []string
+	client.OnStateChange(func(from, to ConnState) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	})
+	if _, err := client.ApplicationAuth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mock.eventHandler(TransportDisconnected, nil)
+	mock.eventHandler(TransportReconnecting, nil)
+	mock.eventHandler(TransportConnected, nil)
+	client.Close()
+	expected := []string{"connected->app-authorized", "app-authorized->reconnecting", "reconnecting->connected", "connected->closed"}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("transition %d: expected %s, got %s", i, expected[i], transitions[i])
+		}
+	}
+}
+
+func TestClient_DisconnectNotice(t *testing.T) {
+	mock := &notifyingTransport{}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	client.setAccountAuthorized(1, true)
+	client.setAccountAuthorized(2, true)
+	var notices []DisconnectNotice
+	client.OnDisconnect(func(notice DisconnectNotice) {
+		notices = append(notices, notice)
+	})
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNTS_TOKEN_INVALIDATED_EVENT, &openapi.ProtoOAAccountsTokenInvalidatedEvent{
+		CtidTraderAccountIds: []int64{2},
+		Reason:               proto.String("revoked"),
+	}, "")
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_CLIENT_DISCONNECT_EVENT, &openapi.ProtoOAClientDisconnectEvent{}, "")
+	if len(notices) != 2 {
+		t.Fatalf("expected 2 notices, got %d", len(notices))
+	}
+	if notices[0].Kind != DisconnectTokenInvalidated || len(notices[0].AccountIds) != 1 || notices[0].AccountIds[0] != 2 || notices[0].Reason != "revoked" {
+		t.Errorf("unexpected token invalidated notice: %+v", notices[0])
+	}
+	if notices[1].Kind != DisconnectClient || len(notices[1].AccountIds) != 1 || notices[1].AccountIds[0] != 1 {
+		t.Errorf("unexpected client disconnect notice: %+v", notices[1])
+	}
+	if len(client.AuthorizedAccounts()) != 0 {
+		t.Errorf("expected no authorized accounts, got %v", client.AuthorizedAccounts())
+	}
+}
+
+func TestClient_PendingFailsOnDisconnect(t *testing.T) {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		go mock.eventHandler(TransportDisconnected, nil)
+		return nil
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Version(ctx); err != ErrConnectionLost {
+		t.Errorf("expected ErrConnectionLost, got %v", err)
+	}
+}
+
+func TestClient_ErrorResponse(t *testing.T) {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		req := &openapi.ProtoMessage{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			t.Fatal(err)
+		}
+		go mock.push(openapi.ProtoOAPayloadType(openapi.ProtoPayloadType_ERROR_RES), &openapi.ProtoErrorRes{
+			ErrorCode:   proto.String("SERVER_IS_UNDER_MAINTENANCE"),
+			Description: proto.String("maintenance"),
+		}, req.GetClientMsgId())
+		return nil
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.Version(ctx)
+	var apiErr *APIError
+	if resp != nil || !errors.As(err, &apiErr) || apiErr.ErrorCode != "SERVER_IS_UNDER_MAINTENANCE" || apiErr.Description != "maintenance" {
+		t.Fatalf("expected *APIError, got %v, %v", resp, err)
+	}
+
+	// SendRequest 保持原有约定：错误消息作为响应返回
+	msg, err := client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ), &openapi.ProtoOAVersionReq{})
+	if err != nil || msg.GetPayloadType() != uint32(openapi.ProtoPayloadType_ERROR_RES) {
+		t.Fatalf("expected the error response message, got %v, %v", msg, err)
+	}
+	if _, err := CheckResponse(msg, err); !errors.As(err, &apiErr) || apiErr.ErrorCode != "SERVER_IS_UNDER_MAINTENANCE" {
+		t.Fatalf("expected CheckResponse to return *APIError, got %v", err)
+	}
+}
+
+func TestNew_CustomEndpoint(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			req := &openapi.ProtoMessage{}
+			if err := proto.Unmarshal(data, req); err != nil {
+				return
+			}
+			payload, _ := proto.Marshal(&openapi.ProtoOAVersionRes{Version: proto.String("test")})
+			raw, _ := proto.Marshal(&openapi.ProtoMessage{
+				PayloadType: proto.Uint32(uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_RES)),
+				Payload:     payload,
+				ClientMsgId: req.ClientMsgId,
+			})
+			conn.WriteMessage(websocket.BinaryMessage, raw)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(
+		WithEndpoint(strings.TrimPrefix(server.URL, "http://")),
+		WithoutTLS(),
+		WithHeartbeat(0),
+		WithRequestTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	res, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetVersion() != "test" {
+		t.Errorf("expected version test, got %s", res.GetVersion())
+	}
+}
+
+func TestClient_DefaultRequestTimeout(t *testing.T) {
+	client := NewClientWithTransport(&mockTransport{}, "id", "secret", "token")
+	client.SetRequestTimeout(20 * time.Millisecond)
+	client.SetPayloadTimeout(time.Hour, openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ)
+
+	ctx := ContextWithRequestTimeout(context.Background(), 10*time.Millisecond)
+	_, err := client.Version(ctx)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+	if timeoutErr.PayloadType != uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ) || timeoutErr.ClientMsgId == "" || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Errorf("unexpected timeout error: %+v", timeoutErr)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected TimeoutError to wrap context.DeadlineExceeded")
+	}
+
+	_, err = client.ApplicationAuth(context.Background())
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 20*time.Millisecond {
+		t.Fatalf("expected default timeout, got %v", err)
+	}
+}
+
+func TestClient_LoggingRedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	client := NewClientWithTransport(&mockTransport{}, "id", "top-secret", "token-value")
+	client.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client.ApplicationAuth(ctx)
+	client.RefreshToken(ctx, "refresh-value")
+
+	out := buf.String()
+	for _, secret := range []string{"top-secret", "refresh-value"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output leaks %q: %s", secret, out)
+		}
+	}
+	for _, expected := range []string{"PROTO_OA_APPLICATION_AUTH_REQ", "PROTO_OA_REFRESH_TOKEN_REQ", redactedValue, "timed out"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("log output missing %q: %s", expected, out)
+		}
+	}
+}
+
+func TestOrderLinker_CloseUnregistersHandler(t *testing.T) {
+	client := NewClientWithTransport(&mockTransport{}, "id", "secret", "token")
+	linker := NewOrderLinker(client.Account(1))
+	if len(client.watchers) != 1 {
+		t.Fatalf("expected 1 watcher, got %d", len(client.watchers))
+	}
+	linker.Close()
+	if len(client.watchers) != 0 {
+		t.Errorf("expected watchers to be removed on Close, got %d", len(client.watchers))
+	}
+}
+
+func TestClient_WatchEventStop(t *testing.T) {
+	mock := &notifyingTransport{}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	events := make(chan struct{}, 2)
+	stop := client.WatchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) {
+		events <- struct{}{}
+	})
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{CtidTraderAccountId: proto.Int64(1), SymbolId: proto.Int64(1)}, "")
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("expected event before stop")
+	}
+	stop()
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{CtidTraderAccountId: proto.Int64(1), SymbolId: proto.Int64(1)}, "")
+	select {
+	case <-events:
+		t.Error("unexpected event after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
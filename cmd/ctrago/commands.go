@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ctrago "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
+
+// protoVolume 命令行中的数量以基础货币单位表示，协议中为 0.01 个单位
+func protoVolume(units float64) int64 {
+	return int64(math.Round(units * 100))
+}
+
+func optionalPrice(set bool, price float64) *float64 {
+	if !set {
+		return nil
+	}
+	return &price
+}
+
+// parseEnum 按名称（不区分大小写）解析枚举，names 为 openapi 生成的 *_value 表
+func parseEnum(kind, name string, names map[string]int32) (int32, error) {
+	if v, ok := names[strings.ToUpper(name)]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown %s %q", kind, name)
+}
+
+type accountRow struct {
+	AccountId int64  `json:"account_id"`
+	Login     int64  `json:"login"`
+	Live      bool   `json:"live"`
+	Broker    string `json:"broker"`
+}
+
+func runAccounts(ctx context.Context, a *app, args []string) error {
+	if err := a.flags("accounts").Parse(args); err != nil {
+		return err
+	}
+	client, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := client.GetAccountList(ctx)
+	if err != nil {
+		return err
+	}
+	rows := make([]accountRow, 0, len(res.CtidTraderAccount))
+	for _, acc := range res.CtidTraderAccount {
+		rows = append(rows, accountRow{
+			AccountId: int64(acc.GetCtidTraderAccountId()),
+			Login:     acc.GetTraderLogin(),
+			Live:      acc.GetIsLive(),
+			Broker:    acc.GetBrokerTitleShort(),
+		})
+	}
+	return a.print(rows)
+}
+
+type traderRow struct {
+	AccountId      int64   `json:"account_id"`
+	Login          int64   `json:"login"`
+	Broker         string  `json:"broker"`
+	AccountType    string  `json:"account_type"`
+	AccessRights   string  `json:"access_rights"`
+	Balance        float64 `json:"balance"`
+	MoneyDigits    uint32  `json:"money_digits"`
+	Leverage       float64 `json:"leverage"`
+	DepositAssetId int64   `json:"deposit_asset_id"`
+	SwapFree       bool    `json:"swap_free"`
+}
+
+func runTrader(ctx context.Context, a *app, args []string) error {
+	if err := a.flags("trader").Parse(args); err != nil {
+		return err
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := account.Trader().Trader(ctx)
+	if err != nil {
+		return err
+	}
+	t := res.GetTrader()
+	return a.print(traderRow{
+		AccountId:      t.GetCtidTraderAccountId(),
+		Login:          t.GetTraderLogin(),
+		Broker:         t.GetBrokerName(),
+		AccountType:    t.GetAccountType().String(),
+		AccessRights:   t.GetAccessRights().String(),
+		Balance:        ctrago.MoneyValue(t.GetBalance(), t.GetMoneyDigits()),
+		MoneyDigits:    t.GetMoneyDigits(),
+		Leverage:       float64(t.GetLeverageInCents()) / 100,
+		DepositAssetId: t.GetDepositAssetId(),
+		SwapFree:       t.GetSwapFree(),
+	})
+}
+
+type symbolRow struct {
+	SymbolId    int64  `json:"symbol_id"`
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+}
+
+func runSymbols(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("symbols")
+	archived := fs.Bool("archived", false, "包含已归档的品种")
+	filter := fs.String("filter", "", "只显示名称包含该字符串的品种（不区分大小写）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := account.Symbol().SymbolList(ctx, *archived)
+	if err != nil {
+		return err
+	}
+	var rows []symbolRow
+	for _, s := range res.Symbol {
+		if *filter != "" && !strings.Contains(strings.ToUpper(s.GetSymbolName()), strings.ToUpper(*filter)) {
+			continue
+		}
+		rows = append(rows, symbolRow{SymbolId: s.GetSymbolId(), Name: s.GetSymbolName(), Enabled: s.GetEnabled(), Description: s.GetDescription()})
+	}
+	return a.print(rows)
+}
+
+type spotRow struct {
+	Time     time.Time `json:"time"`
+	SymbolId int64     `json:"symbol_id"`
+	Bid      *float64  `json:"bid"`
+	Ask      *float64  `json:"ask"`
+}
+
+// runSpots 订阅报价并逐条输出，直到 ctx 取消（Ctrl+C）
+func runSpots(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("spots")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var symbolIds []int64
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid symbol id %q", arg)
+		}
+		symbolIds = append(symbolIds, id)
+	}
+	if len(symbolIds) == 0 {
+		return ctrago.ErrSymbolIdRequired
+	}
+	if _, err := a.account(ctx); err != nil {
+		return err
+	}
+	spots := make(chan spotRow, 64)
+	a.client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOASpotEvent{}
+		if proto.Unmarshal(msg.Payload, ev) != nil || ev.GetCtidTraderAccountId() != a.cfg.AccountId {
+			return
+		}
+		row := spotRow{SymbolId: ev.GetSymbolId(), Time: time.Now().UTC()}
+		if ev.Timestamp != nil {
+			row.Time = time.UnixMilli(ev.GetTimestamp()).UTC()
+		}
+		row.Bid = optionalPrice(ev.Bid != nil, float64(ev.GetBid())/ctrago.PriceScale)
+		row.Ask = optionalPrice(ev.Ask != nil, float64(ev.GetAsk())/ctrago.PriceScale)
+		select {
+		case spots <- row:
+		default:
+		}
+	})
+	// 命令行不重连，连接关闭或账户会话被服务端断开后不会再收到报价，以错误退出
+	lost := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case lost <- err:
+		default:
+		}
+	}
+	a.client.OnStateChange(func(from, to ctrago.ConnState) {
+		if to == ctrago.StateClosed {
+			report(errors.New("connection closed"))
+		}
+	})
+	a.client.OnDisconnect(func(notice ctrago.DisconnectNotice) {
+		if notice.Kind == ctrago.DisconnectClient || slices.Contains(notice.AccountIds, a.cfg.AccountId) {
+			report(fmt.Errorf("disconnected by server (%s): %s", notice.Kind, notice.Reason))
+		}
+	})
+	if _, err := ctrago.CheckResponse(a.client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ), &openapi.ProtoOASubscribeSpotsReq{
+		CtidTraderAccountId: proto.Int64(a.cfg.AccountId),
+		SymbolId:            symbolIds,
+	})); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-lost:
+			return err
+		case row := <-spots:
+			if err := a.print(row); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type executionRow struct {
+	ExecutionType string   `json:"execution_type"`
+	OrderId       int64    `json:"order_id"`
+	PositionId    int64    `json:"position_id"`
+	OrderStatus   string   `json:"order_status"`
+	Side          string   `json:"side"`
+	Volume        float64  `json:"volume"`
+	Price         *float64 `json:"price"`
+}
+
+func (a *app) printExecution(ev *openapi.ProtoOAExecutionEvent) error {
+	order := ev.GetOrder()
+	row := executionRow{
+		ExecutionType: ev.GetExecutionType().String(),
+		OrderId:       order.GetOrderId(),
+		PositionId:    ev.GetPosition().GetPositionId(),
+		OrderStatus:   order.GetOrderStatus().String(),
+		Side:          order.GetTradeData().GetTradeSide().String(),
+		Volume:        ctrago.VolumeUnits(order.GetTradeData().GetVolume()),
+	}
+	if deal := ev.GetDeal(); deal != nil {
+		row.Price = optionalPrice(deal.ExecutionPrice != nil, deal.GetExecutionPrice())
+	}
+	return a.print(row)
+}
+
+func runOrder(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ctrago order new|amend|cancel [flags]")
+	}
+	switch args[0] {
+	case "new":
+		return runOrderNew(ctx, a, args[1:])
+	case "amend":
+		return runOrderAmend(ctx, a, args[1:])
+	case "cancel":
+		return runOrderCancel(ctx, a, args[1:])
+	}
+	return fmt.Errorf("unknown order command %q", args[0])
+}
+
+func runOrderNew(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("order new")
+	symbolId := fs.Int64("symbol", 0, "品种 ID")
+	side := fs.String("side", "", "方向 buy|sell")
+	volume := fs.Float64("volume", 0, "数量（基础货币单位，如 10000 即 EURUSD 0.1 手）")
+	orderType := fs.String("type", "market", "订单类型 market|limit|stop|stop_limit|market_range")
+	limit := fs.Float64("limit", 0, "限价")
+	stop := fs.Float64("stop", 0, "止损触发价")
+	sl := fs.Float64("sl", 0, "止损价")
+	tp := fs.Float64("tp", 0, "止盈价")
+	label := fs.String("label", "", "标签")
+	comment := fs.String("comment", "", "备注")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tradeSide, err := parseEnum("side", *side, openapi.ProtoOATradeSide_value)
+	if err != nil {
+		return err
+	}
+	typ, err := parseEnum("order type", *orderType, openapi.ProtoOAOrderType_value)
+	if err != nil {
+		return err
+	}
+	opt := &ctrago.OrderOption{}
+	opt.WithLimitPrice(*limit)
+	opt.WithStopPrice(*stop)
+	opt.WithStopLoss(*sl)
+	opt.WithTakeProfit(*tp)
+	opt.WithLabel(*label)
+	opt.WithComment(*comment)
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	ev, err := account.Order().NewOrder(ctx, *symbolId, openapi.ProtoOAOrderType(typ), openapi.ProtoOATradeSide(tradeSide), protoVolume(*volume), opt)
+	if err != nil {
+		return err
+	}
+	return a.printExecution(ev)
+}
+
+func runOrderAmend(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("order amend")
+	orderId := fs.Int64("id", 0, "订单 ID")
+	volume := fs.Float64("volume", 0, "新的数量（基础货币单位）")
+	limit := fs.Float64("limit", 0, "新的限价")
+	stop := fs.Float64("stop", 0, "新的止损触发价")
+	sl := fs.Float64("sl", 0, "新的止损价")
+	tp := fs.Float64("tp", 0, "新的止盈价")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *orderId == 0 {
+		return errors.New("-id is required")
+	}
+	opt := &ctrago.AmendOrderOption{}
+	opt.WithVolume(protoVolume(*volume))
+	opt.WithLimitPrice(*limit)
+	opt.WithStopPrice(*stop)
+	opt.WithStopLoss(*sl)
+	opt.WithTakeProfit(*tp)
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	ev, err := account.Order().AmendOrder(ctx, *orderId, opt)
+	if err != nil {
+		return err
+	}
+	return a.printExecution(ev)
+}
+
+func runOrderCancel(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("order cancel")
+	orderId := fs.Int64("id", 0, "订单 ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *orderId == 0 {
+		return errors.New("-id is required")
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	ev, err := account.Order().CancelOrder(ctx, *orderId)
+	if err != nil {
+		return err
+	}
+	return a.printExecution(ev)
+}
+
+func runSLTP(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("sltp")
+	positionId := fs.Int64("position", 0, "持仓 ID")
+	sl := fs.Float64("sl", 0, "止损价")
+	tp := fs.Float64("tp", 0, "止盈价")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	ev, err := account.Order().AmendOrderPositionSltp(ctx, *positionId, (&ctrago.AmendPositionSLTPOption{}).WithStopLoss(*sl).WithTakeProfit(*tp))
+	if err != nil {
+		return err
+	}
+	return a.printExecution(ev)
+}
+
+// runClose 平仓，未指定数量时通过对账查询持仓的全部数量
+func runClose(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("close")
+	positionId := fs.Int64("position", 0, "持仓 ID")
+	volume := fs.Float64("volume", 0, "平仓数量（基础货币单位），默认全部")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *positionId == 0 {
+		return ctrago.ErrPositionIdRequired
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	closeVolume := protoVolume(*volume)
+	if closeVolume == 0 {
+		res, err := account.Trader().Reconcile(ctx, false)
+		if err != nil {
+			return err
+		}
+		for _, p := range res.Position {
+			if p.GetPositionId() == *positionId {
+				closeVolume = p.GetTradeData().GetVolume()
+			}
+		}
+		if closeVolume == 0 {
+			return fmt.Errorf("position %d not found", *positionId)
+		}
+	}
+	ev, err := account.Order().ClosePosition(ctx, *positionId, closeVolume)
+	if err != nil {
+		return err
+	}
+	return a.printExecution(ev)
+}
+
+type positionRow struct {
+	PositionId int64     `json:"position_id"`
+	SymbolId   int64     `json:"symbol_id"`
+	Side       string    `json:"side"`
+	Volume     float64   `json:"volume"`
+	Price      float64   `json:"price"`
+	StopLoss   *float64  `json:"stop_loss"`
+	TakeProfit *float64  `json:"take_profit"`
+	Swap       float64   `json:"swap"`
+	Commission float64   `json:"commission"`
+	OpenTime   time.Time `json:"open_time"`
+}
+
+type orderRow struct {
+	OrderId    int64     `json:"order_id"`
+	PositionId int64     `json:"position_id"`
+	SymbolId   int64     `json:"symbol_id"`
+	Side       string    `json:"side"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	Volume     float64   `json:"volume"`
+	LimitPrice *float64  `json:"limit_price"`
+	StopPrice  *float64  `json:"stop_price"`
+	StopLoss   *float64  `json:"stop_loss"`
+	TakeProfit *float64  `json:"take_profit"`
+	OpenTime   time.Time `json:"open_time"`
+}
+
+func runReconcile(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("reconcile")
+	protection := fs.Bool("protection", false, "包含止损止盈保护单")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := account.Trader().Reconcile(ctx, *protection)
+	if err != nil {
+		return err
+	}
+	positions := make([]positionRow, 0, len(res.Position))
+	for _, p := range res.Position {
+		trade := p.GetTradeData()
+		positions = append(positions, positionRow{
+			PositionId: p.GetPositionId(),
+			SymbolId:   trade.GetSymbolId(),
+			Side:       trade.GetTradeSide().String(),
+			Volume:     ctrago.VolumeUnits(trade.GetVolume()),
+			Price:      p.GetPrice(),
+			StopLoss:   optionalPrice(p.StopLoss != nil, p.GetStopLoss()),
+			TakeProfit: optionalPrice(p.TakeProfit != nil, p.GetTakeProfit()),
+			Swap:       ctrago.MoneyValue(p.GetSwap(), p.GetMoneyDigits()),
+			Commission: ctrago.MoneyValue(p.GetCommission(), p.GetMoneyDigits()),
+			OpenTime:   time.UnixMilli(trade.GetOpenTimestamp()).UTC(),
+		})
+	}
+	orders := make([]orderRow, 0, len(res.Order))
+	for _, o := range res.Order {
+		trade := o.GetTradeData()
+		orders = append(orders, orderRow{
+			OrderId:    o.GetOrderId(),
+			PositionId: o.GetPositionId(),
+			SymbolId:   trade.GetSymbolId(),
+			Side:       trade.GetTradeSide().String(),
+			Type:       o.GetOrderType().String(),
+			Status:     o.GetOrderStatus().String(),
+			Volume:     ctrago.VolumeUnits(trade.GetVolume()),
+			LimitPrice: optionalPrice(o.LimitPrice != nil, o.GetLimitPrice()),
+			StopPrice:  optionalPrice(o.StopPrice != nil, o.GetStopPrice()),
+			StopLoss:   optionalPrice(o.StopLoss != nil, o.GetStopLoss()),
+			TakeProfit: optionalPrice(o.TakeProfit != nil, o.GetTakeProfit()),
+			OpenTime:   time.UnixMilli(trade.GetOpenTimestamp()).UTC(),
+		})
+	}
+	if a.output == "json" {
+		return a.print(struct {
+			Positions []positionRow `json:"positions"`
+			Orders    []orderRow    `json:"orders"`
+		}{positions, orders})
+	}
+	if err := a.print(positions); err != nil {
+		return err
+	}
+	fmt.Fprintln(a.stdout)
+	return a.print(orders)
+}
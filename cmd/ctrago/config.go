@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// config 连接与账户配置，优先级：命令行参数 > 环境变量 > 配置文件
+type config struct {
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// TokenExpiry 访问令牌过期时间，由 login -save 写入
+	TokenExpiry time.Time `json:"token_expiry,omitzero"`
+	AccountId   int64     `json:"account_id"`
+	Live        bool      `json:"live"`
+	// Endpoint 自定义服务地址，为空时按 Live 选择 demo 或 live
+	Endpoint string `json:"endpoint"`
+}
+
+// defaultConfigPath 默认配置文件路径，如 ~/.config/ctrago/config.json
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ctrago", "config.json")
+}
+
+// loadConfig 读取配置文件并以环境变量覆盖；path 为空时读取默认路径，默认文件不存在不视为错误
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	cfg, err := readConfigFile(path, explicit)
+	if err != nil {
+		return cfg, err
+	}
+	if v := getenv("CTRAGO_CLIENT_ID"); v != "" {
+		cfg.ClientId = v
+	}
+	if v := getenv("CTRAGO_CLIENT_SECRET"); v != "" {
+		cfg.ClientSecret = v
+	}
+	if v := getenv("CTRAGO_ACCESS_TOKEN"); v != "" {
+		cfg.AccessToken = v
+	}
+	if v := getenv("CTRAGO_ACCOUNT_ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("CTRAGO_ACCOUNT_ID: %w", err)
+		}
+		cfg.AccountId = id
+	}
+	if v := getenv("CTRAGO_LIVE"); v != "" {
+		live, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("CTRAGO_LIVE: %w", err)
+		}
+		cfg.Live = live
+	}
+	if v := getenv("CTRAGO_ENDPOINT"); v != "" {
+		cfg.Endpoint = v
+	}
+	return cfg, nil
+}
+
+// readConfigFile 只读取配置文件，不含环境变量与命令行参数；mustExist 为 false 时文件不存在返回空配置
+func readConfigFile(path string, mustExist bool) (config, error) {
+	var cfg config
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse %s: %w", path, err)
+		}
+	case mustExist || !errors.Is(err, fs.ErrNotExist):
+		return cfg, err
+	}
+	return cfg, nil
+}
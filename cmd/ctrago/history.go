@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/export"
+	"github.com/yockii/ctrago/openapi"
+)
+
+// historySpan 成交、订单与资金流水单次请求的最大时间跨度
+const historySpan = 7 * 24 * time.Hour
+
+// parseTime 解析 RFC3339 或 2006-01-02（UTC）格式的时间
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// runHistory 下载历史数据并以 CSV 或 Parquet 输出
+func runHistory(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ctrago history bars|ticks|deals|orders|cashflow [flags]")
+	}
+	kind := args[0]
+	fs := a.flags("history " + kind)
+	symbolId := fs.Int64("symbol", 0, "品种 ID（bars、ticks）")
+	period := fs.String("period", "H1", "K 线周期，如 M1、H1、D1（bars）")
+	quoteType := fs.String("type", "bid", "报价类型 bid|ask（ticks）")
+	fromFlag := fs.String("from", "", "开始时间，RFC3339 或 2006-01-02，默认结束前 7 天")
+	toFlag := fs.String("to", "", "结束时间，默认当前")
+	out := fs.String("out", "", "输出文件，按扩展名选择 .csv 或 .parquet；为空时以 CSV 写到标准输出")
+	cache := fs.String("cache", "", "历史行情缓存目录（bars、ticks）")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	to := time.Now().UTC()
+	if *toFlag != "" {
+		t, err := parseTime(*toFlag)
+		if err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+		to = t
+	}
+	from := to.Add(-historySpan)
+	if *fromFlag != "" {
+		t, err := parseTime(*fromFlag)
+		if err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+		from = t
+	}
+	if !to.After(from) {
+		return errors.New("-from must be before -to")
+	}
+	account, err := a.account(ctx)
+	if err != nil {
+		return err
+	}
+	if *cache != "" {
+		store, err := ctrago.NewFileStore(*cache)
+		if err != nil {
+			return err
+		}
+		a.client.SetMarketDataStore(store)
+	}
+
+	switch kind {
+	case "bars", "ticks":
+		if *symbolId == 0 {
+			return ctrago.ErrSymbolIdRequired
+		}
+		scale, err := a.scale(ctx, account, []int64{*symbolId}, false)
+		if err != nil {
+			return err
+		}
+		digits, ok := scale.Digits[*symbolId]
+		if !ok {
+			digits = -1
+		}
+		if kind == "bars" {
+			p, err := parseEnum("period", *period, openapi.ProtoOATrendbarPeriod_value)
+			if err != nil {
+				return err
+			}
+			bars, err := account.Symbol().Trendbars(ctx, *symbolId, openapi.ProtoOATrendbarPeriod(p), from, to)
+			if err != nil {
+				return err
+			}
+			return writeRows(a, *out, export.Bars(bars, digits))
+		}
+		q, err := parseEnum("quote type", *quoteType, openapi.ProtoOAQuoteType_value)
+		if err != nil {
+			return err
+		}
+		ticks, err := account.Symbol().Ticks(ctx, *symbolId, openapi.ProtoOAQuoteType(q), from, to)
+		if err != nil {
+			return err
+		}
+		return writeRows(a, *out, export.Ticks(ticks, openapi.ProtoOAQuoteType(q), digits))
+	case "deals":
+		deals, err := fetchDeals(ctx, account, from, to)
+		if err != nil {
+			return err
+		}
+		var symbolIds []int64
+		for _, deal := range deals {
+			symbolIds = append(symbolIds, deal.GetSymbolId())
+		}
+		scale, err := a.scale(ctx, account, symbolIds, true)
+		if err != nil {
+			return err
+		}
+		return writeRows(a, *out, export.Deals(deals, scale))
+	case "orders":
+		var orders []*openapi.ProtoOAOrder
+		for start := from; start.Before(to); start = start.Add(historySpan) {
+			end := minTime(start.Add(historySpan), to)
+			res, err := account.Trader().OrderList(ctx, start.UnixMilli(), end.UnixMilli())
+			if err != nil {
+				return err
+			}
+			if res.GetHasMore() {
+				fmt.Fprintf(a.stderr, "ctrago: order list truncated between %s and %s\n", start.Format(time.RFC3339), end.Format(time.RFC3339))
+			}
+			orders = append(orders, res.Order...)
+		}
+		var symbolIds []int64
+		for _, order := range orders {
+			symbolIds = append(symbolIds, order.GetTradeData().GetSymbolId())
+		}
+		scale, err := a.scale(ctx, account, symbolIds, false)
+		if err != nil {
+			return err
+		}
+		return writeRows(a, *out, export.Orders(orders, scale))
+	case "cashflow":
+		var items []*openapi.ProtoOADepositWithdraw
+		for start := from; start.Before(to); start = start.Add(historySpan) {
+			end := minTime(start.Add(historySpan), to)
+			res, err := account.Trader().CashFlowHistoryList(ctx, start.UnixMilli(), end.UnixMilli())
+			if err != nil {
+				return err
+			}
+			items = append(items, res.DepositWithdraw...)
+		}
+		scale, err := a.scale(ctx, account, nil, true)
+		if err != nil {
+			return err
+		}
+		return writeRows(a, *out, export.CashFlows(items, scale))
+	}
+	return fmt.Errorf("unknown history kind %q", kind)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// fetchDeals 按 7 天分段请求成交记录，HasMore 时以最早一笔的时间为终点继续请求
+func fetchDeals(ctx context.Context, account *ctrago.Account, from, to time.Time) ([]*openapi.ProtoOADeal, error) {
+	seen := make(map[int64]struct{})
+	var deals []*openapi.ProtoOADeal
+	for start := from; start.Before(to); start = start.Add(historySpan) {
+		end := minTime(start.Add(historySpan), to).UnixMilli()
+		for {
+			res, err := account.Trader().DealList(ctx, start.UnixMilli(), end, 0)
+			if err != nil {
+				return nil, err
+			}
+			oldest := end
+			for _, deal := range res.Deal {
+				oldest = min(oldest, deal.GetExecutionTimestamp())
+				if _, ok := seen[deal.GetDealId()]; !ok {
+					seen[deal.GetDealId()] = struct{}{}
+					deals = append(deals, deal)
+				}
+			}
+			if !res.GetHasMore() || oldest >= end {
+				break
+			}
+			end = oldest
+		}
+	}
+	return deals, nil
+}
+
+// scale 查询品种价格精度，withMoney 时同时查询账户金额精度
+func (a *app) scale(ctx context.Context, account *ctrago.Account, symbolIds []int64, withMoney bool) (export.Scale, error) {
+	var scale export.Scale
+	unique := make(map[int64]struct{})
+	var ids []int64
+	for _, id := range symbolIds {
+		if _, ok := unique[id]; !ok && id != 0 {
+			unique[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		res, err := account.Symbol().SymbolById(ctx, ids)
+		if err != nil {
+			return scale, err
+		}
+		scale.Digits = export.SymbolDigits(res.Symbol)
+	}
+	if withMoney {
+		res, err := account.Trader().Trader(ctx)
+		if err != nil {
+			return scale, err
+		}
+		scale.MoneyDigits = res.GetTrader().GetMoneyDigits()
+	}
+	return scale, nil
+}
+
+// writeRows 写到 -out 指定的文件，未指定时以 CSV 写到标准输出
+func writeRows[T any](a *app, out string, rows []T) error {
+	if out == "" {
+		return export.Write(a.stdout, export.CSV, rows)
+	}
+	if err := export.WriteFile(out, rows); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "wrote %d rows to %s\n", len(rows), out)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yockii/ctrago/oauth"
+)
+
+type tokenRow struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// runLogin 执行 OAuth 授权码流程，-save 时把令牌写回配置文件
+func runLogin(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("login")
+	redirect := fs.String("redirect", "http://localhost:5000/callback", "应用登记的 redirect URI，需为本机 http 地址")
+	scope := fs.String("scope", "trading", "授权范围 trading|accounts")
+	save := fs.Bool("save", false, "把令牌写入配置文件")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *scope != string(oauth.ScopeTrading) && *scope != string(oauth.ScopeAccounts) {
+		return fmt.Errorf("unknown scope %q", *scope)
+	}
+	if a.cfg.ClientId == "" || a.cfg.ClientSecret == "" {
+		return errors.New("client id and client secret are required (config file or CTRAGO_* environment variables)")
+	}
+	cfg := &oauth.Config{
+		ClientId:     a.cfg.ClientId,
+		ClientSecret: a.cfg.ClientSecret,
+		RedirectURL:  *redirect,
+		Scope:        oauth.Scope(*scope),
+	}
+	token, err := cfg.Authorize(ctx, func(authURL string) error {
+		_, err := fmt.Fprintf(a.stderr, "请在浏览器中打开以下地址完成授权:\n\n  %s\n\n", authURL)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if *save {
+		if err := saveTokens(a.configPath, token); err != nil {
+			return err
+		}
+		fmt.Fprintln(a.stderr, "saved to", a.configPath)
+	}
+	return a.print(tokenRow{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken, Expiry: token.Expiry})
+}
+
+// saveTokens 把令牌写回配置文件：只替换文件中的令牌与过期时间字段，其余内容（包括未知字段）原样保留，
+// 环境变量与命令行参数的覆盖不会写入；写入前把文件权限收紧为仅本人可读写
+func saveTokens(path string, token *oauth.Token) error {
+	if path == "" {
+		return errors.New("no config file path")
+	}
+	fields := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if fields == nil {
+		// 文件内容为 null
+		fields = make(map[string]json.RawMessage)
+	}
+	updates := map[string]any{"access_token": token.AccessToken, "refresh_token": token.RefreshToken}
+	delete(fields, "token_expiry")
+	if !token.Expiry.IsZero() {
+		updates["token_expiry"] = token.Expiry
+	}
+	for key, value := range updates {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		fields[key] = raw
+	}
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
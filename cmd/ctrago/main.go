@@ -0,0 +1,181 @@
+// Command ctrago 基于 ctrago.Client 的命令行工具，用于查看账户、行情、下单、平仓、对账与下载历史数据
+//
+// 凭证从配置文件（默认 ~/.config/ctrago/config.json）与环境变量 CTRAGO_CLIENT_ID、CTRAGO_CLIENT_SECRET、
+// CTRAGO_ACCESS_TOKEN、CTRAGO_ACCOUNT_ID、CTRAGO_LIVE、CTRAGO_ENDPOINT 读取：
+//
+//	ctrago login -save
+//	ctrago accounts
+//	ctrago -account 123456 reconcile
+//	ctrago -account 123456 close -position 987654
+//	ctrago -account 123456 history bars -symbol 1 -period H1 -from 2026-10-01 -out eurusd.parquet
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/yockii/ctrago"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = map[string]command{
+	"login":     {"在浏览器中授权并获取访问令牌 [-scope trading|accounts] [-save]", runLogin},
+	"accounts":  {"列出访问令牌下的交易账户", runAccounts},
+	"trader":    {"显示账户信息", runTrader},
+	"symbols":   {"列出品种 [-archived] [-filter 名称]", runSymbols},
+	"spots":     {"订阅并持续输出报价 SYMBOL_ID...", runSpots},
+	"order":     {"下单、修改或撤销订单 new|amend|cancel", runOrder},
+	"sltp":      {"修改持仓止损止盈 -position ID [-sl 价格] [-tp 价格]", runSLTP},
+	"close":     {"平仓 -position ID [-volume 数量]，默认全部平仓", runClose},
+	"reconcile": {"列出当前持仓与挂单", runReconcile},
+	"history":   {"下载历史数据 bars|ticks|deals|orders|cashflow", runHistory},
+}
+
+// app 一次命令执行的上下文
+type app struct {
+	cfg        config
+	configPath string
+	output     string
+	timeout    time.Duration
+	stdout     io.Writer
+	stderr     io.Writer
+	// dial 创建 Client，测试中替换为内存传输层
+	dial func(cfg config) (*ctrago.Client, error)
+
+	client *ctrago.Client
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a := &app{stdout: os.Stdout, stderr: os.Stderr, dial: dial}
+	if err := a.run(ctx, os.Args[1:], os.Getenv); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "ctrago:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func dial(cfg config) (*ctrago.Client, error) {
+	opts := []ctrago.Option{
+		ctrago.WithLive(cfg.Live),
+		ctrago.WithCredentials(cfg.ClientId, cfg.ClientSecret),
+		ctrago.WithAccessToken(cfg.AccessToken),
+		// 命令行是短连接，断线直接报错
+		ctrago.WithReconnectPolicy(ctrago.NoReconnect()),
+	}
+	if cfg.Endpoint != "" {
+		opts = append(opts, ctrago.WithEndpoint(cfg.Endpoint))
+	}
+	return ctrago.New(opts...)
+}
+
+func (a *app) run(ctx context.Context, args []string, getenv func(string) string) error {
+	fs := flag.NewFlagSet("ctrago", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	configPath := fs.String("config", "", "配置文件路径（默认 "+defaultConfigPath()+"）")
+	accountId := fs.Int64("account", 0, "交易账户 ID，覆盖配置")
+	live := fs.Bool("live", false, "使用 live 环境，覆盖配置")
+	fs.StringVar(&a.output, "output", "table", "输出格式 table|json")
+	fs.DurationVar(&a.timeout, "timeout", 30*time.Second, "单个请求的超时时间")
+	fs.Usage = func() {
+		fmt.Fprintln(a.stderr, "用法: ctrago [选项] 命令 [参数]\n\n命令:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(a.stderr, "  %-10s %s\n", name, commands[name].usage)
+		}
+		fmt.Fprintln(a.stderr, "\n选项:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if a.output != "table" && a.output != "json" {
+		return fmt.Errorf("unknown output format %q", a.output)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	cfg, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "account":
+			cfg.AccountId = *accountId
+		case "live":
+			cfg.Live = *live
+		}
+	})
+	a.cfg = cfg
+	a.configPath = *configPath
+	if a.configPath == "" {
+		a.configPath = defaultConfigPath()
+	}
+	defer func() {
+		if a.client != nil {
+			a.client.Close()
+		}
+	}()
+	return cmd.run(ctx, a, fs.Args()[1:])
+}
+
+// connect 建立连接并完成应用鉴权
+func (a *app) connect(ctx context.Context) (*ctrago.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	if a.cfg.ClientId == "" || a.cfg.ClientSecret == "" || a.cfg.AccessToken == "" {
+		return nil, errors.New("client id, client secret and access token are required (config file or CTRAGO_* environment variables)")
+	}
+	client, err := a.dial(a.cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.SetRequestTimeout(a.timeout)
+	a.client = client
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		return nil, fmt.Errorf("application auth: %w", err)
+	}
+	return client, nil
+}
+
+// account 建立连接并完成账户鉴权
+func (a *app) account(ctx context.Context) (*ctrago.Account, error) {
+	if a.cfg.AccountId == 0 {
+		return nil, errors.New("account id is required (-account or CTRAGO_ACCOUNT_ID)")
+	}
+	client, err := a.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	account := client.Account(a.cfg.AccountId)
+	if _, err := account.Auth(ctx); err != nil {
+		return nil, fmt.Errorf("account auth: %w", err)
+	}
+	return account, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/oauth"
+	"github.com/yockii/ctrago/openapi"
+)
+
+func testEnv(key string) string {
+	switch key {
+	case "CTRAGO_CLIENT_ID":
+		return ctragotest.DefaultClientId
+	case "CTRAGO_CLIENT_SECRET":
+		return ctragotest.DefaultClientSecret
+	case "CTRAGO_ACCESS_TOKEN":
+		return ctragotest.DefaultAccessToken
+	}
+	return ""
+}
+
+// runCLI 以内存服务端执行一条命令，返回标准输出
+func runCLI(t *testing.T, srv *ctragotest.Server, args ...string) string {
+	t.Helper()
+	out, err := runCLIContext(context.Background(), t, srv, args...)
+	if err != nil {
+		t.Fatalf("ctrago %s: %v", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+func runCLIContext(ctx context.Context, t *testing.T, srv *ctragotest.Server, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	a := &app{
+		stdout: &stdout,
+		stderr: io.Discard,
+		dial: func(cfg config) (*ctrago.Client, error) {
+			return ctrago.NewClientWithTransport(srv.NewTransport(), cfg.ClientId, cfg.ClientSecret, cfg.AccessToken), nil
+		},
+	}
+	config := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(config, []byte(`{"account_id": 1000001}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := a.run(ctx, append([]string{"-config", config}, args...), testEnv)
+	return stdout.String(), err
+}
+
+func TestLoadConfig_EnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"client_id": "file", "access_token": "file-token", "account_id": 1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path, func(key string) string {
+		switch key {
+		case "CTRAGO_CLIENT_ID":
+			return "env"
+		case "CTRAGO_ACCOUNT_ID":
+			return "2"
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientId != "env" || cfg.AccessToken != "file-token" || cfg.AccountId != 2 {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"), testEnv); err == nil {
+		t.Fatal("expected error for missing explicit config file")
+	}
+}
+
+func TestSaveTokens_KeepsFileConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"client_id": "file", "client_secret": "secret", "account_id": 1, "access_token": "old", "extra": {"keep": true}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expiry := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	if err := saveTokens(path, &oauth.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfigFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientId != "file" || cfg.ClientSecret != "secret" || cfg.AccountId != 1 || cfg.AccessToken != "access" || cfg.RefreshToken != "refresh" || !cfg.TokenExpiry.Equal(expiry) {
+		t.Fatalf("config = %+v", cfg)
+	}
+	// 配置结构之外的字段原样保留
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if extra := strings.Join(strings.Fields(string(raw["extra"])), ""); extra != `{"keep":true}` {
+		t.Fatalf("unknown field not preserved: %s", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestRun_TraderTable(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+
+	out := runCLI(t, srv, "trader")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ACCOUNT_ID") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if !strings.Contains(lines[1], "1000001") || !strings.Contains(lines[1], "10000") {
+		t.Fatalf("unexpected row: %s", lines[1])
+	}
+}
+
+func TestRun_OrderCloseAndDeals(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1, 1.1002)
+
+	runCLI(t, srv, "order", "new", "-symbol", "1", "-side", "buy", "-volume", "10000")
+
+	var reconciled struct {
+		Positions []positionRow `json:"positions"`
+	}
+	if err := json.Unmarshal([]byte(runCLI(t, srv, "-output", "json", "reconcile")), &reconciled); err != nil {
+		t.Fatal(err)
+	}
+	if len(reconciled.Positions) != 1 || reconciled.Positions[0].Volume != 10000 || reconciled.Positions[0].Side != "BUY" {
+		t.Fatalf("positions = %+v", reconciled.Positions)
+	}
+
+	runCLI(t, srv, "close", "-position", strconv.FormatInt(reconciled.Positions[0].PositionId, 10))
+	if err := json.Unmarshal([]byte(runCLI(t, srv, "-output", "json", "reconcile")), &reconciled); err != nil {
+		t.Fatal(err)
+	}
+	if len(reconciled.Positions) != 0 {
+		t.Fatalf("positions after close = %+v", reconciled.Positions)
+	}
+
+	csv := runCLI(t, srv, "history", "deals")
+	if lines := strings.Split(strings.TrimSpace(csv), "\n"); len(lines) != 3 {
+		t.Fatalf("expected header and 2 deals, got:\n%s", csv)
+	}
+}
+
+func TestRun_SpotsExitsOnDisconnect(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1, 1.1002)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := runCLIContext(ctx, t, srv, "spots", strconv.FormatInt(ctragotest.DefaultSymbolId, 10))
+		done <- err
+	}()
+	for subscribed := false; !subscribed; time.Sleep(5 * time.Millisecond) {
+		for _, req := range srv.Requests() {
+			subscribed = subscribed || req.PayloadType == uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ)
+		}
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for subscription")
+		}
+	}
+	for _, sess := range srv.Sessions() {
+		sess.Close()
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error after the connection was lost")
+		}
+	case <-ctx.Done():
+		t.Fatal("spots kept running after disconnect")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// print 按 -output 输出结构体或结构体切片：json 原样编码，table 以 json 标签为列名对齐输出
+func (a *app) print(v any) error {
+	if a.output == "json" {
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		slice := reflect.MakeSlice(reflect.SliceOf(rv.Type()), 1, 1)
+		slice.Index(0).Set(rv)
+		rv = slice
+	}
+	typ := rv.Type().Elem()
+	tw := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	header := make([]string, typ.NumField())
+	for i := range header {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		header[i] = strings.ToUpper(name)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	cells := make([]string, len(header))
+	for i := 0; i < rv.Len(); i++ {
+		row := rv.Index(i)
+		for j := range cells {
+			cells[j] = cell(row.Field(j))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func cell(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "-"
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.String:
+		if v.String() == "" {
+			return "-"
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,217 @@
+package ctrago
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+const (
+	// defaultLotSize 品种未指定 LotSize 时，1 手为 100000 单位（单位 0.01）
+	defaultLotSize = 100000_00
+	// defaultSwapTime 品种未指定 SwapTime 时的隔夜利息收取时间（UTC 22:00，单位分钟）
+	defaultSwapTime = 22 * 60
+)
+
+// CostModel 按品种定义估算手续费与隔夜利息，金额均为存款货币，正数为支出、负数为收入
+type CostModel struct {
+	// Symbol 品种定义，nil 时费率均为 0，手数与隔夜计费时间取默认值
+	Symbol *openapi.ProtoOASymbol
+	// QuoteRate 1 单位报价货币折算为存款货币的汇率，报价货币即存款货币时为 1
+	QuoteRate float64
+	// USDRate 1 USD 折算为存款货币的汇率，用于按 USD 计价的手续费与管理费，0 视为 1
+	USDRate float64
+	// SwapFree 免息账户（ProtoOATrader.swapFree），以 rolloverCommission 管理费代替隔夜利息
+	SwapFree bool
+}
+
+// TradeCost 一笔交易的预估成本
+type TradeCost struct {
+	// Commission 开仓与平仓的手续费合计
+	Commission float64
+	// Swap 持仓期间的隔夜利息（免息账户为管理费），负数为收入
+	Swap float64
+	// Rollovers 计费的隔夜次数，三倍日计 3 次
+	Rollovers float64
+	Total     float64
+}
+
+// Rollover 一次隔夜计费
+type Rollover struct {
+	At time.Time
+	// Multiplier 计费倍数：0 为不收取（周末或初始免收期），3 为三倍日
+	Multiplier float64
+}
+
+func (m CostModel) usdRate() float64 {
+	if m.USDRate == 0 {
+		return 1
+	}
+	return m.USDRate
+}
+
+// Commission 单边手续费，按 CommissionType 与 PreciseTradingCommissionRate 计算，不低于 PreciseMinCommission
+func (m CostModel) Commission(volume int64, price float64) float64 {
+	symbol := m.Symbol
+	notional := VolumeUnits(volume) * price * m.QuoteRate
+	lotSize := symbol.GetLotSize()
+	if lotSize <= 0 {
+		lotSize = defaultLotSize
+	}
+	lots := float64(volume) / float64(lotSize)
+	rate := float64(symbol.GetPreciseTradingCommissionRate()) / 1e8
+	var amount float64
+	switch symbol.GetCommissionType() {
+	case openapi.ProtoOACommissionType_USD_PER_LOT:
+		amount = rate * lots * m.usdRate()
+	case openapi.ProtoOACommissionType_PERCENTAGE_OF_VALUE:
+		// 百分比费率按 10^5 缩放
+		amount = float64(symbol.GetPreciseTradingCommissionRate()) / 1e5 / 100 * notional
+	case openapi.ProtoOACommissionType_QUOTE_CCY_PER_LOT:
+		amount = rate * lots * m.QuoteRate
+	default:
+		amount = rate * notional / 1e6
+	}
+	if minimum := float64(symbol.GetPreciseMinCommission()) / 1e8; minimum > 0 {
+		if symbol.GetMinCommissionType() == openapi.ProtoOAMinCommissionType_QUOTE_CURRENCY {
+			minimum *= m.QuoteRate
+		} else {
+			minimum *= m.usdRate()
+		}
+		amount = math.Max(amount, minimum)
+	}
+	return amount
+}
+
+// SwapPerRollover 持仓一次普通隔夜计费的金额，按 SwapCalculationType 计算，SwapPeriod 不足一天时按比例折算
+//
+// 免息账户返回每日管理费：rolloverCommission（USD/手，按 10^8 缩放）乘以手数
+func (m CostModel) SwapPerRollover(side openapi.ProtoOATradeSide, volume int64, price float64) float64 {
+	symbol := m.Symbol
+	if m.SwapFree {
+		lotSize := symbol.GetLotSize()
+		if lotSize <= 0 {
+			lotSize = defaultLotSize
+		}
+		return float64(symbol.GetRolloverCommission()) / 1e8 * float64(volume) / float64(lotSize) * m.usdRate()
+	}
+	rate := symbol.GetSwapLong()
+	if side == openapi.ProtoOATradeSide_SELL {
+		rate = symbol.GetSwapShort()
+	}
+	units := VolumeUnits(volume)
+	var daily float64
+	switch symbol.GetSwapCalculationType() {
+	case openapi.ProtoOASwapCalculationType_PERCENTAGE:
+		daily = rate / 100 / 365 * units * price * m.QuoteRate
+	case openapi.ProtoOASwapCalculationType_POINTS:
+		daily = rate * math.Pow10(-int(symbol.GetDigits())) * units * m.QuoteRate
+	default:
+		daily = rate * math.Pow10(-int(symbol.GetPipPosition())) * units * m.QuoteRate
+	}
+	// swapLong、swapShort 为正表示持仓方收取
+	return -daily * m.swapPeriod().Hours() / 24
+}
+
+// swapPeriod 隔夜计费周期，默认每天一次；免息账户的管理费每天收取
+func (m CostModel) swapPeriod() time.Duration {
+	if p := m.Symbol.GetSwapPeriod(); p > 0 && !m.SwapFree {
+		return time.Duration(p) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// NextRollover t 之后（不含）最近的一次隔夜计费时间，从 SwapTime（UTC）起每 SwapPeriod 一次
+func (m CostModel) NextRollover(t time.Time) time.Time {
+	swapTime := defaultSwapTime
+	if m.Symbol != nil && m.Symbol.SwapTime != nil {
+		swapTime = int(m.Symbol.GetSwapTime())
+	}
+	t = t.UTC()
+	next := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).
+		Add(-24*time.Hour + time.Duration(swapTime)*time.Minute)
+	for !next.After(t) {
+		next = next.Add(m.swapPeriod())
+	}
+	return next
+}
+
+// RolloverMultiplier 在 at 计费的倍数（不含初始免收期）：开启 ChargeSwapAtWeekends 时每天收取一倍；
+// 否则周六、周日（UTC）不收，隔夜利息在 SwapRollover3Days、管理费在 RolloverCommission3Days 收取三倍
+func (m CostModel) RolloverMultiplier(at time.Time) float64 {
+	if m.Symbol.GetChargeSwapAtWeekends() {
+		// 周末照常收取时不再有三倍日
+		return 1
+	}
+	weekday := at.UTC().Weekday()
+	if weekday == time.Saturday || weekday == time.Sunday {
+		return 0
+	}
+	triple := m.Symbol.GetSwapRollover3Days()
+	if m.SwapFree {
+		triple = m.Symbol.GetRolloverCommission3Days()
+	}
+	if triple != openapi.ProtoOADayOfWeek_NONE && int(triple)%7 == int(weekday) {
+		return 3
+	}
+	return 1
+}
+
+// Rollovers 在 open 开仓并持有到 until 期间的各次隔夜计费；
+// 前 SkipSWAPPeriods 个周期（免息账户为前 SkipRolloverDays 天）不收取
+func (m CostModel) Rollovers(open, until time.Time) []Rollover {
+	skip := int(m.Symbol.GetSkipSWAPPeriods())
+	if m.SwapFree {
+		skip = int(m.Symbol.GetSkipRolloverDays())
+	}
+	var rollovers []Rollover
+	for at := m.NextRollover(open); !at.After(until); at = m.NextRollover(at) {
+		r := Rollover{At: at, Multiplier: m.RolloverMultiplier(at)}
+		if len(rollovers) < skip {
+			r.Multiplier = 0
+		}
+		rollovers = append(rollovers, r)
+	}
+	return rollovers
+}
+
+// Swap 在 open 开仓、持有 days 天的隔夜利息合计
+func (m CostModel) Swap(side openapi.ProtoOATradeSide, volume int64, price float64, open time.Time, days int) float64 {
+	return m.Estimate(side, volume, price, open, days).Swap
+}
+
+// Estimate 估算在 open 以 price 开仓 volume、持有 days 天后平仓的手续费与隔夜利息
+func (m CostModel) Estimate(side openapi.ProtoOATradeSide, volume int64, price float64, open time.Time, days int) TradeCost {
+	cost := TradeCost{Commission: 2 * m.Commission(volume, price)}
+	for _, r := range m.Rollovers(open, open.AddDate(0, 0, days)) {
+		cost.Rollovers += r.Multiplier
+	}
+	cost.Swap = cost.Rollovers * m.SwapPerRollover(side, volume, price)
+	cost.Total = cost.Commission + cost.Swap
+	return cost
+}
+
+// CostModel 查询品种定义与账户是否免息并创建成本模型，quoteRate、usdRate 见 CostModel 的同名字段
+func (a *AccountSymbol) CostModel(ctx context.Context, symbolId int64, quoteRate, usdRate float64) (*CostModel, error) {
+	res, err := a.SymbolById(ctx, []int64{symbolId})
+	if err != nil {
+		return nil, err
+	}
+	var symbol *openapi.ProtoOASymbol
+	for _, s := range res.GetSymbol() {
+		if s.GetSymbolId() == symbolId {
+			symbol = s
+		}
+	}
+	if symbol == nil {
+		return nil, fmt.Errorf("symbol %d not found", symbolId)
+	}
+	trader, err := a.Trader().Trader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &CostModel{Symbol: symbol, QuoteRate: quoteRate, USDRate: usdRate, SwapFree: trader.GetTrader().GetSwapFree()}, nil
+}
@@ -0,0 +1,112 @@
+package ctrago_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func costSymbol() *openapi.ProtoOASymbol {
+	commissionType := openapi.ProtoOACommissionType_USD_PER_MILLION_USD
+	tripleDay := openapi.ProtoOADayOfWeek_WEDNESDAY
+	return &openapi.ProtoOASymbol{
+		SymbolId:    proto.Int64(1),
+		Digits:      proto.Int32(5),
+		PipPosition: proto.Int32(4),
+		LotSize:     proto.Int64(100000_00),
+		// 每百万 USD 成交额 30 USD
+		CommissionType:               &commissionType,
+		PreciseTradingCommissionRate: proto.Int64(30 * 1e8),
+		SwapLong:                     proto.Float64(-5),
+		SwapShort:                    proto.Float64(1),
+		SwapRollover3Days:            &tripleDay,
+	}
+}
+
+func TestCostModel(t *testing.T) {
+	const lot = 100000_00
+	monday := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	model := ctrago.CostModel{Symbol: costSymbol(), QuoteRate: 1}
+
+	// 周一至周日的 22:00 收取，周三三倍、周末不收
+	cost := model.Estimate(openapi.ProtoOATradeSide_BUY, lot, 1.1, monday, 7)
+	if !near(cost.Commission, 6.6) || cost.Rollovers != 7 || !near(cost.Swap, 350) || !near(cost.Total, 356.6) {
+		t.Fatalf("unexpected long cost %+v", cost)
+	}
+	if swap := model.Swap(openapi.ProtoOATradeSide_SELL, lot, 1.1, monday, 7); !near(swap, -70) {
+		t.Fatalf("short swap should be income of 70, got %v", swap)
+	}
+	if swap := model.Swap(openapi.ProtoOATradeSide_BUY, lot, 1.1, monday, 1); !near(swap, 50) {
+		t.Fatalf("one day swap = %v", swap)
+	}
+
+	// 报价货币折算、初始免收期与周末收取
+	model.QuoteRate = 0.5
+	model.Symbol.SkipSWAPPeriods = proto.Int32(2)
+	if cost := model.Estimate(openapi.ProtoOATradeSide_BUY, lot, 1.1, monday, 7); cost.Rollovers != 5 || !near(cost.Swap, 125) {
+		t.Fatalf("unexpected cost with skipped periods %+v", cost)
+	}
+	model.Symbol.SkipSWAPPeriods = nil
+	model.Symbol.ChargeSwapAtWeekends = proto.Bool(true)
+	if rollovers := model.Rollovers(monday, monday.AddDate(0, 0, 7)); len(rollovers) != 7 || rollovers[2].Multiplier != 1 || rollovers[5].Multiplier != 1 {
+		t.Fatalf("unexpected weekend rollovers %+v", rollovers)
+	}
+
+	// 百分比手续费与以 USD 计的最低手续费
+	percentage := openapi.ProtoOACommissionType_PERCENTAGE_OF_VALUE
+	model = ctrago.CostModel{Symbol: costSymbol(), QuoteRate: 1, USDRate: 0.9}
+	model.Symbol.CommissionType = &percentage
+	model.Symbol.PreciseTradingCommissionRate = proto.Int64(500)
+	if c := model.Commission(lot, 1.1); !near(c, 5.5) {
+		t.Fatalf("percentage commission = %v", c)
+	}
+	model.Symbol.PreciseMinCommission = proto.Int64(10 * 1e8)
+	if c := model.Commission(lot, 1.1); !near(c, 9) {
+		t.Fatalf("minimum commission = %v", c)
+	}
+
+	// 免息账户按管理费计，首日免收，周五三倍
+	friday := openapi.ProtoOADayOfWeek_FRIDAY
+	model = ctrago.CostModel{Symbol: costSymbol(), QuoteRate: 1, SwapFree: true}
+	model.Symbol.RolloverCommission = proto.Int64(2 * 1e8)
+	model.Symbol.SkipRolloverDays = proto.Int32(1)
+	model.Symbol.RolloverCommission3Days = &friday
+	if cost := model.Estimate(openapi.ProtoOATradeSide_SELL, lot, 1.1, monday, 7); cost.Rollovers != 6 || !near(cost.Swap, 12) {
+		t.Fatalf("unexpected swap-free cost %+v", cost)
+	}
+}
+
+func TestCostModel_NilSymbol(t *testing.T) {
+	// 未设置品种时按默认值估算，不应 panic
+	m := ctrago.CostModel{QuoteRate: 1}
+	open := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+	if next := m.NextRollover(open); !next.Equal(time.Date(2025, 1, 6, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("NextRollover = %v", next)
+	}
+	if cost := m.Estimate(openapi.ProtoOATradeSide_BUY, 100000, 1.1, open, 2); cost.Total != 0 {
+		t.Errorf("expected zero cost without symbol rates, got %+v", cost)
+	}
+}
+
+func TestAccountSymbol_CostModel(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account := newRiskAccount(t, ctx, srv)
+	model, err := account.Symbol().CostModel(ctx, ctragotest.DefaultSymbolId, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Symbol.GetSymbolId() != ctragotest.DefaultSymbolId || model.SwapFree {
+		t.Fatalf("unexpected model %+v", model)
+	}
+	if _, err := account.Symbol().CostModel(ctx, 99, 1, 1); err == nil {
+		t.Fatal("expected error for unknown symbol")
+	}
+}
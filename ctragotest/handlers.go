@@ -0,0 +1,473 @@
+package ctragotest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func (s *Server) defaultHandlers() map[uint32]HandlerFunc {
+	return map[uint32]HandlerFunc{
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ):                      s.handleVersion,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_REQ):             s.handleApplicationAuth,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_GET_ACCOUNTS_BY_ACCESS_TOKEN_REQ): s.handleAccountList,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ):                 s.handleAccountAuth,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_LOGOUT_REQ):               s.handleAccountLogout,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_REFRESH_TOKEN_REQ):                s.handleRefreshToken,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_LIST_REQ):                 s.handleSymbolsList,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_REQ):                 s.handleSymbolById,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_LIST_REQ):                   s.handleAssetList,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_FOR_CONVERSION_REQ):       s.handleSymbolsForConversion,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ):              s.handleSubscribeSpots,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_REQ):            s.handleUnsubscribeSpots,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ):                       s.handleTrader,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_REQ):                    s.handleReconcile,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_REQ):                    s.handleDealList,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ):                    s.handleNewOrder,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_CANCEL_ORDER_REQ):                 s.handleCancelOrder,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_AMEND_ORDER_REQ):                  s.handleAmendOrder,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_AMEND_POSITION_SLTP_REQ):          s.handleAmendPositionSLTP,
+		uint32(openapi.ProtoOAPayloadType_PROTO_OA_CLOSE_POSITION_REQ):               s.handleClosePosition,
+	}
+}
+
+func accountError(accountId int64, code openapi.ProtoOAErrorCode, description string) *Response {
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{
+		CtidTraderAccountId: proto.Int64(accountId),
+		ErrorCode:           proto.String(code.String()),
+		Description:         proto.String(description),
+	})
+}
+
+func invalidRequest(err error) *Response {
+	return Error(openapi.ProtoErrorCode_INVALID_REQUEST.String(), err.Error())
+}
+
+// checkAccount 校验应用与账户均已鉴权
+func (s *Server) checkAccount(sess *Session, accountId int64) *Response {
+	if !sess.IsAppAuthorized() {
+		return accountError(accountId, openapi.ProtoOAErrorCode_CH_CLIENT_NOT_AUTHENTICATED, "application is not authorized")
+	}
+	if !sess.IsAccountAuthorized(accountId) {
+		return accountError(accountId, openapi.ProtoOAErrorCode_ACCOUNT_NOT_AUTHORIZED, "account is not authorized")
+	}
+	return nil
+}
+
+func (s *Server) handleVersion(sess *Session, req *Request) *Response {
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_RES, &openapi.ProtoOAVersionRes{Version: proto.String("ctragotest")})
+}
+
+func (s *Server) handleApplicationAuth(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAApplicationAuthReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	s.lock.Lock()
+	ok := r.GetClientId() == s.ClientId && r.GetClientSecret() == s.ClientSecret
+	s.lock.Unlock()
+	if !ok {
+		return Error(openapi.ProtoOAErrorCode_CH_CLIENT_AUTH_FAILURE.String(), "wrong client credentials")
+	}
+	sess.lock.Lock()
+	already := sess.appAuthorized
+	sess.appAuthorized = true
+	sess.lock.Unlock()
+	if already {
+		return Error(openapi.ProtoOAErrorCode_CH_CLIENT_ALREADY_AUTHENTICATED.String(), "application is already authorized")
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_RES, &openapi.ProtoOAApplicationAuthRes{})
+}
+
+func (s *Server) handleAccountList(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAGetAccountListByAccessTokenReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	if !sess.IsAppAuthorized() {
+		return Error(openapi.ProtoOAErrorCode_CH_CLIENT_NOT_AUTHENTICATED.String(), "application is not authorized")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r.GetAccessToken() != s.AccessToken {
+		return Error(openapi.ProtoOAErrorCode_CH_ACCESS_TOKEN_INVALID.String(), "access token is invalid")
+	}
+	res := &openapi.ProtoOAGetAccountListByAccessTokenRes{AccessToken: proto.String(s.AccessToken)}
+	for _, id := range sortedIds(s.accounts) {
+		res.CtidTraderAccount = append(res.CtidTraderAccount, &openapi.ProtoOACtidTraderAccount{
+			CtidTraderAccountId: proto.Uint64(uint64(id)),
+			IsLive:              proto.Bool(false),
+			TraderLogin:         proto.Int64(id),
+		})
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_GET_ACCOUNTS_BY_ACCESS_TOKEN_RES, res)
+}
+
+func (s *Server) handleAccountAuth(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAAccountAuthReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if !sess.IsAppAuthorized() {
+		return accountError(accountId, openapi.ProtoOAErrorCode_CH_CLIENT_NOT_AUTHENTICATED, "application is not authorized")
+	}
+	s.lock.Lock()
+	_, exists := s.accounts[accountId]
+	tokenOk := r.GetAccessToken() == s.AccessToken
+	s.lock.Unlock()
+	if !exists {
+		return accountError(accountId, openapi.ProtoOAErrorCode_CH_CTID_TRADER_ACCOUNT_NOT_FOUND, "trading account is not found")
+	}
+	if !tokenOk {
+		return accountError(accountId, openapi.ProtoOAErrorCode_CH_ACCESS_TOKEN_INVALID, "access token is invalid")
+	}
+	sess.lock.Lock()
+	sess.accounts[accountId] = struct{}{}
+	sess.lock.Unlock()
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{CtidTraderAccountId: proto.Int64(accountId)})
+}
+
+func (s *Server) handleAccountLogout(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAAccountLogoutReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	res := Reply(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_LOGOUT_RES, &openapi.ProtoOAAccountLogoutRes{CtidTraderAccountId: proto.Int64(accountId)})
+	// 与 cTrader 一致，注销完成后推送 ProtoOAAccountDisconnectEvent
+	res.after = append(res.after, func() { sess.DisconnectAccount(accountId) })
+	return res
+}
+
+func (s *Server) handleRefreshToken(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOARefreshTokenReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	if !sess.IsAppAuthorized() {
+		return Error(openapi.ProtoOAErrorCode_CH_CLIENT_NOT_AUTHENTICATED.String(), "application is not authorized")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r.GetRefreshToken() != s.RefreshToken {
+		return Error(openapi.ProtoOAErrorCode_CH_ACCESS_TOKEN_INVALID.String(), "refresh token is invalid")
+	}
+	id := s.newId()
+	s.AccessToken = fmt.Sprintf("%s-%d", DefaultAccessToken, id)
+	s.RefreshToken = fmt.Sprintf("%s-%d", DefaultRefreshToken, id)
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_REFRESH_TOKEN_RES, &openapi.ProtoOARefreshTokenRes{
+		AccessToken:  proto.String(s.AccessToken),
+		TokenType:    proto.String("bearer"),
+		ExpiresIn:    proto.Int64(s.TokenExpiresIn),
+		RefreshToken: proto.String(s.RefreshToken),
+	})
+}
+
+func (s *Server) handleSymbolsList(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOASymbolsListReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	if res := s.checkAccount(sess, r.GetCtidTraderAccountId()); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := &openapi.ProtoOASymbolsListRes{CtidTraderAccountId: proto.Int64(r.GetCtidTraderAccountId())}
+	for _, id := range sortedIds(s.symbols) {
+		sym := s.symbols[id]
+		res.Symbol = append(res.Symbol, lightSymbol(sym))
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_LIST_RES, res)
+}
+
+func lightSymbol(sym *Symbol) *openapi.ProtoOALightSymbol {
+	light := &openapi.ProtoOALightSymbol{
+		SymbolId:   proto.Int64(sym.Id),
+		SymbolName: proto.String(sym.Name),
+		Enabled:    proto.Bool(true),
+	}
+	if sym.BaseAssetId != 0 {
+		light.BaseAssetId = proto.Int64(sym.BaseAssetId)
+		light.QuoteAssetId = proto.Int64(sym.QuoteAssetId)
+	}
+	return light
+}
+
+func (s *Server) handleAssetList(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAAssetListReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	if res := s.checkAccount(sess, r.GetCtidTraderAccountId()); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := &openapi.ProtoOAAssetListRes{CtidTraderAccountId: proto.Int64(r.GetCtidTraderAccountId())}
+	for _, id := range sortedIds(s.assets) {
+		asset := s.assets[id]
+		res.Asset = append(res.Asset, &openapi.ProtoOAAsset{
+			AssetId:     proto.Int64(asset.Id),
+			Name:        proto.String(asset.Name),
+			DisplayName: proto.String(asset.Name),
+			Digits:      proto.Int32(asset.Digits),
+		})
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_ASSET_LIST_RES, res)
+}
+
+// handleSymbolsForConversion 按品种的基础/报价货币求最短的折算链
+func (s *Server) handleSymbolsForConversion(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOASymbolsForConversionReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	from, to := r.GetFirstAssetId(), r.GetLastAssetId()
+	// via 到达各资产的品种与上一个资产
+	type step struct {
+		symbol *Symbol
+		prev   int64
+	}
+	via := map[int64]step{from: {}}
+	queue := []int64{from}
+	for len(queue) > 0 && from != to {
+		asset := queue[0]
+		queue = queue[1:]
+		for _, id := range sortedIds(s.symbols) {
+			sym := s.symbols[id]
+			next := int64(0)
+			switch asset {
+			case sym.BaseAssetId:
+				next = sym.QuoteAssetId
+			case sym.QuoteAssetId:
+				next = sym.BaseAssetId
+			}
+			if next == 0 {
+				continue
+			}
+			if _, seen := via[next]; !seen {
+				via[next] = step{symbol: sym, prev: asset}
+				queue = append(queue, next)
+			}
+		}
+		if _, ok := via[to]; ok {
+			break
+		}
+	}
+	if _, ok := via[to]; !ok || from == to {
+		return accountError(accountId, openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND, "no conversion symbols")
+	}
+	var chain []*openapi.ProtoOALightSymbol
+	for asset := to; asset != from; asset = via[asset].prev {
+		chain = append([]*openapi.ProtoOALightSymbol{lightSymbol(via[asset].symbol)}, chain...)
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOLS_FOR_CONVERSION_RES, &openapi.ProtoOASymbolsForConversionRes{
+		CtidTraderAccountId: proto.Int64(accountId),
+		Symbol:              chain,
+	})
+}
+
+func (s *Server) handleSymbolById(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOASymbolByIdReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	if res := s.checkAccount(sess, r.GetCtidTraderAccountId()); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := &openapi.ProtoOASymbolByIdRes{CtidTraderAccountId: proto.Int64(r.GetCtidTraderAccountId())}
+	for _, id := range r.GetSymbolId() {
+		sym, ok := s.symbols[id]
+		if !ok {
+			continue
+		}
+		symbol := &openapi.ProtoOASymbol{
+			SymbolId:           proto.Int64(sym.Id),
+			Digits:             proto.Int32(sym.Digits),
+			PipPosition:        proto.Int32(sym.PipPosition),
+			EnableShortSelling: proto.Bool(true),
+			MinVolume:          proto.Int64(sym.MinVolume),
+			MaxVolume:          proto.Int64(sym.MaxVolume),
+			StepVolume:         proto.Int64(sym.StepVolume),
+			LotSize:            proto.Int64(sym.LotSize),
+			Schedule:           sym.Schedule,
+			Holiday:            sym.Holidays,
+		}
+		if sym.ScheduleTimeZone != "" {
+			symbol.ScheduleTimeZone = proto.String(sym.ScheduleTimeZone)
+		}
+		res.Symbol = append(res.Symbol, symbol)
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_RES, res)
+}
+
+func (s *Server) handleSubscribeSpots(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOASubscribeSpotsReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	quotes := make(map[int64]quote)
+	for _, id := range r.GetSymbolId() {
+		if _, ok := s.symbols[id]; !ok {
+			s.lock.Unlock()
+			return accountError(accountId, openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND, "symbol not found")
+		}
+		if q, ok := s.quotes[id]; ok {
+			quotes[id] = q
+		}
+	}
+	s.lock.Unlock()
+
+	sess.lock.Lock()
+	subscribed := sess.spots[accountId]
+	if subscribed == nil {
+		subscribed = make(map[int64]struct{})
+		sess.spots[accountId] = subscribed
+	}
+	for _, id := range r.GetSymbolId() {
+		if _, ok := subscribed[id]; ok {
+			sess.lock.Unlock()
+			return accountError(accountId, openapi.ProtoOAErrorCode_ALREADY_SUBSCRIBED, "already subscribed")
+		}
+	}
+	for _, id := range r.GetSymbolId() {
+		subscribed[id] = struct{}{}
+	}
+	sess.lock.Unlock()
+
+	res := Reply(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_RES, &openapi.ProtoOASubscribeSpotsRes{CtidTraderAccountId: proto.Int64(accountId)})
+	// 与真实服务端一致，订阅成功后立即推送一次当前报价
+	for _, id := range r.GetSymbolId() {
+		if q, ok := quotes[id]; ok {
+			ev := spotEvent(accountId, id, q.bid, q.ask)
+			res.after = append(res.after, func() { sess.Push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, ev) })
+		}
+	}
+	return res
+}
+
+func (s *Server) handleUnsubscribeSpots(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAUnsubscribeSpotsReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	sess.lock.Lock()
+	for _, id := range r.GetSymbolId() {
+		delete(sess.spots[accountId], id)
+	}
+	sess.lock.Unlock()
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_RES, &openapi.ProtoOAUnsubscribeSpotsRes{CtidTraderAccountId: proto.Int64(accountId)})
+}
+
+func (s *Server) handleTrader(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOATraderReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.accounts[accountId]
+	accessRights := openapi.ProtoOAAccessRights_FULL_ACCESS
+	accountType := openapi.ProtoOAAccountType_HEDGED
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_RES, &openapi.ProtoOATraderRes{
+		CtidTraderAccountId: proto.Int64(accountId),
+		Trader: &openapi.ProtoOATrader{
+			CtidTraderAccountId: proto.Int64(accountId),
+			Balance:             proto.Int64(a.balance),
+			DepositAssetId:      proto.Int64(DefaultDepositAssetId),
+			AccessRights:        &accessRights,
+			AccountType:         &accountType,
+			TraderLogin:         proto.Int64(accountId),
+			BrokerName:          proto.String("ctragotest"),
+			MoneyDigits:         proto.Uint32(moneyDigits),
+		},
+	})
+}
+
+func (s *Server) handleReconcile(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAReconcileReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.accounts[accountId]
+	res := &openapi.ProtoOAReconcileRes{CtidTraderAccountId: proto.Int64(accountId)}
+	for _, id := range sortedIds(a.positions) {
+		res.Position = append(res.Position, proto.Clone(a.positions[id]).(*openapi.ProtoOAPosition))
+	}
+	for _, id := range sortedIds(a.orders) {
+		res.Order = append(res.Order, proto.Clone(a.orders[id]).(*openapi.ProtoOAOrder))
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_RES, res)
+}
+
+func (s *Server) handleDealList(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOADealListReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	if r.GetFromTimestamp() > r.GetToTimestamp() {
+		return accountError(accountId, openapi.ProtoOAErrorCode_INCORRECT_BOUNDARIES, "fromTimestamp is after toTimestamp")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := &openapi.ProtoOADealListRes{CtidTraderAccountId: proto.Int64(accountId), HasMore: proto.Bool(false)}
+	for _, deal := range s.accounts[accountId].deals {
+		ts := deal.GetExecutionTimestamp()
+		if ts < r.GetFromTimestamp() || ts > r.GetToTimestamp() {
+			continue
+		}
+		if r.GetMaxRows() > 0 && len(res.Deal) >= int(r.GetMaxRows()) {
+			res.HasMore = proto.Bool(true)
+			break
+		}
+		res.Deal = append(res.Deal, proto.Clone(deal).(*openapi.ProtoOADeal))
+	}
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_RES, res)
+}
+
+func sortedIds[V any](m map[int64]V) []int64 {
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
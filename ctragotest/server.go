@@ -0,0 +1,356 @@
+// Package ctragotest 提供进程内的伪 cTrader OpenAPI 服务端，用于离线测试 ctrago 及基于它的策略
+//
+// Server 实现了应用/账户鉴权、令牌刷新、品种与资产列表、折算品种、报价订阅、下单与模拟成交、对账、成交记录和错误响应，
+// 可通过 Handle 按测试替换任意请求的处理逻辑。客户端可经内存 Transport、回环 WebSocket 或回环 TCP 连接：
+//
+//	srv := ctragotest.NewServer()
+//	defer srv.Close()
+//	client := ctrago.NewClientWithTransport(srv.NewTransport(), ctragotest.DefaultClientId, ctragotest.DefaultClientSecret, ctragotest.DefaultAccessToken)
+package ctragotest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// 默认的应用凭证、访问令牌、账户与品种
+const (
+	DefaultClientId     = "ctragotest-client"
+	DefaultClientSecret = "ctragotest-secret"
+	DefaultAccessToken  = "ctragotest-token"
+	DefaultRefreshToken = "ctragotest-refresh"
+	DefaultAccountId    = int64(1000001)
+	// DefaultBalance 默认账户余额，单位为分（moneyDigits = 2）
+	DefaultBalance = int64(10000_00)
+	// DefaultSymbolId 默认品种 EURUSD
+	DefaultSymbolId = int64(1)
+	// DefaultDepositAssetId 账户存款货币 USD，EUR 的资产ID为 2
+	DefaultDepositAssetId = int64(1)
+)
+
+// moneyDigits 金额精度，所有金额以分为单位
+const moneyDigits = 2
+
+// Symbol 品种配置
+type Symbol struct {
+	Id          int64
+	Name        string
+	Digits      int32
+	PipPosition int32
+	// 以下成交量单位为 0.01 个单位，与 cTrader 一致
+	MinVolume  int64
+	MaxVolume  int64
+	StepVolume int64
+	LotSize    int64
+	// BaseAssetId、QuoteAssetId 基础货币与报价货币，用于资产列表与折算品种
+	BaseAssetId  int64
+	QuoteAssetId int64
+	// Schedule、ScheduleTimeZone、Holidays 交易时段与假期，原样返回给 SymbolById
+	Schedule         []*openapi.ProtoOAInterval
+	ScheduleTimeZone string
+	Holidays         []*openapi.ProtoOAHoliday
+}
+
+// Asset 资产（货币）配置
+type Asset struct {
+	Id     int64
+	Name   string
+	Digits int32
+}
+
+// Request 客户端发来的请求
+type Request struct {
+	PayloadType uint32
+	ClientMsgId string
+	Payload     []byte
+}
+
+// Decode 将请求内容解析到 m
+func (r *Request) Decode(m proto.Message) error {
+	return proto.Unmarshal(r.Payload, m)
+}
+
+// Response 请求的响应，发送时会带上请求的 clientMsgId
+type Response struct {
+	PayloadType uint32
+	Payload     proto.Message
+
+	// after 在响应发出后执行，用于推送成交等后续事件
+	after []func()
+}
+
+// Reply 构造响应
+func Reply(payloadType openapi.ProtoOAPayloadType, payload proto.Message) *Response {
+	return &Response{PayloadType: uint32(payloadType), Payload: payload}
+}
+
+// Error 构造 ProtoOAErrorRes 错误响应，code 一般为 ProtoOAErrorCode 或 ProtoErrorCode 的名称
+func Error(code string, description string) *Response {
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{
+		ErrorCode:   proto.String(code),
+		Description: proto.String(description),
+	})
+}
+
+// HandlerFunc 处理一个请求；返回 nil 表示不响应
+type HandlerFunc func(sess *Session, req *Request) *Response
+
+// Server 伪 cTrader OpenAPI 服务端，方法均可并发调用
+type Server struct {
+	ClientId     string
+	ClientSecret string
+	AccessToken  string
+	// RefreshToken 刷新后 AccessToken 与 RefreshToken 都会更换，旧令牌随即失效
+	RefreshToken string
+	// TokenExpiresIn 刷新令牌返回的有效期（秒）
+	TokenExpiresIn int64
+
+	lock     sync.Mutex
+	symbols  map[int64]*Symbol
+	assets   map[int64]*Asset
+	quotes   map[int64]quote
+	accounts map[int64]*account
+	nextId   int64
+	handlers map[uint32]HandlerFunc
+	defaults map[uint32]HandlerFunc
+	requests []*Request
+	sessions map[*Session]struct{}
+	closers  []func()
+	closed   bool
+}
+
+type quote struct {
+	bid, ask float64
+}
+
+// NewServer 创建服务端，预置默认凭证、一个默认账户和默认品种 EURUSD（尚无报价，需先调用 SetPrice）
+func NewServer() *Server {
+	s := &Server{
+		ClientId:     DefaultClientId,
+		ClientSecret: DefaultClientSecret,
+		AccessToken:  DefaultAccessToken,
+		RefreshToken: DefaultRefreshToken,
+		// 与 cTrader 一致，约 30 天
+		TokenExpiresIn: 2628000,
+		symbols:        make(map[int64]*Symbol),
+		assets:         make(map[int64]*Asset),
+		quotes:         make(map[int64]quote),
+		accounts:       make(map[int64]*account),
+		handlers:       make(map[uint32]HandlerFunc),
+		sessions:       make(map[*Session]struct{}),
+	}
+	s.defaults = s.defaultHandlers()
+	s.AddAccount(DefaultAccountId, DefaultBalance)
+	s.AddAsset(Asset{Id: DefaultDepositAssetId, Name: "USD", Digits: 2})
+	s.AddAsset(Asset{Id: 2, Name: "EUR", Digits: 2})
+	s.AddSymbol(Symbol{Id: DefaultSymbolId, Name: "EURUSD", Digits: 5, PipPosition: 4, BaseAssetId: 2, QuoteAssetId: DefaultDepositAssetId})
+	return s
+}
+
+// AddAsset 添加资产
+func (s *Server) AddAsset(asset Asset) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.assets[asset.Id] = &asset
+}
+
+// AddAccount 添加交易账户，balance 单位为分；账户归属 AccessToken
+func (s *Server) AddAccount(accountId int64, balance int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accounts[accountId] = &account{
+		id:        accountId,
+		balance:   balance,
+		positions: make(map[int64]*openapi.ProtoOAPosition),
+		orders:    make(map[int64]*openapi.ProtoOAOrder),
+	}
+}
+
+// AddSymbol 添加品种，未设置的成交量限制使用常见的外汇默认值
+func (s *Server) AddSymbol(symbol Symbol) {
+	if symbol.MinVolume == 0 {
+		symbol.MinVolume = 100000
+	}
+	if symbol.MaxVolume == 0 {
+		symbol.MaxVolume = 10000000000
+	}
+	if symbol.StepVolume == 0 {
+		symbol.StepVolume = 100000
+	}
+	if symbol.LotSize == 0 {
+		symbol.LotSize = 10000000
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.symbols[symbol.Id] = &symbol
+}
+
+// Balance 返回账户余额（分）
+func (s *Server) Balance(accountId int64) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if a, ok := s.accounts[accountId]; ok {
+		return a.balance
+	}
+	return 0
+}
+
+// Tokens 返回当前有效的访问令牌与刷新令牌
+func (s *Server) Tokens() (accessToken, refreshToken string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.AccessToken, s.RefreshToken
+}
+
+// Handle 替换某类请求的处理逻辑，handler 为 nil 时恢复默认处理
+func (s *Server) Handle(payloadType openapi.ProtoOAPayloadType, handler HandlerFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if handler == nil {
+		delete(s.handlers, uint32(payloadType))
+		return
+	}
+	s.handlers[uint32(payloadType)] = handler
+}
+
+// Fail 让某类请求始终返回 ProtoOAErrorRes，直到再次调用 Handle 恢复
+func (s *Server) Fail(payloadType openapi.ProtoOAPayloadType, code openapi.ProtoOAErrorCode, description string) {
+	s.Handle(payloadType, func(*Session, *Request) *Response {
+		return Error(code.String(), description)
+	})
+}
+
+// DefaultHandler 返回某类请求的默认处理逻辑，便于在自定义 handler 中复用；不支持的类型返回 nil
+func (s *Server) DefaultHandler(payloadType openapi.ProtoOAPayloadType) HandlerFunc {
+	return s.defaults[uint32(payloadType)]
+}
+
+// Requests 返回收到的全部请求（不含心跳）
+func (s *Server) Requests() []*Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Request(nil), s.requests...)
+}
+
+// Sessions 返回当前所有连接
+func (s *Server) Sessions() []*Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// Push 向所有已授权 accountId 的连接推送事件
+func (s *Server) Push(accountId int64, payloadType openapi.ProtoOAPayloadType, payload proto.Message) {
+	for _, sess := range s.Sessions() {
+		if sess.IsAccountAuthorized(accountId) {
+			sess.Push(payloadType, payload)
+		}
+	}
+}
+
+// SetPrice 更新品种报价：向订阅了该品种的连接推送 ProtoOASpotEvent，并撮合触及价格的挂单
+func (s *Server) SetPrice(symbolId int64, bid, ask float64) {
+	s.lock.Lock()
+	s.quotes[symbolId] = quote{bid: bid, ask: ask}
+	var after []func()
+	for _, a := range s.accounts {
+		after = append(after, s.matchOrders(a, symbolId)...)
+	}
+	s.lock.Unlock()
+
+	for _, sess := range s.Sessions() {
+		for _, accountId := range sess.spotSubscribers(symbolId) {
+			sess.Push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, spotEvent(accountId, symbolId, bid, ask))
+		}
+	}
+	for _, fn := range after {
+		fn()
+	}
+}
+
+func spotEvent(accountId, symbolId int64, bid, ask float64) *openapi.ProtoOASpotEvent {
+	return &openapi.ProtoOASpotEvent{
+		CtidTraderAccountId: proto.Int64(accountId),
+		SymbolId:            proto.Int64(symbolId),
+		Bid:                 proto.Uint64(uint64(bid*1e5 + 0.5)),
+		Ask:                 proto.Uint64(uint64(ask*1e5 + 0.5)),
+		Timestamp:           proto.Int64(time.Now().UnixMilli()),
+	}
+}
+
+// Close 关闭所有连接与监听
+func (s *Server) Close() {
+	s.lock.Lock()
+	s.closed = true
+	closers := s.closers
+	s.closers = nil
+	s.lock.Unlock()
+	for _, sess := range s.Sessions() {
+		sess.Close()
+	}
+	for _, fn := range closers {
+		fn()
+	}
+}
+
+func (s *Server) addSession(sess *Session) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	s.sessions[sess] = struct{}{}
+	return true
+}
+
+func (s *Server) removeSession(sess *Session) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, sess)
+}
+
+func (s *Server) addCloser(fn func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closers = append(s.closers, fn)
+}
+
+// dispatch 处理一帧请求并发送响应
+func (s *Server) dispatch(sess *Session, data []byte) {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return
+	}
+	if msg.GetPayloadType() == uint32(openapi.ProtoPayloadType_HEARTBEAT_EVENT) {
+		return
+	}
+	req := &Request{PayloadType: msg.GetPayloadType(), ClientMsgId: msg.GetClientMsgId(), Payload: msg.GetPayload()}
+	s.lock.Lock()
+	s.requests = append(s.requests, req)
+	handler, ok := s.handlers[req.PayloadType]
+	if !ok {
+		handler = s.defaults[req.PayloadType]
+	}
+	s.lock.Unlock()
+
+	var res *Response
+	if handler == nil {
+		res = Error(openapi.ProtoErrorCode_UNSUPPORTED_MESSAGE.String(), "unsupported payload type")
+	} else {
+		res = handler(sess, req)
+	}
+	if res == nil {
+		return
+	}
+	sess.send(res.PayloadType, res.Payload, req.ClientMsgId)
+	for _, fn := range res.after {
+		fn()
+	}
+}
@@ -0,0 +1,227 @@
+package ctragotest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func newClient(t *testing.T, srv *ctragotest.Server) *ctrago.Client {
+	t.Helper()
+	client := ctrago.NewClientWithTransport(srv.NewTransport(), ctragotest.DefaultClientId, ctragotest.DefaultClientSecret, ctragotest.DefaultAccessToken)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func authorize(t *testing.T, ctx context.Context, client *ctrago.Client) *ctrago.Account {
+	t.Helper()
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := client.Account(ctragotest.DefaultAccountId)
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func recv[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	var zero T
+	return zero
+}
+
+func TestServer_TradingFlow(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account := authorize(t, ctx, client)
+
+	list, err := client.GetAccountList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.CtidTraderAccount) != 1 || int64(list.CtidTraderAccount[0].GetCtidTraderAccountId()) != ctragotest.DefaultAccountId {
+		t.Fatalf("unexpected account list: %v", list)
+	}
+	symbols, err := account.Symbol().SymbolList(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols.Symbol) != 1 || symbols.Symbol[0].GetSymbolName() != "EURUSD" {
+		t.Fatalf("unexpected symbols: %v", symbols)
+	}
+
+	spots := make(chan *openapi.ProtoOASpotEvent, 10)
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOASpotEvent{}
+		proto.Unmarshal(msg.Payload, ev)
+		spots <- ev
+	})
+	fills := make(chan *openapi.ProtoOAExecutionEvent, 10)
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOAExecutionEvent{}
+		proto.Unmarshal(msg.Payload, ev)
+		if ev.GetExecutionType() == openapi.ProtoOAExecutionType_ORDER_FILLED {
+			fills <- ev
+		}
+	})
+	if _, err := client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ), &openapi.ProtoOASubscribeSpotsReq{
+		CtidTraderAccountId: proto.Int64(ctragotest.DefaultAccountId),
+		SymbolId:            []int64{ctragotest.DefaultSymbolId},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	if ev := recv(t, spots); ev.GetBid() != 110000 || ev.GetAsk() != 110020 {
+		t.Errorf("unexpected spot: %v", ev)
+	}
+
+	// 市价买入按 ask 成交
+	res, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetExecutionType() != openapi.ProtoOAExecutionType_ORDER_ACCEPTED {
+		t.Errorf("expected ORDER_ACCEPTED, got %v", res.GetExecutionType())
+	}
+	fill := recv(t, fills)
+	positionId := fill.GetPosition().GetPositionId()
+	if fill.GetDeal().GetExecutionPrice() != 1.1002 || positionId == 0 {
+		t.Fatalf("unexpected fill: %v", fill)
+	}
+
+	// 平仓按 bid 成交，盈利 (1.1010-1.1002)*100000 = 80 分
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1010, 1.1012)
+	recv(t, spots)
+	if _, err := account.Order().ClosePosition(ctx, positionId, 100000); err != nil {
+		t.Fatal(err)
+	}
+	closeFill := recv(t, fills)
+	if got := closeFill.GetDeal().GetClosePositionDetail().GetGrossProfit(); got != 80 {
+		t.Errorf("expected gross profit 80, got %d", got)
+	}
+	if got := srv.Balance(ctragotest.DefaultAccountId); got != ctragotest.DefaultBalance+80 {
+		t.Errorf("unexpected balance %d", got)
+	}
+
+	opt := &ctrago.OrderOption{}
+	opt.WithLimitPrice(1.1020)
+	if _, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOATradeSide_SELL, 100000, opt); err != nil {
+		t.Fatal(err)
+	}
+	// 卖出限价单在报价触及后成交
+	reconcile, err := account.Trader().Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reconcile.Position) != 0 || len(reconcile.Order) != 1 {
+		t.Fatalf("unexpected reconcile: %v", reconcile)
+	}
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1020, 1.1022)
+	if fill := recv(t, fills); fill.GetDeal().GetExecutionPrice() != 1.1020 {
+		t.Errorf("unexpected limit fill: %v", fill)
+	}
+
+	deals, err := account.Trader().DealList(ctx, 0, time.Now().Add(time.Minute).UnixMilli(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deals.Deal) != 3 {
+		t.Errorf("expected 3 deals, got %d", len(deals.Deal))
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wrong := ctrago.NewClientWithTransport(srv.NewTransport(), "other", "secret", "token")
+	defer wrong.Close()
+	if _, err := wrong.ApplicationAuth(ctx); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_CH_CLIENT_AUTH_FAILURE) {
+		t.Errorf("expected CH_CLIENT_AUTH_FAILURE, got %v", err)
+	}
+
+	client := newClient(t, srv)
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := client.Account(ctragotest.DefaultAccountId)
+	if _, err := account.Trader().Trader(ctx); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_ACCOUNT_NOT_AUTHORIZED) {
+		t.Errorf("expected ACCOUNT_NOT_AUTHORIZED, got %v", err)
+	}
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_NO_QUOTES) {
+		t.Errorf("expected NO_QUOTES, got %v", err)
+	}
+
+	srv.Fail(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ, openapi.ProtoOAErrorCode_SERVER_IS_UNDER_MAINTENANCE, "maintenance")
+	if _, err := account.Trader().Trader(ctx); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_SERVER_IS_UNDER_MAINTENANCE) {
+		t.Errorf("expected scripted error, got %v", err)
+	}
+	srv.Handle(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ, nil)
+	trader, err := account.Trader().Trader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trader.GetTrader().GetBalance() != ctragotest.DefaultBalance {
+		t.Errorf("unexpected balance %d", trader.GetTrader().GetBalance())
+	}
+}
+
+func TestServer_Loopback(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	tcpAddr, err := srv.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]ctrago.Option{
+		"websocket": {ctrago.WithEndpoint(srv.ListenWebsocket())},
+		"tcp":       {ctrago.WithEndpoint(tcpAddr), ctrago.WithTransportKind(ctrago.TransportTCP), ctrago.WithoutTLS()},
+	}
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts = append(opts,
+				ctrago.WithCredentials(ctragotest.DefaultClientId, ctragotest.DefaultClientSecret),
+				ctrago.WithAccessToken(ctragotest.DefaultAccessToken),
+				ctrago.WithReconnectPolicy(ctrago.NoReconnect()),
+			)
+			client, err := ctrago.New(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			authorize(t, ctx, client)
+			if client.State() != ctrago.StateAppAuthorized {
+				t.Errorf("unexpected state %v", client.State())
+			}
+			version, err := client.Version(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version.GetVersion() != "ctragotest" {
+				t.Errorf("unexpected version %q", version.GetVersion())
+			}
+		})
+	}
+}
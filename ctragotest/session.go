@@ -0,0 +1,104 @@
+package ctragotest
+
+import (
+	"sync"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// Session 服务端的一个客户端连接，保存该连接的鉴权与订阅状态
+type Session struct {
+	server *Server
+	write  func(data []byte) error
+	close  func()
+
+	lock          sync.Mutex
+	appAuthorized bool
+	accounts      map[int64]struct{}
+	// spots 每个账户订阅的品种
+	spots     map[int64]map[int64]struct{}
+	closeOnce sync.Once
+}
+
+func newSession(server *Server, write func(data []byte) error, close func()) *Session {
+	return &Session{
+		server:   server,
+		write:    write,
+		close:    close,
+		accounts: make(map[int64]struct{}),
+		spots:    make(map[int64]map[int64]struct{}),
+	}
+}
+
+// Server 返回所属服务端
+func (s *Session) Server() *Server {
+	return s.server
+}
+
+// IsAppAuthorized 是否已完成应用鉴权
+func (s *Session) IsAppAuthorized() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.appAuthorized
+}
+
+// IsAccountAuthorized 账户是否已在该连接上鉴权
+func (s *Session) IsAccountAuthorized(accountId int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.accounts[accountId]
+	return ok
+}
+
+// Push 推送事件（不带 clientMsgId）
+func (s *Session) Push(payloadType openapi.ProtoOAPayloadType, payload proto.Message) error {
+	return s.send(uint32(payloadType), payload, "")
+}
+
+func (s *Session) send(payloadType uint32, payload proto.Message, clientMsgId string) error {
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := &openapi.ProtoMessage{PayloadType: proto.Uint32(payloadType), Payload: data}
+	if clientMsgId != "" {
+		msg.ClientMsgId = proto.String(clientMsgId)
+	}
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.write(raw)
+}
+
+// DisconnectAccount 断开该连接上的账户会话并推送 ProtoOAAccountDisconnectEvent
+func (s *Session) DisconnectAccount(accountId int64) error {
+	s.lock.Lock()
+	delete(s.accounts, accountId)
+	delete(s.spots, accountId)
+	s.lock.Unlock()
+	return s.Push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_DISCONNECT_EVENT, &openapi.ProtoOAAccountDisconnectEvent{
+		CtidTraderAccountId: proto.Int64(accountId),
+	})
+}
+
+// Close 由服务端断开连接，客户端会收到断线
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		s.server.removeSession(s)
+		s.close()
+	})
+}
+
+func (s *Session) spotSubscribers(symbolId int64) []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var accountIds []int64
+	for accountId, symbols := range s.spots {
+		if _, ok := symbols[symbolId]; ok {
+			accountIds = append(accountIds, accountId)
+		}
+	}
+	return accountIds
+}
@@ -0,0 +1,427 @@
+package ctragotest
+
+import (
+	"math"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// account 服务端保存的账户状态，均在 Server.lock 保护下访问
+type account struct {
+	id        int64
+	balance   int64
+	positions map[int64]*openapi.ProtoOAPosition
+	// orders 未成交的挂单
+	orders map[int64]*openapi.ProtoOAOrder
+	deals  []*openapi.ProtoOADeal
+}
+
+func (s *Server) newId() int64 {
+	s.nextId++
+	return s.nextId
+}
+
+// orderError 以 ProtoOAOrderErrorEvent 响应下单类请求，与真实服务端一致
+func orderError(accountId int64, code openapi.ProtoOAErrorCode, description string) *Response {
+	return Reply(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT, &openapi.ProtoOAOrderErrorEvent{
+		CtidTraderAccountId: proto.Int64(accountId),
+		ErrorCode:           proto.String(code.String()),
+		Description:         proto.String(description),
+	})
+}
+
+func executionEvent(accountId int64, executionType openapi.ProtoOAExecutionType, order *openapi.ProtoOAOrder, position *openapi.ProtoOAPosition, deal *openapi.ProtoOADeal) *openapi.ProtoOAExecutionEvent {
+	ev := &openapi.ProtoOAExecutionEvent{
+		CtidTraderAccountId: proto.Int64(accountId),
+		ExecutionType:       &executionType,
+	}
+	if order != nil {
+		ev.Order = proto.Clone(order).(*openapi.ProtoOAOrder)
+	}
+	if position != nil {
+		ev.Position = proto.Clone(position).(*openapi.ProtoOAPosition)
+	}
+	if deal != nil {
+		ev.Deal = proto.Clone(deal).(*openapi.ProtoOADeal)
+	}
+	return ev
+}
+
+// broadcast 向授权了账户的连接推送执行事件，except 为已通过响应收到该事件的连接
+func (s *Server) broadcast(accountId int64, ev *openapi.ProtoOAExecutionEvent, except *Session) func() {
+	return func() {
+		for _, sess := range s.Sessions() {
+			if sess != except && sess.IsAccountAuthorized(accountId) {
+				sess.Push(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT, ev)
+			}
+		}
+	}
+}
+
+// accepted 构造 ORDER_ACCEPTED 响应，并在响应后推送给其他连接以及执行后续撮合
+func (s *Server) accepted(sess *Session, accountId int64, ev *openapi.ProtoOAExecutionEvent, after []func()) *Response {
+	res := Reply(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT, ev)
+	res.after = append([]func(){s.broadcast(accountId, ev, sess)}, after...)
+	return res
+}
+
+func (s *Server) handleNewOrder(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOANewOrderReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.accounts[accountId]
+	sym, ok := s.symbols[r.GetSymbolId()]
+	if !ok {
+		return orderError(accountId, openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND, "symbol not found")
+	}
+	if res := checkVolume(accountId, sym, r.GetVolume()); res != nil {
+		return res
+	}
+	if r.GetPositionId() != 0 {
+		if _, ok := a.positions[r.GetPositionId()]; !ok {
+			return orderError(accountId, openapi.ProtoOAErrorCode_POSITION_NOT_FOUND, "position not found")
+		}
+	}
+	switch r.GetOrderType() {
+	case openapi.ProtoOAOrderType_MARKET, openapi.ProtoOAOrderType_MARKET_RANGE:
+		if _, ok := s.quotes[sym.Id]; !ok {
+			return orderError(accountId, openapi.ProtoOAErrorCode_NO_QUOTES, "no quotes for symbol")
+		}
+	case openapi.ProtoOAOrderType_LIMIT:
+		if r.GetLimitPrice() <= 0 {
+			return orderError(accountId, openapi.ProtoOAErrorCode_TRADING_BAD_PRICES, "limit price is required")
+		}
+	case openapi.ProtoOAOrderType_STOP, openapi.ProtoOAOrderType_STOP_LIMIT:
+		if r.GetStopPrice() <= 0 {
+			return orderError(accountId, openapi.ProtoOAErrorCode_TRADING_BAD_STOPS, "stop price is required")
+		}
+	default:
+		return orderError(accountId, openapi.ProtoOAErrorCode_TRADING_NOT_ALLOWED, "unsupported order type")
+	}
+
+	now := time.Now().UnixMilli()
+	status := openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED
+	orderType := r.GetOrderType()
+	tradeSide := r.GetTradeSide()
+	order := &openapi.ProtoOAOrder{
+		OrderId: proto.Int64(s.newId()),
+		TradeData: &openapi.ProtoOATradeData{
+			SymbolId:      proto.Int64(sym.Id),
+			Volume:        proto.Int64(r.GetVolume()),
+			TradeSide:     &tradeSide,
+			OpenTimestamp: proto.Int64(now),
+			Label:         r.Label,
+			Comment:       r.Comment,
+		},
+		OrderType:              &orderType,
+		OrderStatus:            &status,
+		UtcLastUpdateTimestamp: proto.Int64(now),
+		LimitPrice:             r.LimitPrice,
+		StopPrice:              r.StopPrice,
+		StopLoss:               r.StopLoss,
+		TakeProfit:             r.TakeProfit,
+		ClientOrderId:          r.ClientOrderId,
+		PositionId:             r.PositionId,
+		ExpirationTimestamp:    r.ExpirationTimestamp,
+	}
+	if r.TimeInForce != nil {
+		order.TimeInForce = r.TimeInForce
+	}
+	ev := executionEvent(accountId, openapi.ProtoOAExecutionType_ORDER_ACCEPTED, order, nil, nil)
+	var after []func()
+	if isMarket(orderType) {
+		after = append(after, s.broadcast(accountId, s.fill(a, order, s.marketPrice(sym.Id, tradeSide)), nil))
+	} else {
+		a.orders[order.GetOrderId()] = order
+		after = append(after, s.matchOrders(a, sym.Id)...)
+	}
+	return s.accepted(sess, accountId, ev, after)
+}
+
+func (s *Server) handleClosePosition(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAClosePositionReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.accounts[accountId]
+	pos, ok := a.positions[r.GetPositionId()]
+	if !ok {
+		return orderError(accountId, openapi.ProtoOAErrorCode_POSITION_NOT_FOUND, "position not found")
+	}
+	if r.GetVolume() <= 0 {
+		return orderError(accountId, openapi.ProtoOAErrorCode_TRADING_BAD_VOLUME, "volume must be positive")
+	}
+	symbolId := pos.GetTradeData().GetSymbolId()
+	if _, ok := s.quotes[symbolId]; !ok {
+		return orderError(accountId, openapi.ProtoOAErrorCode_NO_QUOTES, "no quotes for symbol")
+	}
+	now := time.Now().UnixMilli()
+	status := openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED
+	orderType := openapi.ProtoOAOrderType_MARKET
+	side := opposite(pos.GetTradeData().GetTradeSide())
+	order := &openapi.ProtoOAOrder{
+		OrderId: proto.Int64(s.newId()),
+		TradeData: &openapi.ProtoOATradeData{
+			SymbolId:      proto.Int64(symbolId),
+			Volume:        proto.Int64(min(r.GetVolume(), pos.GetTradeData().GetVolume())),
+			TradeSide:     &side,
+			OpenTimestamp: proto.Int64(now),
+		},
+		OrderType:              &orderType,
+		OrderStatus:            &status,
+		UtcLastUpdateTimestamp: proto.Int64(now),
+		ClosingOrder:           proto.Bool(true),
+		PositionId:             proto.Int64(pos.GetPositionId()),
+	}
+	ev := executionEvent(accountId, openapi.ProtoOAExecutionType_ORDER_ACCEPTED, order, pos, nil)
+	fill := s.fill(a, order, s.marketPrice(symbolId, side))
+	return s.accepted(sess, accountId, ev, []func(){s.broadcast(accountId, fill, nil)})
+}
+
+func (s *Server) handleCancelOrder(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOACancelOrderReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.accounts[accountId]
+	order, ok := a.orders[r.GetOrderId()]
+	if !ok {
+		return orderError(accountId, openapi.ProtoOAErrorCode_ORDER_NOT_FOUND, "order not found")
+	}
+	delete(a.orders, order.GetOrderId())
+	status := openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED
+	order.OrderStatus = &status
+	order.UtcLastUpdateTimestamp = proto.Int64(time.Now().UnixMilli())
+	return s.accepted(sess, accountId, executionEvent(accountId, openapi.ProtoOAExecutionType_ORDER_CANCELLED, order, nil, nil), nil)
+}
+
+func (s *Server) handleAmendOrder(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAAmendOrderReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.accounts[accountId]
+	order, ok := a.orders[r.GetOrderId()]
+	if !ok {
+		return orderError(accountId, openapi.ProtoOAErrorCode_ORDER_NOT_FOUND, "order not found")
+	}
+	if r.Volume != nil {
+		if res := checkVolume(accountId, s.symbols[order.GetTradeData().GetSymbolId()], r.GetVolume()); res != nil {
+			return res
+		}
+		order.TradeData.Volume = r.Volume
+	}
+	if r.LimitPrice != nil {
+		order.LimitPrice = r.LimitPrice
+	}
+	if r.StopPrice != nil {
+		order.StopPrice = r.StopPrice
+	}
+	if r.StopLoss != nil {
+		order.StopLoss = r.StopLoss
+	}
+	if r.TakeProfit != nil {
+		order.TakeProfit = r.TakeProfit
+	}
+	if r.ExpirationTimestamp != nil {
+		order.ExpirationTimestamp = r.ExpirationTimestamp
+	}
+	order.UtcLastUpdateTimestamp = proto.Int64(time.Now().UnixMilli())
+	ev := executionEvent(accountId, openapi.ProtoOAExecutionType_ORDER_REPLACED, order, nil, nil)
+	return s.accepted(sess, accountId, ev, s.matchOrders(a, order.GetTradeData().GetSymbolId()))
+}
+
+func (s *Server) handleAmendPositionSLTP(sess *Session, req *Request) *Response {
+	r := &openapi.ProtoOAAmendPositionSLTPReq{}
+	if err := req.Decode(r); err != nil {
+		return invalidRequest(err)
+	}
+	accountId := r.GetCtidTraderAccountId()
+	if res := s.checkAccount(sess, accountId); res != nil {
+		return res
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pos, ok := s.accounts[accountId].positions[r.GetPositionId()]
+	if !ok {
+		return orderError(accountId, openapi.ProtoOAErrorCode_POSITION_NOT_FOUND, "position not found")
+	}
+	pos.StopLoss = r.StopLoss
+	pos.TakeProfit = r.TakeProfit
+	pos.UtcLastUpdateTimestamp = proto.Int64(time.Now().UnixMilli())
+	return s.accepted(sess, accountId, executionEvent(accountId, openapi.ProtoOAExecutionType_ORDER_REPLACED, nil, pos, nil), nil)
+}
+
+func checkVolume(accountId int64, sym *Symbol, volume int64) *Response {
+	if volume < sym.MinVolume || volume > sym.MaxVolume || volume%sym.StepVolume != 0 {
+		return orderError(accountId, openapi.ProtoOAErrorCode_TRADING_BAD_VOLUME, "invalid volume")
+	}
+	return nil
+}
+
+func isMarket(orderType openapi.ProtoOAOrderType) bool {
+	return orderType == openapi.ProtoOAOrderType_MARKET || orderType == openapi.ProtoOAOrderType_MARKET_RANGE
+}
+
+func opposite(side openapi.ProtoOATradeSide) openapi.ProtoOATradeSide {
+	if side == openapi.ProtoOATradeSide_BUY {
+		return openapi.ProtoOATradeSide_SELL
+	}
+	return openapi.ProtoOATradeSide_BUY
+}
+
+// marketPrice 买入按 ask、卖出按 bid 成交
+func (s *Server) marketPrice(symbolId int64, side openapi.ProtoOATradeSide) float64 {
+	q := s.quotes[symbolId]
+	if side == openapi.ProtoOATradeSide_BUY {
+		return q.ask
+	}
+	return q.bid
+}
+
+// matchOrders 撮合触及当前报价的挂单，返回需在解锁后推送的成交事件
+func (s *Server) matchOrders(a *account, symbolId int64) []func() {
+	q, ok := s.quotes[symbolId]
+	if !ok {
+		return nil
+	}
+	var after []func()
+	for _, id := range sortedIds(a.orders) {
+		order := a.orders[id]
+		if order.GetTradeData().GetSymbolId() != symbolId {
+			continue
+		}
+		buy := order.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_BUY
+		var price float64
+		switch order.GetOrderType() {
+		case openapi.ProtoOAOrderType_LIMIT:
+			if buy && q.ask <= order.GetLimitPrice() || !buy && q.bid >= order.GetLimitPrice() {
+				price = order.GetLimitPrice()
+			}
+		case openapi.ProtoOAOrderType_STOP, openapi.ProtoOAOrderType_STOP_LIMIT:
+			if buy && q.ask >= order.GetStopPrice() || !buy && q.bid <= order.GetStopPrice() {
+				price = s.marketPrice(symbolId, order.GetTradeData().GetTradeSide())
+			}
+		}
+		if price == 0 {
+			continue
+		}
+		delete(a.orders, id)
+		after = append(after, s.broadcast(a.id, s.fill(a, order, price), nil))
+	}
+	return after
+}
+
+// fill 以 price 全部成交订单并更新持仓、余额与成交记录
+func (s *Server) fill(a *account, order *openapi.ProtoOAOrder, price float64) *openapi.ProtoOAExecutionEvent {
+	now := time.Now().UnixMilli()
+	side := order.GetTradeData().GetTradeSide()
+	volume := order.GetTradeData().GetVolume()
+	status := openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED
+	order.OrderStatus = &status
+	order.ExecutionPrice = proto.Float64(price)
+	order.ExecutedVolume = proto.Int64(volume)
+	order.UtcLastUpdateTimestamp = proto.Int64(now)
+
+	dealStatus := openapi.ProtoOADealStatus_FILLED
+	deal := &openapi.ProtoOADeal{
+		DealId:             proto.Int64(s.newId()),
+		OrderId:            proto.Int64(order.GetOrderId()),
+		Volume:             proto.Int64(volume),
+		FilledVolume:       proto.Int64(volume),
+		SymbolId:           proto.Int64(order.GetTradeData().GetSymbolId()),
+		CreateTimestamp:    proto.Int64(now),
+		ExecutionTimestamp: proto.Int64(now),
+		ExecutionPrice:     proto.Float64(price),
+		TradeSide:          &side,
+		DealStatus:         &dealStatus,
+		MoneyDigits:        proto.Uint32(moneyDigits),
+	}
+
+	pos, ok := a.positions[order.GetPositionId()]
+	switch {
+	case ok && pos.GetTradeData().GetTradeSide() != side:
+		// 平仓（可部分平仓），盈亏按报价货币即账户货币计算
+		closed := min(volume, pos.GetTradeData().GetVolume())
+		diff := price - pos.GetPrice()
+		if pos.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			diff = -diff
+		}
+		gross := int64(math.Round(diff * float64(closed)))
+		a.balance += gross
+		deal.ClosePositionDetail = &openapi.ProtoOAClosePositionDetail{
+			EntryPrice:   proto.Float64(pos.GetPrice()),
+			GrossProfit:  proto.Int64(gross),
+			Swap:         proto.Int64(0),
+			Commission:   proto.Int64(0),
+			Balance:      proto.Int64(a.balance),
+			ClosedVolume: proto.Int64(closed),
+			MoneyDigits:  proto.Uint32(moneyDigits),
+		}
+		pos.TradeData.Volume = proto.Int64(pos.GetTradeData().GetVolume() - closed)
+		if pos.GetTradeData().GetVolume() == 0 {
+			closedStatus := openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED
+			pos.PositionStatus = &closedStatus
+			pos.TradeData.CloseTimestamp = proto.Uint64(uint64(now))
+			delete(a.positions, pos.GetPositionId())
+		}
+	case ok:
+		// 加仓，按成交量加权平均开仓价
+		total := pos.GetTradeData().GetVolume() + volume
+		pos.Price = proto.Float64((pos.GetPrice()*float64(pos.GetTradeData().GetVolume()) + price*float64(volume)) / float64(total))
+		pos.TradeData.Volume = proto.Int64(total)
+	default:
+		openStatus := openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN
+		pos = &openapi.ProtoOAPosition{
+			PositionId: proto.Int64(s.newId()),
+			TradeData: &openapi.ProtoOATradeData{
+				SymbolId:      proto.Int64(order.GetTradeData().GetSymbolId()),
+				Volume:        proto.Int64(volume),
+				TradeSide:     &side,
+				OpenTimestamp: proto.Int64(now),
+				Label:         order.GetTradeData().Label,
+				Comment:       order.GetTradeData().Comment,
+			},
+			PositionStatus: &openStatus,
+			Price:          proto.Float64(price),
+			StopLoss:       order.StopLoss,
+			TakeProfit:     order.TakeProfit,
+			Swap:           proto.Int64(0),
+			Commission:     proto.Int64(0),
+			MoneyDigits:    proto.Uint32(moneyDigits),
+		}
+		a.positions[pos.GetPositionId()] = pos
+		order.PositionId = proto.Int64(pos.GetPositionId())
+	}
+	pos.UtcLastUpdateTimestamp = proto.Int64(now)
+	deal.PositionId = proto.Int64(pos.GetPositionId())
+	a.deals = append(a.deals, deal)
+	return executionEvent(a.id, openapi.ProtoOAExecutionType_ORDER_FILLED, order, pos, deal)
+}
@@ -0,0 +1,236 @@
+package ctragotest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/ctrago"
+)
+
+var ErrTransportClosed = errors.New("ctragotest: transport closed")
+
+// Transport 直接连接 Server 的内存传输层，实现 ctrago.Transport 与 ctrago.TransportNotifier
+//
+// 服务端的响应与推送按顺序在独立的 goroutine 中回调，无需调用 Listen
+type Transport struct {
+	session *Session
+
+	lock          sync.Mutex
+	cond          *sync.Cond
+	queue         [][]byte
+	handlers      []ctrago.MessageHandler
+	eventHandlers []ctrago.TransportEventHandler
+	closed        bool
+	// closing 由客户端主动关闭
+	closing bool
+	done    chan struct{}
+}
+
+// NewTransport 创建连接到 s 的内存传输层
+func (s *Server) NewTransport() *Transport {
+	t := &Transport{done: make(chan struct{})}
+	t.cond = sync.NewCond(&t.lock)
+	t.session = newSession(s, t.deliver, t.disconnect)
+	if !s.addSession(t.session) {
+		t.closed = true
+		close(t.done)
+		return t
+	}
+	go t.loop()
+	return t
+}
+
+// Session 返回服务端对应的连接
+func (t *Transport) Session() *Session {
+	return t.session
+}
+
+func (t *Transport) deliver(data []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.queue = append(t.queue, data)
+	t.cond.Broadcast()
+	return nil
+}
+
+func (t *Transport) loop() {
+	defer close(t.done)
+	for {
+		t.lock.Lock()
+		for len(t.queue) == 0 && !t.closed {
+			t.cond.Wait()
+		}
+		if t.closed {
+			t.lock.Unlock()
+			return
+		}
+		data := t.queue[0]
+		t.queue = t.queue[1:]
+		handlers := t.handlers
+		t.lock.Unlock()
+		for _, h := range handlers {
+			h(websocket.BinaryMessage, data)
+		}
+	}
+}
+
+func (t *Transport) Send(messageType int, data []byte) error {
+	t.lock.Lock()
+	closed := t.closed
+	t.lock.Unlock()
+	if closed {
+		return ErrTransportClosed
+	}
+	t.session.server.dispatch(t.session, data)
+	return nil
+}
+
+func (t *Transport) OnMessage(handler ctrago.MessageHandler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+// OnTransportEvent 注册连接事件回调，未关闭时立即回调 TransportConnected
+func (t *Transport) OnTransportEvent(handler ctrago.TransportEventHandler) {
+	t.lock.Lock()
+	t.eventHandlers = append(t.eventHandlers, handler)
+	closed := t.closed
+	t.lock.Unlock()
+	if !closed {
+		handler(ctrago.TransportConnected, nil)
+	}
+}
+
+// Listen 阻塞直到连接关闭
+func (t *Transport) Listen() error {
+	<-t.done
+	return nil
+}
+
+func (t *Transport) SetHeartbeat(time.Duration, func() (int, []byte)) {}
+
+func (t *Transport) Close() error {
+	t.lock.Lock()
+	t.closing = true
+	t.lock.Unlock()
+	t.session.Close()
+	return nil
+}
+
+// disconnect 关闭连接并通知客户端；由服务端断开时先通知 TransportDisconnected，内存连接不会重连
+func (t *Transport) disconnect() {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return
+	}
+	t.closed = true
+	t.cond.Broadcast()
+	byServer := !t.closing
+	handlers := append([]ctrago.TransportEventHandler(nil), t.eventHandlers...)
+	t.lock.Unlock()
+	for _, h := range handlers {
+		if byServer {
+			h(ctrago.TransportDisconnected, ErrTransportClosed)
+		}
+		h(ctrago.TransportClosed, nil)
+	}
+}
+
+// ListenWebsocket 在回环地址上启动 WebSocket 服务，返回 ws:// 地址，可配合 ctrago.WithEndpoint 使用
+func (s *Server) ListenWebsocket() string {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var writeLock sync.Mutex
+		sess := newSession(s, func(data []byte) error {
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			return conn.WriteMessage(websocket.BinaryMessage, data)
+		}, func() { conn.Close() })
+		if !s.addSession(sess) {
+			conn.Close()
+			return
+		}
+		defer sess.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			s.dispatch(sess, data)
+		}
+	}))
+	s.addCloser(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// ListenTCP 在回环地址上启动 TCP 服务（4 字节大端序长度前缀分帧），返回 host:port
+func (s *Server) ListenTCP() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.addCloser(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveTCP(conn)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+func (s *Server) serveTCP(conn net.Conn) {
+	var writeLock sync.Mutex
+	sess := newSession(s, func(data []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		frame := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(frame, uint32(len(data)))
+		copy(frame[4:], data)
+		_, err := conn.Write(frame)
+		return err
+	}, func() { conn.Close() })
+	if !s.addSession(sess) {
+		conn.Close()
+		return
+	}
+	defer sess.Close()
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > ctrago.MaxTcpFrameSize {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		s.dispatch(sess, data)
+	}
+}
+
+var _ ctrago.Transport = (*Transport)(nil)
+var _ ctrago.TransportNotifier = (*Transport)(nil)
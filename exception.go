@@ -1,6 +1,12 @@
 ﻿package ctrago
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
 
 var (
 	ErrSymbolIdRequired      error = fmt.Errorf("symbolId is required")
@@ -10,4 +16,78 @@ var (
 	ErrTimestampRange        error = fmt.Errorf("timestamp range is invalid, it should be less than 7 days")
 	ErrVolumeRequired        error = fmt.Errorf("volume is required")
 	ErrPositionIdRequired    error = fmt.Errorf("positionId is required")
+	ErrConnectionLost        error = fmt.Errorf("connection lost before response was received")
 )
+
+// APIError 服务端返回的错误响应（ProtoOAErrorRes、ProtoOAOrderErrorEvent 或 ProtoErrorRes）
+type APIError struct {
+	// PayloadType 错误响应的类型
+	PayloadType uint32
+	// ErrorCode 错误码，通常为 ProtoOAErrorCode 的名称
+	ErrorCode   string
+	Description string
+	AccountId   int64
+	OrderId     int64
+	PositionId  int64
+	// MaintenanceEndTimestamp 服务维护结束时间（毫秒）
+	MaintenanceEndTimestamp int64
+	// RetryAfter 请求频率超限时建议的重试等待秒数
+	RetryAfter uint64
+}
+
+func (e *APIError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.ErrorCode, e.Description)
+	}
+	return e.ErrorCode
+}
+
+// IsErrorCode 判断 err 是否为指定错误码的 APIError
+func IsErrorCode(err error, code openapi.ProtoOAErrorCode) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == code.String()
+}
+
+// responseError 将错误类响应转换为 *APIError，非错误响应返回 nil
+func responseError(msg *openapi.ProtoMessage) *APIError {
+	switch msg.GetPayloadType() {
+	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES):
+		res := &openapi.ProtoOAErrorRes{}
+		if err := proto.Unmarshal(msg.Payload, res); err != nil {
+			return &APIError{PayloadType: msg.GetPayloadType(), ErrorCode: "UNPARSEABLE_ERROR_RES", Description: err.Error()}
+		}
+		return &APIError{
+			PayloadType:             msg.GetPayloadType(),
+			ErrorCode:               res.GetErrorCode(),
+			Description:             res.GetDescription(),
+			AccountId:               res.GetCtidTraderAccountId(),
+			MaintenanceEndTimestamp: res.GetMaintenanceEndTimestamp(),
+			RetryAfter:              res.GetRetryAfter(),
+		}
+	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT):
+		res := &openapi.ProtoOAOrderErrorEvent{}
+		if err := proto.Unmarshal(msg.Payload, res); err != nil {
+			return &APIError{PayloadType: msg.GetPayloadType(), ErrorCode: "UNPARSEABLE_ORDER_ERROR_EVENT", Description: err.Error()}
+		}
+		return &APIError{
+			PayloadType: msg.GetPayloadType(),
+			ErrorCode:   res.GetErrorCode(),
+			Description: res.GetDescription(),
+			AccountId:   res.GetCtidTraderAccountId(),
+			OrderId:     res.GetOrderId(),
+			PositionId:  res.GetPositionId(),
+		}
+	case uint32(openapi.ProtoPayloadType_ERROR_RES):
+		res := &openapi.ProtoErrorRes{}
+		if err := proto.Unmarshal(msg.Payload, res); err != nil {
+			return &APIError{PayloadType: msg.GetPayloadType(), ErrorCode: "UNPARSEABLE_ERROR_RES", Description: err.Error()}
+		}
+		return &APIError{
+			PayloadType:             msg.GetPayloadType(),
+			ErrorCode:               res.GetErrorCode(),
+			Description:             res.GetDescription(),
+			MaintenanceEndTimestamp: int64(res.GetMaintenanceEndTimestamp()),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package export 将历史 K 线、tick、成交、订单与资金流水导出为 CSV 或 Parquet，便于在 pandas 或表格软件中分析
+//
+// 导出的列均为可直接阅读的值：价格按品种 digits 取整，金额按 moneyDigits 换算，成交量以基础货币单位表示，
+// 时间为 UTC（CSV 中为 ISO 8601），枚举为名称：
+//
+//	rows := export.Deals(res.Deal, export.Scale{MoneyDigits: trader.GetMoneyDigits(), Digits: export.SymbolDigits(symbols)})
+//	err := export.WriteFile("deals.parquet", rows)
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Format 导出格式
+type Format int
+
+const (
+	CSV Format = iota
+	Parquet
+)
+
+func (f Format) String() string {
+	if f == Parquet {
+		return "parquet"
+	}
+	return "csv"
+}
+
+// ParseFormat 根据名称或文件扩展名（csv、parquet、.parquet 等）解析格式
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "csv":
+		return CSV, nil
+	case "parquet", "pq":
+		return Parquet, nil
+	}
+	return CSV, fmt.Errorf("unknown export format %q", name)
+}
+
+// Write 以指定格式写出一组行，T 为本包的 *Row 类型
+func Write[T any](w io.Writer, format Format, rows []T) error {
+	if format == Parquet {
+		pw := parquet.NewGenericWriter[T](w)
+		if _, err := pw.Write(rows); err != nil {
+			return err
+		}
+		return pw.Close()
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteFile 按文件扩展名选择格式写出到文件
+func WriteFile[T any](path string, rows []T) error {
+	format, err := ParseFormat(filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, format, rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeCSV 以 parquet 标签中的名称为表头，每个字段一列；nil 指针与零值时间写为空
+func writeCSV[T any](w io.Writer, rows []T) error {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("export: unsupported row type %s", typ)
+	}
+	cw := csv.NewWriter(w)
+	header := make([]string, typ.NumField())
+	for i := range header {
+		field := typ.Field(i)
+		header[i], _, _ = strings.Cut(field.Tag.Get("parquet"), ",")
+		if header[i] == "" {
+			header[i] = field.Name
+		}
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, row := range rows {
+		v := reflect.ValueOf(row)
+		for i := range record {
+			record[i] = formatValue(v.Field(i))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Int64, reflect.Int32, reflect.Int:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint64, reflect.Uint32, reflect.Uint:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	}
+	return fmt.Sprint(v.Interface())
+}
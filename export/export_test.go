@@ -0,0 +1,116 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func testDeals() []*openapi.ProtoOADeal {
+	return []*openapi.ProtoOADeal{{
+		DealId:             proto.Int64(11),
+		OrderId:            proto.Int64(12),
+		PositionId:         proto.Int64(13),
+		Volume:             proto.Int64(100000),
+		FilledVolume:       proto.Int64(100000),
+		SymbolId:           proto.Int64(1),
+		CreateTimestamp:    proto.Int64(1760000000000),
+		ExecutionTimestamp: proto.Int64(1760000000123),
+		ExecutionPrice:     proto.Float64(1.1234500000000001),
+		TradeSide:          openapi.ProtoOATradeSide_SELL.Enum(),
+		DealStatus:         openapi.ProtoOADealStatus_FILLED.Enum(),
+		Commission:         proto.Int64(-350),
+		ClosePositionDetail: &openapi.ProtoOAClosePositionDetail{
+			EntryPrice:   proto.Float64(1.12),
+			GrossProfit:  proto.Int64(34500),
+			Swap:         proto.Int64(-120),
+			Commission:   proto.Int64(-350),
+			Balance:      proto.Int64(1034030),
+			ClosedVolume: proto.Int64(100000),
+		},
+	}, {
+		DealId:             proto.Int64(10),
+		OrderId:            proto.Int64(9),
+		PositionId:         proto.Int64(13),
+		Volume:             proto.Int64(100000),
+		FilledVolume:       proto.Int64(0),
+		SymbolId:           proto.Int64(1),
+		CreateTimestamp:    proto.Int64(1759990000000),
+		ExecutionTimestamp: proto.Int64(1759990000000),
+		TradeSide:          openapi.ProtoOATradeSide_BUY.Enum(),
+		DealStatus:         openapi.ProtoOADealStatus_REJECTED.Enum(),
+		Commission:         proto.Int64(0),
+		MoneyDigits:        proto.Uint32(3),
+	}}
+}
+
+func TestWrite_DealsCSV(t *testing.T) {
+	rows := Deals(testDeals(), Scale{MoneyDigits: 2, Digits: map[int64]int{1: 5}})
+	var buf bytes.Buffer
+	if err := Write(&buf, CSV, rows); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "deal_id,order_id,position_id,symbol_id,side,status,volume,") {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	want := "11,12,13,1,SELL,FILLED,1000,1000,2025-10-09T08:53:20Z,2025-10-09T08:53:20.123Z,1.12345,,-3.5,,1.12,1000,345,-1.2,-3.5,0,10340.3,"
+	if lines[1] != want {
+		t.Errorf("unexpected row\n got %s\nwant %s", lines[1], want)
+	}
+	if !strings.HasPrefix(lines[2], "10,9,13,1,BUY,REJECTED,1000,0,") || !strings.HasSuffix(lines[2], ",,,0,,,,,,,,,") {
+		t.Errorf("unexpected rejected deal row %q", lines[2])
+	}
+}
+
+func TestWrite_OrdersParquet(t *testing.T) {
+	orders := []*openapi.ProtoOAOrder{{
+		OrderId: proto.Int64(5),
+		TradeData: &openapi.ProtoOATradeData{
+			SymbolId:      proto.Int64(1),
+			Volume:        proto.Int64(250000),
+			TradeSide:     openapi.ProtoOATradeSide_BUY.Enum(),
+			OpenTimestamp: proto.Int64(1760000000000),
+			Label:         proto.String("grid"),
+		},
+		OrderType:   openapi.ProtoOAOrderType_LIMIT.Enum(),
+		OrderStatus: openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED.Enum(),
+		LimitPrice:  proto.Float64(1.099999999),
+		TimeInForce: openapi.ProtoOATimeInForce_GOOD_TILL_CANCEL.Enum(),
+	}}
+	var buf bytes.Buffer
+	if err := Write(&buf, Parquet, Orders(orders, Scale{Digits: map[int64]int{1: 5}})); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := parquet.Read[OrderRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+	row := rows[0]
+	if row.OrderType != "LIMIT" || row.Volume != 2500 || row.LimitPrice == nil || *row.LimitPrice != 1.1 || row.StopLoss != nil || row.Label != "grid" {
+		t.Errorf("unexpected row: %+v", row)
+	}
+	if row.OpenTime == nil || !row.OpenTime.Equal(time.UnixMilli(1760000000000)) || row.ExpirationTime != nil {
+		t.Errorf("unexpected timestamps: %v %v", row.OpenTime, row.ExpirationTime)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat(".parquet"); err != nil || f != Parquet {
+		t.Errorf("expected parquet, got %v %v", f, err)
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,305 @@
+package export
+
+import (
+	"math"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+)
+
+// Scale 数值换算参数
+type Scale struct {
+	// MoneyDigits 金额的小数位数（ProtoOATrader.MoneyDigits），消息自带 moneyDigits 时以消息为准
+	MoneyDigits uint32
+	// Digits 各品种价格的小数位数，未列出的品种不取整
+	Digits map[int64]int
+}
+
+// SymbolDigits 从品种详情中提取价格小数位数
+func SymbolDigits(symbols []*openapi.ProtoOASymbol) map[int64]int {
+	digits := make(map[int64]int, len(symbols))
+	for _, symbol := range symbols {
+		digits[symbol.GetSymbolId()] = int(symbol.GetDigits())
+	}
+	return digits
+}
+
+func (s Scale) price(symbolId int64, price float64) float64 {
+	if digits, ok := s.Digits[symbolId]; ok {
+		return round(price, digits)
+	}
+	return price
+}
+
+func (s Scale) money(value int64, digits uint32) float64 {
+	if digits == 0 {
+		digits = s.MoneyDigits
+	}
+	return round(ctrago.MoneyValue(value, digits), int(digits))
+}
+
+func round(v float64, digits int) float64 {
+	p := math.Pow10(digits)
+	return math.Round(v*p) / p
+}
+
+func millis(ts int64) time.Time {
+	return time.UnixMilli(ts).UTC()
+}
+
+// optionalTime 0 表示未设置
+func optionalTime(ts int64) *time.Time {
+	if ts == 0 {
+		return nil
+	}
+	return ptr(millis(ts))
+}
+
+// BarRow K 线
+type BarRow struct {
+	Time   time.Time `parquet:"time,timestamp(millisecond)"`
+	Period string    `parquet:"period"`
+	Open   float64   `parquet:"open"`
+	High   float64   `parquet:"high"`
+	Low    float64   `parquet:"low"`
+	Close  float64   `parquet:"close"`
+	Volume int64     `parquet:"volume"`
+}
+
+// Bars 转换解码后的 K 线，digits 为品种价格小数位数，小于 0 表示不取整
+func Bars(bars []ctrago.Bar, digits int) []BarRow {
+	p := func(v float64) float64 {
+		if digits < 0 {
+			return v
+		}
+		return round(v, digits)
+	}
+	rows := make([]BarRow, 0, len(bars))
+	for _, bar := range bars {
+		rows = append(rows, BarRow{
+			Time:   bar.Time,
+			Period: bar.Period.String(),
+			Open:   p(bar.Open),
+			High:   p(bar.High),
+			Low:    p(bar.Low),
+			Close:  p(bar.Close),
+			Volume: bar.Volume,
+		})
+	}
+	return rows
+}
+
+// Trendbars 转换 ProtoOAGetTrendbarsRes 的 Period 与 Trendbar，按时间升序排列
+func Trendbars(period openapi.ProtoOATrendbarPeriod, trendbars []*openapi.ProtoOATrendbar, digits int) []BarRow {
+	return Bars(ctrago.DecodeTrendbars(period, trendbars), digits)
+}
+
+// TickRow bid 或 ask tick
+type TickRow struct {
+	Time  time.Time `parquet:"time,timestamp(millisecond)"`
+	Type  string    `parquet:"type"`
+	Price float64   `parquet:"price"`
+}
+
+// Ticks 转换解码后的 tick，digits 小于 0 表示不取整
+func Ticks(ticks []ctrago.Tick, quoteType openapi.ProtoOAQuoteType, digits int) []TickRow {
+	rows := make([]TickRow, 0, len(ticks))
+	for _, tick := range ticks {
+		price := tick.Price
+		if digits >= 0 {
+			price = round(price, digits)
+		}
+		rows = append(rows, TickRow{Time: tick.Time, Type: quoteType.String(), Price: price})
+	}
+	return rows
+}
+
+// TickData 转换 ProtoOAGetTickDataRes.TickData，按时间升序排列
+func TickData(data []*openapi.ProtoOATickData, quoteType openapi.ProtoOAQuoteType, digits int) []TickRow {
+	return Ticks(ctrago.DecodeTicks(data), quoteType, digits)
+}
+
+// DealRow 成交，平仓成交带有 Close* 列
+type DealRow struct {
+	DealId             int64     `parquet:"deal_id"`
+	OrderId            int64     `parquet:"order_id"`
+	PositionId         int64     `parquet:"position_id"`
+	SymbolId           int64     `parquet:"symbol_id"`
+	Side               string    `parquet:"side"`
+	Status             string    `parquet:"status"`
+	Volume             float64   `parquet:"volume"`
+	FilledVolume       float64   `parquet:"filled_volume"`
+	CreateTime         time.Time `parquet:"create_time,timestamp(millisecond)"`
+	ExecutionTime      time.Time `parquet:"execution_time,timestamp(millisecond)"`
+	ExecutionPrice     *float64  `parquet:"execution_price,optional"`
+	MarginRate         *float64  `parquet:"margin_rate,optional"`
+	Commission         float64   `parquet:"commission"`
+	BaseToUsdRate      *float64  `parquet:"base_to_usd_rate,optional"`
+	EntryPrice         *float64  `parquet:"entry_price,optional"`
+	ClosedVolume       *float64  `parquet:"closed_volume,optional"`
+	GrossProfit        *float64  `parquet:"gross_profit,optional"`
+	Swap               *float64  `parquet:"swap,optional"`
+	CloseCommission    *float64  `parquet:"close_commission,optional"`
+	PnlConversionFee   *float64  `parquet:"pnl_conversion_fee,optional"`
+	Balance            *float64  `parquet:"balance,optional"`
+	QuoteToDepositRate *float64  `parquet:"quote_to_deposit_rate,optional"`
+}
+
+// Deals 转换 ProtoOADealListRes.Deal
+func Deals(deals []*openapi.ProtoOADeal, scale Scale) []DealRow {
+	rows := make([]DealRow, 0, len(deals))
+	for _, deal := range deals {
+		symbolId := deal.GetSymbolId()
+		row := DealRow{
+			DealId:        deal.GetDealId(),
+			OrderId:       deal.GetOrderId(),
+			PositionId:    deal.GetPositionId(),
+			SymbolId:      symbolId,
+			Side:          deal.GetTradeSide().String(),
+			Status:        deal.GetDealStatus().String(),
+			Volume:        ctrago.VolumeUnits(deal.GetVolume()),
+			FilledVolume:  ctrago.VolumeUnits(deal.GetFilledVolume()),
+			CreateTime:    millis(deal.GetCreateTimestamp()),
+			ExecutionTime: millis(deal.GetExecutionTimestamp()),
+			Commission:    scale.money(deal.GetCommission(), deal.GetMoneyDigits()),
+		}
+		if deal.ExecutionPrice != nil {
+			row.ExecutionPrice = ptr(scale.price(symbolId, deal.GetExecutionPrice()))
+		}
+		if deal.MarginRate != nil {
+			row.MarginRate = ptr(scale.price(symbolId, deal.GetMarginRate()))
+		}
+		if deal.BaseToUsdConversionRate != nil {
+			row.BaseToUsdRate = ptr(deal.GetBaseToUsdConversionRate())
+		}
+		if detail := deal.GetClosePositionDetail(); detail != nil {
+			digits := detail.GetMoneyDigits()
+			if digits == 0 {
+				digits = deal.GetMoneyDigits()
+			}
+			row.EntryPrice = ptr(scale.price(symbolId, detail.GetEntryPrice()))
+			row.ClosedVolume = ptr(ctrago.VolumeUnits(detail.GetClosedVolume()))
+			row.GrossProfit = ptr(scale.money(detail.GetGrossProfit(), digits))
+			row.Swap = ptr(scale.money(detail.GetSwap(), digits))
+			row.CloseCommission = ptr(scale.money(detail.GetCommission(), digits))
+			row.PnlConversionFee = ptr(scale.money(detail.GetPnlConversionFee(), digits))
+			row.Balance = ptr(scale.money(detail.GetBalance(), digits))
+			if detail.QuoteToDepositConversionRate != nil {
+				row.QuoteToDepositRate = ptr(detail.GetQuoteToDepositConversionRate())
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// OrderRow 订单
+type OrderRow struct {
+	OrderId            int64      `parquet:"order_id"`
+	PositionId         int64      `parquet:"position_id"`
+	SymbolId           int64      `parquet:"symbol_id"`
+	Side               string     `parquet:"side"`
+	OrderType          string     `parquet:"order_type"`
+	Status             string     `parquet:"status"`
+	TimeInForce        string     `parquet:"time_in_force"`
+	Volume             float64    `parquet:"volume"`
+	ExecutedVolume     float64    `parquet:"executed_volume"`
+	ExecutionPrice     *float64   `parquet:"execution_price,optional"`
+	LimitPrice         *float64   `parquet:"limit_price,optional"`
+	StopPrice          *float64   `parquet:"stop_price,optional"`
+	StopLoss           *float64   `parquet:"stop_loss,optional"`
+	TakeProfit         *float64   `parquet:"take_profit,optional"`
+	TrailingStopLoss   bool       `parquet:"trailing_stop_loss"`
+	GuaranteedStopLoss bool       `parquet:"guaranteed_stop_loss"`
+	ClosingOrder       bool       `parquet:"closing_order"`
+	IsStopOut          bool       `parquet:"is_stop_out"`
+	OpenTime           *time.Time `parquet:"open_time,optional"`
+	CloseTime          *time.Time `parquet:"close_time,optional"`
+	LastUpdateTime     *time.Time `parquet:"last_update_time,optional"`
+	ExpirationTime     *time.Time `parquet:"expiration_time,optional"`
+	Label              string     `parquet:"label"`
+	Comment            string     `parquet:"comment"`
+	ClientOrderId      string     `parquet:"client_order_id"`
+}
+
+// Orders 转换 ProtoOAOrderListRes.Order 或 ProtoOAReconcileRes.Order
+func Orders(orders []*openapi.ProtoOAOrder, scale Scale) []OrderRow {
+	rows := make([]OrderRow, 0, len(orders))
+	for _, order := range orders {
+		trade := order.GetTradeData()
+		symbolId := trade.GetSymbolId()
+		price := func(set bool, v float64) *float64 {
+			if !set {
+				return nil
+			}
+			return ptr(scale.price(symbolId, v))
+		}
+		rows = append(rows, OrderRow{
+			OrderId:            order.GetOrderId(),
+			PositionId:         order.GetPositionId(),
+			SymbolId:           symbolId,
+			Side:               trade.GetTradeSide().String(),
+			OrderType:          order.GetOrderType().String(),
+			Status:             order.GetOrderStatus().String(),
+			TimeInForce:        order.GetTimeInForce().String(),
+			Volume:             ctrago.VolumeUnits(trade.GetVolume()),
+			ExecutedVolume:     ctrago.VolumeUnits(order.GetExecutedVolume()),
+			ExecutionPrice:     price(order.ExecutionPrice != nil, order.GetExecutionPrice()),
+			LimitPrice:         price(order.LimitPrice != nil, order.GetLimitPrice()),
+			StopPrice:          price(order.StopPrice != nil, order.GetStopPrice()),
+			StopLoss:           price(order.StopLoss != nil, order.GetStopLoss()),
+			TakeProfit:         price(order.TakeProfit != nil, order.GetTakeProfit()),
+			TrailingStopLoss:   order.GetTrailingStopLoss(),
+			GuaranteedStopLoss: trade.GetGuaranteedStopLoss(),
+			ClosingOrder:       order.GetClosingOrder(),
+			IsStopOut:          order.GetIsStopOut(),
+			OpenTime:           optionalTime(trade.GetOpenTimestamp()),
+			CloseTime:          optionalTime(int64(trade.GetCloseTimestamp())),
+			LastUpdateTime:     optionalTime(order.GetUtcLastUpdateTimestamp()),
+			ExpirationTime:     optionalTime(order.GetExpirationTimestamp()),
+			Label:              trade.GetLabel(),
+			Comment:            trade.GetComment(),
+			ClientOrderId:      order.GetClientOrderId(),
+		})
+	}
+	return rows
+}
+
+// CashFlowRow 资金流水（充值、提现等）
+type CashFlowRow struct {
+	BalanceHistoryId int64     `parquet:"balance_history_id"`
+	OperationType    string    `parquet:"operation_type"`
+	Time             time.Time `parquet:"time,timestamp(millisecond)"`
+	Delta            float64   `parquet:"delta"`
+	Balance          float64   `parquet:"balance"`
+	Equity           *float64  `parquet:"equity,optional"`
+	BalanceVersion   int64     `parquet:"balance_version"`
+	Note             string    `parquet:"note"`
+}
+
+// CashFlows 转换 ProtoOACashFlowHistoryListRes.DepositWithdraw
+func CashFlows(items []*openapi.ProtoOADepositWithdraw, scale Scale) []CashFlowRow {
+	rows := make([]CashFlowRow, 0, len(items))
+	for _, item := range items {
+		digits := item.GetMoneyDigits()
+		row := CashFlowRow{
+			BalanceHistoryId: item.GetBalanceHistoryId(),
+			OperationType:    item.GetOperationType().String(),
+			Time:             millis(item.GetChangeBalanceTimestamp()),
+			Delta:            scale.money(item.GetDelta(), digits),
+			Balance:          scale.money(item.GetBalance(), digits),
+			BalanceVersion:   item.GetBalanceVersion(),
+			Note:             item.GetExternalNote(),
+		}
+		if item.Equity != nil {
+			row.Equity = ptr(scale.money(item.GetEquity(), digits))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,433 @@
+package ctrago
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// exposureRequestTimeout 事件触发的补充查询（新品种的折算链、订阅）的超时
+const exposureRequestTimeout = 10 * time.Second
+
+// SymbolExposure 单个品种的净头寸，对冲账户中同一品种的多空持仓相互抵消
+type SymbolExposure struct {
+	SymbolId     int64
+	SymbolName   string
+	BaseAssetId  int64
+	QuoteAssetId int64
+	// LongVolume、ShortVolume 多头与空头持仓量之和，NetVolume 为两者之差，单位 0.01
+	LongVolume  int64
+	ShortVolume int64
+	NetVolume   int64
+	Positions   int
+	// Value 净头寸按当前中间价折算为存款货币的价值，空头为负；Priced 为 false 表示缺少报价，Value 为 0
+	Value  float64
+	Priced bool
+}
+
+// AssetExposure 单个资产（货币）的净头寸
+//
+// 每个持仓计为基础货币 +volume、报价货币 -volume*开仓价（空头相反），
+// 因此各资产 Value 之和等于持仓的浮动盈亏（不含隔夜利息与手续费）
+type AssetExposure struct {
+	AssetId int64
+	Name    string
+	// Amount 资产净数量，多头为正
+	Amount float64
+	// Value Amount 折算为存款货币的价值，Priced 为 false 表示缺少报价
+	Value  float64
+	Priced bool
+}
+
+// Exposure 按品种与资产汇总账户的净头寸，随执行事件与报价实时更新
+//
+// 创建时对账并通过 Account.AcquireSpots 订阅持仓品种及折算所需品种的报价，Close 时释放这些订阅
+type Exposure struct {
+	account   *Account
+	accountId int64
+	stop      []func()
+
+	lock           sync.Mutex
+	closed         bool
+	depositAssetId int64
+	symbols        map[int64]*openapi.ProtoOALightSymbol
+	assets         map[int64]*openapi.ProtoOAAsset
+	positions      map[int64]*openapi.ProtoOAPosition
+	quotes         map[int64]exposureQuote
+	// conversions 资产折算为存款货币的品种链，空链表示无法折算
+	conversions map[int64][]*openapi.ProtoOALightSymbol
+	// subscribed 已订阅的品种及释放函数，pending 正在订阅的品种
+	subscribed map[int64]func()
+	pending    map[int64]struct{}
+	// touched 对账期间被执行事件更新过的持仓，对账结果不覆盖它们
+	loading bool
+	touched map[int64]struct{}
+}
+
+type exposureQuote struct {
+	bid, ask float64
+}
+
+// NewExposure 创建账户的头寸视图，账户需已完成鉴权
+func NewExposure(ctx context.Context, account *Account) (*Exposure, error) {
+	e := &Exposure{
+		account:     account,
+		accountId:   account.accountId,
+		symbols:     make(map[int64]*openapi.ProtoOALightSymbol),
+		assets:      make(map[int64]*openapi.ProtoOAAsset),
+		positions:   make(map[int64]*openapi.ProtoOAPosition),
+		quotes:      make(map[int64]exposureQuote),
+		conversions: make(map[int64][]*openapi.ProtoOALightSymbol),
+		subscribed:  make(map[int64]func()),
+		pending:     make(map[int64]struct{}),
+	}
+	trader, err := account.Trader().Trader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("trader: %w", err)
+	}
+	e.depositAssetId = trader.GetTrader().GetDepositAssetId()
+	assets, err := account.Asset().Assets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("asset list: %w", err)
+	}
+	for _, asset := range assets.GetAsset() {
+		e.assets[asset.GetAssetId()] = asset
+	}
+	symbols, err := account.Symbol().SymbolList(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("symbol list: %w", err)
+	}
+	for _, symbol := range symbols.GetSymbol() {
+		e.symbols[symbol.GetSymbolId()] = symbol
+	}
+	e.stop = append(e.stop,
+		account.watchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), e.handleExecution),
+		account.watchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), e.handleSpot),
+	)
+	if err := e.Refresh(ctx); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Refresh 重新对账并补齐报价订阅，断线重连后应调用以纠正期间错过的事件
+func (e *Exposure) Refresh(ctx context.Context) error {
+	e.lock.Lock()
+	e.loading = true
+	e.touched = make(map[int64]struct{})
+	e.lock.Unlock()
+	res, err := e.account.Trader().Reconcile(ctx, false)
+	e.lock.Lock()
+	if err == nil {
+		for id := range e.positions {
+			if _, ok := e.touched[id]; !ok {
+				delete(e.positions, id)
+			}
+		}
+		for _, pos := range res.GetPosition() {
+			if _, ok := e.touched[pos.GetPositionId()]; !ok {
+				e.positions[pos.GetPositionId()] = pos
+			}
+		}
+	}
+	e.loading = false
+	e.touched = nil
+	e.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("reconcile: %w", err)
+	}
+	return e.ensure(ctx)
+}
+
+// Close 停止更新并释放报价订阅，其他组件仍在使用的品种不会被退订
+func (e *Exposure) Close() {
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return
+	}
+	e.closed = true
+	subscribed := e.subscribed
+	e.subscribed = make(map[int64]func())
+	e.lock.Unlock()
+	for _, stop := range e.stop {
+		stop()
+	}
+	for _, release := range subscribed {
+		release()
+	}
+}
+
+// Symbols 返回有持仓的品种的净头寸，按品种ID排序
+func (e *Exposure) Symbols() []SymbolExposure {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	bySymbol := make(map[int64]*SymbolExposure)
+	for _, pos := range e.positions {
+		td := pos.GetTradeData()
+		s, ok := bySymbol[td.GetSymbolId()]
+		if !ok {
+			s = e.symbolExposureLocked(td.GetSymbolId())
+			bySymbol[td.GetSymbolId()] = s
+		}
+		if td.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			s.ShortVolume += td.GetVolume()
+		} else {
+			s.LongVolume += td.GetVolume()
+		}
+		s.Positions++
+	}
+	result := make([]SymbolExposure, 0, len(bySymbol))
+	for _, s := range bySymbol {
+		s.NetVolume = s.LongVolume - s.ShortVolume
+		price, ok := e.midLocked(s.SymbolId)
+		rate, rateOk := e.rateLocked(s.QuoteAssetId)
+		if s.Priced = ok && rateOk; s.Priced {
+			s.Value = VolumeUnits(s.NetVolume) * price * rate
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].SymbolId < result[j].SymbolId })
+	return result
+}
+
+// Symbol 返回单个品种的净头寸，无持仓时各数量为 0，Priced 表示是否已收到该品种及其折算所需的报价
+func (e *Exposure) Symbol(symbolId int64) SymbolExposure {
+	for _, s := range e.Symbols() {
+		if s.SymbolId == symbolId {
+			return s
+		}
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	s := e.symbolExposureLocked(symbolId)
+	_, ok := e.midLocked(symbolId)
+	_, rateOk := e.rateLocked(s.QuoteAssetId)
+	s.Priced = ok && rateOk
+	return *s
+}
+
+// Assets 返回各资产的净头寸，按资产ID排序
+func (e *Exposure) Assets() []AssetExposure {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	amounts := make(map[int64]float64)
+	for _, pos := range e.positions {
+		td := pos.GetTradeData()
+		symbol, ok := e.symbols[td.GetSymbolId()]
+		if !ok {
+			continue
+		}
+		volume := VolumeUnits(td.GetVolume())
+		if td.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			volume = -volume
+		}
+		amounts[symbol.GetBaseAssetId()] += volume
+		amounts[symbol.GetQuoteAssetId()] -= volume * pos.GetPrice()
+	}
+	result := make([]AssetExposure, 0, len(amounts))
+	for assetId, amount := range amounts {
+		result = append(result, e.assetExposureLocked(assetId, amount))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AssetId < result[j].AssetId })
+	return result
+}
+
+// Asset 按资产名称（如 "EUR"）返回净头寸，未知资产返回 false
+func (e *Exposure) Asset(name string) (AssetExposure, bool) {
+	for _, a := range e.Assets() {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, asset := range e.assets {
+		if asset.GetName() == name {
+			return e.assetExposureLocked(asset.GetAssetId(), 0), true
+		}
+	}
+	return AssetExposure{}, false
+}
+
+func (e *Exposure) symbolExposureLocked(symbolId int64) *SymbolExposure {
+	symbol := e.symbols[symbolId]
+	return &SymbolExposure{
+		SymbolId:     symbolId,
+		SymbolName:   symbol.GetSymbolName(),
+		BaseAssetId:  symbol.GetBaseAssetId(),
+		QuoteAssetId: symbol.GetQuoteAssetId(),
+	}
+}
+
+func (e *Exposure) assetExposureLocked(assetId int64, amount float64) AssetExposure {
+	a := AssetExposure{AssetId: assetId, Name: e.assets[assetId].GetName(), Amount: amount}
+	if rate, ok := e.rateLocked(assetId); ok {
+		a.Value = amount * rate
+		a.Priced = true
+	}
+	return a
+}
+
+// midLocked 品种的中间价，只有一侧报价时使用该侧
+func (e *Exposure) midLocked(symbolId int64) (float64, bool) {
+	q := e.quotes[symbolId]
+	switch {
+	case q.bid > 0 && q.ask > 0:
+		return (q.bid + q.ask) / 2, true
+	case q.bid > 0:
+		return q.bid, true
+	case q.ask > 0:
+		return q.ask, true
+	}
+	return 0, false
+}
+
+// rateLocked 1 单位资产折算为存款货币的汇率，沿折算链逐个品种乘除中间价
+func (e *Exposure) rateLocked(assetId int64) (float64, bool) {
+	if assetId == e.depositAssetId {
+		return 1, true
+	}
+	rate, current := 1.0, assetId
+	for _, symbol := range e.conversions[assetId] {
+		price, ok := e.midLocked(symbol.GetSymbolId())
+		if !ok {
+			return 0, false
+		}
+		switch current {
+		case symbol.GetBaseAssetId():
+			rate *= price
+			current = symbol.GetQuoteAssetId()
+		case symbol.GetQuoteAssetId():
+			rate /= price
+			current = symbol.GetBaseAssetId()
+		default:
+			return 0, false
+		}
+	}
+	return rate, current == e.depositAssetId
+}
+
+// ensure 为持仓涉及的资产查询折算链，并订阅持仓品种与折算品种的报价
+func (e *Exposure) ensure(ctx context.Context) error {
+	e.lock.Lock()
+	var assetIds []int64
+	needed := make(map[int64]struct{})
+	for _, pos := range e.positions {
+		symbolId := pos.GetTradeData().GetSymbolId()
+		needed[symbolId] = struct{}{}
+		symbol := e.symbols[symbolId]
+		for _, assetId := range []int64{symbol.GetBaseAssetId(), symbol.GetQuoteAssetId()} {
+			if _, ok := e.conversions[assetId]; !ok && assetId != 0 && assetId != e.depositAssetId && !slices.Contains(assetIds, assetId) {
+				assetIds = append(assetIds, assetId)
+			}
+		}
+	}
+	e.lock.Unlock()
+
+	for _, assetId := range assetIds {
+		res, err := e.account.Symbol().SymbolsForConversion(ctx, assetId, e.depositAssetId)
+		if err != nil {
+			return fmt.Errorf("symbols for conversion of asset %d: %w", assetId, err)
+		}
+		e.lock.Lock()
+		e.conversions[assetId] = res.GetSymbol()
+		e.lock.Unlock()
+	}
+
+	e.lock.Lock()
+	for _, chain := range e.conversions {
+		for _, symbol := range chain {
+			needed[symbol.GetSymbolId()] = struct{}{}
+		}
+	}
+	var symbolIds []int64
+	for id := range needed {
+		_, subscribed := e.subscribed[id]
+		_, pending := e.pending[id]
+		if !subscribed && !pending && !e.closed {
+			symbolIds = append(symbolIds, id)
+			e.pending[id] = struct{}{}
+		}
+	}
+	e.lock.Unlock()
+	if len(symbolIds) == 0 {
+		return nil
+	}
+	sort.Slice(symbolIds, func(i, j int) bool { return symbolIds[i] < symbolIds[j] })
+	var errs []error
+	for _, id := range symbolIds {
+		release, err := e.account.AcquireSpots(ctx, id)
+		e.lock.Lock()
+		delete(e.pending, id)
+		closed := e.closed
+		if err == nil && !closed {
+			e.subscribed[id] = release
+		}
+		e.lock.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscribe spots %d: %w", id, err))
+		} else if closed {
+			release()
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (e *Exposure) handleExecution(msg *openapi.ProtoMessage) {
+	ev := &openapi.ProtoOAExecutionEvent{}
+	if err := proto.Unmarshal(msg.Payload, ev); err != nil || ev.GetCtidTraderAccountId() != e.accountId || ev.Position == nil {
+		return
+	}
+	pos := ev.GetPosition()
+	e.lock.Lock()
+	if e.loading {
+		e.touched[pos.GetPositionId()] = struct{}{}
+	}
+	_, known := e.positions[pos.GetPositionId()]
+	if pos.GetPositionStatus() == openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED || pos.GetTradeData().GetVolume() == 0 {
+		delete(e.positions, pos.GetPositionId())
+	} else {
+		e.positions[pos.GetPositionId()] = pos
+	}
+	closed := e.closed
+	e.lock.Unlock()
+	if !known && !closed {
+		// 新品种可能需要订阅报价与折算链，不能在消息循环中发送同步请求
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), exposureRequestTimeout)
+			defer cancel()
+			if err := e.ensure(ctx); err != nil {
+				e.account.conn().log().Warn("ctrago exposure update failed", "accountId", e.accountId, "error", err)
+			}
+		}()
+	}
+}
+
+func (e *Exposure) handleSpot(msg *openapi.ProtoMessage) {
+	ev := &openapi.ProtoOASpotEvent{}
+	if err := proto.Unmarshal(msg.Payload, ev); err != nil || ev.GetCtidTraderAccountId() != e.accountId {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	q := e.quotes[ev.GetSymbolId()]
+	// 报价事件只携带变化的一侧
+	if ev.Bid != nil {
+		q.bid = float64(ev.GetBid()) / PriceScale
+	}
+	if ev.Ask != nil {
+		q.ask = float64(ev.GetAsk()) / PriceScale
+	}
+	e.quotes[ev.GetSymbolId()] = q
+}
@@ -0,0 +1,157 @@
+package ctrago_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+)
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestExposure(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.AddAsset(ctragotest.Asset{Id: 3, Name: "GBP", Digits: 2})
+	srv.AddSymbol(ctragotest.Symbol{Id: 2, Name: "EURGBP", Digits: 5, PipPosition: 4, BaseAssetId: 2, QuoteAssetId: 3})
+	srv.AddSymbol(ctragotest.Symbol{Id: 3, Name: "GBPUSD", Digits: 5, PipPosition: 4, BaseAssetId: 3, QuoteAssetId: 1})
+	srv.SetPrice(1, 1.1000, 1.1002)
+	srv.SetPrice(2, 0.8500, 0.8502)
+	srv.SetPrice(3, 1.2900, 1.2902)
+	account := newRiskAccount(t, ctx, srv)
+	orders := account.Order()
+	for _, o := range []struct {
+		symbolId int64
+		side     openapi.ProtoOATradeSide
+		volume   int64
+	}{
+		{1, openapi.ProtoOATradeSide_BUY, 300000},
+		{1, openapi.ProtoOATradeSide_SELL, 100000},
+		{2, openapi.ProtoOATradeSide_BUY, 100000},
+	} {
+		if _, err := orders.NewOrder(ctx, o.symbolId, openapi.ProtoOAOrderType_MARKET, o.side, o.volume, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "positions", func() bool {
+		res, err := account.Trader().Reconcile(ctx, false)
+		return err == nil && len(res.Position) == 3
+	})
+
+	exposure, err := ctrago.NewExposure(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exposure.Close()
+	eventually(t, "quotes", func() bool {
+		eur, _ := exposure.Asset("EUR")
+		gbp, _ := exposure.Asset("GBP")
+		return eur.Priced && gbp.Priced
+	})
+
+	eurusd := exposure.Symbol(1)
+	if eurusd.LongVolume != 300000 || eurusd.ShortVolume != 100000 || eurusd.NetVolume != 200000 || eurusd.Positions != 2 || !near(eurusd.Value, 2000*1.1001) {
+		t.Fatalf("unexpected EURUSD exposure %+v", eurusd)
+	}
+	want := map[string][2]float64{
+		"USD": {-3000*1.1002 + 1000*1.1000, -3000*1.1002 + 1000*1.1000},
+		"EUR": {3000, 3000 * 1.1001},
+		"GBP": {-1000 * 0.8502, -1000 * 0.8502 * 1.2901},
+	}
+	assets := exposure.Assets()
+	if len(assets) != len(want) {
+		t.Fatalf("unexpected assets %+v", assets)
+	}
+	for _, a := range assets {
+		if w := want[a.Name]; !near(a.Amount, w[0]) || !near(a.Value, w[1]) {
+			t.Errorf("%s: got amount %v value %v, want %v", a.Name, a.Amount, a.Value, w)
+		}
+	}
+
+	// 报价与平仓事件实时更新
+	srv.SetPrice(1, 1.2000, 1.2002)
+	eventually(t, "EUR revaluation", func() bool {
+		eur, _ := exposure.Asset("EUR")
+		return near(eur.Value, 3000*1.2001)
+	})
+	res, err := account.Trader().Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range res.Position {
+		if pos.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			if _, err := orders.ClosePosition(ctx, pos.GetPositionId(), pos.GetTradeData().GetVolume()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	eventually(t, "netting update", func() bool {
+		s := exposure.Symbol(1)
+		return s.ShortVolume == 0 && s.NetVolume == 300000 && s.Positions == 1
+	})
+}
+
+func TestExposure_SharesSpotSubscriptions(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(1, 1.1000, 1.1002)
+	account := newRiskAccount(t, ctx, srv)
+	if _, err := account.Order().NewOrder(ctx, 1, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "position", func() bool {
+		res, err := account.Trader().Reconcile(ctx, false)
+		return err == nil && len(res.Position) == 1
+	})
+	// 另一个组件（如算法引擎）先持有同一品种的订阅
+	release, err := account.AcquireSpots(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exposure, err := ctrago.NewExposure(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := exposure.Symbol(2); s.Priced {
+		t.Fatalf("symbol without quotes reported as priced: %+v", s)
+	}
+	exposure.Close()
+	unsubscribes := func() int {
+		n := 0
+		for _, req := range srv.Requests() {
+			if req.PayloadType == uint32(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_REQ) {
+				n++
+			}
+		}
+		return n
+	}
+	if n := unsubscribes(); n != 0 {
+		t.Fatalf("exposure unsubscribed a symbol still in use, %d requests", n)
+	}
+	release()
+	release()
+	if n := unsubscribes(); n != 1 {
+		t.Fatalf("expected one unsubscribe after the last release, got %d", n)
+	}
+}
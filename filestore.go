@@ -0,0 +1,474 @@
+package ctrago
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCorruptSegment 缓存分段文件损坏（截断、校验和不一致或格式错误）
+var ErrCorruptSegment = errors.New("corrupt market data segment")
+
+const (
+	segmentMagic   = "CTMD"
+	segmentVersion = 1
+	segmentExt     = ".seg"
+
+	segmentBars  byte = 1
+	segmentTicks byte = 2
+)
+
+// FileStore 基于本地文件的 MarketDataStore
+//
+// 目录结构为 <dir>/<broker>/<symbolId>/<series>/<YYYY-MM>.<序号>.seg：每次保存按自然月（UTC）切分，
+// 写入新的分段文件，记录覆盖的时间段与数据，并以 CRC32 校验。读取时忽略损坏的分段，相应时间段会重新请求；
+// 同一时间段有多个分段时以最新的为准。Compact 将同月的分段合并为一个文件
+type FileStore struct {
+	dir  string
+	lock sync.Mutex
+	last int64
+}
+
+// NewFileStore 创建以 dir 为根目录的 FileStore，目录不存在时自动创建
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Dir 缓存根目录
+func (s *FileStore) Dir() string {
+	return s.dir
+}
+
+func (s *FileStore) seriesDir(key SeriesKey) string {
+	broker := url.PathEscape(key.Broker)
+	if broker == "" {
+		broker = "_"
+	}
+	return filepath.Join(s.dir, broker, strconv.FormatInt(key.SymbolId, 10), key.Series())
+}
+
+// segment 一个分段文件的内容，记录统一以整数保存：时间为毫秒，价格为 1/PriceScale
+type segment struct {
+	kind    byte
+	covered []TimeRange
+	records [][]int64
+}
+
+func (seg *segment) width() int {
+	if seg.kind == segmentBars {
+		return 6
+	}
+	return 2
+}
+
+func (seg *segment) encode() []byte {
+	buf := make([]byte, 0, 16+len(seg.covered)*16+len(seg.records)*seg.width()*8)
+	buf = append(buf, segmentMagic...)
+	buf = append(buf, segmentVersion, seg.kind)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(seg.covered)))
+	for _, r := range seg.covered {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(r.From.UnixMilli()))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(r.To.UnixMilli()))
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(seg.records)))
+	for _, rec := range seg.records {
+		for _, v := range rec {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+		}
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+func decodeSegment(data []byte) (*segment, error) {
+	if len(data) < 16 || string(data[:4]) != segmentMagic {
+		return nil, ErrCorruptSegment
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, ErrCorruptSegment
+	}
+	if body[4] != segmentVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorruptSegment, body[4])
+	}
+	seg := &segment{kind: body[5]}
+	if seg.kind != segmentBars && seg.kind != segmentTicks {
+		return nil, fmt.Errorf("%w: unknown kind %d", ErrCorruptSegment, seg.kind)
+	}
+	n := int(binary.LittleEndian.Uint16(body[6:]))
+	pos := 8
+	if len(body) < pos+n*16+4 {
+		return nil, ErrCorruptSegment
+	}
+	for i := 0; i < n; i++ {
+		from := int64(binary.LittleEndian.Uint64(body[pos:]))
+		to := int64(binary.LittleEndian.Uint64(body[pos+8:]))
+		seg.covered = append(seg.covered, TimeRange{From: time.UnixMilli(from).UTC(), To: time.UnixMilli(to).UTC()})
+		pos += 16
+	}
+	count := int(binary.LittleEndian.Uint32(body[pos:]))
+	pos += 4
+	width := seg.width()
+	if len(body)-pos != count*width*8 {
+		return nil, ErrCorruptSegment
+	}
+	seg.records = make([][]int64, count)
+	for i := range seg.records {
+		rec := make([]int64, width)
+		for j := range rec {
+			rec[j] = int64(binary.LittleEndian.Uint64(body[pos:]))
+			pos += 8
+		}
+		seg.records[i] = rec
+	}
+	return seg, nil
+}
+
+func readSegment(path string) (*segment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := decodeSegment(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return seg, nil
+}
+
+// writeSegment 先写入临时文件再重命名，避免留下写了一半的分段
+func writeSegment(path string, seg *segment) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(seg.encode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// segmentFiles 按月份分组列出分段文件，每组按写入顺序排列
+func segmentFiles(dir string) (map[string][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]string)
+	for _, e := range entries {
+		name := e.Name()
+		month, _, ok := strings.Cut(name, ".")
+		if e.IsDir() || !ok || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		files[month] = append(files[month], filepath.Join(dir, name))
+	}
+	for _, paths := range files {
+		sort.Strings(paths)
+	}
+	return files, nil
+}
+
+// merge 合并分段，越新的分段优先；只保留 [from, to) 内的记录与覆盖范围
+func merge(segments []*segment, from, to time.Time) ([][]int64, []TimeRange) {
+	window := TimeRange{From: from, To: to}
+	var records [][]int64
+	var covered []TimeRange
+	for i := len(segments) - 1; i >= 0; i-- {
+		seg := segments[i]
+		for _, rec := range seg.records {
+			ts := time.UnixMilli(rec[0])
+			if !window.Contains(ts) || inRanges(covered, ts) {
+				continue
+			}
+			records = append(records, rec)
+		}
+		covered = append(covered, seg.covered...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i][0] < records[j][0] })
+	var clipped []TimeRange
+	for _, r := range MergeRanges(covered) {
+		if r.From.Before(from) {
+			r.From = from
+		}
+		if r.To.After(to) {
+			r.To = to
+		}
+		if r.To.After(r.From) {
+			clipped = append(clipped, r)
+		}
+	}
+	return records, clipped
+}
+
+func inRanges(ranges []TimeRange, t time.Time) bool {
+	for _, r := range ranges {
+		if r.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FileStore) load(key SeriesKey, kind byte, from, to time.Time) ([][]int64, []TimeRange, error) {
+	if !to.After(from) {
+		return nil, nil, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	files, err := segmentFiles(s.seriesDir(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	var segments []*segment
+	for month := monthStart(from); month.Before(to); month = month.AddDate(0, 1, 0) {
+		for _, path := range files[month.Format("2006-01")] {
+			seg, err := readSegment(path)
+			if errors.Is(err, ErrCorruptSegment) || err == nil && seg.kind != kind {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			segments = append(segments, seg)
+		}
+	}
+	records, covered := merge(segments, from, to)
+	return records, covered, nil
+}
+
+func (s *FileStore) save(key SeriesKey, kind byte, covered TimeRange, records [][]int64) error {
+	// 覆盖范围向内取整到毫秒
+	from := covered.From.Add(time.Millisecond - time.Nanosecond).Truncate(time.Millisecond)
+	to := covered.To.Truncate(time.Millisecond)
+	if !to.After(from) {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dir := s.seriesDir(key)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for month := monthStart(from); month.Before(to); month = month.AddDate(0, 1, 0) {
+		part := TimeRange{From: month, To: month.AddDate(0, 1, 0)}
+		if part.From.Before(from) {
+			part.From = from
+		}
+		if part.To.After(to) {
+			part.To = to
+		}
+		seg := &segment{kind: kind, covered: []TimeRange{part}}
+		for _, rec := range records {
+			if part.Contains(time.UnixMilli(rec[0])) {
+				seg.records = append(seg.records, rec)
+			}
+		}
+		if err := writeSegment(s.segmentPath(dir, month), seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentPath 新分段的文件名，序号单调递增以保证按名称排序即为写入顺序
+func (s *FileStore) segmentPath(dir string, month time.Time) string {
+	seq := max(time.Now().UnixNano(), s.last+1)
+	s.last = seq
+	return filepath.Join(dir, fmt.Sprintf("%s.%019d%s", month.Format("2006-01"), seq, segmentExt))
+}
+
+func scalePrice(price float64) int64 {
+	return int64(math.Round(price * PriceScale))
+}
+
+// LoadBars 实现 MarketDataStore
+func (s *FileStore) LoadBars(key SeriesKey, from, to time.Time) ([]Bar, []TimeRange, error) {
+	records, covered, err := s.load(key, segmentBars, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	bars := make([]Bar, 0, len(records))
+	for _, rec := range records {
+		bars = append(bars, Bar{
+			Time:   time.UnixMilli(rec[0]).UTC(),
+			Period: key.Period,
+			Open:   float64(rec[1]) / PriceScale,
+			High:   float64(rec[2]) / PriceScale,
+			Low:    float64(rec[3]) / PriceScale,
+			Close:  float64(rec[4]) / PriceScale,
+			Volume: rec[5],
+		})
+	}
+	return bars, covered, nil
+}
+
+// SaveBars 实现 MarketDataStore
+func (s *FileStore) SaveBars(key SeriesKey, covered TimeRange, bars []Bar) error {
+	records := make([][]int64, 0, len(bars))
+	for _, bar := range bars {
+		records = append(records, []int64{bar.Time.UnixMilli(), scalePrice(bar.Open), scalePrice(bar.High), scalePrice(bar.Low), scalePrice(bar.Close), bar.Volume})
+	}
+	return s.save(key, segmentBars, covered, records)
+}
+
+// LoadTicks 实现 MarketDataStore
+func (s *FileStore) LoadTicks(key SeriesKey, from, to time.Time) ([]Tick, []TimeRange, error) {
+	records, covered, err := s.load(key, segmentTicks, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	ticks := make([]Tick, 0, len(records))
+	for _, rec := range records {
+		ticks = append(ticks, Tick{Time: time.UnixMilli(rec[0]).UTC(), Price: float64(rec[1]) / PriceScale})
+	}
+	return ticks, covered, nil
+}
+
+// SaveTicks 实现 MarketDataStore
+func (s *FileStore) SaveTicks(key SeriesKey, covered TimeRange, ticks []Tick) error {
+	records := make([][]int64, 0, len(ticks))
+	for _, tick := range ticks {
+		records = append(records, []int64{tick.Time.UnixMilli(), scalePrice(tick.Price)})
+	}
+	return s.save(key, segmentTicks, covered, records)
+}
+
+// seriesDirs 列出全部序列目录
+func (s *FileStore) seriesDirs() ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), segmentExt) {
+			if dir := filepath.Dir(path); len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+				dirs = append(dirs, dir)
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// Verify 校验全部分段文件，返回损坏的文件路径
+func (s *FileStore) Verify() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dirs, err := s.seriesDirs()
+	if err != nil {
+		return nil, err
+	}
+	var corrupt []string
+	for _, dir := range dirs {
+		files, err := segmentFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, paths := range files {
+			for _, path := range paths {
+				if _, err := readSegment(path); errors.Is(err, ErrCorruptSegment) {
+					corrupt = append(corrupt, path)
+				} else if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	sort.Strings(corrupt)
+	return corrupt, nil
+}
+
+// Compact 将每个序列同月的分段合并为一个文件，并删除损坏的分段（其时间段会在下次读取时重新请求）
+func (s *FileStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dirs, err := s.seriesDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		files, err := segmentFiles(dir)
+		if err != nil {
+			return err
+		}
+		for name, paths := range files {
+			if err := s.compactMonth(dir, name, paths); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) compactMonth(dir, name string, paths []string) error {
+	month, err := time.Parse("2006-01", name)
+	if err != nil {
+		return nil
+	}
+	var segments []*segment
+	for _, path := range paths {
+		seg, err := readSegment(path)
+		if errors.Is(err, ErrCorruptSegment) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 1 && len(paths) == 1 {
+		return nil
+	}
+	if len(segments) > 0 {
+		merged := &segment{kind: segments[len(segments)-1].kind}
+		var kept []*segment
+		for _, seg := range segments {
+			if seg.kind == merged.kind {
+				kept = append(kept, seg)
+			}
+		}
+		merged.records, merged.covered = merge(kept, month, month.AddDate(0, 1, 0))
+		if err := writeSegment(s.segmentPath(dir, month), merged); err != nil {
+			return err
+		}
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ MarketDataStore = (*FileStore)(nil)
@@ -0,0 +1,100 @@
+package ctrago
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+func TestFileStore_BarsAcrossMonths(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := SeriesKey{Broker: "Demo Broker/1", SymbolId: 1, Period: openapi.ProtoOATrendbarPeriod_D1}
+	start := time.Date(2026, 9, 29, 0, 0, 0, 0, time.UTC)
+	var bars []Bar
+	for i := 0; i < 4; i++ {
+		bars = append(bars, Bar{Time: start.AddDate(0, 0, i), Period: key.Period, Open: 1.1, High: 1.2, Low: 1.05, Close: float64(115000+i) / PriceScale, Volume: int64(i)})
+	}
+	if err := store.SaveBars(key, TimeRange{From: start, To: start.AddDate(0, 0, 4)}, bars); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(store.Dir(), "*", "1", "D1", "*.seg"))
+	if len(files) != 2 {
+		t.Fatalf("expected one segment per month, got %v", files)
+	}
+
+	loaded, covered, err := store.LoadBars(key, start.AddDate(0, 0, 1), start.AddDate(0, 0, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 3 || loaded[0] != bars[1] || loaded[2] != bars[3] {
+		t.Fatalf("unexpected bars: %+v", loaded)
+	}
+	if len(covered) != 1 || !covered[0].From.Equal(start.AddDate(0, 0, 1)) || !covered[0].To.Equal(start.AddDate(0, 0, 4)) {
+		t.Fatalf("unexpected covered ranges: %+v", covered)
+	}
+
+	// 较新的分段覆盖旧数据
+	bars[2].Close = 1.3
+	if err := store.SaveBars(key, TimeRange{From: bars[2].Time, To: bars[3].Time}, bars[2:3]); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, _ = store.LoadBars(key, start, start.AddDate(0, 0, 4))
+	if len(loaded) != 4 || loaded[2].Close != 1.3 {
+		t.Fatalf("expected newer segment to win: %+v", loaded)
+	}
+
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = filepath.Glob(filepath.Join(store.Dir(), "*", "1", "D1", "*.seg"))
+	if len(files) != 2 {
+		t.Fatalf("expected one segment per month after compaction, got %v", files)
+	}
+	compacted, covered, _ := store.LoadBars(key, start, start.AddDate(0, 0, 4))
+	if len(compacted) != 4 || compacted[2].Close != 1.3 || len(covered) != 1 {
+		t.Fatalf("unexpected data after compaction: %+v %+v", compacted, covered)
+	}
+}
+
+func TestFileStore_CorruptSegment(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := SeriesKey{Broker: "demo", SymbolId: 2, QuoteType: openapi.ProtoOAQuoteType_BID}
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	ticks := []Tick{{Time: start, Price: 1.1}, {Time: start, Price: 1.10001}, {Time: start.Add(time.Second), Price: 1.10002}}
+	if err := store.SaveTicks(key, TimeRange{From: start, To: start.Add(time.Minute)}, ticks); err != nil {
+		t.Fatal(err)
+	}
+	loaded, covered, err := store.LoadTicks(key, start, start.Add(time.Hour))
+	if err != nil || len(loaded) != 3 || loaded[1] != ticks[1] || len(covered) != 1 {
+		t.Fatalf("unexpected ticks: %+v %+v %v", loaded, covered, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(store.Dir(), "demo", "2", "BID", "*.seg"))
+	data, _ := os.ReadFile(files[0])
+	data[len(data)-5] ^= 0xff
+	os.WriteFile(files[0], data, 0o644)
+
+	corrupt, err := store.Verify()
+	if err != nil || len(corrupt) != 1 || corrupt[0] != files[0] {
+		t.Fatalf("expected corrupt segment to be reported, got %v %v", corrupt, err)
+	}
+	loaded, covered, err = store.LoadTicks(key, start, start.Add(time.Hour))
+	if err != nil || len(loaded) != 0 || len(covered) != 0 {
+		t.Fatalf("expected corrupt segment to be ignored, got %+v %+v %v", loaded, covered, err)
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if corrupt, _ := store.Verify(); len(corrupt) != 0 {
+		t.Fatalf("expected compaction to remove corrupt segment, got %v", corrupt)
+	}
+}
@@ -0,0 +1,405 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrAccountHalted 账户已被熔断，Rearm 之前拒绝新订单
+	ErrAccountHalted = errors.New("account is halted by kill switch")
+	// ErrNotFlat FlattenAll 结束时账户仍有持仓或挂单，详情见 *FlattenReport
+	ErrNotFlat = errors.New("account is not flat")
+)
+
+const (
+	DefaultFlattenConcurrency   = 5
+	DefaultFlattenInterval      = 25 * time.Millisecond
+	DefaultFlattenVerifyTimeout = 10 * time.Second
+	DefaultFlattenRounds        = 3
+)
+
+// haltRegistry 被熔断的账户及原因，零值可用
+type haltRegistry struct {
+	lock    sync.Mutex
+	reasons map[int64]string
+}
+
+func (h *haltRegistry) set(accountId int64, reason string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.reasons == nil {
+		h.reasons = make(map[int64]string)
+	}
+	h.reasons[accountId] = reason
+}
+
+func (h *haltRegistry) clear(accountId int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.reasons, accountId)
+}
+
+func (h *haltRegistry) get(accountId int64) (string, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	reason, ok := h.reasons[accountId]
+	return reason, ok
+}
+
+func (a *Account) halts() *haltRegistry {
+	if a.pool != nil {
+		return &a.pool.halts
+	}
+	return &a.client.halts
+}
+
+// Halt 熔断账户：此后 NewOrder 以 ErrAccountHalted 拒绝，直到调用 Rearm；撤单、改单与平仓不受影响
+func (a *Account) Halt(reason string) {
+	a.halts().set(a.accountId, reason)
+	a.conn().log().Warn("ctrago account halted", "accountId", a.accountId, "reason", reason)
+}
+
+// Rearm 解除熔断，恢复下单
+func (a *Account) Rearm() {
+	a.halts().clear(a.accountId)
+	a.conn().log().Info("ctrago account rearmed", "accountId", a.accountId)
+}
+
+// Halted 返回账户是否被熔断及原因
+func (a *Account) Halted() (reason string, halted bool) {
+	return a.halts().get(a.accountId)
+}
+
+// FlattenOptions FlattenAll 的参数，零值表示处理全部持仓与挂单
+type FlattenOptions struct {
+	// SymbolIds 非空时只处理这些品种
+	SymbolIds []int64
+	// Label 非空时只处理该标签的持仓与挂单
+	Label string
+	// Halt 开始前熔断账户，结束后保持熔断，需显式 Rearm
+	Halt       bool
+	HaltReason string
+	// Concurrency 同时进行的请求数，默认 DefaultFlattenConcurrency
+	Concurrency int
+	// Interval 相邻请求的最小间隔，默认 DefaultFlattenInterval，使请求速率低于交易请求的限频
+	Interval time.Duration
+	// VerifyTimeout 每轮等待平仓成交事件的最长时间，默认 DefaultFlattenVerifyTimeout
+	VerifyTimeout time.Duration
+	// Rounds 核对后仍未清空时最多处理的轮数，默认 DefaultFlattenRounds
+	Rounds int
+}
+
+// FlattenFailure 单个撤单或平仓请求的失败，OrderId 与 PositionId 只有一个非 0
+type FlattenFailure struct {
+	Round      int
+	OrderId    int64
+	PositionId int64
+	Err        error
+}
+
+func (f FlattenFailure) Error() string {
+	if f.OrderId != 0 {
+		return fmt.Sprintf("round %d: cancel order %d: %v", f.Round, f.OrderId, f.Err)
+	}
+	return fmt.Sprintf("round %d: close position %d: %v", f.Round, f.PositionId, f.Err)
+}
+
+func (f FlattenFailure) Unwrap() error {
+	return f.Err
+}
+
+// FlattenReport FlattenAll 的执行结果
+type FlattenReport struct {
+	AccountId int64
+	// CancelledOrders 已确认撤销的挂单
+	CancelledOrders []int64
+	// ClosedPositions 已收到平仓成交事件的持仓
+	ClosedPositions []int64
+	Failures        []FlattenFailure
+	// RemainingOrders、RemainingPositions 最后一次核对时仍存在的挂单与持仓（按过滤条件）
+	RemainingOrders    []int64
+	RemainingPositions []int64
+	// Rounds 实际执行的轮数，开始时已清空则为 0
+	Rounds   int
+	Flat     bool
+	Duration time.Duration
+}
+
+// Err 账户已清空时返回 nil，否则返回包含 ErrNotFlat 与各失败原因的错误
+func (r *FlattenReport) Err() error {
+	if r.Flat {
+		return nil
+	}
+	errs := []error{fmt.Errorf("%w: account %d has %d positions and %d orders remaining", ErrNotFlat, r.AccountId, len(r.RemainingPositions), len(r.RemainingOrders))}
+	for _, f := range r.Failures {
+		errs = append(errs, f)
+	}
+	return errors.Join(errs...)
+}
+
+// FlattenAll 撤销全部挂单并平掉全部持仓（可按品种或标签过滤）
+//
+// 每轮先核对持仓与挂单，在并发与限速约束下先撤单后平仓，通过执行事件确认平仓，
+// 再核对一次；仍未清空且未达轮数上限时继续下一轮。账户已清空时返回的 error 为 nil，
+// 否则为 report.Err()；核对失败或 ctx 结束时返回已有的报告与对应错误
+func (a *AccountOrder) FlattenAll(ctx context.Context, opts *FlattenOptions) (*FlattenReport, error) {
+	if opts == nil {
+		opts = &FlattenOptions{}
+	}
+	f := &flattener{
+		account:   a,
+		opts:      *opts,
+		closed:    make(map[int64]chan struct{}),
+		report:    &FlattenReport{AccountId: a.accountId},
+		startTime: time.Now(),
+	}
+	f.defaults()
+	if f.opts.Halt {
+		reason := f.opts.HaltReason
+		if reason == "" {
+			reason = "flatten all"
+		}
+		a.Halt(reason)
+	}
+	client := a.conn()
+	client.log().Warn("ctrago flattening account", "accountId", a.accountId, "symbolIds", f.opts.SymbolIds, "label", f.opts.Label)
+	stop := client.WatchEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOAExecutionEvent{}
+		if err := proto.Unmarshal(msg.Payload, ev); err != nil || ev.GetCtidTraderAccountId() != a.accountId {
+			return
+		}
+		f.observe(ev)
+	})
+	defer stop()
+
+	for round := 0; ; round++ {
+		res, err := a.Trader().Reconcile(ctx, false)
+		if err != nil {
+			return f.finish(), fmt.Errorf("reconcile: %w", err)
+		}
+		orders, positions := f.filter(res)
+		f.report.RemainingOrders = orderIds(orders)
+		f.report.RemainingPositions = positionIds(positions)
+		if len(orders) == 0 && len(positions) == 0 {
+			f.report.Flat = true
+			break
+		}
+		if round == f.opts.Rounds {
+			break
+		}
+		f.report.Rounds = round + 1
+		f.run(ctx, round+1, orders, positions)
+	}
+	report := f.finish()
+	if report.Flat {
+		client.log().Info("ctrago account flattened", "accountId", a.accountId, "rounds", report.Rounds, "duration", report.Duration)
+	} else {
+		client.log().Error("ctrago account not flat", "accountId", a.accountId, "positions", report.RemainingPositions, "orders", report.RemainingOrders, "failures", len(report.Failures))
+	}
+	return report, report.Err()
+}
+
+type flattener struct {
+	account   *AccountOrder
+	opts      FlattenOptions
+	startTime time.Time
+
+	lock sync.Mutex
+	// closed 待确认平仓的持仓，收到平仓事件后关闭通道并移除
+	closed map[int64]chan struct{}
+	report *FlattenReport
+}
+
+func (f *flattener) defaults() {
+	if f.opts.Concurrency <= 0 {
+		f.opts.Concurrency = DefaultFlattenConcurrency
+	}
+	if f.opts.Interval <= 0 {
+		f.opts.Interval = DefaultFlattenInterval
+	}
+	if f.opts.VerifyTimeout <= 0 {
+		f.opts.VerifyTimeout = DefaultFlattenVerifyTimeout
+	}
+	if f.opts.Rounds <= 0 {
+		f.opts.Rounds = DefaultFlattenRounds
+	}
+}
+
+func (f *flattener) match(tradeData *openapi.ProtoOATradeData) bool {
+	if len(f.opts.SymbolIds) > 0 && !slices.Contains(f.opts.SymbolIds, tradeData.GetSymbolId()) {
+		return false
+	}
+	return f.opts.Label == "" || tradeData.GetLabel() == f.opts.Label
+}
+
+func (f *flattener) filter(res *openapi.ProtoOAReconcileRes) (orders []*openapi.ProtoOAOrder, positions []*openapi.ProtoOAPosition) {
+	for _, o := range res.GetOrder() {
+		if f.match(o.GetTradeData()) {
+			orders = append(orders, o)
+		}
+	}
+	for _, p := range res.GetPosition() {
+		if f.match(p.GetTradeData()) {
+			positions = append(positions, p)
+		}
+	}
+	return orders, positions
+}
+
+// run 执行一轮：挂单排在平仓之前，避免挂单在平仓过程中成交开出新仓位
+func (f *flattener) run(ctx context.Context, round int, orders []*openapi.ProtoOAOrder, positions []*openapi.ProtoOAPosition) {
+	f.lock.Lock()
+	for _, p := range positions {
+		f.closed[p.GetPositionId()] = make(chan struct{})
+	}
+	f.lock.Unlock()
+
+	var tasks []func()
+	for _, o := range orders {
+		tasks = append(tasks, func() { f.cancel(ctx, round, o.GetOrderId()) })
+	}
+	for _, p := range positions {
+		tasks = append(tasks, func() { f.close(ctx, round, p.GetPositionId(), p.GetTradeData().GetVolume()) })
+	}
+	sem := make(chan struct{}, f.opts.Concurrency)
+	var wg sync.WaitGroup
+	var last time.Time
+	for _, task := range tasks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if wait := time.Until(last.Add(f.opts.Interval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		last = time.Now()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			task()
+		}()
+	}
+	wg.Wait()
+	f.verify(ctx)
+}
+
+func (f *flattener) cancel(ctx context.Context, round int, orderId int64) {
+	res, err := f.account.CancelOrder(ctx, orderId)
+	if err != nil {
+		// 撤单前已成交或已撤销，由下一次核对判断
+		if IsErrorCode(err, openapi.ProtoOAErrorCode_ORDER_NOT_FOUND) {
+			return
+		}
+		f.fail(FlattenFailure{Round: round, OrderId: orderId, Err: err})
+		return
+	}
+	if res.GetExecutionType() == openapi.ProtoOAExecutionType_ORDER_CANCELLED {
+		f.lock.Lock()
+		f.report.CancelledOrders = append(f.report.CancelledOrders, orderId)
+		f.lock.Unlock()
+	}
+}
+
+func (f *flattener) close(ctx context.Context, round int, positionId, volume int64) {
+	res, err := f.account.ClosePosition(ctx, positionId, volume)
+	if err != nil {
+		f.lock.Lock()
+		delete(f.closed, positionId)
+		f.lock.Unlock()
+		if IsErrorCode(err, openapi.ProtoOAErrorCode_POSITION_NOT_FOUND) {
+			return
+		}
+		f.fail(FlattenFailure{Round: round, PositionId: positionId, Err: err})
+		return
+	}
+	f.observe(res)
+}
+
+func (f *flattener) fail(failure FlattenFailure) {
+	f.account.conn().log().Warn("ctrago flatten request failed", "accountId", f.account.accountId, "error", failure)
+	f.lock.Lock()
+	f.report.Failures = append(f.report.Failures, failure)
+	f.lock.Unlock()
+}
+
+// observe 处理执行事件与平仓响应，确认持仓已平
+func (f *flattener) observe(ev *openapi.ProtoOAExecutionEvent) {
+	if ev.GetPosition().GetPositionStatus() != openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED {
+		return
+	}
+	positionId := ev.GetPosition().GetPositionId()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if ch, ok := f.closed[positionId]; ok {
+		close(ch)
+		delete(f.closed, positionId)
+		f.report.ClosedPositions = append(f.report.ClosedPositions, positionId)
+	}
+}
+
+// verify 等待本轮平仓的成交事件，超时后交由核对结果判断
+func (f *flattener) verify(ctx context.Context) {
+	timer := time.NewTimer(f.opts.VerifyTimeout)
+	defer timer.Stop()
+	for {
+		f.lock.Lock()
+		var ch chan struct{}
+		for _, c := range f.closed {
+			ch = c
+			break
+		}
+		f.lock.Unlock()
+		if ch == nil {
+			return
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			f.lock.Lock()
+			clear(f.closed)
+			f.lock.Unlock()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *flattener) finish() *FlattenReport {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.report.Duration = time.Since(f.startTime)
+	r := *f.report
+	r.CancelledOrders = slices.Clone(r.CancelledOrders)
+	r.ClosedPositions = slices.Clone(r.ClosedPositions)
+	r.Failures = slices.Clone(r.Failures)
+	return &r
+}
+
+func orderIds(orders []*openapi.ProtoOAOrder) []int64 {
+	ids := make([]int64, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.GetOrderId())
+	}
+	return ids
+}
+
+func positionIds(positions []*openapi.ProtoOAPosition) []int64 {
+	ids := make([]int64, 0, len(positions))
+	for _, p := range positions {
+		ids = append(ids, p.GetPositionId())
+	}
+	return ids
+}
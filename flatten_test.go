@@ -0,0 +1,106 @@
+package ctrago_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+)
+
+func openFlattenBook(t *testing.T, ctx context.Context, account *ctrago.Account) {
+	t.Helper()
+	orders := account.Order()
+	for _, label := range []string{"bot", ""} {
+		opt := &ctrago.OrderOption{}
+		opt.WithLabel(label)
+		if _, err := orders.NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, opt); err != nil {
+			t.Fatal(err)
+		}
+		opt.WithLimitPrice(1.0)
+		if _, err := orders.NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOATradeSide_BUY, 100000, opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		res, err := account.Trader().Reconcile(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Position) == 2 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFlattenAll(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	account := newRiskAccount(t, ctx, srv)
+	openFlattenBook(t, ctx, account)
+
+	report, err := account.Order().FlattenAll(ctx, &ctrago.FlattenOptions{Label: "bot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Flat || report.Rounds != 1 || len(report.CancelledOrders) != 1 || len(report.ClosedPositions) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	res, err := account.Trader().Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Position) != 1 || len(res.Order) != 1 || res.Position[0].GetTradeData().GetLabel() != "" {
+		t.Fatalf("unlabelled position and order should remain, got %v", res)
+	}
+
+	report, err = account.Order().FlattenAll(ctx, &ctrago.FlattenOptions{Halt: true, HaltReason: "incident"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(report.ClosedPositions, res.Position[0].GetPositionId()) || !slices.Contains(report.CancelledOrders, res.Order[0].GetOrderId()) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	_, err = account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil)
+	if !errors.Is(err, ctrago.ErrAccountHalted) {
+		t.Fatalf("expected halted account to reject orders, got %v", err)
+	}
+	expectRejected(t, err, "kill-switch")
+	account.Rearm()
+	if _, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil); err != nil {
+		t.Fatalf("rearmed account rejected order: %v", err)
+	}
+}
+
+func TestFlattenAll_Failures(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	account := newRiskAccount(t, ctx, srv)
+	openFlattenBook(t, ctx, account)
+	srv.Fail(openapi.ProtoOAPayloadType_PROTO_OA_CANCEL_ORDER_REQ, openapi.ProtoOAErrorCode_UNABLE_TO_CANCEL_ORDER, "unable to cancel")
+
+	report, err := account.Order().FlattenAll(ctx, &ctrago.FlattenOptions{Rounds: 2})
+	if !errors.Is(err, ctrago.ErrNotFlat) || !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_UNABLE_TO_CANCEL_ORDER) {
+		t.Fatalf("expected not flat error, got %v", err)
+	}
+	if report.Flat || report.Rounds != 2 || len(report.Failures) != 4 || len(report.RemainingOrders) != 2 || len(report.RemainingPositions) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, f := range report.Failures {
+		if f.OrderId == 0 || f.PositionId != 0 {
+			t.Fatalf("unexpected failure %v", f)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ctrago
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+// PriceScale 报价、K 线与 tick 中的整数价格以 1/100000 为单位
+const PriceScale = 100000
+
+// VolumeUnits 将成交量（以 0.01 个基础货币单位传输）换算为基础货币数量
+func VolumeUnits(volume int64) float64 {
+	return float64(volume) / 100
+}
+
+// MoneyValue 将以 10^-digits 为单位的金额（余额、盈亏、手续费等）换算为存款货币
+func MoneyValue(value int64, digits uint32) float64 {
+	return float64(value) / math.Pow10(int(digits))
+}
+
+// Bar 解码后的 K 线
+type Bar struct {
+	Time   time.Time
+	Period openapi.ProtoOATrendbarPeriod
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	// Volume 成交量（tick 数）
+	Volume int64
+}
+
+// DecodeTrendbar 将 ProtoOATrendbar 的低价加差值编码换算为实际价格
+//
+// period 为 ProtoOAGetTrendbarsRes.period，服务端通常不在每根 K 线上设置周期，K 线自带周期时以其为准
+func DecodeTrendbar(period openapi.ProtoOATrendbarPeriod, tb *openapi.ProtoOATrendbar) Bar {
+	if tb.Period != nil {
+		period = tb.GetPeriod()
+	}
+	low := tb.GetLow()
+	return Bar{
+		Time:   time.Unix(int64(tb.GetUtcTimestampInMinutes())*60, 0).UTC(),
+		Period: period,
+		Open:   float64(low+int64(tb.GetDeltaOpen())) / PriceScale,
+		High:   float64(low+int64(tb.GetDeltaHigh())) / PriceScale,
+		Low:    float64(low) / PriceScale,
+		Close:  float64(low+int64(tb.GetDeltaClose())) / PriceScale,
+		Volume: tb.GetVolume(),
+	}
+}
+
+// DecodeTrendbars 解码一组周期为 period 的 K 线并按时间升序排列，见 DecodeTrendbar
+func DecodeTrendbars(period openapi.ProtoOATrendbarPeriod, trendbars []*openapi.ProtoOATrendbar) []Bar {
+	bars := make([]Bar, 0, len(trendbars))
+	for _, tb := range trendbars {
+		bars = append(bars, DecodeTrendbar(period, tb))
+	}
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+	return bars
+}
+
+// Tick 解码后的 bid 或 ask 报价
+type Tick struct {
+	Time  time.Time
+	Price float64
+}
+
+// DecodeTicks 解码 ProtoOAGetTickDataRes.TickData
+//
+// 服务端按由新到旧排列，第一条为绝对值，其后每条的时间与价格均为相对前一条的差值；返回结果按时间升序排列
+func DecodeTicks(data []*openapi.ProtoOATickData) []Tick {
+	ticks := make([]Tick, len(data))
+	var ts, price int64
+	for i, d := range data {
+		ts += d.GetTimestamp()
+		price += d.GetTick()
+		ticks[len(data)-1-i] = Tick{Time: time.UnixMilli(ts).UTC(), Price: float64(price) / PriceScale}
+	}
+	return ticks
+}
+
+// PeriodDuration K 线周期的时长，MN1 按 30 天计算
+func PeriodDuration(period openapi.ProtoOATrendbarPeriod) time.Duration {
+	switch period {
+	case openapi.ProtoOATrendbarPeriod_M1:
+		return time.Minute
+	case openapi.ProtoOATrendbarPeriod_M2:
+		return 2 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M3:
+		return 3 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M4:
+		return 4 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M5:
+		return 5 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M10:
+		return 10 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M15:
+		return 15 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M30:
+		return 30 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_H1:
+		return time.Hour
+	case openapi.ProtoOATrendbarPeriod_H4:
+		return 4 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_H12:
+		return 12 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_D1:
+		return 24 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_W1:
+		return 7 * 24 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_MN1:
+		return 30 * 24 * time.Hour
+	}
+	return 0
+}
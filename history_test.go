@@ -0,0 +1,68 @@
+package ctrago
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecodeTicks(t *testing.T) {
+	// 由新到旧，第一条为绝对值，其后为差值
+	data := []*openapi.ProtoOATickData{
+		{Timestamp: proto.Int64(1_700_000_002_000), Tick: proto.Int64(110020)},
+		{Timestamp: proto.Int64(-1500), Tick: proto.Int64(-15)},
+		{Timestamp: proto.Int64(-500), Tick: proto.Int64(5)},
+	}
+	ticks := DecodeTicks(data)
+	want := []Tick{
+		{Time: time.UnixMilli(1_700_000_000_000).UTC(), Price: 1.1001},
+		{Time: time.UnixMilli(1_700_000_000_500).UTC(), Price: 1.10005},
+		{Time: time.UnixMilli(1_700_000_002_000).UTC(), Price: 1.1002},
+	}
+	if len(ticks) != len(want) {
+		t.Fatalf("expected %d ticks, got %d", len(want), len(ticks))
+	}
+	for i := range want {
+		if !ticks[i].Time.Equal(want[i].Time) || ticks[i].Price != want[i].Price {
+			t.Errorf("tick %d: expected %+v, got %+v", i, want[i], ticks[i])
+		}
+	}
+}
+
+func TestDecodeTrendbar(t *testing.T) {
+	// 响应的周期作用于未设置周期的 K 线
+	bar := DecodeTrendbar(openapi.ProtoOATrendbarPeriod_M5, &openapi.ProtoOATrendbar{
+		Volume:                proto.Int64(10),
+		Low:                   proto.Int64(109900),
+		DeltaOpen:             proto.Uint64(100),
+		DeltaHigh:             proto.Uint64(250),
+		DeltaClose:            proto.Uint64(200),
+		UtcTimestampInMinutes: proto.Uint32(28_333_333),
+	})
+	if bar.Open != 1.1 || bar.High != 1.1015 || bar.Low != 1.099 || bar.Close != 1.101 {
+		t.Errorf("unexpected prices: %+v", bar)
+	}
+	if !bar.Time.Equal(time.Unix(28_333_333*60, 0)) || PeriodDuration(bar.Period) != 5*time.Minute {
+		t.Errorf("unexpected time %v", bar.Time)
+	}
+	if bar := DecodeTrendbar(openapi.ProtoOATrendbarPeriod_M5, &openapi.ProtoOATrendbar{Period: openapi.ProtoOATrendbarPeriod_H1.Enum()}); bar.Period != openapi.ProtoOATrendbarPeriod_H1 {
+		t.Errorf("per-bar period should override, got %v", bar.Period)
+	}
+}
+
+func TestVolumeUnits(t *testing.T) {
+	if v := VolumeUnits(100000); v != 1000 {
+		t.Errorf("VolumeUnits(100000) = %v", v)
+	}
+}
+
+func TestMoneyValue(t *testing.T) {
+	if v := MoneyValue(123456, 2); v != 1234.56 {
+		t.Errorf("MoneyValue(123456, 2) = %v", v)
+	}
+	if v := MoneyValue(-5, 0); v != -5 {
+		t.Errorf("MoneyValue(-5, 0) = %v", v)
+	}
+}
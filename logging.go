@@ -0,0 +1,150 @@
+package ctrago
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// redactedFields 日志中需要隐藏的字段
+var redactedFields = map[protoreflect.Name]struct{}{
+	"clientSecret": {},
+	"accessToken":  {},
+	"refreshToken": {},
+}
+
+const redactedValue = "[REDACTED]"
+
+// redacted 延迟格式化 proto 消息，隐藏 clientSecret、accessToken、refreshToken，仅在日志级别启用时才序列化
+type redacted struct {
+	msg proto.Message
+}
+
+func (r redacted) LogValue() slog.Value {
+	if r.msg == nil {
+		return slog.StringValue("")
+	}
+	msg := proto.Clone(r.msg)
+	redactMessage(msg.ProtoReflect())
+	return slog.StringValue(prototext.MarshalOptions{}.Format(msg))
+}
+
+func redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if _, ok := redactedFields[fd.Name()]; ok && fd.Kind() == protoreflect.StringKind && !fd.IsList() {
+			m.Set(fd, protoreflect.ValueOfString(redactedValue))
+			return true
+		}
+		if fd.Kind() == protoreflect.MessageKind && !fd.IsMap() {
+			if fd.IsList() {
+				list := v.List()
+				for i := 0; i < list.Len(); i++ {
+					redactMessage(list.Get(i).Message())
+				}
+			} else {
+				redactMessage(v.Message())
+			}
+		}
+		return true
+	})
+}
+
+// redactedPayloads 记录各 payloadType 的消息是否含需隐藏的字段
+var redactedPayloads sync.Map
+
+// redactFrame 隐藏帧（序列化的 ProtoMessage）中的 clientSecret、accessToken、refreshToken，不含这些字段时原样返回
+func redactFrame(data []byte) []byte {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return data
+	}
+	payload := NewPayload(msg.GetPayloadType())
+	if payload == nil || !hasRedactedFields(msg.GetPayloadType(), payload.ProtoReflect().Descriptor()) {
+		return data
+	}
+	if err := proto.Unmarshal(msg.Payload, payload); err != nil {
+		return data
+	}
+	redactMessage(payload.ProtoReflect())
+	redactedPayload, err := proto.Marshal(payload)
+	if err != nil {
+		return data
+	}
+	msg.Payload = redactedPayload
+	redactedData, err := proto.Marshal(msg)
+	if err != nil {
+		return data
+	}
+	return redactedData
+}
+
+func hasRedactedFields(payloadType uint32, md protoreflect.MessageDescriptor) bool {
+	if v, ok := redactedPayloads.Load(payloadType); ok {
+		return v.(bool)
+	}
+	found := containsRedactedField(md, make(map[protoreflect.FullName]bool))
+	redactedPayloads.Store(payloadType, found)
+	return found
+}
+
+func containsRedactedField(md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) bool {
+	if seen[md.FullName()] {
+		return false
+	}
+	seen[md.FullName()] = true
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if _, ok := redactedFields[fd.Name()]; ok && fd.Kind() == protoreflect.StringKind {
+			return true
+		}
+		if fd.Kind() == protoreflect.MessageKind && !fd.IsMap() && containsRedactedField(fd.Message(), seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// SetLogger 设置日志输出，nil 表示不输出日志
+func (c *Client) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.logger = logger
+}
+
+func (c *Client) log() *slog.Logger {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.logger
+}
+
+// SetLogger 设置日志输出，nil 表示不输出日志
+func (c *WsClient) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.logger = logger.With("transport", "websocket", "url", c.url)
+}
+
+// SetLogger 设置日志输出，nil 表示不输出日志
+func (c *TcpClient) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.logger = logger.With("transport", "tcp", "addr", c.addr)
+}
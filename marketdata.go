@@ -0,0 +1,342 @@
+package ctrago
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+// MarketDataStore 历史行情的本地缓存，Trendbars 与 Ticks 优先读取缓存，只向服务端请求缺失的时间段
+//
+// 缓存以时间段为单位记录覆盖范围：SaveBars/SaveTicks 的 covered 表示该时间段内的全部数据均已保存（可能为空），
+// Load 返回 [from, to) 内的数据（按时间升序）及其中已覆盖的时间段。实现需保证并发安全
+type MarketDataStore interface {
+	LoadBars(key SeriesKey, from, to time.Time) ([]Bar, []TimeRange, error)
+	SaveBars(key SeriesKey, covered TimeRange, bars []Bar) error
+	LoadTicks(key SeriesKey, from, to time.Time) ([]Tick, []TimeRange, error)
+	SaveTicks(key SeriesKey, covered TimeRange, ticks []Tick) error
+}
+
+// SeriesKey 一组历史行情的标识，K 线设置 Period，tick 设置 QuoteType
+//
+// 不同经纪商的品种 ID 互不相关，因此以经纪商名称区分，同一经纪商的多个账户共享缓存
+type SeriesKey struct {
+	Broker    string
+	SymbolId  int64
+	Period    openapi.ProtoOATrendbarPeriod
+	QuoteType openapi.ProtoOAQuoteType
+}
+
+// Series 序列名称，如 H1、BID
+func (k SeriesKey) Series() string {
+	if k.Period != 0 {
+		return k.Period.String()
+	}
+	return k.QuoteType.String()
+}
+
+func (k SeriesKey) String() string {
+	return fmt.Sprintf("%s/%d/%s", k.Broker, k.SymbolId, k.Series())
+}
+
+// TimeRange 左闭右开的时间段 [From, To)
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains 判断 t 是否在时间段内
+func (r TimeRange) Contains(t time.Time) bool {
+	return !t.Before(r.From) && t.Before(r.To)
+}
+
+// MergeRanges 排序并合并重叠或相邻的时间段，忽略空时间段
+func MergeRanges(ranges []TimeRange) []TimeRange {
+	sorted := make([]TimeRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.To.After(r.From) {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From.Before(sorted[j].From) })
+	var merged []TimeRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && !r.From.After(merged[n-1].To) {
+			if r.To.After(merged[n-1].To) {
+				merged[n-1].To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// MissingRanges 返回 [from, to) 中未被 covered 覆盖的时间段
+func MissingRanges(covered []TimeRange, from, to time.Time) []TimeRange {
+	var missing []TimeRange
+	cursor := from
+	for _, r := range MergeRanges(covered) {
+		if !r.To.After(cursor) {
+			continue
+		}
+		if !r.From.Before(to) {
+			break
+		}
+		if r.From.After(cursor) {
+			missing = append(missing, TimeRange{From: cursor, To: r.From})
+		}
+		cursor = r.To
+	}
+	if to.After(cursor) {
+		missing = append(missing, TimeRange{From: cursor, To: to})
+	}
+	return missing
+}
+
+// WithMarketDataStore 设置历史行情缓存，如 NewFileStore，默认不缓存
+func WithMarketDataStore(store MarketDataStore) Option {
+	return func(c *clientConfig) {
+		c.marketData = store
+	}
+}
+
+// SetMarketDataStore 设置历史行情缓存，nil 表示不缓存
+func (c *Client) SetMarketDataStore(store MarketDataStore) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.marketData = store
+}
+
+func (c *Client) marketDataStore() MarketDataStore {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.marketData
+}
+
+// broker 账户所属经纪商，首次调用时通过 ProtoOATraderReq 查询
+func (a *Account) broker(ctx context.Context) (string, error) {
+	c := a.conn()
+	c.lock.Lock()
+	broker, ok := c.brokers[a.accountId]
+	c.lock.Unlock()
+	if ok {
+		return broker, nil
+	}
+	res, err := a.Trader().Trader(ctx)
+	if err != nil {
+		return "", err
+	}
+	broker = res.GetTrader().GetBrokerName()
+	c.lock.Lock()
+	if c.brokers == nil {
+		c.brokers = make(map[int64]string)
+	}
+	c.brokers[a.accountId] = broker
+	c.lock.Unlock()
+	return broker, nil
+}
+
+// trendbarSpan 单次 K 线请求允许的最大时间跨度
+func trendbarSpan(period openapi.ProtoOATrendbarPeriod) time.Duration {
+	const week = 7 * 24 * time.Hour
+	switch period {
+	case openapi.ProtoOATrendbarPeriod_M1, openapi.ProtoOATrendbarPeriod_M2, openapi.ProtoOATrendbarPeriod_M3,
+		openapi.ProtoOATrendbarPeriod_M4, openapi.ProtoOATrendbarPeriod_M5:
+		return 5 * week
+	case openapi.ProtoOATrendbarPeriod_M10, openapi.ProtoOATrendbarPeriod_M15, openapi.ProtoOATrendbarPeriod_M30,
+		openapi.ProtoOATrendbarPeriod_H1:
+		return 35 * week
+	case openapi.ProtoOATrendbarPeriod_H4, openapi.ProtoOATrendbarPeriod_H12, openapi.ProtoOATrendbarPeriod_D1:
+		return 366 * 24 * time.Hour
+	}
+	return 5 * 365 * 24 * time.Hour
+}
+
+// tickSpan 单次 tick 请求允许的最大时间跨度
+const tickSpan = 7 * 24 * time.Hour
+
+// tickCacheMargin 距当前时间不足该时长的 tick 可能尚未全部进入服务端历史数据，不计入缓存的覆盖范围
+const tickCacheMargin = time.Minute
+
+// splitRange 将时间段按 span 切分
+func splitRange(r TimeRange, span time.Duration) []TimeRange {
+	var chunks []TimeRange
+	for from := r.From; from.Before(r.To); from = from.Add(span) {
+		chunks = append(chunks, TimeRange{From: from, To: minTime(from.Add(span), r.To)})
+	}
+	return chunks
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// Trendbars 获取 [from, to) 内的 K 线，按时间升序排列
+//
+// 按服务端限制自动分段请求；设置了 MarketDataStore 时先读取缓存，只请求缺失的时间段，并缓存已收盘的 K 线
+func (a *AccountSymbol) Trendbars(ctx context.Context, symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) ([]Bar, error) {
+	if symbolId == 0 {
+		return nil, ErrSymbolIdRequired
+	}
+	store := a.conn().marketDataStore()
+	var key SeriesKey
+	var bars []Bar
+	missing := []TimeRange{{From: from, To: to}}
+	if store != nil {
+		broker, err := a.broker(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key = SeriesKey{Broker: broker, SymbolId: symbolId, Period: period}
+		var covered []TimeRange
+		if bars, covered, err = store.LoadBars(key, from, to); err != nil {
+			return nil, err
+		}
+		missing = MissingRanges(covered, from, to)
+	}
+	for _, r := range missing {
+		for _, chunk := range splitRange(r, trendbarSpan(period)) {
+			fetched, err := a.fetchBars(ctx, symbolId, period, chunk)
+			if err != nil {
+				return nil, err
+			}
+			bars = append(bars, fetched...)
+			if store == nil {
+				continue
+			}
+			// 最后一根 K 线可能尚未收盘，不缓存
+			end := minTime(chunk.To, time.Now().Add(-PeriodDuration(period)))
+			if !end.After(chunk.From) {
+				continue
+			}
+			closed := fetched[:sort.Search(len(fetched), func(i int) bool { return !fetched[i].Time.Before(end) })]
+			if err := store.SaveBars(key, TimeRange{From: chunk.From, To: end}, closed); err != nil {
+				a.conn().log().Warn("ctrago failed to save trendbars", "series", key.String(), "error", err)
+			}
+		}
+	}
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+	return bars, nil
+}
+
+// fetchBars 请求一个时间段内的 K 线，HasMore 时以最早一根的时间为终点继续请求
+func (a *AccountSymbol) fetchBars(ctx context.Context, symbolId int64, period openapi.ProtoOATrendbarPeriod, r TimeRange) ([]Bar, error) {
+	seen := make(map[time.Time]struct{})
+	var bars []Bar
+	to := r.To.UnixMilli()
+	for {
+		res, err := a.GetTrendbars(ctx, symbolId, period, r.From.UnixMilli(), to, 0)
+		if err != nil {
+			return nil, err
+		}
+		oldest := to
+		for _, bar := range DecodeTrendbars(period, res.Trendbar) {
+			oldest = min(oldest, bar.Time.UnixMilli())
+			if _, ok := seen[bar.Time]; ok || !r.Contains(bar.Time) {
+				continue
+			}
+			seen[bar.Time] = struct{}{}
+			bars = append(bars, bar)
+		}
+		if !res.GetHasMore() || oldest >= to {
+			break
+		}
+		to = oldest
+	}
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+	return bars, nil
+}
+
+// Ticks 获取 [from, to) 内的 bid 或 ask tick，按时间升序排列
+//
+// 按 7 天自动分段请求；设置了 MarketDataStore 时先读取缓存，只请求缺失的时间段，并缓存 tickCacheMargin 之前的数据
+func (a *AccountSymbol) Ticks(ctx context.Context, symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) ([]Tick, error) {
+	if symbolId == 0 {
+		return nil, ErrSymbolIdRequired
+	}
+	store := a.conn().marketDataStore()
+	var key SeriesKey
+	var ticks []Tick
+	missing := []TimeRange{{From: from, To: to}}
+	if store != nil {
+		broker, err := a.broker(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key = SeriesKey{Broker: broker, SymbolId: symbolId, QuoteType: quoteType}
+		var covered []TimeRange
+		if ticks, covered, err = store.LoadTicks(key, from, to); err != nil {
+			return nil, err
+		}
+		missing = MissingRanges(covered, from, to)
+	}
+	for _, r := range missing {
+		for _, chunk := range splitRange(r, tickSpan) {
+			fetched, err := a.fetchTicks(ctx, symbolId, quoteType, chunk)
+			if err != nil {
+				return nil, err
+			}
+			ticks = append(ticks, fetched...)
+			if store == nil {
+				continue
+			}
+			// 临近当前时间的 tick 可能尚不完整，留待下次请求时补齐
+			end := minTime(chunk.To, time.Now().Add(-tickCacheMargin))
+			if !end.After(chunk.From) {
+				continue
+			}
+			saved := fetched[:sort.Search(len(fetched), func(i int) bool { return !fetched[i].Time.Before(end) })]
+			if err := store.SaveTicks(key, TimeRange{From: chunk.From, To: end}, saved); err != nil {
+				a.conn().log().Warn("ctrago failed to save ticks", "series", key.String(), "error", err)
+			}
+		}
+	}
+	sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].Time.Before(ticks[j].Time) })
+	return ticks, nil
+}
+
+// fetchTicks 请求一个时间段内的 tick，HasMore 时以最早一条的时间为终点继续请求
+//
+// toTimestamp 不含边界；同一毫秒可能有多条 tick，继续请求前丢弃最早一毫秒的数据，由下一页完整返回
+func (a *AccountSymbol) fetchTicks(ctx context.Context, symbolId int64, quoteType openapi.ProtoOAQuoteType, r TimeRange) ([]Tick, error) {
+	var pages [][]Tick
+	to := r.To.UnixMilli()
+	for {
+		res, err := a.GetTickData(ctx, symbolId, quoteType, r.From.UnixMilli(), to)
+		if err != nil {
+			return nil, err
+		}
+		page := DecodeTicks(res.TickData)
+		if !res.GetHasMore() || len(page) == 0 {
+			pages = append(pages, page)
+			break
+		}
+		oldest := page[0].Time.UnixMilli()
+		if oldest+1 >= to {
+			// 整页都在同一毫秒，无法继续分页
+			pages = append(pages, page)
+			break
+		}
+		for len(page) > 0 && page[0].Time.UnixMilli() == oldest {
+			page = page[1:]
+		}
+		pages = append(pages, page)
+		to = oldest + 1
+	}
+	var ticks []Tick
+	for i := len(pages) - 1; i >= 0; i-- {
+		for _, tick := range pages[i] {
+			if r.Contains(tick.Time) {
+				ticks = append(ticks, tick)
+			}
+		}
+	}
+	return ticks, nil
+}
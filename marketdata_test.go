@@ -0,0 +1,140 @@
+package ctrago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMissingRanges(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2026, 10, 1, h, 0, 0, 0, time.UTC) }
+	covered := []TimeRange{{From: at(5), To: at(7)}, {From: at(2), To: at(4)}, {From: at(3), To: at(5)}}
+	missing := MissingRanges(covered, at(0), at(10))
+	if len(missing) != 2 || !missing[0].From.Equal(at(0)) || !missing[0].To.Equal(at(2)) ||
+		!missing[1].From.Equal(at(7)) || !missing[1].To.Equal(at(10)) {
+		t.Fatalf("unexpected missing ranges: %+v", missing)
+	}
+	if missing := MissingRanges(covered, at(3), at(6)); len(missing) != 0 {
+		t.Fatalf("expected no missing ranges, got %+v", missing)
+	}
+}
+
+func TestAccountSymbol_TrendbarsCached(t *testing.T) {
+	const accountId = 7
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	var requests []*openapi.ProtoOAGetTrendbarsReq
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, msg)
+		switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ:
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_RES, &openapi.ProtoOATraderRes{
+				CtidTraderAccountId: proto.Int64(accountId),
+				Trader: &openapi.ProtoOATrader{
+					CtidTraderAccountId: proto.Int64(accountId),
+					Balance:             proto.Int64(0),
+					DepositAssetId:      proto.Int64(1),
+					BrokerName:          proto.String("demo"),
+				},
+			}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_GET_TRENDBARS_REQ:
+			req := &openapi.ProtoOAGetTrendbarsReq{}
+			proto.Unmarshal(msg.Payload, req)
+			requests = append(requests, req)
+			res := &openapi.ProtoOAGetTrendbarsRes{CtidTraderAccountId: proto.Int64(accountId), Period: req.Period, Timestamp: proto.Int64(0)}
+			for ts := time.UnixMilli(req.GetFromTimestamp()); ts.Before(time.UnixMilli(req.GetToTimestamp())); ts = ts.Add(24 * time.Hour) {
+				res.Trendbar = append(res.Trendbar, &openapi.ProtoOATrendbar{
+					Volume:                proto.Int64(1),
+					Low:                   proto.Int64(110000),
+					DeltaHigh:             proto.Uint64(100),
+					UtcTimestampInMinutes: proto.Uint32(uint32(ts.Unix() / 60)),
+				})
+			}
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_GET_TRENDBARS_RES, res, msg.GetClientMsgId())
+		}
+		return nil
+	}
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	client.SetMarketDataStore(store)
+	symbol := client.Account(accountId).Symbol()
+	ctx := context.Background()
+
+	bars, err := symbol.Trendbars(ctx, 1, openapi.ProtoOATrendbarPeriod_D1, start, start.AddDate(0, 0, 10))
+	if err != nil || len(bars) != 10 || len(requests) != 1 {
+		t.Fatalf("expected 10 bars from 1 request, got %d bars, %d requests, %v", len(bars), len(requests), err)
+	}
+	// 再次请求只补齐缺失的后 5 天
+	bars, err = symbol.Trendbars(ctx, 1, openapi.ProtoOATrendbarPeriod_D1, start.AddDate(0, 0, 5), start.AddDate(0, 0, 15))
+	if err != nil || len(bars) != 10 || len(requests) != 2 {
+		t.Fatalf("expected 10 bars from 2 requests, got %d bars, %d requests, %v", len(bars), len(requests), err)
+	}
+	if from := time.UnixMilli(requests[1].GetFromTimestamp()); !from.Equal(start.AddDate(0, 0, 10)) {
+		t.Fatalf("expected only the missing range to be requested, got from %v", from)
+	}
+	if !bars[0].Time.Equal(start.AddDate(0, 0, 5)) || bars[0].High != 1.101 || bars[0].Period != openapi.ProtoOATrendbarPeriod_D1 || bars[9].Period != openapi.ProtoOATrendbarPeriod_D1 {
+		t.Fatalf("unexpected bar: %+v", bars[0])
+	}
+}
+
+func TestAccountSymbol_TicksRecentNotCached(t *testing.T) {
+	const accountId = 7
+	var requests []*openapi.ProtoOAGetTickDataReq
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, msg)
+		switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ:
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_RES, &openapi.ProtoOATraderRes{
+				CtidTraderAccountId: proto.Int64(accountId),
+				Trader: &openapi.ProtoOATrader{
+					CtidTraderAccountId: proto.Int64(accountId),
+					Balance:             proto.Int64(0),
+					DepositAssetId:      proto.Int64(1),
+					BrokerName:          proto.String("demo"),
+				},
+			}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_GET_TICKDATA_REQ:
+			req := &openapi.ProtoOAGetTickDataReq{}
+			proto.Unmarshal(msg.Payload, req)
+			requests = append(requests, req)
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_GET_TICKDATA_RES, &openapi.ProtoOAGetTickDataRes{
+				CtidTraderAccountId: proto.Int64(accountId),
+				HasMore:             proto.Bool(false),
+			}, msg.GetClientMsgId())
+		}
+		return nil
+	}
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	client.SetMarketDataStore(store)
+	symbol := client.Account(accountId).Symbol()
+	ctx := context.Background()
+
+	to := time.Now().Truncate(time.Millisecond)
+	from := to.Add(-10 * time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := symbol.Ticks(ctx, 1, openapi.ProtoOAQuoteType_BID, from, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 最近 tickCacheMargin 内的数据不视为已缓存，第二次只重新请求这一段
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	refetched := time.UnixMilli(requests[1].GetFromTimestamp())
+	if refetched.Before(to.Add(-tickCacheMargin-time.Second)) || refetched.After(to.Add(-tickCacheMargin+time.Second)) {
+		t.Fatalf("expected the last %s to be refetched, got from %v (to %v)", tickCacheMargin, refetched, to)
+	}
+}
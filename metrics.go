@@ -0,0 +1,134 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// Metrics 指标采集接口，所有方法都可能被并发调用，实现需保证线程安全且不阻塞
+type Metrics interface {
+	// ObserveRequest 请求结束（收到响应、错误响应、超时或失败），outcome 见 RequestOutcome 常量
+	ObserveRequest(payloadType string, outcome string, latency time.Duration)
+	// IncErrorCode 收到错误码，来源于错误响应、ProtoOAOrderErrorEvent 或被拒绝的 ProtoOAExecutionEvent
+	IncErrorCode(payloadType string, errorCode string)
+	// IncEvent 收到服务端推送事件
+	IncEvent(payloadType string)
+	// SetPendingRequests 当前等待响应的请求数
+	SetPendingRequests(n int)
+	// ObserveHeartbeatRTT 连接往返延迟，由 Client.Ping 测量
+	ObserveHeartbeatRTT(rtt time.Duration)
+	// IncReconnect 重连成功一次
+	IncReconnect()
+}
+
+// 请求结果，用于 Metrics.ObserveRequest 的 outcome
+const (
+	OutcomeOK             = "ok"
+	OutcomeError          = "error"
+	OutcomeTimeout        = "timeout"
+	OutcomeCancelled      = "cancelled"
+	OutcomeConnectionLost = "connection_lost"
+	OutcomeSendFailed     = "send_failed"
+)
+
+// NopMetrics 不采集任何指标，为 Client 的默认值
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveRequest(string, string, time.Duration) {}
+func (NopMetrics) IncErrorCode(string, string)                  {}
+func (NopMetrics) IncEvent(string)                              {}
+func (NopMetrics) SetPendingRequests(int)                       {}
+func (NopMetrics) ObserveHeartbeatRTT(time.Duration)            {}
+func (NopMetrics) IncReconnect()                                {}
+
+// WithMetrics 设置指标采集，默认不采集
+func WithMetrics(metrics Metrics) Option {
+	return func(c *clientConfig) {
+		c.metrics = metrics
+	}
+}
+
+// WithPingInterval 定期调用 Client.Ping 测量往返延迟，0 表示不测量
+func WithPingInterval(interval time.Duration) Option {
+	return func(c *clientConfig) {
+		c.pingInterval = interval
+	}
+}
+
+// SetMetrics 设置指标采集，nil 表示不采集
+func (c *Client) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics = metrics
+}
+
+func (c *Client) metricsRecorder() Metrics {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.metrics
+}
+
+// Ping 发送 ProtoOAVersionReq 测量连接往返延迟，并记录到 Metrics.ObserveHeartbeatRTT
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if _, err := c.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ), &openapi.ProtoOAVersionReq{}); err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	c.metricsRecorder().ObserveHeartbeatRTT(rtt)
+	return rtt, nil
+}
+
+func (c *Client) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		switch c.State() {
+		case StateClosed:
+			return
+		case StateConnected, StateAppAuthorized:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			c.Ping(ctx)
+			cancel()
+		}
+	}
+}
+
+// requestOutcome 将请求未收到响应的原因归类为 outcome，错误类响应由调用方记为 OutcomeError
+func requestOutcome(err error) string {
+	var timeoutErr *TimeoutError
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case errors.As(err, &timeoutErr):
+		return OutcomeTimeout
+	case errors.Is(err, ErrConnectionLost):
+		return OutcomeConnectionLost
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return OutcomeCancelled
+	}
+	return OutcomeSendFailed
+}
+
+// eventErrorCode 提取推送事件中的错误码（ProtoOAOrderErrorEvent、带 errorCode 的 ProtoOAExecutionEvent）
+func eventErrorCode(msg *openapi.ProtoMessage) string {
+	switch msg.GetPayloadType() {
+	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT):
+		ev := &openapi.ProtoOAExecutionEvent{}
+		if err := proto.Unmarshal(msg.Payload, ev); err == nil {
+			return ev.GetErrorCode()
+		}
+	default:
+		if apiErr := responseError(msg); apiErr != nil {
+			return apiErr.ErrorCode
+		}
+	}
+	return ""
+}
@@ -0,0 +1,261 @@
+package ctrago
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets 请求延迟直方图的默认分桶（秒）
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// PrometheusMetrics 以 Prometheus 文本格式（0.0.4）导出指标的 Metrics 实现
+//
+// 不依赖 Prometheus 客户端库，也不启动 HTTP 服务：可作为 http.Handler 挂载到已有的 /metrics 路由，
+// 或通过 WriteTo 写入任意 io.Writer（如 node_exporter 的 textfile 目录）
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	lock            sync.Mutex
+	requests        map[[2]string]float64
+	requestLatency  map[string]*histogram
+	errorCodes      map[[2]string]float64
+	events          map[string]float64
+	pendingRequests float64
+	heartbeatRTT    *histogram
+	reconnects      float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewPrometheusMetrics 创建 PrometheusMetrics，namespace 为指标名前缀（默认 ctrago），buckets 为空时使用 DefaultLatencyBuckets
+func NewPrometheusMetrics(namespace string, buckets []float64) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "ctrago"
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusMetrics{
+		namespace:      namespace,
+		buckets:        buckets,
+		requests:       make(map[[2]string]float64),
+		requestLatency: make(map[string]*histogram),
+		errorCodes:     make(map[[2]string]float64),
+		events:         make(map[string]float64),
+		heartbeatRTT:   &histogram{counts: make([]uint64, len(buckets))},
+	}
+}
+
+func (m *PrometheusMetrics) observe(h *histogram, value float64) {
+	for i, upper := range m.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (m *PrometheusMetrics) ObserveRequest(payloadType string, outcome string, latency time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[[2]string{payloadType, outcome}]++
+	h, ok := m.requestLatency[payloadType]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.requestLatency[payloadType] = h
+	}
+	m.observe(h, latency.Seconds())
+}
+
+func (m *PrometheusMetrics) IncErrorCode(payloadType string, errorCode string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.errorCodes[[2]string{payloadType, errorCode}]++
+}
+
+func (m *PrometheusMetrics) IncEvent(payloadType string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.events[payloadType]++
+}
+
+func (m *PrometheusMetrics) SetPendingRequests(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pendingRequests = float64(n)
+}
+
+func (m *PrometheusMetrics) ObserveHeartbeatRTT(rtt time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.observe(m.heartbeatRTT, rtt.Seconds())
+}
+
+func (m *PrometheusMetrics) IncReconnect() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.reconnects++
+}
+
+// ServeHTTP 输出 Prometheus 文本格式的指标
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo 将全部指标以 Prometheus 文本格式写入 w
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	name := func(suffix string) string { return m.namespace + "_" + suffix }
+
+	requests := name("requests_total")
+	cw.header(requests, "Requests sent, by payload type and outcome.", "counter")
+	for _, key := range sortedKeys2(m.requests) {
+		cw.sample(requests, labels("payload_type", key[0], "outcome", key[1]), m.requests[key])
+	}
+
+	latency := name("request_duration_seconds")
+	cw.header(latency, "Request latency from send to response, by payload type.", "histogram")
+	for _, payloadType := range sortedKeys(m.requestLatency) {
+		m.writeHistogram(cw, latency, []string{"payload_type", payloadType}, m.requestLatency[payloadType])
+	}
+
+	errorCodes := name("error_codes_total")
+	cw.header(errorCodes, "Error codes received in error responses and events.", "counter")
+	for _, key := range sortedKeys2(m.errorCodes) {
+		cw.sample(errorCodes, labels("payload_type", key[0], "error_code", key[1]), m.errorCodes[key])
+	}
+
+	events := name("events_total")
+	cw.header(events, "Server push events received, by payload type.", "counter")
+	for _, payloadType := range sortedKeys(m.events) {
+		cw.sample(events, labels("payload_type", payloadType), m.events[payloadType])
+	}
+
+	pending := name("pending_requests")
+	cw.header(pending, "Requests waiting for a response.", "gauge")
+	cw.sample(pending, "", m.pendingRequests)
+
+	rtt := name("heartbeat_rtt_seconds")
+	cw.header(rtt, "Connection round-trip time measured by Ping.", "histogram")
+	m.writeHistogram(cw, rtt, nil, m.heartbeatRTT)
+
+	reconnects := name("reconnects_total")
+	cw.header(reconnects, "Successful reconnects.", "counter")
+	cw.sample(reconnects, "", m.reconnects)
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *PrometheusMetrics) writeHistogram(cw *countingWriter, name string, baseLabels []string, h *histogram) {
+	for i, upper := range m.buckets {
+		cw.sample(name+"_bucket", labels(append(append([]string(nil), baseLabels...), "le", formatFloat(upper))...), float64(h.counts[i]))
+	}
+	cw.sample(name+"_bucket", labels(append(append([]string(nil), baseLabels...), "le", "+Inf")...), float64(h.count))
+	cw.sample(name+"_sum", labels(baseLabels...), h.sum)
+	cw.sample(name+"_count", labels(baseLabels...), float64(h.count))
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...any) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func (cw *countingWriter) header(name, help, typ string) {
+	cw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (cw *countingWriter) sample(name, labels string, value float64) {
+	cw.printf("%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// labels 按 key, value 交替的参数生成 {k="v",...}
+func labels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(kv[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys2(m map[[2]string]float64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+var _ Metrics = NopMetrics{}
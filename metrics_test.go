@@ -0,0 +1,52 @@
+package ctrago
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPrometheusMetrics_RequestsAndErrors(t *testing.T) {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		req := &openapi.ProtoMessage{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			t.Fatal(err)
+		}
+		go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{
+			ErrorCode:   proto.String(openapi.ProtoOAErrorCode_NOT_ENOUGH_MONEY.String()),
+			Description: proto.String("not enough money"),
+		}, req.GetClientMsgId())
+		return nil
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	metrics := NewPrometheusMetrics("", nil)
+	client.SetMetrics(metrics)
+
+	_, err := client.Account(1).Order().NewOrder(context.Background(), 1, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil)
+	if !IsErrorCode(err, openapi.ProtoOAErrorCode_NOT_ENOUGH_MONEY) {
+		t.Fatalf("expected NOT_ENOUGH_MONEY, got %v", err)
+	}
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{CtidTraderAccountId: proto.Int64(1), SymbolId: proto.Int64(1)}, "")
+
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, expected := range []string{
+		`ctrago_requests_total{payload_type="PROTO_OA_NEW_ORDER_REQ",outcome="error"} 1`,
+		`ctrago_error_codes_total{payload_type="PROTO_OA_NEW_ORDER_REQ",error_code="NOT_ENOUGH_MONEY"} 1`,
+		`ctrago_events_total{payload_type="PROTO_OA_SPOT_EVENT"} 1`,
+		`ctrago_request_duration_seconds_count{payload_type="PROTO_OA_NEW_ORDER_REQ"} 1`,
+		`ctrago_pending_requests 0`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("missing %q in output:\n%s", expected, out)
+		}
+	}
+}
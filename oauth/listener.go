@@ -0,0 +1,134 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Listener 本地 redirect URI 回调监听，接收授权页面重定向带回的授权码
+type Listener struct {
+	ln          net.Listener
+	srv         *http.Server
+	redirectURL string
+	path        string
+
+	once   sync.Once
+	result chan callback
+}
+
+type callback struct {
+	code  string
+	state string
+	err   error
+	// accepted Wait 回复该回调是否被接受，state 不符的回调被忽略
+	accepted chan bool
+}
+
+// Listen 在 redirectURL 的 host:port 上监听回调；端口为 0 时由系统分配，实际地址见 RedirectURL
+func Listen(redirectURL string) (*Listener, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("oauth: parse redirect url: %w", err)
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("oauth: redirect url must be a local http address, got %q", redirectURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	ln, err := net.Listen("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	u.Host = net.JoinHostPort(u.Hostname(), fmt.Sprint(port))
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	l := &Listener{
+		ln:          ln,
+		redirectURL: u.String(),
+		path:        path,
+		result:      make(chan callback),
+	}
+	l.srv = &http.Server{Handler: http.HandlerFunc(l.handle)}
+	go l.srv.Serve(ln)
+	return l, nil
+}
+
+// RedirectURL 实际监听的 redirect URI
+func (l *Listener) RedirectURL() string {
+	return l.redirectURL
+}
+
+// handle 只处理 redirect 路径上带 code 或 error 的请求，其余请求（如 /favicon.ico）返回 404
+func (l *Listener) handle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.URL.Path != l.path || (!q.Has("code") && !q.Has("error")) {
+		http.NotFound(w, r)
+		return
+	}
+	cb := callback{code: q.Get("code"), state: q.Get("state"), accepted: make(chan bool, 1)}
+	switch {
+	case q.Get("error") != "":
+		cb.err = &Error{Code: q.Get("error"), Description: q.Get("error_description")}
+	case cb.code == "":
+		cb.err = ErrMissingCode
+	}
+	// 等待 Wait 校验 state
+	select {
+	case l.result <- cb:
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case !<-cb.accepted:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "授权失败:", ErrStateMismatch)
+	case cb.err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "授权失败:", cb.err)
+	default:
+		fmt.Fprintln(w, "授权完成，可以关闭此页面")
+	}
+}
+
+// Wait 等待回调并返回授权码；state 非空时忽略 state 不符的回调，继续等待
+func (l *Listener) Wait(ctx context.Context, state string) (string, error) {
+	for {
+		select {
+		case cb := <-l.result:
+			if state != "" && cb.state != state {
+				cb.accepted <- false
+				continue
+			}
+			cb.accepted <- true
+			if cb.err != nil {
+				return "", cb.err
+			}
+			return cb.code, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// Close 停止监听
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		err = l.srv.Close()
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	})
+	return err
+}
@@ -0,0 +1,282 @@
+// Package oauth 实现 cTrader Open API 的 OAuth2 授权码流程，用于获取 ctrago.New 所需的访问令牌
+//
+// 授权页面（/apps/auth）由交易者在浏览器中确认，随后重定向到应用登记的 redirect URI 并带上授权码，
+// 再用授权码向 /apps/token 换取访问令牌与刷新令牌。Authorize 在本地监听 redirect URI 完成整个流程：
+//
+//	cfg := &oauth.Config{ClientId: id, ClientSecret: secret, RedirectURL: "http://localhost:5000/callback", Scope: oauth.ScopeTrading}
+//	token, err := cfg.Authorize(ctx, func(url string) error {
+//		fmt.Println("请在浏览器中打开:", url)
+//		return nil
+//	})
+//	client, err := ctrago.New(ctrago.WithCredentials(id, secret), ctrago.WithAccessToken(token.AccessToken))
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+)
+
+const (
+	// DefaultAuthURL 授权页面地址
+	DefaultAuthURL = "https://openapi.ctrader.com/apps/auth"
+	// DefaultTokenURL 令牌接口地址
+	DefaultTokenURL = "https://openapi.ctrader.com/apps/token"
+)
+
+var (
+	ErrStateMismatch = errors.New("oauth: state mismatch")
+	ErrMissingCode   = errors.New("oauth: redirect without authorization code")
+)
+
+// Scope 授权范围
+type Scope string
+
+const (
+	// ScopeAccounts 只读，对应 SCOPE_VIEW
+	ScopeAccounts Scope = "accounts"
+	// ScopeTrading 可交易，对应 SCOPE_TRADE
+	ScopeTrading Scope = "trading"
+)
+
+// ScopeFor 返回与 ProtoOAClientPermissionScope 对应的授权范围
+func ScopeFor(scope openapi.ProtoOAClientPermissionScope) Scope {
+	if scope == openapi.ProtoOAClientPermissionScope_SCOPE_TRADE {
+		return ScopeTrading
+	}
+	return ScopeAccounts
+}
+
+// PermissionScope 返回对应的 ProtoOAClientPermissionScope
+func (s Scope) PermissionScope() openapi.ProtoOAClientPermissionScope {
+	if s == ScopeTrading {
+		return openapi.ProtoOAClientPermissionScope_SCOPE_TRADE
+	}
+	return openapi.ProtoOAClientPermissionScope_SCOPE_VIEW
+}
+
+// Error 授权页面或令牌接口返回的错误
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oauth: " + e.Code
+	}
+	return fmt.Sprintf("oauth: %s: %s", e.Code, e.Description)
+}
+
+// Token 访问令牌
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	// Expiry 访问令牌过期时间，接口未返回有效期时为零值
+	Expiry time.Time `json:"expiry"`
+}
+
+// Expired 令牌是否已过期或将在 leeway 内过期
+func (t *Token) Expired(leeway time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(leeway).After(t.Expiry)
+}
+
+// ClientToken 转换为 ctrago.Token，可保存到 ctrago.TokenStore
+func (t *Token) ClientToken() *ctrago.Token {
+	return &ctrago.Token{AccessToken: t.AccessToken, RefreshToken: t.RefreshToken, Expiry: t.Expiry}
+}
+
+// Config 应用在 cTrader Open API 登记的信息
+type Config struct {
+	ClientId     string
+	ClientSecret string
+	// RedirectURL 应用登记的 redirect URI，Authorize 会在该地址的 host:port 上监听
+	RedirectURL string
+	Scope       Scope
+
+	// AuthURL、TokenURL 为空时使用 DefaultAuthURL、DefaultTokenURL，测试中可指向本地服务
+	AuthURL  string
+	TokenURL string
+	// HTTPClient 为空时使用 http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// AuthCodeURL 返回授权页面地址，state 非空时附加在 URL 中并随重定向原样返回
+func (c *Config) AuthCodeURL(state string) string {
+	base := c.AuthURL
+	if base == "" {
+		base = DefaultAuthURL
+	}
+	scope := c.Scope
+	if scope == "" {
+		scope = ScopeAccounts
+	}
+	q := url.Values{}
+	q.Set("client_id", c.ClientId)
+	q.Set("redirect_uri", c.RedirectURL)
+	q.Set("scope", string(scope))
+	q.Set("product", "web")
+	if state != "" {
+		q.Set("state", state)
+	}
+	return withQuery(base, q)
+}
+
+// Exchange 用授权码换取访问令牌
+func (c *Config) Exchange(ctx context.Context, code string) (*Token, error) {
+	q := url.Values{}
+	q.Set("grant_type", "authorization_code")
+	q.Set("code", code)
+	q.Set("redirect_uri", c.RedirectURL)
+	return c.token(ctx, q)
+}
+
+// Refresh 用刷新令牌换取新的访问令牌；已连接时也可以使用 ctrago.Client.RefreshToken
+func (c *Config) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	q := url.Values{}
+	q.Set("grant_type", "refresh_token")
+	q.Set("refresh_token", refreshToken)
+	return c.token(ctx, q)
+}
+
+// RefreshFunc 返回通过令牌接口刷新的 ctrago.RefreshFunc，用于 ctrago.NewRefreshingTokenSource，
+// 与 Client.TokenRefresher 不同，不依赖已鉴权的连接
+func (c *Config) RefreshFunc() ctrago.RefreshFunc {
+	return func(ctx context.Context, refreshToken string) (*ctrago.Token, error) {
+		token, err := c.Refresh(ctx, refreshToken)
+		if err != nil {
+			return nil, err
+		}
+		return token.ClientToken(), nil
+	}
+}
+
+// tokenResponse 令牌接口的返回，兼容驼峰与下划线两种字段名
+type tokenResponse struct {
+	AccessToken       string `json:"accessToken"`
+	TokenType         string `json:"tokenType"`
+	ExpiresIn         int64  `json:"expiresIn"`
+	RefreshToken      string `json:"refreshToken"`
+	AccessTokenSnake  string `json:"access_token"`
+	TokenTypeSnake    string `json:"token_type"`
+	ExpiresInSnake    int64  `json:"expires_in"`
+	RefreshTokenSnake string `json:"refresh_token"`
+	ErrorCode         string `json:"errorCode"`
+	Description       string `json:"description"`
+}
+
+func (c *Config) token(ctx context.Context, q url.Values) (*Token, error) {
+	base := c.TokenURL
+	if base == "" {
+		base = DefaultTokenURL
+	}
+	q.Set("client_id", c.ClientId)
+	q.Set("client_secret", c.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(base, q), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var res tokenResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &Error{Code: resp.Status}
+		}
+		return nil, fmt.Errorf("oauth: decode token response: %w", err)
+	}
+	if res.ErrorCode != "" {
+		return nil, &Error{Code: res.ErrorCode, Description: res.Description}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Code: resp.Status, Description: res.Description}
+	}
+	token := &Token{
+		AccessToken:  firstNonEmpty(res.AccessToken, res.AccessTokenSnake),
+		TokenType:    firstNonEmpty(res.TokenType, res.TokenTypeSnake),
+		RefreshToken: firstNonEmpty(res.RefreshToken, res.RefreshTokenSnake),
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("oauth: token response without access token")
+	}
+	if expiresIn := max(res.ExpiresIn, res.ExpiresInSnake); expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// Authorize 执行完整的授权码流程：在 RedirectURL 上监听回调，调用 open 打开授权页面，等待回调并换取令牌
+func (c *Config) Authorize(ctx context.Context, open func(authURL string) error) (*Token, error) {
+	l, err := Listen(c.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	cfg := *c
+	cfg.RedirectURL = l.RedirectURL()
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	if err := open(cfg.AuthCodeURL(state)); err != nil {
+		return nil, err
+	}
+	code, err := l.Wait(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Exchange(ctx, code)
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func withQuery(base string, q url.Values) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base + "?" + q.Encode()
+	}
+	existing := u.Query()
+	for k, v := range q {
+		existing[k] = v
+	}
+	u.RawQuery = existing.Encode()
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
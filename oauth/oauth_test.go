@@ -0,0 +1,154 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newProvider 本地授权服务：/apps/auth 直接重定向回 redirect_uri，/apps/token 只接受 code "abc"
+func newProvider(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/auth", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "id" || q.Get("scope") != "trading" {
+			t.Errorf("auth query = %v", q)
+		}
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"abc"}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/apps/token", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_secret") != "secret" {
+			json.NewEncoder(w).Encode(map[string]any{"errorCode": "ACCESS_DENIED", "description": "bad secret"})
+			return
+		}
+		switch q.Get("grant_type") {
+		case "authorization_code":
+			if q.Get("code") != "abc" {
+				json.NewEncoder(w).Encode(map[string]any{"errorCode": "INVALID_REQUEST", "description": "bad code"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"accessToken": "access-1", "tokenType": "bearer", "expiresIn": 3600, "refreshToken": "refresh-1", "errorCode": nil})
+		case "refresh_token":
+			json.NewEncoder(w).Encode(map[string]any{"accessToken": "access-2", "tokenType": "bearer", "expiresIn": 3600, "refreshToken": "refresh-2"})
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestAuthorize(t *testing.T) {
+	provider := newProvider(t)
+	defer provider.Close()
+	cfg := &Config{
+		ClientId:     "id",
+		ClientSecret: "secret",
+		RedirectURL:  "http://127.0.0.1:0/callback",
+		Scope:        ScopeTrading,
+		AuthURL:      provider.URL + "/apps/auth",
+		TokenURL:     provider.URL + "/apps/token",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := cfg.Authorize(ctx, func(authURL string) error {
+		// 模拟浏览器：打开授权页面并跟随重定向到本地回调
+		go func() {
+			resp, err := http.Get(authURL)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" || token.Expired(0) {
+		t.Fatalf("token = %+v", token)
+	}
+
+	refreshed, err := cfg.Refresh(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.AccessToken != "access-2" {
+		t.Fatalf("refreshed = %+v", refreshed)
+	}
+	if clientToken, err := cfg.RefreshFunc()(ctx, token.RefreshToken); err != nil || clientToken.RefreshToken != "refresh-2" {
+		t.Fatalf("RefreshFunc = %+v, %v", clientToken, err)
+	}
+
+	cfg.ClientSecret = "wrong"
+	_, err = cfg.Exchange(ctx, "abc")
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "ACCESS_DENIED" {
+		t.Fatalf("expected ACCESS_DENIED, got %v", err)
+	}
+}
+
+func TestListener_StateAndError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l, err := Listen("http://127.0.0.1:0/cb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := l.Wait(ctx, "expected")
+		done <- result{code, err}
+	}()
+	// 其他路径、不带 code 或 error 的请求，以及 state 不符的回调都被忽略
+	base := strings.TrimSuffix(l.RedirectURL(), "/cb")
+	for _, u := range []string{base + "/favicon.ico", base + "/cb/extra?code=abc&state=expected", l.RedirectURL(), l.RedirectURL() + "?code=abc&state=other"} {
+		res, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			t.Errorf("GET %s should be rejected", u)
+		}
+	}
+	go http.Get(l.RedirectURL() + "?code=good&state=expected")
+	if r := <-done; r.err != nil || r.code != "good" {
+		t.Fatalf("expected code good, got %q, %v", r.code, r.err)
+	}
+	l.Close()
+
+	l, err = Listen("http://127.0.0.1:0/cb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Get(l.RedirectURL() + "?error=access_denied&error_description=denied")
+	var oauthErr *Error
+	if _, err := l.Wait(ctx, ""); !errors.As(err, &oauthErr) || oauthErr.Code != "access_denied" {
+		t.Fatalf("expected access_denied, got %v", err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	cfg := &Config{ClientId: "id", RedirectURL: "http://localhost:5000/cb"}
+	u, err := url.Parse(cfg.AuthCodeURL("s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != "openapi.ctrader.com" || q.Get("scope") != "accounts" || q.Get("state") != "s1" || q.Get("redirect_uri") != "http://localhost:5000/cb" {
+		t.Fatalf("url = %s", u)
+	}
+}
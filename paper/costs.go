@@ -0,0 +1,75 @@
+package paper
+
+import (
+	"math"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// toMoney 存款货币金额换算为 10^-MoneyDigits 的整数
+func (e *Engine) toMoney(amount float64) int64 {
+	return int64(math.Round(amount * math.Pow10(int(e.cfg.MoneyDigits))))
+}
+
+// grossProfit 以 price 平掉 pos 中 volume 部分的毛利
+func (e *Engine) grossProfit(pos *openapi.ProtoOAPosition, price float64, volume int64) int64 {
+	diff := price - pos.GetPrice()
+	if pos.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+		diff = -diff
+	}
+	return e.toMoney(diff * ctrago.VolumeUnits(volume) * e.cfg.Conversion(pos.GetTradeData().GetSymbolId()))
+}
+
+// margin 以 price 开 volume 所需保证金
+func (e *Engine) margin(symbolId int64, volume int64, price float64) int64 {
+	return e.toMoney(ctrago.VolumeUnits(volume) * price * e.cfg.Conversion(symbolId) * 100 / float64(e.cfg.LeverageInCents))
+}
+
+// costModel 品种的成本模型，报价货币按 Conversion 折算
+func (e *Engine) costModel(symbol *openapi.ProtoOASymbol) ctrago.CostModel {
+	return ctrago.CostModel{Symbol: symbol, QuoteRate: e.cfg.Conversion(symbol.GetSymbolId())}
+}
+
+// commission 单边手续费（负数），见 ctrago.CostModel.Commission
+func (e *Engine) commission(symbol *openapi.ProtoOASymbol, volume int64, price float64) int64 {
+	return -e.toMoney(e.costModel(symbol).Commission(volume, price))
+}
+
+// swap 持仓一次收取周期的隔夜利息（正数为收入），见 ctrago.CostModel.SwapPerRollover
+func (e *Engine) swap(symbol *openapi.ProtoOASymbol, pos *openapi.ProtoOAPosition, price float64) float64 {
+	return -e.costModel(symbol).SwapPerRollover(pos.GetTradeData().GetTradeSide(), pos.GetTradeData().GetVolume(), price)
+}
+
+// nextRollover t 之后（不含）最近的一次隔夜利息收取时间
+func nextRollover(symbol *openapi.ProtoOASymbol, t time.Time) time.Time {
+	return ctrago.CostModel{Symbol: symbol}.NextRollover(t)
+}
+
+// swapMultiplier 在 at 收取隔夜利息的倍数，见 ctrago.CostModel.RolloverMultiplier
+func swapMultiplier(symbol *openapi.ProtoOASymbol, at time.Time) float64 {
+	return ctrago.CostModel{Symbol: symbol}.RolloverMultiplier(at)
+}
+
+// chargeSwaps 对已到收取时间的持仓计提隔夜利息，每个持仓产生一条 SWAP 事件
+func (e *Engine) chargeSwaps() []*openapi.ProtoOAExecutionEvent {
+	var events []*openapi.ProtoOAExecutionEvent
+	for _, id := range sortedIds(e.positions) {
+		pos := e.positions[id]
+		symbol := e.symbols[pos.GetTradeData().GetSymbolId()]
+		q := e.quotes[pos.GetTradeData().GetSymbolId()]
+		var charged float64
+		for next := e.nextSwap[id]; !next.After(e.now); next = nextRollover(symbol, next) {
+			charged += e.swap(symbol, pos, closePrice(q, pos.GetTradeData().GetTradeSide())) * swapMultiplier(symbol, next)
+			e.nextSwap[id] = nextRollover(symbol, next)
+		}
+		if amount := e.toMoney(charged); amount != 0 {
+			pos.Swap = proto.Int64(pos.GetSwap() + amount)
+			pos.UtcLastUpdateTimestamp = proto.Int64(e.now.UnixMilli())
+			events = append(events, e.event(openapi.ProtoOAExecutionType_SWAP, nil, pos, nil))
+		}
+	}
+	return events
+}
@@ -0,0 +1,336 @@
+// Package paper 提供本地模拟成交（纸上交易），订单在本地按实时或回放的报价撮合，不会影响任何真实账户
+//
+// Engine 维护一个模拟账户的余额、持仓、挂单与成交记录，按 ProtoOASymbol 计算手续费、隔夜利息与保证金，
+// 并在报价变化时触发挂单成交、止损止盈、过期与强平。Transport 把 Engine 接到任意 ctrago.Transport 上，
+// 使 AccountOrder 的下单类请求在本地执行，其他请求与行情仍走原连接：
+//
+//	engine := paper.NewEngine(paper.Config{AccountId: accountId, Balance: 10000_00})
+//	client, err := ctrago.New(ctrago.WithTransportWrapper(paper.Wrap(engine)), ...)
+package paper
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// Config 模拟账户配置
+type Config struct {
+	// AccountId 模拟的交易账户，只有该账户的请求在本地执行
+	AccountId int64
+	// Balance 初始余额，单位为 10^-MoneyDigits 存款货币
+	Balance int64
+	// MoneyDigits 金额精度，默认 2
+	MoneyDigits uint32
+	// DepositAssetId 存款货币的资产 id，0 表示沿用被包装传输层返回的账户信息
+	DepositAssetId int64
+	// LeverageInCents 杠杆，默认 10000 即 1:100
+	LeverageInCents uint32
+	// StopOutLevel 保证金比例（%）低于该值时强平，默认 50
+	StopOutLevel float64
+	// Conversion 报价货币兑存款货币的汇率，默认视为同一货币
+	Conversion func(symbolId int64) float64
+	// Clock 报价不带时间戳时使用的时钟，默认 time.Now
+	Clock func() time.Time
+}
+
+// Quote 品种报价
+type Quote struct {
+	Bid  float64
+	Ask  float64
+	Time time.Time
+}
+
+// Engine 模拟成交引擎，方法均可并发调用
+type Engine struct {
+	cfg Config
+
+	lock      sync.Mutex
+	symbols   map[int64]*openapi.ProtoOASymbol
+	quotes    map[int64]Quote
+	now       time.Time
+	balance   int64
+	positions map[int64]*openapi.ProtoOAPosition
+	orders    map[int64]*openapi.ProtoOAOrder
+	deals     []*openapi.ProtoOADeal
+	nextId    int64
+	// nextSwap 每个持仓下一次收取隔夜利息的时间
+	nextSwap map[int64]time.Time
+	// trailing 移动止损持仓的止损距离
+	trailing map[int64]float64
+}
+
+// NewEngine 创建模拟成交引擎
+func NewEngine(cfg Config) *Engine {
+	if cfg.MoneyDigits == 0 {
+		cfg.MoneyDigits = 2
+	}
+	if cfg.LeverageInCents == 0 {
+		cfg.LeverageInCents = 10000
+	}
+	if cfg.StopOutLevel == 0 {
+		cfg.StopOutLevel = 50
+	}
+	if cfg.Conversion == nil {
+		cfg.Conversion = func(int64) float64 { return 1 }
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	return &Engine{
+		cfg:       cfg,
+		symbols:   make(map[int64]*openapi.ProtoOASymbol),
+		quotes:    make(map[int64]Quote),
+		balance:   cfg.Balance,
+		positions: make(map[int64]*openapi.ProtoOAPosition),
+		orders:    make(map[int64]*openapi.ProtoOAOrder),
+		nextSwap:  make(map[int64]time.Time),
+		trailing:  make(map[int64]float64),
+	}
+}
+
+// AccountId 模拟的交易账户
+func (e *Engine) AccountId() int64 {
+	return e.cfg.AccountId
+}
+
+// SetSymbol 设置品种参数（成交量限制、手续费、隔夜利息），下单前必须已设置
+func (e *Engine) SetSymbol(symbol *openapi.ProtoOASymbol) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.symbols[symbol.GetSymbolId()] = proto.Clone(symbol).(*openapi.ProtoOASymbol)
+}
+
+// HasSymbol 是否已设置品种参数
+func (e *Engine) HasSymbol(symbolId int64) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	_, ok := e.symbols[symbolId]
+	return ok
+}
+
+// Quote 返回品种的最新报价
+func (e *Engine) Quote(symbolId int64) (Quote, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	q, ok := e.quotes[symbolId]
+	return q, ok
+}
+
+// OnQuote 更新报价并推进时钟，返回因此产生的执行事件（挂单成交、止损止盈、过期、隔夜利息、强平）
+//
+// bid/ask 为 0 表示该边未变化，与 ProtoOASpotEvent 一致；ts 为零值时使用 Config.Clock
+func (e *Engine) OnQuote(symbolId int64, bid, ask float64, ts time.Time) []*openapi.ProtoOAExecutionEvent {
+	if ts.IsZero() {
+		ts = e.cfg.Clock()
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	q := e.quotes[symbolId]
+	if bid > 0 {
+		q.Bid = bid
+	}
+	if ask > 0 {
+		q.Ask = ask
+	}
+	q.Time = ts
+	e.quotes[symbolId] = q
+	if ts.After(e.now) {
+		e.now = ts
+	}
+	if q.Bid == 0 || q.Ask == 0 {
+		return nil
+	}
+	var events []*openapi.ProtoOAExecutionEvent
+	events = append(events, e.chargeSwaps()...)
+	events = append(events, e.expireOrders()...)
+	events = append(events, e.matchOrders(symbolId)...)
+	events = append(events, e.checkProtection(symbolId)...)
+	events = append(events, e.stopOut()...)
+	return events
+}
+
+// Balance 余额
+func (e *Engine) Balance() int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.balance
+}
+
+// Equity 净值：余额 + 持仓浮动盈亏、隔夜利息与开仓手续费
+func (e *Engine) Equity() int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.equity()
+}
+
+// UsedMargin 已用保证金
+func (e *Engine) UsedMargin() int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.usedMargin()
+}
+
+// MarginLevel 保证金比例（%），没有持仓时返回 +Inf
+func (e *Engine) MarginLevel() float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.marginLevel()
+}
+
+// Positions 当前持仓
+func (e *Engine) Positions() []*openapi.ProtoOAPosition {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	positions := make([]*openapi.ProtoOAPosition, 0, len(e.positions))
+	for _, id := range sortedIds(e.positions) {
+		positions = append(positions, proto.Clone(e.positions[id]).(*openapi.ProtoOAPosition))
+	}
+	return positions
+}
+
+// Orders 当前挂单
+func (e *Engine) Orders() []*openapi.ProtoOAOrder {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	orders := make([]*openapi.ProtoOAOrder, 0, len(e.orders))
+	for _, id := range sortedIds(e.orders) {
+		orders = append(orders, proto.Clone(e.orders[id]).(*openapi.ProtoOAOrder))
+	}
+	return orders
+}
+
+// Deals 执行时间在 [from, to] 内的成交记录（毫秒时间戳），maxRows <= 0 表示不限
+func (e *Engine) Deals(from, to int64, maxRows int) (deals []*openapi.ProtoOADeal, hasMore bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, deal := range e.deals {
+		ts := deal.GetExecutionTimestamp()
+		if ts < from || ts > to {
+			continue
+		}
+		if maxRows > 0 && len(deals) >= maxRows {
+			return deals, true
+		}
+		deals = append(deals, proto.Clone(deal).(*openapi.ProtoOADeal))
+	}
+	return deals, false
+}
+
+// Trader 模拟账户信息，用于响应 ProtoOATraderReq
+//
+// real 为被包装传输层返回的账户信息，经纪商、登录名等保持不变，只替换余额、杠杆、金额精度与存款货币；
+// real 为 nil 时（如回测）经纪商名称为 "paper"
+func (e *Engine) Trader(real *openapi.ProtoOATrader) *openapi.ProtoOATrader {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	trader := &openapi.ProtoOATrader{
+		TraderLogin: proto.Int64(e.cfg.AccountId),
+		BrokerName:  proto.String("paper"),
+	}
+	if real != nil {
+		trader = proto.Clone(real).(*openapi.ProtoOATrader)
+	}
+	trader.CtidTraderAccountId = proto.Int64(e.cfg.AccountId)
+	trader.Balance = proto.Int64(e.balance)
+	trader.LeverageInCents = proto.Uint32(e.cfg.LeverageInCents)
+	trader.MoneyDigits = proto.Uint32(e.cfg.MoneyDigits)
+	if e.cfg.DepositAssetId != 0 || trader.DepositAssetId == nil {
+		trader.DepositAssetId = proto.Int64(e.cfg.DepositAssetId)
+	}
+	return trader
+}
+
+func (e *Engine) newId() int64 {
+	e.nextId++
+	return e.nextId
+}
+
+func (e *Engine) clock() time.Time {
+	if e.now.IsZero() {
+		return e.cfg.Clock()
+	}
+	return e.now
+}
+
+func (e *Engine) equity() int64 {
+	equity := e.balance
+	for _, pos := range e.positions {
+		equity += e.unrealized(pos) + pos.GetSwap() + pos.GetCommission()
+	}
+	return equity
+}
+
+func (e *Engine) usedMargin() int64 {
+	var used int64
+	for _, pos := range e.positions {
+		used += int64(pos.GetUsedMargin())
+	}
+	return used
+}
+
+func (e *Engine) marginLevel() float64 {
+	used := e.usedMargin()
+	if used == 0 {
+		return math.Inf(1)
+	}
+	return float64(e.equity()) / float64(used) * 100
+}
+
+// unrealized 持仓按当前报价平仓的毛利
+func (e *Engine) unrealized(pos *openapi.ProtoOAPosition) int64 {
+	q, ok := e.quotes[pos.GetTradeData().GetSymbolId()]
+	if !ok {
+		return 0
+	}
+	return e.grossProfit(pos, closePrice(q, pos.GetTradeData().GetTradeSide()), pos.GetTradeData().GetVolume())
+}
+
+// stopOut 保证金比例低于 StopOutLevel 时，依次平掉亏损最大的持仓
+func (e *Engine) stopOut() []*openapi.ProtoOAExecutionEvent {
+	var events []*openapi.ProtoOAExecutionEvent
+	for len(e.positions) > 0 && e.marginLevel() < e.cfg.StopOutLevel {
+		var worst *openapi.ProtoOAPosition
+		var worstPnl int64
+		for _, id := range sortedIds(e.positions) {
+			pos := e.positions[id]
+			if pnl := e.unrealized(pos); worst == nil || pnl < worstPnl {
+				worst, worstPnl = pos, pnl
+			}
+		}
+		order := e.closingOrder(worst, worst.GetTradeData().GetVolume(), openapi.ProtoOAOrderType_MARKET)
+		order.IsStopOut = proto.Bool(true)
+		q := e.quotes[worst.GetTradeData().GetSymbolId()]
+		events = append(events, e.fill(order, closePrice(q, worst.GetTradeData().GetTradeSide())))
+	}
+	return events
+}
+
+// closePrice 平仓价：多头按 bid，空头按 ask
+func closePrice(q Quote, side openapi.ProtoOATradeSide) float64 {
+	if side == openapi.ProtoOATradeSide_BUY {
+		return q.Bid
+	}
+	return q.Ask
+}
+
+// openPrice 开仓价：买入按 ask，卖出按 bid
+func openPrice(q Quote, side openapi.ProtoOATradeSide) float64 {
+	if side == openapi.ProtoOATradeSide_BUY {
+		return q.Ask
+	}
+	return q.Bid
+}
+
+func sortedIds[V any](m map[int64]V) []int64 {
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,170 @@
+package paper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+const eurusd = 1
+
+func newEngine(balance int64) *Engine {
+	e := NewEngine(Config{AccountId: 1, Balance: balance})
+	commissionType := openapi.ProtoOACommissionType_USD_PER_MILLION_USD
+	tripleDay := openapi.ProtoOADayOfWeek_WEDNESDAY
+	e.SetSymbol(&openapi.ProtoOASymbol{
+		SymbolId:                     proto.Int64(eurusd),
+		Digits:                       proto.Int32(5),
+		PipPosition:                  proto.Int32(4),
+		MinVolume:                    proto.Int64(100000),
+		MaxVolume:                    proto.Int64(10000000000),
+		StepVolume:                   proto.Int64(100000),
+		CommissionType:               &commissionType,
+		PreciseTradingCommissionRate: proto.Int64(30 * 1e8),
+		SwapLong:                     proto.Float64(-5),
+		SwapShort:                    proto.Float64(1),
+		SwapRollover3Days:            &tripleDay,
+	})
+	return e
+}
+
+func marketOrder(side openapi.ProtoOATradeSide, volume int64) *openapi.ProtoOANewOrderReq {
+	orderType := openapi.ProtoOAOrderType_MARKET
+	return &openapi.ProtoOANewOrderReq{
+		CtidTraderAccountId: proto.Int64(1),
+		SymbolId:            proto.Int64(eurusd),
+		OrderType:           &orderType,
+		TradeSide:           &side,
+		Volume:              proto.Int64(volume),
+	}
+}
+
+func TestEngine_MarketOrderCommission(t *testing.T) {
+	e := newEngine(10000_00)
+	if _, err := e.NewOrder(marketOrder(openapi.ProtoOATradeSide_BUY, 10000000)); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_NO_QUOTES) {
+		t.Fatalf("expected NO_QUOTES, got %v", err)
+	}
+	e.OnQuote(eurusd, 1.1000, 1.1002, time.Time{})
+	events, err := e.NewOrder(marketOrder(openapi.ProtoOATradeSide_BUY, 10000000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].GetExecutionType() != openapi.ProtoOAExecutionType_ORDER_ACCEPTED || events[1].GetExecutionType() != openapi.ProtoOAExecutionType_ORDER_FILLED {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	pos := events[1].GetPosition()
+	// 100000 单位 × 1.1002 × 30/1000000 = 3.30 美元
+	if pos.GetPrice() != 1.1002 || pos.GetCommission() != -330 || pos.GetUsedMargin() != 110020 {
+		t.Fatalf("unexpected position: %v", pos)
+	}
+
+	e.OnQuote(eurusd, 1.1010, 1.1012, time.Time{})
+	events, err = e.ClosePosition(pos.GetPositionId(), 10000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := events[1].GetDeal().GetClosePositionDetail()
+	if detail.GetGrossProfit() != 8000 || detail.GetCommission() != -660 {
+		t.Errorf("unexpected close detail: %v", detail)
+	}
+	if e.Balance() != 10000_00+8000-660 || len(e.Positions()) != 0 || e.UsedMargin() != 0 {
+		t.Errorf("unexpected balance %d", e.Balance())
+	}
+	if deals, _ := e.Deals(0, time.Now().Add(time.Minute).UnixMilli(), 0); len(deals) != 2 {
+		t.Errorf("expected 2 deals, got %d", len(deals))
+	}
+}
+
+func TestEngine_StopLossAndPendingOrders(t *testing.T) {
+	e := newEngine(10000_00)
+	e.OnQuote(eurusd, 1.1000, 1.1002, time.Time{})
+	req := marketOrder(openapi.ProtoOATradeSide_BUY, 10000000)
+	req.RelativeStopLoss = proto.Int64(200)
+	events, err := e.NewOrder(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl := events[1].GetPosition().GetStopLoss(); sl != 1.0982 {
+		t.Fatalf("expected stop loss 1.0982, got %v", sl)
+	}
+
+	limit := marketOrder(openapi.ProtoOATradeSide_SELL, 10000000)
+	limit.OrderType = openapi.ProtoOAOrderType_LIMIT.Enum()
+	limit.LimitPrice = proto.Float64(1.1050)
+	if _, err := e.NewOrder(limit); err != nil {
+		t.Fatal(err)
+	}
+	expiring := marketOrder(openapi.ProtoOATradeSide_BUY, 10000000)
+	expiring.OrderType = openapi.ProtoOAOrderType_STOP.Enum()
+	expiring.StopPrice = proto.Float64(1.1100)
+	expiring.TimeInForce = openapi.ProtoOATimeInForce_GOOD_TILL_DATE.Enum()
+	expiring.ExpirationTimestamp = proto.Int64(time.Now().Add(time.Hour).UnixMilli())
+	if _, err := e.NewOrder(expiring); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Orders()) != 2 {
+		t.Fatalf("expected 2 pending orders, got %d", len(e.Orders()))
+	}
+
+	events = e.OnQuote(eurusd, 1.0981, 1.0983, time.Now().Add(2*time.Hour))
+	var types []openapi.ProtoOAExecutionType
+	for _, ev := range events {
+		types = append(types, ev.GetExecutionType())
+	}
+	if len(events) != 2 || types[0] != openapi.ProtoOAExecutionType_ORDER_EXPIRED || types[1] != openapi.ProtoOAExecutionType_ORDER_FILLED {
+		t.Fatalf("unexpected events: %v", types)
+	}
+	if events[1].GetOrder().GetOrderType() != openapi.ProtoOAOrderType_STOP_LOSS_TAKE_PROFIT || events[1].GetDeal().GetExecutionPrice() != 1.0981 {
+		t.Errorf("unexpected stop loss fill: %v", events[1])
+	}
+	if len(e.Positions()) != 0 || len(e.Orders()) != 1 {
+		t.Errorf("unexpected state: %d positions, %d orders", len(e.Positions()), len(e.Orders()))
+	}
+}
+
+func TestEngine_StopOut(t *testing.T) {
+	e := newEngine(1000_00)
+	e.OnQuote(eurusd, 1.1000, 1.1002, time.Time{})
+	if _, err := e.NewOrder(marketOrder(openapi.ProtoOATradeSide_BUY, 100000000)); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_NOT_ENOUGH_MONEY) {
+		t.Fatalf("expected NOT_ENOUGH_MONEY, got %v", err)
+	}
+	if _, err := e.NewOrder(marketOrder(openapi.ProtoOATradeSide_BUY, 5000000)); err != nil {
+		t.Fatal(err)
+	}
+	if events := e.OnQuote(eurusd, 1.0900, 1.0902, time.Time{}); len(events) != 0 {
+		t.Fatalf("unexpected stop out at margin level %.1f%%", e.MarginLevel())
+	}
+	// 亏损 (1.0850-1.1002)×50000 = 760 美元，保证金比例约 44%
+	events := e.OnQuote(eurusd, 1.0850, 1.0852, time.Time{})
+	if len(events) != 1 || !events[0].GetOrder().GetIsStopOut() {
+		t.Fatalf("expected stop out, got %v", events)
+	}
+	if len(e.Positions()) != 0 || e.Balance() != 1000_00-76000-165-163 {
+		t.Errorf("unexpected balance %d", e.Balance())
+	}
+}
+
+func TestEngine_Swap(t *testing.T) {
+	e := newEngine(10000_00)
+	tuesday := time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)
+	e.OnQuote(eurusd, 1.1000, 1.1002, tuesday)
+	if _, err := e.NewOrder(marketOrder(openapi.ProtoOATradeSide_BUY, 10000000)); err != nil {
+		t.Fatal(err)
+	}
+	if events := e.OnQuote(eurusd, 1.1000, 1.1002, tuesday.Add(9*time.Hour)); len(events) != 0 {
+		t.Fatalf("unexpected swap before rollover: %v", events)
+	}
+	// 周二 22:00 收取 -5 点 × 100000 = -50 美元
+	events := e.OnQuote(eurusd, 1.1000, 1.1002, tuesday.Add(11*time.Hour))
+	if len(events) != 1 || events[0].GetExecutionType() != openapi.ProtoOAExecutionType_SWAP || events[0].GetPosition().GetSwap() != -5000 {
+		t.Fatalf("unexpected swap events: %v", events)
+	}
+	// 周三三倍
+	events = e.OnQuote(eurusd, 1.1000, 1.1002, tuesday.Add(35*time.Hour))
+	if len(events) != 1 || events[0].GetPosition().GetSwap() != -20000 {
+		t.Fatalf("unexpected swap events: %v", events)
+	}
+}
@@ -0,0 +1,578 @@
+package paper
+
+import (
+	"math"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// orderError 与真实服务端的 ProtoOAOrderErrorEvent 对应的错误
+func (e *Engine) orderError(code openapi.ProtoOAErrorCode, description string) *ctrago.APIError {
+	return &ctrago.APIError{
+		PayloadType: uint32(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT),
+		ErrorCode:   code.String(),
+		Description: description,
+		AccountId:   e.cfg.AccountId,
+	}
+}
+
+func (e *Engine) event(executionType openapi.ProtoOAExecutionType, order *openapi.ProtoOAOrder, position *openapi.ProtoOAPosition, deal *openapi.ProtoOADeal) *openapi.ProtoOAExecutionEvent {
+	ev := &openapi.ProtoOAExecutionEvent{
+		CtidTraderAccountId: proto.Int64(e.cfg.AccountId),
+		ExecutionType:       &executionType,
+	}
+	if order != nil {
+		ev.Order = proto.Clone(order).(*openapi.ProtoOAOrder)
+	}
+	if position != nil {
+		ev.Position = proto.Clone(position).(*openapi.ProtoOAPosition)
+	}
+	if deal != nil {
+		ev.Deal = proto.Clone(deal).(*openapi.ProtoOADeal)
+	}
+	return ev
+}
+
+// NewOrder 在本地执行下单请求
+//
+// 返回的第一个事件对应服务端的响应（ORDER_ACCEPTED），其后为立即产生的成交、取消等事件；
+// 请求被拒绝时返回 *ctrago.APIError，错误码与真实服务端一致
+func (e *Engine) NewOrder(req *openapi.ProtoOANewOrderReq) ([]*openapi.ProtoOAExecutionEvent, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	symbol, ok := e.symbols[req.GetSymbolId()]
+	if !ok {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND, "symbol not found")
+	}
+	if err := e.checkVolume(symbol, req.GetVolume()); err != nil {
+		return nil, err
+	}
+	if req.GetPositionId() != 0 {
+		pos, ok := e.positions[req.GetPositionId()]
+		if !ok {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_POSITION_NOT_FOUND, "position not found")
+		}
+		if pos.GetTradeData().GetSymbolId() != req.GetSymbolId() {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_INCORRECT_BOUNDARIES, "position belongs to another symbol")
+		}
+	}
+	q, hasQuote := e.quote(req.GetSymbolId())
+	orderType := req.GetOrderType()
+	switch orderType {
+	case openapi.ProtoOAOrderType_MARKET, openapi.ProtoOAOrderType_MARKET_RANGE:
+		if !hasQuote {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_NO_QUOTES, "no quotes for symbol")
+		}
+	case openapi.ProtoOAOrderType_LIMIT:
+		if req.GetLimitPrice() <= 0 {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_PRICES, "limit price is required")
+		}
+	case openapi.ProtoOAOrderType_STOP, openapi.ProtoOAOrderType_STOP_LIMIT:
+		if req.GetStopPrice() <= 0 {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_STOPS, "stop price is required")
+		}
+	default:
+		return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_NOT_ALLOWED, "unsupported order type")
+	}
+	now := e.clock().UnixMilli()
+	if req.GetTimeInForce() == openapi.ProtoOATimeInForce_GOOD_TILL_DATE && req.GetExpirationTimestamp() <= now {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_EXPIRATION_DATE, "expiration timestamp is in the past")
+	}
+	market := isMarket(orderType)
+	if market && !e.closes(req.GetPositionId(), req.GetTradeSide()) {
+		if e.margin(symbol.GetSymbolId(), req.GetVolume(), openPrice(q, req.GetTradeSide())) > e.equity()-e.usedMargin() {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_NOT_ENOUGH_MONEY, "not enough money")
+		}
+	}
+
+	status := openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED
+	tradeSide := req.GetTradeSide()
+	// ProtoOAOrder 的 TimeInForce 默认值与请求不同，需显式设置
+	timeInForce := req.GetTimeInForce()
+	if market {
+		timeInForce = openapi.ProtoOATimeInForce_IMMEDIATE_OR_CANCEL
+	}
+	order := &openapi.ProtoOAOrder{
+		OrderId: proto.Int64(e.newId()),
+		TradeData: &openapi.ProtoOATradeData{
+			SymbolId:           proto.Int64(symbol.GetSymbolId()),
+			Volume:             proto.Int64(req.GetVolume()),
+			TradeSide:          &tradeSide,
+			OpenTimestamp:      proto.Int64(now),
+			Label:              req.Label,
+			Comment:            req.Comment,
+			GuaranteedStopLoss: req.GuaranteedStopLoss,
+		},
+		OrderType:              &orderType,
+		OrderStatus:            &status,
+		UtcLastUpdateTimestamp: proto.Int64(now),
+		LimitPrice:             req.LimitPrice,
+		StopPrice:              req.StopPrice,
+		StopLoss:               req.StopLoss,
+		TakeProfit:             req.TakeProfit,
+		RelativeStopLoss:       req.RelativeStopLoss,
+		RelativeTakeProfit:     req.RelativeTakeProfit,
+		TrailingStopLoss:       req.TrailingStopLoss,
+		BaseSlippagePrice:      req.BaseSlippagePrice,
+		ClientOrderId:          req.ClientOrderId,
+		PositionId:             req.PositionId,
+		ExpirationTimestamp:    req.ExpirationTimestamp,
+		TimeInForce:            &timeInForce,
+	}
+	if req.SlippageInPoints != nil {
+		order.SlippageInPoints = proto.Int64(int64(req.GetSlippageInPoints()))
+	}
+	if req.GetPositionId() != 0 && e.closes(req.GetPositionId(), tradeSide) {
+		order.ClosingOrder = proto.Bool(true)
+	}
+	events := []*openapi.ProtoOAExecutionEvent{e.event(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, order, nil, nil)}
+	if market {
+		price := openPrice(q, tradeSide)
+		if orderType == openapi.ProtoOAOrderType_MARKET_RANGE && !withinSlippage(symbol, order, order.GetBaseSlippagePrice(), price) {
+			return append(events, e.cancel(order)), nil
+		}
+		return append(events, e.execute(order, price)), nil
+	}
+	e.orders[order.GetOrderId()] = order
+	events = append(events, e.matchOrders(symbol.GetSymbolId())...)
+	if _, pending := e.orders[order.GetOrderId()]; pending {
+		switch order.GetTimeInForce() {
+		case openapi.ProtoOATimeInForce_IMMEDIATE_OR_CANCEL, openapi.ProtoOATimeInForce_FILL_OR_KILL:
+			delete(e.orders, order.GetOrderId())
+			events = append(events, e.cancel(order))
+		}
+	}
+	return events, nil
+}
+
+// AmendOrder 在本地修改挂单，修改后立即按当前报价撮合
+func (e *Engine) AmendOrder(req *openapi.ProtoOAAmendOrderReq) ([]*openapi.ProtoOAExecutionEvent, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	order, ok := e.orders[req.GetOrderId()]
+	if !ok {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_ORDER_NOT_FOUND, "order not found")
+	}
+	if req.Volume != nil {
+		if err := e.checkVolume(e.symbols[order.GetTradeData().GetSymbolId()], req.GetVolume()); err != nil {
+			return nil, err
+		}
+		order.TradeData.Volume = req.Volume
+	}
+	if req.LimitPrice != nil {
+		order.LimitPrice = req.LimitPrice
+	}
+	if req.StopPrice != nil {
+		order.StopPrice = req.StopPrice
+	}
+	if req.StopLoss != nil {
+		order.StopLoss = req.StopLoss
+	}
+	if req.TakeProfit != nil {
+		order.TakeProfit = req.TakeProfit
+	}
+	if req.RelativeStopLoss != nil {
+		order.RelativeStopLoss = req.RelativeStopLoss
+	}
+	if req.RelativeTakeProfit != nil {
+		order.RelativeTakeProfit = req.RelativeTakeProfit
+	}
+	if req.SlippageInPoints != nil {
+		order.SlippageInPoints = proto.Int64(int64(req.GetSlippageInPoints()))
+	}
+	if req.TrailingStopLoss != nil {
+		order.TrailingStopLoss = req.TrailingStopLoss
+	}
+	if req.ExpirationTimestamp != nil {
+		order.ExpirationTimestamp = req.ExpirationTimestamp
+	}
+	order.UtcLastUpdateTimestamp = proto.Int64(e.clock().UnixMilli())
+	events := []*openapi.ProtoOAExecutionEvent{e.event(openapi.ProtoOAExecutionType_ORDER_REPLACED, order, nil, nil)}
+	return append(events, e.matchOrders(order.GetTradeData().GetSymbolId())...), nil
+}
+
+// CancelOrder 在本地撤销挂单
+func (e *Engine) CancelOrder(orderId int64) ([]*openapi.ProtoOAExecutionEvent, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	order, ok := e.orders[orderId]
+	if !ok {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_ORDER_NOT_FOUND, "order not found")
+	}
+	delete(e.orders, orderId)
+	return []*openapi.ProtoOAExecutionEvent{e.cancel(order)}, nil
+}
+
+// AmendPositionSLTP 在本地修改持仓止损止盈，未设置的价格表示取消
+func (e *Engine) AmendPositionSLTP(req *openapi.ProtoOAAmendPositionSLTPReq) ([]*openapi.ProtoOAExecutionEvent, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	pos, ok := e.positions[req.GetPositionId()]
+	if !ok {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_POSITION_NOT_FOUND, "position not found")
+	}
+	if q, ok := e.quote(pos.GetTradeData().GetSymbolId()); ok {
+		price := closePrice(q, pos.GetTradeData().GetTradeSide())
+		buy := pos.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_BUY
+		if sl := req.GetStopLoss(); sl > 0 && (buy && sl >= price || !buy && sl <= price) {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_STOPS, "stop loss is on the wrong side of the market")
+		}
+		if tp := req.GetTakeProfit(); tp > 0 && (buy && tp <= price || !buy && tp >= price) {
+			return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_STOPS, "take profit is on the wrong side of the market")
+		}
+	}
+	pos.StopLoss = req.StopLoss
+	pos.TakeProfit = req.TakeProfit
+	pos.GuaranteedStopLoss = req.GuaranteedStopLoss
+	pos.TrailingStopLoss = req.TrailingStopLoss
+	pos.UtcLastUpdateTimestamp = proto.Int64(e.clock().UnixMilli())
+	return []*openapi.ProtoOAExecutionEvent{e.event(openapi.ProtoOAExecutionType_ORDER_REPLACED, nil, pos, nil)}, nil
+}
+
+// ClosePosition 在本地按市价平掉持仓的 volume 部分，volume 超过持仓量时全部平仓
+func (e *Engine) ClosePosition(positionId, volume int64) ([]*openapi.ProtoOAExecutionEvent, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	pos, ok := e.positions[positionId]
+	if !ok {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_POSITION_NOT_FOUND, "position not found")
+	}
+	if volume <= 0 {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_VOLUME, "volume must be positive")
+	}
+	q, ok := e.quote(pos.GetTradeData().GetSymbolId())
+	if !ok {
+		return nil, e.orderError(openapi.ProtoOAErrorCode_NO_QUOTES, "no quotes for symbol")
+	}
+	order := e.closingOrder(pos, min(volume, pos.GetTradeData().GetVolume()), openapi.ProtoOAOrderType_MARKET)
+	events := []*openapi.ProtoOAExecutionEvent{e.event(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, order, pos, nil)}
+	return append(events, e.fill(order, closePrice(q, pos.GetTradeData().GetTradeSide()))), nil
+}
+
+// Reconcile 当前持仓与挂单，用于响应 ProtoOAReconcileReq
+func (e *Engine) Reconcile() *openapi.ProtoOAReconcileRes {
+	return &openapi.ProtoOAReconcileRes{
+		CtidTraderAccountId: proto.Int64(e.cfg.AccountId),
+		Position:            e.Positions(),
+		Order:               e.Orders(),
+	}
+}
+
+func (e *Engine) checkVolume(symbol *openapi.ProtoOASymbol, volume int64) error {
+	if volume <= 0 ||
+		symbol.GetMinVolume() > 0 && volume < symbol.GetMinVolume() ||
+		symbol.GetMaxVolume() > 0 && volume > symbol.GetMaxVolume() ||
+		symbol.GetStepVolume() > 0 && volume%symbol.GetStepVolume() != 0 {
+		return e.orderError(openapi.ProtoOAErrorCode_TRADING_BAD_VOLUME, "invalid volume")
+	}
+	return nil
+}
+
+// quote 返回双边都有价格的报价
+func (e *Engine) quote(symbolId int64) (Quote, bool) {
+	q, ok := e.quotes[symbolId]
+	return q, ok && q.Bid > 0 && q.Ask > 0
+}
+
+// closes 以 side 方向的订单作用于 positionId 是否为平仓
+func (e *Engine) closes(positionId int64, side openapi.ProtoOATradeSide) bool {
+	pos, ok := e.positions[positionId]
+	return ok && pos.GetTradeData().GetTradeSide() != side
+}
+
+func isMarket(orderType openapi.ProtoOAOrderType) bool {
+	return orderType == openapi.ProtoOAOrderType_MARKET || orderType == openapi.ProtoOAOrderType_MARKET_RANGE
+}
+
+func opposite(side openapi.ProtoOATradeSide) openapi.ProtoOATradeSide {
+	if side == openapi.ProtoOATradeSide_BUY {
+		return openapi.ProtoOATradeSide_SELL
+	}
+	return openapi.ProtoOATradeSide_BUY
+}
+
+// withinSlippage 成交价是否在 base ± SlippageInPoints 的范围内（只限制不利方向）
+func withinSlippage(symbol *openapi.ProtoOASymbol, order *openapi.ProtoOAOrder, base, price float64) bool {
+	if base <= 0 {
+		return true
+	}
+	slippage := float64(order.GetSlippageInPoints()) * math.Pow10(-int(symbol.GetDigits()))
+	if order.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_BUY {
+		return price <= base+slippage
+	}
+	return price >= base-slippage
+}
+
+func (e *Engine) setStatus(order *openapi.ProtoOAOrder, status openapi.ProtoOAOrderStatus) {
+	order.OrderStatus = &status
+	order.UtcLastUpdateTimestamp = proto.Int64(e.clock().UnixMilli())
+}
+
+func (e *Engine) cancel(order *openapi.ProtoOAOrder) *openapi.ProtoOAExecutionEvent {
+	e.setStatus(order, openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED)
+	return e.event(openapi.ProtoOAExecutionType_ORDER_CANCELLED, order, nil, nil)
+}
+
+// closingOrder 构造平掉 pos 中 volume 部分的订单
+func (e *Engine) closingOrder(pos *openapi.ProtoOAPosition, volume int64, orderType openapi.ProtoOAOrderType) *openapi.ProtoOAOrder {
+	now := e.clock().UnixMilli()
+	status := openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED
+	side := opposite(pos.GetTradeData().GetTradeSide())
+	return &openapi.ProtoOAOrder{
+		OrderId: proto.Int64(e.newId()),
+		TradeData: &openapi.ProtoOATradeData{
+			SymbolId:      proto.Int64(pos.GetTradeData().GetSymbolId()),
+			Volume:        proto.Int64(volume),
+			TradeSide:     &side,
+			OpenTimestamp: proto.Int64(now),
+		},
+		OrderType:              &orderType,
+		OrderStatus:            &status,
+		UtcLastUpdateTimestamp: proto.Int64(now),
+		ClosingOrder:           proto.Bool(true),
+		PositionId:             proto.Int64(pos.GetPositionId()),
+	}
+}
+
+// expireOrders 使到期的 GOOD_TILL_DATE 挂单过期
+func (e *Engine) expireOrders() []*openapi.ProtoOAExecutionEvent {
+	var events []*openapi.ProtoOAExecutionEvent
+	now := e.now.UnixMilli()
+	for _, id := range sortedIds(e.orders) {
+		order := e.orders[id]
+		if order.GetTimeInForce() != openapi.ProtoOATimeInForce_GOOD_TILL_DATE || order.GetExpirationTimestamp() > now {
+			continue
+		}
+		delete(e.orders, id)
+		e.setStatus(order, openapi.ProtoOAOrderStatus_ORDER_STATUS_EXPIRED)
+		events = append(events, e.event(openapi.ProtoOAExecutionType_ORDER_EXPIRED, order, nil, nil))
+	}
+	return events
+}
+
+// matchOrders 撮合触及当前报价的挂单，限价单与止损单均按当前报价成交
+func (e *Engine) matchOrders(symbolId int64) []*openapi.ProtoOAExecutionEvent {
+	q, ok := e.quote(symbolId)
+	if !ok {
+		return nil
+	}
+	var events []*openapi.ProtoOAExecutionEvent
+	for _, id := range sortedIds(e.orders) {
+		order := e.orders[id]
+		if order.GetTradeData().GetSymbolId() != symbolId {
+			continue
+		}
+		side := order.GetTradeData().GetTradeSide()
+		buy := side == openapi.ProtoOATradeSide_BUY
+		price := openPrice(q, side)
+		var triggered bool
+		switch order.GetOrderType() {
+		case openapi.ProtoOAOrderType_LIMIT:
+			triggered = buy && price <= order.GetLimitPrice() || !buy && price >= order.GetLimitPrice()
+		case openapi.ProtoOAOrderType_STOP, openapi.ProtoOAOrderType_STOP_LIMIT:
+			triggered = buy && price >= order.GetStopPrice() || !buy && price <= order.GetStopPrice()
+		}
+		if !triggered {
+			continue
+		}
+		delete(e.orders, id)
+		if order.GetOrderType() == openapi.ProtoOAOrderType_STOP_LIMIT && !withinSlippage(e.symbols[symbolId], order, order.GetStopPrice(), price) {
+			events = append(events, e.cancel(order))
+			continue
+		}
+		if order.GetPositionId() != 0 {
+			if _, ok := e.positions[order.GetPositionId()]; !ok {
+				events = append(events, e.cancel(order))
+				continue
+			}
+		}
+		events = append(events, e.execute(order, price))
+	}
+	return events
+}
+
+// execute 检查保证金后成交订单，保证金不足时拒绝
+func (e *Engine) execute(order *openapi.ProtoOAOrder, price float64) *openapi.ProtoOAExecutionEvent {
+	symbolId := order.GetTradeData().GetSymbolId()
+	if !e.closes(order.GetPositionId(), order.GetTradeData().GetTradeSide()) &&
+		e.margin(symbolId, order.GetTradeData().GetVolume(), price) > e.equity()-e.usedMargin() {
+		e.setStatus(order, openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED)
+		ev := e.event(openapi.ProtoOAExecutionType_ORDER_REJECTED, order, nil, nil)
+		ev.ErrorCode = proto.String(openapi.ProtoOAErrorCode_NOT_ENOUGH_MONEY.String())
+		return ev
+	}
+	return e.fill(order, price)
+}
+
+// checkProtection 触发止损止盈：多头按 bid、空头按 ask 判断，保证止损按止损价成交
+func (e *Engine) checkProtection(symbolId int64) []*openapi.ProtoOAExecutionEvent {
+	q, ok := e.quote(symbolId)
+	if !ok {
+		return nil
+	}
+	var events []*openapi.ProtoOAExecutionEvent
+	for _, id := range sortedIds(e.positions) {
+		pos := e.positions[id]
+		if pos.GetTradeData().GetSymbolId() != symbolId {
+			continue
+		}
+		buy := pos.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_BUY
+		price := closePrice(q, pos.GetTradeData().GetTradeSide())
+		e.trail(pos, price)
+		sl, tp := pos.GetStopLoss(), pos.GetTakeProfit()
+		hitSL := sl > 0 && (buy && price <= sl || !buy && price >= sl)
+		hitTP := tp > 0 && (buy && price >= tp || !buy && price <= tp)
+		if !hitSL && !hitTP {
+			continue
+		}
+		if hitSL && pos.GetGuaranteedStopLoss() {
+			price = sl
+		}
+		order := e.closingOrder(pos, pos.GetTradeData().GetVolume(), openapi.ProtoOAOrderType_STOP_LOSS_TAKE_PROFIT)
+		events = append(events, e.fill(order, price))
+	}
+	return events
+}
+
+// trail 移动止损：价格向有利方向移动时，止损按开仓时的距离跟随
+func (e *Engine) trail(pos *openapi.ProtoOAPosition, price float64) {
+	distance, ok := e.trailing[pos.GetPositionId()]
+	if !pos.GetTrailingStopLoss() || pos.GetStopLoss() <= 0 {
+		delete(e.trailing, pos.GetPositionId())
+		return
+	}
+	if !ok {
+		distance = math.Abs(price - pos.GetStopLoss())
+		e.trailing[pos.GetPositionId()] = distance
+	}
+	if pos.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_BUY {
+		if sl := price - distance; sl > pos.GetStopLoss() {
+			pos.StopLoss = proto.Float64(sl)
+		}
+	} else if sl := price + distance; sl < pos.GetStopLoss() {
+		pos.StopLoss = proto.Float64(sl)
+	}
+}
+
+// fill 以 price 全部成交订单，更新持仓、余额、保证金与成交记录
+func (e *Engine) fill(order *openapi.ProtoOAOrder, price float64) *openapi.ProtoOAExecutionEvent {
+	now := e.clock().UnixMilli()
+	symbolId := order.GetTradeData().GetSymbolId()
+	symbol := e.symbols[symbolId]
+	side := order.GetTradeData().GetTradeSide()
+	volume := order.GetTradeData().GetVolume()
+	e.setStatus(order, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED)
+	order.ExecutionPrice = proto.Float64(price)
+	order.ExecutedVolume = proto.Int64(volume)
+
+	commission := e.commission(symbol, volume, price)
+	dealStatus := openapi.ProtoOADealStatus_FILLED
+	deal := &openapi.ProtoOADeal{
+		DealId:                 proto.Int64(e.newId()),
+		OrderId:                proto.Int64(order.GetOrderId()),
+		Volume:                 proto.Int64(volume),
+		FilledVolume:           proto.Int64(volume),
+		SymbolId:               proto.Int64(symbolId),
+		CreateTimestamp:        proto.Int64(now),
+		ExecutionTimestamp:     proto.Int64(now),
+		UtcLastUpdateTimestamp: proto.Int64(now),
+		ExecutionPrice:         proto.Float64(price),
+		TradeSide:              &side,
+		DealStatus:             &dealStatus,
+		Commission:             proto.Int64(commission),
+		MoneyDigits:            proto.Uint32(e.cfg.MoneyDigits),
+	}
+
+	pos, ok := e.positions[order.GetPositionId()]
+	switch {
+	case ok && pos.GetTradeData().GetTradeSide() != side:
+		// 平仓（可部分平仓），隔夜利息、开仓手续费与保证金按平仓比例结算
+		closed := min(volume, pos.GetTradeData().GetVolume())
+		ratio := float64(closed) / float64(pos.GetTradeData().GetVolume())
+		gross := e.grossProfit(pos, price, closed)
+		swap := int64(math.Round(float64(pos.GetSwap()) * ratio))
+		openCommission := int64(math.Round(float64(pos.GetCommission()) * ratio))
+		margin := uint64(math.Round(float64(pos.GetUsedMargin()) * ratio))
+		e.balance += gross + swap + openCommission + commission
+		deal.ClosePositionDetail = &openapi.ProtoOAClosePositionDetail{
+			EntryPrice:   proto.Float64(pos.GetPrice()),
+			GrossProfit:  proto.Int64(gross),
+			Swap:         proto.Int64(swap),
+			Commission:   proto.Int64(openCommission + commission),
+			Balance:      proto.Int64(e.balance),
+			ClosedVolume: proto.Int64(closed),
+			MoneyDigits:  proto.Uint32(e.cfg.MoneyDigits),
+		}
+		pos.TradeData.Volume = proto.Int64(pos.GetTradeData().GetVolume() - closed)
+		pos.Swap = proto.Int64(pos.GetSwap() - swap)
+		pos.Commission = proto.Int64(pos.GetCommission() - openCommission)
+		pos.UsedMargin = proto.Uint64(pos.GetUsedMargin() - margin)
+		if pos.GetTradeData().GetVolume() == 0 {
+			closedStatus := openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED
+			pos.PositionStatus = &closedStatus
+			pos.TradeData.CloseTimestamp = proto.Uint64(uint64(now))
+			delete(e.positions, pos.GetPositionId())
+			delete(e.nextSwap, pos.GetPositionId())
+			delete(e.trailing, pos.GetPositionId())
+		}
+	case ok:
+		// 加仓，按成交量加权平均开仓价
+		total := pos.GetTradeData().GetVolume() + volume
+		pos.Price = proto.Float64((pos.GetPrice()*float64(pos.GetTradeData().GetVolume()) + price*float64(volume)) / float64(total))
+		pos.TradeData.Volume = proto.Int64(total)
+		pos.Commission = proto.Int64(pos.GetCommission() + commission)
+		pos.UsedMargin = proto.Uint64(pos.GetUsedMargin() + uint64(e.margin(symbolId, volume, price)))
+	default:
+		openStatus := openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN
+		pos = &openapi.ProtoOAPosition{
+			PositionId: proto.Int64(e.newId()),
+			TradeData: &openapi.ProtoOATradeData{
+				SymbolId:           proto.Int64(symbolId),
+				Volume:             proto.Int64(volume),
+				TradeSide:          &side,
+				OpenTimestamp:      proto.Int64(now),
+				Label:              order.GetTradeData().Label,
+				Comment:            order.GetTradeData().Comment,
+				GuaranteedStopLoss: order.GetTradeData().GuaranteedStopLoss,
+			},
+			PositionStatus:     &openStatus,
+			Price:              proto.Float64(price),
+			StopLoss:           protectionPrice(symbol, order.StopLoss, order.RelativeStopLoss, price, side, false),
+			TakeProfit:         protectionPrice(symbol, order.TakeProfit, order.RelativeTakeProfit, price, side, true),
+			Swap:               proto.Int64(0),
+			Commission:         proto.Int64(commission),
+			UsedMargin:         proto.Uint64(uint64(e.margin(symbolId, volume, price))),
+			GuaranteedStopLoss: order.GetTradeData().GuaranteedStopLoss,
+			TrailingStopLoss:   order.TrailingStopLoss,
+			MoneyDigits:        proto.Uint32(e.cfg.MoneyDigits),
+		}
+		e.positions[pos.GetPositionId()] = pos
+		e.nextSwap[pos.GetPositionId()] = nextRollover(symbol, e.clock())
+		order.PositionId = proto.Int64(pos.GetPositionId())
+	}
+	pos.UtcLastUpdateTimestamp = proto.Int64(now)
+	deal.PositionId = proto.Int64(pos.GetPositionId())
+	e.deals = append(e.deals, deal)
+	return e.event(openapi.ProtoOAExecutionType_ORDER_FILLED, order, pos, deal)
+}
+
+// protectionPrice 新持仓的止损（profit 为 false）或止盈价，相对距离以 1/100000 为单位并按品种精度取整
+func protectionPrice(symbol *openapi.ProtoOASymbol, absolute *float64, relative *int64, price float64, side openapi.ProtoOATradeSide, profit bool) *float64 {
+	if absolute != nil {
+		return proto.Float64(*absolute)
+	}
+	if relative == nil || *relative <= 0 {
+		return nil
+	}
+	distance := float64(*relative) / 100000
+	if (side == openapi.ProtoOATradeSide_BUY) != profit {
+		distance = -distance
+	}
+	if symbol.GetDigits() <= 0 {
+		return proto.Float64(price + distance)
+	}
+	scale := math.Pow10(int(symbol.GetDigits()))
+	return proto.Float64(math.Round((price+distance)*scale) / scale)
+}
@@ -0,0 +1,392 @@
+package paper
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// fetchPrefix 自动获取品种信息的请求使用的 clientMsgId 前缀，其响应不会转发给客户端
+const fetchPrefix = "paper-"
+
+// Transport 包装真实传输层，把模拟账户的下单类请求交给 Engine 在本地执行
+//
+// 本地处理的请求：NewOrder、AmendOrder、CancelOrder、AmendPositionSLTP、ClosePosition、Reconcile、DealList，
+// Trader 转发后以模拟账户的余额等改写响应，其余请求（认证、行情订阅等）原样转发。收到的 ProtoOASpotEvent 会驱动 Engine 撮合，因此需要订阅所交易品种的报价；
+// 下单时品种信息未知则先通过被包装的传输层获取 ProtoOASymbol
+type Transport struct {
+	ctrago.TransportWrapper
+	engine *Engine
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	handlers []ctrago.MessageHandler
+	// queue 本地产生的待投递消息
+	queue [][]byte
+	// delivering 正在回调本地消息
+	delivering bool
+	closed     bool
+	// pending 等待品种信息的下单请求
+	pending map[int64][]*openapi.ProtoMessage
+	// fetching 自动获取品种信息的请求 clientMsgId 与对应品种
+	fetching  map[string][]int64
+	nextFetch int
+	// traders 转发给被包装传输层的 ProtoOATraderReq 的 clientMsgId，响应按模拟账户改写
+	traders map[string]bool
+}
+
+// NewTransport 创建模拟成交传输层，engine 只处理 Config.AccountId 对应账户的请求
+func NewTransport(transport ctrago.Transport, engine *Engine) *Transport {
+	t := &Transport{
+		TransportWrapper: ctrago.TransportWrapper{Transport: transport},
+		engine:           engine,
+		pending:          make(map[int64][]*openapi.ProtoMessage),
+		fetching:         make(map[string][]int64),
+		traders:          make(map[string]bool),
+	}
+	t.cond = sync.NewCond(&t.lock)
+	transport.OnMessage(t.receive)
+	go t.loop()
+	return t
+}
+
+// Wrap 返回用于 ctrago.WithTransportWrapper 的包装函数
+func Wrap(engine *Engine) func(ctrago.Transport) ctrago.Transport {
+	return func(transport ctrago.Transport) ctrago.Transport {
+		return NewTransport(transport, engine)
+	}
+}
+
+// Engine 返回使用的模拟成交引擎
+func (t *Transport) Engine() *Engine {
+	return t.engine
+}
+
+func (t *Transport) Send(messageType int, data []byte) error {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(data, msg); err == nil && t.handle(msg) {
+		return nil
+	}
+	err := t.Transport.Send(messageType, data)
+	if err != nil {
+		t.lock.Lock()
+		delete(t.traders, msg.GetClientMsgId())
+		t.lock.Unlock()
+	}
+	return err
+}
+
+func (t *Transport) OnMessage(handler ctrago.MessageHandler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+// Close 关闭被包装的传输层，未投递的本地消息被丢弃
+func (t *Transport) Close() error {
+	t.lock.Lock()
+	t.closed = true
+	t.cond.Broadcast()
+	t.lock.Unlock()
+	return t.Transport.Close()
+}
+
+// handle 在本地处理请求，返回 false 表示需要转发
+func (t *Transport) handle(msg *openapi.ProtoMessage) bool {
+	clientMsgId := msg.GetClientMsgId()
+	accountId := t.engine.AccountId()
+	switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+	case openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ:
+		req := &openapi.ProtoOANewOrderReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		if !t.engine.HasSymbol(req.GetSymbolId()) {
+			t.waitSymbol(req.GetSymbolId(), msg)
+			return true
+		}
+		events, err := t.engine.NewOrder(req)
+		t.respond(clientMsgId, events, err)
+	case openapi.ProtoOAPayloadType_PROTO_OA_AMEND_ORDER_REQ:
+		req := &openapi.ProtoOAAmendOrderReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		events, err := t.engine.AmendOrder(req)
+		t.respond(clientMsgId, events, err)
+	case openapi.ProtoOAPayloadType_PROTO_OA_CANCEL_ORDER_REQ:
+		req := &openapi.ProtoOACancelOrderReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		events, err := t.engine.CancelOrder(req.GetOrderId())
+		t.respond(clientMsgId, events, err)
+	case openapi.ProtoOAPayloadType_PROTO_OA_AMEND_POSITION_SLTP_REQ:
+		req := &openapi.ProtoOAAmendPositionSLTPReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		events, err := t.engine.AmendPositionSLTP(req)
+		t.respond(clientMsgId, events, err)
+	case openapi.ProtoOAPayloadType_PROTO_OA_CLOSE_POSITION_REQ:
+		req := &openapi.ProtoOAClosePositionReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		events, err := t.engine.ClosePosition(req.GetPositionId(), req.GetVolume())
+		t.respond(clientMsgId, events, err)
+	case openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_REQ:
+		req := &openapi.ProtoOAReconcileReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		t.push(clientMsgId, openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_RES, t.engine.Reconcile())
+	case openapi.ProtoOAPayloadType_PROTO_OA_TRADER_REQ:
+		req := &openapi.ProtoOATraderReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		// 经纪商名称等取自真实账户（行情缓存以经纪商区分），响应在 receive 中改写
+		t.lock.Lock()
+		t.traders[clientMsgId] = true
+		t.lock.Unlock()
+		return false
+	case openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_REQ:
+		req := &openapi.ProtoOADealListReq{}
+		if proto.Unmarshal(msg.Payload, req) != nil || req.GetCtidTraderAccountId() != accountId {
+			return false
+		}
+		deals, hasMore := t.engine.Deals(req.GetFromTimestamp(), req.GetToTimestamp(), int(req.GetMaxRows()))
+		t.push(clientMsgId, openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_RES, &openapi.ProtoOADealListRes{
+			CtidTraderAccountId: proto.Int64(accountId),
+			Deal:                deals,
+			HasMore:             proto.Bool(hasMore),
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// waitSymbol 暂存下单请求，并在该品种尚未请求时通过被包装的传输层获取品种信息
+func (t *Transport) waitSymbol(symbolId int64, msg *openapi.ProtoMessage) {
+	t.lock.Lock()
+	first := len(t.pending[symbolId]) == 0
+	t.pending[symbolId] = append(t.pending[symbolId], msg)
+	var clientMsgId string
+	if first {
+		t.nextFetch++
+		clientMsgId = fmt.Sprintf("%s%d", fetchPrefix, t.nextFetch)
+		t.fetching[clientMsgId] = []int64{symbolId}
+	}
+	t.lock.Unlock()
+	if !first {
+		return
+	}
+	payload, _ := proto.Marshal(&openapi.ProtoOASymbolByIdReq{
+		CtidTraderAccountId: proto.Int64(t.engine.AccountId()),
+		SymbolId:            []int64{symbolId},
+	})
+	data, _ := proto.Marshal(&openapi.ProtoMessage{
+		PayloadType: proto.Uint32(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_REQ)),
+		Payload:     payload,
+		ClientMsgId: proto.String(clientMsgId),
+	})
+	if err := t.Transport.Send(websocket.BinaryMessage, data); err != nil {
+		t.symbolFetched(clientMsgId, t.engine.orderError(openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND, err.Error()))
+	}
+}
+
+// symbolFetched 品种信息获取完成（或失败）后处理暂存的下单请求
+func (t *Transport) symbolFetched(clientMsgId string, err error) {
+	t.lock.Lock()
+	var waiting []*openapi.ProtoMessage
+	for _, symbolId := range t.fetching[clientMsgId] {
+		waiting = append(waiting, t.pending[symbolId]...)
+		delete(t.pending, symbolId)
+	}
+	delete(t.fetching, clientMsgId)
+	t.lock.Unlock()
+	for _, msg := range waiting {
+		if err != nil {
+			t.respond(msg.GetClientMsgId(), nil, err)
+			continue
+		}
+		req := &openapi.ProtoOANewOrderReq{}
+		proto.Unmarshal(msg.Payload, req)
+		events, err := t.engine.NewOrder(req)
+		t.respond(msg.GetClientMsgId(), events, err)
+	}
+}
+
+// receive 处理被包装传输层收到的消息：记录品种信息、以报价驱动撮合，并转发给客户端
+func (t *Transport) receive(messageType int, data []byte) {
+	msg := &openapi.ProtoMessage{}
+	if proto.Unmarshal(data, msg) != nil {
+		t.forward(messageType, data)
+		return
+	}
+	t.lock.Lock()
+	_, fetched := t.fetching[msg.GetClientMsgId()]
+	trader := t.traders[msg.GetClientMsgId()]
+	delete(t.traders, msg.GetClientMsgId())
+	t.lock.Unlock()
+	if trader {
+		t.traderReceived(msg)
+		return
+	}
+
+	switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+	case openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_BY_ID_RES:
+		res := &openapi.ProtoOASymbolByIdRes{}
+		if proto.Unmarshal(msg.Payload, res) == nil {
+			for _, symbol := range res.Symbol {
+				t.engine.SetSymbol(symbol)
+			}
+		}
+		if fetched {
+			t.symbolFetched(msg.GetClientMsgId(), nil)
+			return
+		}
+	case openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT:
+		// 先更新报价再转发，客户端响应该报价的下单按该报价成交
+		ev := &openapi.ProtoOASpotEvent{}
+		if proto.Unmarshal(msg.Payload, ev) != nil || ev.GetCtidTraderAccountId() != t.engine.AccountId() {
+			break
+		}
+		var ts time.Time
+		if ev.Timestamp != nil {
+			ts = time.UnixMilli(ev.GetTimestamp())
+		}
+		events := t.engine.OnQuote(ev.GetSymbolId(), float64(ev.GetBid())/ctrago.PriceScale, float64(ev.GetAsk())/ctrago.PriceScale, ts)
+		t.forward(messageType, data)
+		for _, ev := range events {
+			t.push("", openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT, ev)
+		}
+		return
+	}
+	if fetched {
+		// 获取品种信息失败，通常为 ProtoOAErrorRes
+		description := "symbol not available"
+		res := &openapi.ProtoOAErrorRes{}
+		if msg.GetPayloadType() == uint32(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES) && proto.Unmarshal(msg.Payload, res) == nil {
+			description = res.GetErrorCode() + ": " + res.GetDescription()
+		}
+		t.symbolFetched(msg.GetClientMsgId(), t.engine.orderError(openapi.ProtoOAErrorCode_SYMBOL_NOT_FOUND, description))
+		return
+	}
+	t.forward(messageType, data)
+}
+
+// traderReceived 以模拟账户改写 ProtoOATraderRes；被包装的传输层无法提供账户信息（如回测）时只返回模拟账户
+func (t *Transport) traderReceived(msg *openapi.ProtoMessage) {
+	var real *openapi.ProtoOATrader
+	res := &openapi.ProtoOATraderRes{}
+	if msg.GetPayloadType() == uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_RES) && proto.Unmarshal(msg.Payload, res) == nil {
+		real = res.GetTrader()
+	}
+	t.push(msg.GetClientMsgId(), openapi.ProtoOAPayloadType_PROTO_OA_TRADER_RES, &openapi.ProtoOATraderRes{
+		CtidTraderAccountId: proto.Int64(t.engine.AccountId()),
+		Trader:              t.engine.Trader(real),
+	})
+}
+
+func (t *Transport) forward(messageType int, data []byte) {
+	t.lock.Lock()
+	handlers := t.handlers
+	t.lock.Unlock()
+	for _, h := range handlers {
+		h(messageType, data)
+	}
+}
+
+// respond 投递 Engine 的处理结果：第一个事件作为请求的响应，其余作为推送；错误以 ProtoOAOrderErrorEvent 响应
+func (t *Transport) respond(clientMsgId string, events []*openapi.ProtoOAExecutionEvent, err error) {
+	if err != nil {
+		var apiErr *ctrago.APIError
+		if !errors.As(err, &apiErr) {
+			apiErr = t.engine.orderError(openapi.ProtoOAErrorCode_INCORRECT_BOUNDARIES, err.Error())
+		}
+		t.push(clientMsgId, openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT, &openapi.ProtoOAOrderErrorEvent{
+			CtidTraderAccountId: proto.Int64(t.engine.AccountId()),
+			ErrorCode:           proto.String(apiErr.ErrorCode),
+			Description:         proto.String(apiErr.Description),
+		})
+		return
+	}
+	for i, ev := range events {
+		if i > 0 {
+			clientMsgId = ""
+		}
+		t.push(clientMsgId, openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT, ev)
+	}
+}
+
+// push 把本地产生的消息加入投递队列，clientMsgId 为空表示推送
+func (t *Transport) push(clientMsgId string, payloadType openapi.ProtoOAPayloadType, payload proto.Message) {
+	raw, err := proto.Marshal(payload)
+	if err != nil {
+		return
+	}
+	msg := &openapi.ProtoMessage{
+		PayloadType: proto.Uint32(uint32(payloadType)),
+		Payload:     raw,
+	}
+	if clientMsgId != "" {
+		msg.ClientMsgId = proto.String(clientMsgId)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.queue = append(t.queue, data)
+	t.cond.Broadcast()
+}
+
+// loop 按顺序投递本地消息，避免在 Send 调用中回调客户端
+func (t *Transport) loop() {
+	for {
+		t.lock.Lock()
+		for len(t.queue) == 0 && !t.closed {
+			t.cond.Wait()
+		}
+		if t.closed {
+			t.lock.Unlock()
+			return
+		}
+		data := t.queue[0]
+		t.queue = t.queue[1:]
+		t.delivering = true
+		handlers := t.handlers
+		t.lock.Unlock()
+		for _, h := range handlers {
+			h(websocket.BinaryMessage, data)
+		}
+		t.lock.Lock()
+		t.delivering = false
+		t.cond.Broadcast()
+		t.lock.Unlock()
+	}
+}
+
+// Wait 阻塞直到本地产生的消息全部投递且回调返回，用于回测等需要按报价逐步推进的场景
+//
+// 不能在消息回调中调用
+func (t *Transport) Wait() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for (len(t.queue) > 0 || t.delivering) && !t.closed {
+		t.cond.Wait()
+	}
+}
+
+var _ ctrago.Transport = (*Transport)(nil)
+var _ ctrago.TransportNotifier = (*Transport)(nil)
@@ -0,0 +1,111 @@
+package paper_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+	"github.com/yockii/ctrago/paper"
+	"google.golang.org/protobuf/proto"
+)
+
+func recv[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	var zero T
+	return zero
+}
+
+func TestTransport_ExecutesLocally(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	engine := paper.NewEngine(paper.Config{AccountId: ctragotest.DefaultAccountId, Balance: 5000_00, DepositAssetId: ctragotest.DefaultDepositAssetId})
+	client := ctrago.NewClientWithTransport(paper.NewTransport(srv.NewTransport(), engine), ctragotest.DefaultClientId, ctragotest.DefaultClientSecret, ctragotest.DefaultAccessToken)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := client.Account(ctragotest.DefaultAccountId)
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	spots := make(chan *openapi.ProtoOASpotEvent, 10)
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOASpotEvent{}
+		proto.Unmarshal(msg.Payload, ev)
+		spots <- ev
+	})
+	fills := make(chan *openapi.ProtoOAExecutionEvent, 10)
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT), func(msg *openapi.ProtoMessage) {
+		ev := &openapi.ProtoOAExecutionEvent{}
+		proto.Unmarshal(msg.Payload, ev)
+		if ev.GetExecutionType() == openapi.ProtoOAExecutionType_ORDER_FILLED {
+			fills <- ev
+		}
+	})
+	if _, err := client.SendRequest(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ), &openapi.ProtoOASubscribeSpotsReq{
+		CtidTraderAccountId: proto.Int64(ctragotest.DefaultAccountId),
+		SymbolId:            []int64{ctragotest.DefaultSymbolId},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	recv(t, spots)
+
+	// 品种信息由 Transport 自动获取
+	res, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetExecutionType() != openapi.ProtoOAExecutionType_ORDER_ACCEPTED {
+		t.Errorf("expected ORDER_ACCEPTED, got %v", res.GetExecutionType())
+	}
+	fill := recv(t, fills)
+	if fill.GetDeal().GetExecutionPrice() != 1.1002 {
+		t.Fatalf("unexpected fill: %v", fill)
+	}
+	if _, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 1, nil); !ctrago.IsErrorCode(err, openapi.ProtoOAErrorCode_TRADING_BAD_VOLUME) {
+		t.Errorf("expected TRADING_BAD_VOLUME, got %v", err)
+	}
+
+	// 报价推动本地止盈
+	if _, err := account.Order().AmendOrderPositionSltp(ctx, fill.GetPosition().GetPositionId(), (&ctrago.AmendPositionSLTPOption{}).WithTakeProfit(1.1010)); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1010, 1.1012)
+	if closed := recv(t, fills); closed.GetDeal().GetClosePositionDetail().GetGrossProfit() != 80 {
+		t.Errorf("unexpected take profit fill: %v", closed)
+	}
+	trader, err := account.Trader().Trader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trader.GetTrader().GetBalance() != 5000_00+80 || engine.Balance() != 5000_00+80 {
+		t.Errorf("unexpected paper balance %d", trader.GetTrader().GetBalance())
+	}
+	// 经纪商名称取自真实账户
+	if trader.GetTrader().GetBrokerName() != "ctragotest" || trader.GetTrader().GetDepositAssetId() != ctragotest.DefaultDepositAssetId {
+		t.Errorf("unexpected paper trader %v", trader.GetTrader())
+	}
+
+	if srv.Balance(ctragotest.DefaultAccountId) != ctragotest.DefaultBalance {
+		t.Errorf("server balance changed")
+	}
+	for _, req := range srv.Requests() {
+		switch openapi.ProtoOAPayloadType(req.PayloadType) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ, openapi.ProtoOAPayloadType_PROTO_OA_AMEND_POSITION_SLTP_REQ:
+			t.Errorf("request %v reached the server", req.PayloadType)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ctrago
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// PayloadTypeName 返回 payloadType 的名称，如 PROTO_OA_NEW_ORDER_REQ；未知类型返回数字
+func PayloadTypeName(payloadType uint32) string {
+	if name, ok := openapi.ProtoOAPayloadType_name[int32(payloadType)]; ok {
+		return name
+	}
+	if name, ok := openapi.ProtoPayloadType_name[int32(payloadType)]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(payloadType), 10)
+}
+
+var (
+	payloadTypesOnce sync.Once
+	payloadTypes     map[uint32]protoreflect.MessageType
+)
+
+// NewPayload 返回 payloadType 对应的空消息，如 PROTO_OA_SPOT_EVENT 返回 *openapi.ProtoOASpotEvent；未知类型返回 nil
+//
+// 对应关系取自 openapi 消息中 payloadType 字段的默认值
+func NewPayload(payloadType uint32) proto.Message {
+	payloadTypesOnce.Do(func() {
+		payloadTypes = make(map[uint32]protoreflect.MessageType)
+		protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+			fd := mt.Descriptor().Fields().ByName("payloadType")
+			if fd != nil && fd.Kind() == protoreflect.EnumKind && fd.HasDefault() {
+				switch fd.Enum().FullName() {
+				case "ProtoOAPayloadType", "ProtoPayloadType":
+					payloadTypes[uint32(fd.Default().Enum())] = mt
+				}
+			}
+			return true
+		})
+	})
+	mt, ok := payloadTypes[payloadType]
+	if !ok {
+		return nil
+	}
+	return mt.New().Interface()
+}
+
+// EventAccountId 返回消息中的 ctidTraderAccountId，消息不含该字段时返回 false
+func EventAccountId(msg *openapi.ProtoMessage) (int64, bool) {
+	payload := NewPayload(msg.GetPayloadType())
+	if payload == nil {
+		return 0, false
+	}
+	if err := proto.Unmarshal(msg.Payload, payload); err != nil {
+		return 0, false
+	}
+	return accountIdOf(payload)
+}
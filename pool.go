@@ -0,0 +1,513 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// ClientPool 多连接池，把账户与报价订阅分散到多个连接上
+//
+// 每个账户有一个主连接，Account 返回的对象的请求都发往主连接；报价与深度订阅按品种分配到负载最低的连接，
+// 需要时在该连接上补充账户鉴权。连接断开时，其上的账户与订阅迁移到其他连接；没有可用连接时保留等待，
+// 连接重连并重新完成应用鉴权后再恢复账户鉴权与订阅，并承接新的负载
+type ClientPool struct {
+	members []*poolMember
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	lock sync.Mutex
+	// homes 账户的主连接
+	homes map[int64]*poolMember
+	// authorized 通过 Account.Auth 鉴权过的账户，迁移主连接时重新鉴权
+	authorized map[int64]struct{}
+	subs       map[poolSub]*poolMember
+	// pendingAccounts、pendingSubs 主连接或订阅所在连接断开后尚未迁移成功的账户与订阅，有可用连接时恢复
+	pendingAccounts map[int64]struct{}
+	pendingSubs     map[poolSub]struct{}
+	// halts 熔断状态保存在池上，主连接迁移后依然有效
+	halts haltRegistry
+	// spots Account.AcquireSpots 的订阅引用，订阅随连接迁移
+	spots spotRegistry
+}
+
+type poolMember struct {
+	index  int
+	client *Client
+	// accounts 已在该连接上鉴权的账户，由 ClientPool.lock 保护
+	accounts map[int64]struct{}
+}
+
+type poolSub struct {
+	depth     bool
+	accountId int64
+	symbolId  int64
+}
+
+// PoolConnStats 单个连接的负载
+type PoolConnStats struct {
+	State ConnState
+	// Accounts 以该连接为主连接的账户数
+	Accounts int
+	// Spots、Depth 该连接上的报价与深度订阅数
+	Spots int
+	Depth int
+}
+
+// NewClientPool 以相同的配置项创建 size 个连接；配置项中不能包含 WithTransport
+func NewClientPool(size int, opts ...Option) (*ClientPool, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("pool size must be positive, got %d", size)
+	}
+	clients := make([]*Client, 0, size)
+	for i := 0; i < size; i++ {
+		client, err := New(opts...)
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return NewClientPoolFromClients(clients...), nil
+}
+
+// NewClientPoolFromClients 以已创建的 Client 组成连接池
+func NewClientPoolFromClients(clients ...*Client) *ClientPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &ClientPool{
+		ctx:        ctx,
+		cancel:     cancel,
+		homes:      make(map[int64]*poolMember),
+		authorized: make(map[int64]struct{}),
+		subs:       make(map[poolSub]*poolMember),
+
+		pendingAccounts: make(map[int64]struct{}),
+		pendingSubs:     make(map[poolSub]struct{}),
+	}
+	for i, client := range clients {
+		m := &poolMember{index: i, client: client, accounts: make(map[int64]struct{})}
+		p.members = append(p.members, m)
+		client.OnStateChange(func(from, to ConnState) {
+			p.handleStateChange(m, from, to)
+		})
+	}
+	return p
+}
+
+// Clients 返回池中的连接
+func (p *ClientPool) Clients() []*Client {
+	clients := make([]*Client, len(p.members))
+	for i, m := range p.members {
+		clients[i] = m.client
+	}
+	return clients
+}
+
+// ApplicationAuth 在所有连接上完成应用鉴权
+func (p *ClientPool) ApplicationAuth(ctx context.Context) error {
+	var errs []error
+	for _, m := range p.members {
+		if _, err := m.client.ApplicationAuth(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("connection %d: %w", m.index, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Account 返回账户操作对象，请求始终发往账户当前的主连接，连接故障转移后无需重新获取
+func (p *ClientPool) Account(accountId int64) *Account {
+	return &Account{accountId: accountId, pool: p}
+}
+
+// OnEvent 在所有连接上注册事件回调
+//
+// 账户在多个连接上鉴权时服务端会在每个连接上推送成交等账户事件，这类事件只转发来自主连接的一份；
+// 报价与深度事件只会来自订阅所在的连接，全部转发
+func (p *ClientPool) OnEvent(payloadType uint32, handler ResponseHandler) {
+	for _, m := range p.members {
+		m.client.OnEvent(payloadType, p.homeFilter(m, handler))
+	}
+}
+
+// watchEvent 在所有连接上注册可注销的事件回调，过滤规则同 OnEvent
+func (p *ClientPool) watchEvent(payloadType uint32, handler ResponseHandler) (stop func()) {
+	stops := make([]func(), 0, len(p.members))
+	for _, m := range p.members {
+		stops = append(stops, m.client.WatchEvent(payloadType, p.homeFilter(m, handler)))
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+func (p *ClientPool) homeFilter(m *poolMember, handler ResponseHandler) ResponseHandler {
+	return func(msg *openapi.ProtoMessage) {
+		if p.fromHome(m, msg) {
+			handler(msg)
+		}
+	}
+}
+
+// SubscribeSpots 订阅报价，各品种分配到负载最低的连接
+func (p *ClientPool) SubscribeSpots(ctx context.Context, accountId int64, symbolIds ...int64) error {
+	return p.subscribe(ctx, false, accountId, symbolIds)
+}
+
+// UnsubscribeSpots 取消报价订阅
+func (p *ClientPool) UnsubscribeSpots(ctx context.Context, accountId int64, symbolIds ...int64) error {
+	return p.unsubscribe(ctx, false, accountId, symbolIds)
+}
+
+// SubscribeDepthQuotes 订阅深度报价，各品种分配到负载最低的连接
+func (p *ClientPool) SubscribeDepthQuotes(ctx context.Context, accountId int64, symbolIds ...int64) error {
+	return p.subscribe(ctx, true, accountId, symbolIds)
+}
+
+// UnsubscribeDepthQuotes 取消深度报价订阅
+func (p *ClientPool) UnsubscribeDepthQuotes(ctx context.Context, accountId int64, symbolIds ...int64) error {
+	return p.unsubscribe(ctx, true, accountId, symbolIds)
+}
+
+// Stats 返回各连接的状态与负载
+func (p *ClientPool) Stats() []PoolConnStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	stats := make([]PoolConnStats, len(p.members))
+	for i, m := range p.members {
+		stats[i].State = m.client.State()
+	}
+	for _, m := range p.homes {
+		stats[m.index].Accounts++
+	}
+	for sub, m := range p.subs {
+		if sub.depth {
+			stats[m.index].Depth++
+		} else {
+			stats[m.index].Spots++
+		}
+	}
+	return stats
+}
+
+// Close 关闭所有连接
+func (p *ClientPool) Close() error {
+	p.cancel()
+	var errs []error
+	for _, m := range p.members {
+		if err := m.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// home 返回账户的主连接，尚未分配时分配到负载最低的连接
+func (p *ClientPool) home(accountId int64) *Client {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.homeLocked(accountId).client
+}
+
+func (p *ClientPool) homeLocked(accountId int64) *poolMember {
+	m, ok := p.homes[accountId]
+	if !ok {
+		m = p.pickLocked()
+		p.homes[accountId] = m
+	}
+	return m
+}
+
+// pickLocked 选择负载最低的可用连接，没有可用连接时在全部连接中选择
+func (p *ClientPool) pickLocked() *poolMember {
+	load := make([]int, len(p.members))
+	for _, m := range p.homes {
+		load[m.index]++
+	}
+	for _, m := range p.subs {
+		load[m.index]++
+	}
+	var best *poolMember
+	bestHealthy := false
+	for _, m := range p.members {
+		healthy := m.client.State() == StateAppAuthorized
+		if best == nil || (healthy && !bestHealthy) || (healthy == bestHealthy && load[m.index] < load[best.index]) {
+			best, bestHealthy = m, healthy
+		}
+	}
+	return best
+}
+
+func (p *ClientPool) member(client *Client) *poolMember {
+	for _, m := range p.members {
+		if m.client == client {
+			return m
+		}
+	}
+	return nil
+}
+
+func (p *ClientPool) recordAuth(client *Client, accountId int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if m := p.member(client); m != nil {
+		m.accounts[accountId] = struct{}{}
+	}
+	p.authorized[accountId] = struct{}{}
+}
+
+func (p *ClientPool) recordLogout(client *Client, accountId int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if m := p.member(client); m != nil {
+		delete(m.accounts, accountId)
+	}
+	delete(p.authorized, accountId)
+	delete(p.pendingAccounts, accountId)
+}
+
+// ensureAuth 确保账户已在连接上鉴权
+func (p *ClientPool) ensureAuth(ctx context.Context, m *poolMember, accountId int64) error {
+	p.lock.Lock()
+	_, ok := m.accounts[accountId]
+	p.lock.Unlock()
+	if ok {
+		return nil
+	}
+	if _, err := m.client.Account(accountId).Auth(ctx); err != nil {
+		return err
+	}
+	p.lock.Lock()
+	m.accounts[accountId] = struct{}{}
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *ClientPool) subscribe(ctx context.Context, depth bool, accountId int64, symbolIds []int64) error {
+	if len(symbolIds) == 0 {
+		return ErrSymbolIdRequired
+	}
+	return p.assign(ctx, depth, accountId, symbolIds, false)
+}
+
+// assign 把订阅分配到负载最低的连接并发送订阅请求；requeue 时失败的订阅留待恢复，否则丢弃
+func (p *ClientPool) assign(ctx context.Context, depth bool, accountId int64, symbolIds []int64, requeue bool) error {
+	// 先在锁内占位，同一批品种按占位后的负载依次分配
+	groups := make(map[*poolMember][]int64)
+	p.lock.Lock()
+	for _, symbolId := range symbolIds {
+		key := poolSub{depth: depth, accountId: accountId, symbolId: symbolId}
+		delete(p.pendingSubs, key)
+		if _, ok := p.subs[key]; ok {
+			continue
+		}
+		m := p.pickLocked()
+		p.subs[key] = m
+		groups[m] = append(groups[m], symbolId)
+	}
+	p.lock.Unlock()
+
+	var errs []error
+	for m, ids := range groups {
+		err := p.ensureAuth(ctx, m, accountId)
+		if err == nil {
+			payloadType, req := subscribeRequest(depth, true, accountId, ids)
+			_, err = m.client.request(ctx, payloadType, req)
+		}
+		if err != nil {
+			p.lock.Lock()
+			for _, symbolId := range ids {
+				key := poolSub{depth: depth, accountId: accountId, symbolId: symbolId}
+				if p.subs[key] == m {
+					delete(p.subs, key)
+					if requeue {
+						p.pendingSubs[key] = struct{}{}
+					}
+				}
+			}
+			p.lock.Unlock()
+			errs = append(errs, fmt.Errorf("connection %d: %w", m.index, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// hasSub 订阅是否已由池持有（包括等待恢复的订阅）
+func (p *ClientPool) hasSub(key poolSub) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, ok := p.subs[key]
+	_, pending := p.pendingSubs[key]
+	return ok || pending
+}
+
+func (p *ClientPool) unsubscribe(ctx context.Context, depth bool, accountId int64, symbolIds []int64) error {
+	groups := make(map[*poolMember][]int64)
+	p.lock.Lock()
+	for _, symbolId := range symbolIds {
+		key := poolSub{depth: depth, accountId: accountId, symbolId: symbolId}
+		delete(p.pendingSubs, key)
+		if m, ok := p.subs[key]; ok {
+			groups[m] = append(groups[m], symbolId)
+			delete(p.subs, key)
+		}
+	}
+	p.lock.Unlock()
+
+	var errs []error
+	for m, ids := range groups {
+		payloadType, req := subscribeRequest(depth, false, accountId, ids)
+		if _, err := m.client.request(ctx, payloadType, req); err != nil {
+			errs = append(errs, fmt.Errorf("connection %d: %w", m.index, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func subscribeRequest(depth, subscribe bool, accountId int64, symbolIds []int64) (uint32, proto.Message) {
+	switch {
+	case !depth && subscribe:
+		return uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ), &openapi.ProtoOASubscribeSpotsReq{CtidTraderAccountId: proto.Int64(accountId), SymbolId: symbolIds}
+	case !depth:
+		return uint32(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_REQ), &openapi.ProtoOAUnsubscribeSpotsReq{CtidTraderAccountId: proto.Int64(accountId), SymbolId: symbolIds}
+	case subscribe:
+		return uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_DEPTH_QUOTES_REQ), &openapi.ProtoOASubscribeDepthQuotesReq{CtidTraderAccountId: proto.Int64(accountId), SymbolId: symbolIds}
+	default:
+		return uint32(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_DEPTH_QUOTES_REQ), &openapi.ProtoOAUnsubscribeDepthQuotesReq{CtidTraderAccountId: proto.Int64(accountId), SymbolId: symbolIds}
+	}
+}
+
+// fromHome 账户事件只接受来自主连接的一份
+func (p *ClientPool) fromHome(m *poolMember, msg *openapi.ProtoMessage) bool {
+	switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+	case openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, openapi.ProtoOAPayloadType_PROTO_OA_DEPTH_EVENT:
+		return true
+	}
+	accountId, ok := EventAccountId(msg)
+	if !ok {
+		return true
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	home, ok := p.homes[accountId]
+	return !ok || home == m
+}
+
+func (p *ClientPool) handleStateChange(m *poolMember, from, to ConnState) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	switch {
+	case to == StateReconnecting || to == StateClosed:
+		// 回调在传输层的 goroutine 中执行，迁移需要等待其他连接的响应
+		go p.failover(m)
+	case to == StateConnected && from == StateReconnecting:
+		go p.rejoin(m)
+	}
+}
+
+// failover 把断开连接上的账户与订阅转入待恢复，并尝试迁移到其他连接
+func (p *ClientPool) failover(m *poolMember) {
+	p.lock.Lock()
+	m.accounts = make(map[int64]struct{})
+	var accounts, subs int
+	for accountId, home := range p.homes {
+		if home == m {
+			delete(p.homes, accountId)
+			if _, ok := p.authorized[accountId]; ok {
+				p.pendingAccounts[accountId] = struct{}{}
+				accounts++
+			}
+		}
+	}
+	for sub, member := range p.subs {
+		if member == m {
+			delete(p.subs, sub)
+			p.pendingSubs[sub] = struct{}{}
+			subs++
+		}
+	}
+	p.lock.Unlock()
+	logger := m.client.log().With("connection", m.index)
+	if accounts > 0 || subs > 0 {
+		logger.Warn("ctrago pool moving accounts and subscriptions off a lost connection", "accounts", accounts, "subscriptions", subs)
+	}
+	p.restore(logger)
+}
+
+// restore 在可用连接上重新鉴权待恢复的账户并重新订阅，没有可用连接时继续等待；失败的项留待下次恢复
+func (p *ClientPool) restore(logger *slog.Logger) {
+	p.lock.Lock()
+	healthy := false
+	for _, m := range p.members {
+		healthy = healthy || m.client.State() == StateAppAuthorized
+	}
+	if !healthy || (len(p.pendingAccounts) == 0 && len(p.pendingSubs) == 0) {
+		p.lock.Unlock()
+		return
+	}
+	accounts := make([]int64, 0, len(p.pendingAccounts))
+	for accountId := range p.pendingAccounts {
+		accounts = append(accounts, accountId)
+	}
+	p.pendingAccounts = make(map[int64]struct{})
+	type group struct {
+		depth     bool
+		accountId int64
+	}
+	groups := make(map[group][]int64)
+	for sub := range p.pendingSubs {
+		g := group{depth: sub.depth, accountId: sub.accountId}
+		groups[g] = append(groups[g], sub.symbolId)
+	}
+	p.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(p.ctx, time.Minute)
+	defer cancel()
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+	for _, accountId := range accounts {
+		p.lock.Lock()
+		home := p.homeLocked(accountId)
+		p.lock.Unlock()
+		if err := p.ensureAuth(ctx, home, accountId); err != nil {
+			logger.Warn("ctrago pool failed to re-authorize account", "accountId", accountId, "target", home.index, "error", err)
+			p.lock.Lock()
+			if p.homes[accountId] == home {
+				delete(p.homes, accountId)
+			}
+			if _, ok := p.authorized[accountId]; ok {
+				p.pendingAccounts[accountId] = struct{}{}
+			}
+			p.lock.Unlock()
+		}
+	}
+	for g, symbolIds := range groups {
+		sort.Slice(symbolIds, func(i, j int) bool { return symbolIds[i] < symbolIds[j] })
+		if err := p.assign(ctx, g.depth, g.accountId, symbolIds, true); err != nil {
+			logger.Warn("ctrago pool failed to move subscriptions", "accountId", g.accountId, "depth", g.depth, "error", err)
+		}
+	}
+}
+
+// rejoin 重连后重新完成应用鉴权，之后该连接重新参与分配，并恢复等待中的账户与订阅
+func (p *ClientPool) rejoin(m *poolMember) {
+	ctx, cancel := context.WithTimeout(p.ctx, time.Minute)
+	defer cancel()
+	_, err := m.client.ApplicationAuth(ctx)
+	var apiErr *APIError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode == openapi.ProtoOAErrorCode_CH_CLIENT_ALREADY_AUTHENTICATED.String()) {
+		m.client.log().Warn("ctrago pool application auth failed after reconnect", "connection", m.index, "error", err)
+		return
+	}
+	p.restore(m.client.log().With("connection", m.index))
+}
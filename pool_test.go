@@ -0,0 +1,233 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// poolServer 模拟单个连接：记录账户鉴权与报价订阅
+type poolServer struct {
+	lock     sync.Mutex
+	accounts map[int64]int
+	spots    map[int64]struct{}
+	// down 连接断开期间请求发送失败
+	down bool
+}
+
+func (s *poolServer) transport() *notifyingTransport {
+	s.accounts = make(map[int64]int)
+	s.spots = make(map[int64]struct{})
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, msg)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.down {
+			return errors.New("not connected")
+		}
+		switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_REQ:
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_RES, &openapi.ProtoOAApplicationAuthRes{}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ:
+			req := &openapi.ProtoOAAccountAuthReq{}
+			proto.Unmarshal(msg.Payload, req)
+			s.accounts[req.GetCtidTraderAccountId()]++
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{CtidTraderAccountId: req.CtidTraderAccountId}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ:
+			req := &openapi.ProtoOASubscribeSpotsReq{}
+			proto.Unmarshal(msg.Payload, req)
+			for _, id := range req.SymbolId {
+				s.spots[id] = struct{}{}
+			}
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_RES, &openapi.ProtoOASubscribeSpotsRes{CtidTraderAccountId: req.CtidTraderAccountId}, msg.GetClientMsgId())
+		}
+		return nil
+	}
+	return mock
+}
+
+// drop 模拟断线：服务端的鉴权与订阅全部失效
+func (s *poolServer) drop(mock *notifyingTransport) {
+	s.lock.Lock()
+	s.down = true
+	s.accounts = make(map[int64]int)
+	s.spots = make(map[int64]struct{})
+	s.lock.Unlock()
+	mock.eventHandler(TransportDisconnected, nil)
+	mock.eventHandler(TransportReconnecting, nil)
+}
+
+func (s *poolServer) reconnect(mock *notifyingTransport) {
+	s.lock.Lock()
+	s.down = false
+	s.lock.Unlock()
+	mock.eventHandler(TransportConnected, nil)
+}
+
+func (s *poolServer) authorized(accountId int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.accounts[accountId] > 0
+}
+
+func (s *poolServer) spotIds() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var ids []int64
+	for id := range s.spots {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestClientPool_ShardsAndFailsOver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	servers := []*poolServer{{}, {}}
+	mocks := []*notifyingTransport{servers[0].transport(), servers[1].transport()}
+	pool := NewClientPoolFromClients(
+		NewClientWithTransport(mocks[0], "id", "secret", "token"),
+		NewClientWithTransport(mocks[1], "id", "secret", "token"),
+	)
+	if err := pool.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := pool.Account(1)
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if account.conn() != pool.Clients()[0] {
+		t.Fatal("account 1 expected on connection 0")
+	}
+
+	// 主连接已有一个账户，品种交替分配，账户在连接 1 上补充鉴权
+	if err := pool.SubscribeSpots(ctx, 1, 1, 2, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := servers[0].spotIds(); len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Fatalf("connection 0 spots = %v", got)
+	}
+	if got := servers[1].spotIds(); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("connection 1 spots = %v", got)
+	}
+	if servers[1].accounts[1] != 1 {
+		t.Fatalf("account 1 not authorized on connection 1")
+	}
+
+	// 账户事件在两个连接上都会推送，只转发主连接的一份
+	var lock sync.Mutex
+	var events []uint32
+	for _, pt := range []openapi.ProtoOAPayloadType{openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT} {
+		pool.OnEvent(uint32(pt), func(msg *openapi.ProtoMessage) {
+			lock.Lock()
+			events = append(events, msg.GetPayloadType())
+			lock.Unlock()
+		})
+	}
+	for _, mock := range mocks {
+		mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT, &openapi.ProtoOAOrderErrorEvent{CtidTraderAccountId: proto.Int64(1), ErrorCode: proto.String("X")}, "")
+	}
+	mocks[1].push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{CtidTraderAccountId: proto.Int64(1), SymbolId: proto.Int64(1)}, "")
+	lock.Lock()
+	if len(events) != 2 {
+		t.Fatalf("events = %v", events)
+	}
+	lock.Unlock()
+
+	// 连接 0 断开：账户主连接与订阅迁移到连接 1
+	mocks[0].eventHandler(TransportDisconnected, nil)
+	mocks[0].eventHandler(TransportReconnecting, nil)
+	for len(servers[1].spotIds()) != 4 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("subscriptions not moved: %v", servers[1].spotIds())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if account.conn() != pool.Clients()[1] {
+		t.Fatal("account 1 not moved to connection 1")
+	}
+	stats := pool.Stats()
+	if stats[0].Spots != 0 || stats[1].Spots != 4 || stats[1].Accounts != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// 连接 0 重连后重新应用鉴权，新的订阅分配到它
+	mocks[0].eventHandler(TransportConnected, nil)
+	for pool.Clients()[0].State() != StateAppAuthorized {
+		select {
+		case <-ctx.Done():
+			t.Fatal("connection 0 not re-authorized")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if err := pool.SubscribeSpots(ctx, 1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := servers[0].spotIds(); len(got) != 3 || got[2] != 5 {
+		t.Fatalf("connection 0 spots after rejoin = %v", got)
+	}
+	pool.Close()
+}
+
+func TestClientPool_RestoresAfterAllConnectionsDrop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	servers := []*poolServer{{}, {}}
+	mocks := []*notifyingTransport{servers[0].transport(), servers[1].transport()}
+	pool := NewClientPoolFromClients(
+		NewClientWithTransport(mocks[0], "id", "secret", "token"),
+		NewClientWithTransport(mocks[1], "id", "secret", "token"),
+	)
+	if err := pool.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Account(1).Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.SubscribeSpots(ctx, 1, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个连接先后断开：没有可用连接时账户与订阅保留等待
+	servers[0].drop(mocks[0])
+	servers[1].drop(mocks[1])
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for !cond() {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for %s: %+v", what, pool.Stats())
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}
+	waitFor("subscriptions to be queued", func() bool {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+		return len(pool.subs) == 0 && len(pool.pendingSubs) == 3 && len(pool.pendingAccounts) == 1
+	})
+
+	// 连接 1 先恢复，承接全部账户与订阅；连接 0 恢复后不再重复订阅
+	servers[1].reconnect(mocks[1])
+	waitFor("restore on connection 1", func() bool {
+		return servers[1].authorized(1) && len(servers[1].spotIds()) == 3
+	})
+	servers[0].reconnect(mocks[0])
+	waitFor("connection 0 re-authorized", func() bool { return pool.Clients()[0].State() == StateAppAuthorized })
+	if stats := pool.Stats(); stats[1].Accounts != 1 || stats[1].Spots != 3 || stats[0].Spots != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if pool.Account(1).conn() != pool.Clients()[1] {
+		t.Fatal("account 1 expected on connection 1")
+	}
+}
@@ -0,0 +1,133 @@
+package ctrago
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// ReconnectPolicy 断线重连策略，采用带随机抖动的指数退避
+//
+// 第 n 次重连前等待 InitialInterval * Multiplier^(n-1)，最大不超过 MaxInterval，
+// 再按 Jitter 比例随机缩短，避免大量实例在同一时刻重连
+type ReconnectPolicy struct {
+	// InitialInterval 首次重连前的等待时间，默认 1s
+	InitialInterval time.Duration
+	// MaxInterval 单次等待的上限，默认 1min
+	MaxInterval time.Duration
+	// Multiplier 每次重连等待时间的增长倍数，默认 2
+	Multiplier float64
+	// Jitter 随机抖动比例，取值 [0, 1]，0 表示不抖动
+	Jitter float64
+	// MaxAttempts 最大重连次数，0 表示不限制
+	MaxAttempts int
+	// MaxElapsedTime 从断线开始累计的最长重连时间，0 表示不限制
+	MaxElapsedTime time.Duration
+	// BeforeAttempt 每次重连前回调，attempt 从 1 开始，lastErr 为上一次失败原因；返回 false 放弃重连
+	BeforeAttempt func(attempt int, delay time.Duration, lastErr error) bool
+
+	disabled bool
+}
+
+// DefaultReconnectPolicy 默认重连策略：1s 起步、翻倍增长、最长 1min、50% 抖动、不限次数
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     time.Minute,
+		Multiplier:      2,
+		Jitter:          0.5,
+	}
+}
+
+// NoReconnect 断线后不重连
+func NoReconnect() *ReconnectPolicy {
+	return &ReconnectPolicy{disabled: true}
+}
+
+// ReconnectAbortedError 重连被放弃（达到次数/时间上限、回调拒绝、Close 或 context 取消）
+type ReconnectAbortedError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *ReconnectAbortedError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("reconnect aborted after %d attempts", e.Attempts)
+	}
+	return fmt.Sprintf("reconnect aborted after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *ReconnectAbortedError) Unwrap() error {
+	return e.Err
+}
+
+// Delay 返回第 attempt 次重连前的等待时间（含抖动）
+func (p *ReconnectPolicy) Delay(attempt int) time.Duration {
+	initial := p.InitialInterval
+	if initial <= 0 {
+		initial = time.Second
+	}
+	maxInterval := p.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = time.Minute
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxInterval) {
+		delay = float64(maxInterval)
+	}
+	if jitter := math.Min(math.Max(p.Jitter, 0), 1); jitter > 0 {
+		delay -= delay * jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// retries 策略是否允许重连
+func (p *ReconnectPolicy) retries() bool {
+	return p == nil || !p.disabled
+}
+
+// reconnect 按策略反复调用 connect 直到成功；放弃时返回 *ReconnectAbortedError
+func (p *ReconnectPolicy) reconnect(ctx context.Context, closeCh <-chan struct{}, lastErr error, logger *slog.Logger, connect func() error) error {
+	if p == nil {
+		p = DefaultReconnectPolicy()
+	}
+	if p.disabled {
+		return &ReconnectAbortedError{Err: lastErr}
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+			return &ReconnectAbortedError{Attempts: attempt - 1, Err: lastErr}
+		}
+		delay := p.Delay(attempt)
+		if p.MaxElapsedTime > 0 && time.Since(start)+delay > p.MaxElapsedTime {
+			return &ReconnectAbortedError{Attempts: attempt - 1, Err: lastErr}
+		}
+		if p.BeforeAttempt != nil && !p.BeforeAttempt(attempt, delay, lastErr) {
+			return &ReconnectAbortedError{Attempts: attempt - 1, Err: lastErr}
+		}
+		logger.Info("ctrago reconnect scheduled", "attempt", attempt, "delay", delay, "lastError", lastErr)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-closeCh:
+			timer.Stop()
+			return &ReconnectAbortedError{Attempts: attempt - 1, Err: lastErr}
+		case <-ctx.Done():
+			timer.Stop()
+			return &ReconnectAbortedError{Attempts: attempt - 1, Err: ctx.Err()}
+		}
+		if lastErr = connect(); lastErr == nil {
+			logger.Info("ctrago reconnected", "attempt", attempt, "elapsed", time.Since(start))
+			return nil
+		}
+		logger.Warn("ctrago reconnect attempt failed", "attempt", attempt, "error", lastErr)
+	}
+}
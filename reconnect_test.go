@@ -0,0 +1,159 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicy_Delay(t *testing.T) {
+	p := &ReconnectPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 2}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, d := range expected {
+		if got := p.Delay(i + 1); got != d {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, d, got)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.Delay(3); got < 200*time.Millisecond || got > 400*time.Millisecond {
+			t.Fatalf("jittered delay out of range: %s", got)
+		}
+	}
+}
+
+func TestReconnectPolicy_MaxAttempts(t *testing.T) {
+	p := &ReconnectPolicy{InitialInterval: time.Millisecond, MaxAttempts: 3}
+	dialErr := errors.New("dial failed")
+	attempts := 0
+	err := p.reconnect(context.Background(), nil, nil, discardLogger(), func() error {
+		attempts++
+		return dialErr
+	})
+	var aborted *ReconnectAbortedError
+	if !errors.As(err, &aborted) || aborted.Attempts != 3 || !errors.Is(err, dialErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestReconnectPolicy_AbortOnClose(t *testing.T) {
+	p := &ReconnectPolicy{InitialInterval: time.Hour}
+	closeCh := make(chan struct{})
+	var hooked int
+	p.BeforeAttempt = func(attempt int, delay time.Duration, lastErr error) bool {
+		hooked = attempt
+		close(closeCh)
+		return true
+	}
+	err := p.reconnect(context.Background(), closeCh, nil, discardLogger(), func() error {
+		t.Fatal("connect should not be called")
+		return nil
+	})
+	var aborted *ReconnectAbortedError
+	if !errors.As(err, &aborted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hooked != 1 {
+		t.Errorf("expected BeforeAttempt to be called once, got %d", hooked)
+	}
+}
+
+func TestTcpClient_ConnectAfterClose(t *testing.T) {
+	dialing := make(chan struct{})
+	server, conn := net.Pipe()
+	defer server.Close()
+	c := &TcpClient{
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			close(dialing)
+			<-ctx.Done() // Close 应取消拨号
+			return conn, nil
+		},
+	}
+	c.init("tcp", c.openConn)
+	c.OnTransportEvent(func(event TransportEvent, err error) {
+		if event == TransportConnected {
+			t.Error("closed transport should not report connected")
+		}
+	})
+	go func() {
+		<-dialing
+		c.Close()
+	}()
+	if err := c.connect(context.Background()); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("expected ErrTransportClosed, got %v", err)
+	}
+	if c.conn != nil {
+		t.Fatal("connection should be discarded after Close")
+	}
+	if _, err := conn.Write([]byte{0}); err == nil {
+		t.Fatal("dialed connection should be closed")
+	}
+}
+
+func TestTcpClient_SetHeartbeatAfterListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := NewTcpClient(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go c.Listen()
+
+	c.SetHeartbeat(10*time.Millisecond, func() (int, []byte) { return 0, []byte("ping") })
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := readFrame(server)
+	if err != nil || string(data) != "ping" {
+		t.Fatalf("expected heartbeat after Listen, got %q, %v", data, err)
+	}
+}
+
+func TestTcpClient_NoReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := NewTcpClient(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReconnectPolicy(NoReconnect())
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []TransportEvent
+	c.OnTransportEvent(func(event TransportEvent, err error) {
+		events = append(events, event)
+	})
+	server.Close()
+
+	var aborted *ReconnectAbortedError
+	if err := c.Listen(); !errors.As(err, &aborted) {
+		t.Fatalf("expected ReconnectAbortedError, got %v", err)
+	}
+	want := []TransportEvent{TransportConnected, TransportDisconnected, TransportClosed}
+	if len(events) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("expected events %v, got %v", want, events)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package ctrago
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// 录制文件格式：
+//
+//	header: "CTRAGOREC" + 版本号(1 字节)
+//	record: 方向(1 字节) + 相对录制开始的纳秒数(uvarint) + messageType(uvarint) + 长度(uvarint) + ProtoMessage 原始字节
+const recordingMagic = "CTRAGOREC"
+
+const recordingVersion byte = 1
+
+// RecordDirection 消息方向
+type RecordDirection uint8
+
+const (
+	// RecordInbound 服务端发往客户端
+	RecordInbound RecordDirection = 1
+	// RecordOutbound 客户端发往服务端
+	RecordOutbound RecordDirection = 2
+)
+
+func (d RecordDirection) String() string {
+	switch d {
+	case RecordInbound:
+		return "inbound"
+	case RecordOutbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+// Record 录制的一条消息
+type Record struct {
+	Direction RecordDirection
+	// Offset 相对录制开始的时间
+	Offset      time.Duration
+	MessageType int
+	Data        []byte
+}
+
+// Message 解析为 ProtoMessage
+func (r *Record) Message() (*openapi.ProtoMessage, error) {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(r.Data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+var ErrInvalidRecording = errors.New("invalid recording file")
+
+// RecordingTransport 包装任意 Transport，把收发的每条消息连同时间戳写入录制文件
+//
+// 写入前隐藏 clientSecret、accessToken、refreshToken，录制文件可直接分享用于复现问题
+type RecordingTransport struct {
+	TransportWrapper
+
+	lock     sync.Mutex
+	w        *bufio.Writer
+	closer   io.Closer
+	start    time.Time
+	err      error
+	handlers []MessageHandler
+	buf      [3 * binary.MaxVarintLen64]byte
+}
+
+// NewRecordingTransport 创建录制传输层，录制内容写入 w
+func NewRecordingTransport(transport Transport, w io.Writer) (*RecordingTransport, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(recordingMagic); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(recordingVersion); err != nil {
+		return nil, err
+	}
+	t := &RecordingTransport{
+		TransportWrapper: TransportWrapper{Transport: transport},
+		w:                bw,
+		start:            time.Now(),
+	}
+	transport.OnMessage(t.recordInbound)
+	return t, nil
+}
+
+// RecordToFile 创建录制传输层，录制内容写入 path（权限 0600），Close 时关闭文件
+func RecordToFile(transport Transport, path string) (*RecordingTransport, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewRecordingTransport(transport, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.closer = f
+	return t, nil
+}
+
+func (t *RecordingTransport) write(direction RecordDirection, messageType int, data []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return
+	}
+	data = redactFrame(data)
+	t.buf[0] = byte(direction)
+	n := 1
+	n += binary.PutUvarint(t.buf[n:], uint64(time.Since(t.start)))
+	n += binary.PutUvarint(t.buf[n:], uint64(messageType))
+	n += binary.PutUvarint(t.buf[n:], uint64(len(data)))
+	if _, err := t.w.Write(t.buf[:n]); err != nil {
+		t.err = err
+		return
+	}
+	if _, err := t.w.Write(data); err != nil {
+		t.err = err
+	}
+}
+
+func (t *RecordingTransport) recordInbound(messageType int, data []byte) {
+	t.write(RecordInbound, messageType, data)
+	t.lock.Lock()
+	handlers := t.handlers
+	t.lock.Unlock()
+	for _, h := range handlers {
+		h(messageType, data)
+	}
+}
+
+func (t *RecordingTransport) Send(messageType int, data []byte) error {
+	t.write(RecordOutbound, messageType, data)
+	return t.Transport.Send(messageType, data)
+}
+
+func (t *RecordingTransport) OnMessage(handler MessageHandler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+// Flush 将缓冲的录制内容写出，返回录制过程中遇到的第一个写入错误
+func (t *RecordingTransport) Flush() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+// Close 关闭被包装的传输层并写出录制内容
+func (t *RecordingTransport) Close() error {
+	err := t.Transport.Close()
+	if flushErr := t.Flush(); err == nil {
+		err = flushErr
+	}
+	if t.closer != nil {
+		if closeErr := t.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// RecordReader 逐条读取录制文件
+type RecordReader struct {
+	r *bufio.Reader
+}
+
+// NewRecordReader 校验文件头并返回 RecordReader
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(recordingMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, ErrInvalidRecording
+	}
+	if string(header[:len(recordingMagic)]) != recordingMagic {
+		return nil, ErrInvalidRecording
+	}
+	if header[len(recordingMagic)] != recordingVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidRecording, header[len(recordingMagic)])
+	}
+	return &RecordReader{r: br}, nil
+}
+
+// Next 读取下一条记录，读完时返回 io.EOF
+func (rr *RecordReader) Next() (*Record, error) {
+	direction, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	offset, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	messageType, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	size, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	if size > MaxTcpFrameSize {
+		return nil, fmt.Errorf("%w: record of %d bytes exceeds the maximum frame size", ErrInvalidRecording, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(rr.r, data); err != nil {
+		return nil, truncated(err)
+	}
+	return &Record{
+		Direction:   RecordDirection(direction),
+		Offset:      time.Duration(offset),
+		MessageType: int(messageType),
+		Data:        data,
+	}, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidRecording, err)
+}
+
+// ReadRecording 读取整个录制文件
+func ReadRecording(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rr, err := NewRecordReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, *rec)
+	}
+}
+
+var _ Transport = (*RecordingTransport)(nil)
+var _ TransportNotifier = (*RecordingTransport)(nil)
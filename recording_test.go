@@ -0,0 +1,167 @@
+package ctrago
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRecordingTransport_Replay(t *testing.T) {
+	// 录制：服务端回复版本号后推送一条报价
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		req := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, req)
+		go func() {
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_RES, &openapi.ProtoOAVersionRes{Version: proto.String("91")}, req.GetClientMsgId())
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{CtidTraderAccountId: proto.Int64(1), SymbolId: proto.Int64(2), Bid: proto.Uint64(123)}, "")
+		}()
+		return nil
+	}
+	var buf bytes.Buffer
+	recorder, err := NewRecordingTransport(mock, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientWithTransport(recorder, "id", "secret", "token")
+	spots := make(chan *openapi.ProtoMessage, 1)
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) { spots <- msg })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Version(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-spots
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := NewRecordReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for {
+		rec, err := rr.Next()
+		if err != nil {
+			break
+		}
+		records = append(records, *rec)
+	}
+	if len(records) != 3 || records[0].Direction != RecordOutbound || records[1].Direction != RecordInbound || records[2].Direction != RecordInbound {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// 回放：clientMsgId 与录制时不同，响应仍应投递给对应请求
+	replay := NewReplayTransport(records, 0)
+	client = NewClientWithTransport(replay, "id", "secret", "token")
+	client.nextMsgId()
+	client.nextMsgId()
+	spots = make(chan *openapi.ProtoMessage, 1)
+	client.OnEvent(uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT), func(msg *openapi.ProtoMessage) { spots <- msg })
+	go replay.Listen()
+	res, err := client.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetVersion() != "91" {
+		t.Errorf("expected version 91, got %q", res.GetVersion())
+	}
+	select {
+	case <-spots:
+	case <-ctx.Done():
+		t.Fatal("spot event not replayed")
+	}
+	<-replay.Done()
+	if sent := replay.Sent(); len(sent) != 1 || sent[0].GetPayloadType() != uint32(openapi.ProtoOAPayloadType_PROTO_OA_VERSION_REQ) {
+		t.Errorf("unexpected sent messages: %v", sent)
+	}
+}
+
+func TestRecordReader_InvalidHeader(t *testing.T) {
+	if _, err := NewRecordReader(bytes.NewReader([]byte("not a recording"))); err != ErrInvalidRecording {
+		t.Errorf("expected ErrInvalidRecording, got %v", err)
+	}
+}
+
+func TestRecordReader_OversizedRecord(t *testing.T) {
+	data := append([]byte(recordingMagic), recordingVersion, byte(RecordInbound), 0, 2)
+	data = binary.AppendUvarint(data, MaxTcpFrameSize+1)
+	rr, err := NewRecordReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rr.Next(); !errors.Is(err, ErrInvalidRecording) {
+		t.Errorf("expected ErrInvalidRecording, got %v", err)
+	}
+}
+
+func TestRecordToFile_RedactsCredentials(t *testing.T) {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		req := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, req)
+		if req.GetPayloadType() == uint32(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_REQ) {
+			go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_APPLICATION_AUTH_RES, &openapi.ProtoOAApplicationAuthRes{}, req.GetClientMsgId())
+		} else {
+			go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{CtidTraderAccountId: proto.Int64(1)}, req.GetClientMsgId())
+		}
+		return nil
+	}
+	path := filepath.Join(t.TempDir(), "session.rec")
+	recorder, err := RecordToFile(mock, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientWithTransport(recorder, "id", "top-secret", "token-value")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Account(1).Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected permissions 0600, got %o", perm)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"top-secret", "token-value"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("recording contains %q", secret)
+		}
+	}
+	rr, err := NewRecordReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := rr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := rec.Message()
+	req := &openapi.ProtoOAApplicationAuthReq{}
+	proto.Unmarshal(msg.Payload, req)
+	if req.GetClientId() != "id" || req.GetClientSecret() != redactedValue {
+		t.Errorf("unexpected recorded auth request: %v", req)
+	}
+}
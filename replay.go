@@ -0,0 +1,189 @@
+package ctrago
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrReplayClosed = errors.New("replay transport closed")
+
+// ReplayTransport 将录制文件中的入站消息按时间顺序回放给 Client，不连接服务端
+//
+// 回放的 clientMsgId 由当前 Client 生成，与录制时不同：录制中第 n 个某类型请求的响应，
+// 会改写为回放时第 n 个同类型请求的 clientMsgId，并等待该请求发出后再投递；
+// 录制中找不到对应请求的响应（如录制开始前发出的请求）原样投递
+type ReplayTransport struct {
+	records []Record
+	speed   float64
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	handlers []MessageHandler
+	// requests 录制中每个请求的 clientMsgId -> 回放时对应的同类型请求序号
+	requests map[string]replayRequest
+	// sent 回放期间 Client 发出的每种类型请求的 clientMsgId
+	sent    map[uint32][]string
+	sentLog []*openapi.ProtoMessage
+	closed  bool
+	closeCh chan struct{}
+	done    chan struct{}
+}
+
+type replayRequest struct {
+	payloadType uint32
+	index       int
+}
+
+// NewReplayTransport 创建回放传输层；speed 为回放速度倍数，1 为原速，<=0 表示不等待、尽快回放
+func NewReplayTransport(records []Record, speed float64) *ReplayTransport {
+	t := &ReplayTransport{
+		records:  records,
+		speed:    speed,
+		requests: make(map[string]replayRequest),
+		sent:     make(map[uint32][]string),
+		closeCh:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.lock)
+	counts := make(map[uint32]int)
+	for i := range records {
+		if records[i].Direction != RecordOutbound {
+			continue
+		}
+		msg, err := records[i].Message()
+		if err != nil || msg.GetClientMsgId() == "" {
+			continue
+		}
+		pt := msg.GetPayloadType()
+		t.requests[msg.GetClientMsgId()] = replayRequest{payloadType: pt, index: counts[pt]}
+		counts[pt]++
+	}
+	return t
+}
+
+// OpenReplay 读取录制文件并创建回放传输层
+func OpenReplay(path string, speed float64) (*ReplayTransport, error) {
+	records, err := ReadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayTransport(records, speed), nil
+}
+
+// Send 记录 Client 发出的消息，不会发往任何服务端
+func (t *ReplayTransport) Send(messageType int, data []byte) error {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return ErrReplayClosed
+	}
+	t.sentLog = append(t.sentLog, msg)
+	if msg.GetClientMsgId() != "" {
+		t.sent[msg.GetPayloadType()] = append(t.sent[msg.GetPayloadType()], msg.GetClientMsgId())
+		t.cond.Broadcast()
+	}
+	return nil
+}
+
+// Sent 返回回放期间 Client 发出的全部消息
+func (t *ReplayTransport) Sent() []*openapi.ProtoMessage {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]*openapi.ProtoMessage(nil), t.sentLog...)
+}
+
+func (t *ReplayTransport) OnMessage(handler MessageHandler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+// OnTransportEvent 回放传输层始终处于已连接状态，注册时立即回调 TransportConnected
+func (t *ReplayTransport) OnTransportEvent(handler TransportEventHandler) {
+	handler(TransportConnected, nil)
+}
+
+func (t *ReplayTransport) SetHeartbeat(time.Duration, func() (int, []byte)) {}
+
+// Listen 按录制时间回放全部入站消息，回放完成返回 nil，被 Close 中断时返回 ErrReplayClosed
+func (t *ReplayTransport) Listen() error {
+	defer close(t.done)
+	start := time.Now()
+	for i := range t.records {
+		rec := &t.records[i]
+		if rec.Direction != RecordInbound {
+			continue
+		}
+		if t.speed > 0 {
+			wait := time.Duration(float64(rec.Offset)/t.speed) - time.Since(start)
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-t.closeCh:
+					return ErrReplayClosed
+				}
+			}
+		}
+		data, err := t.rewrite(rec.Data)
+		if err != nil {
+			return err
+		}
+		t.lock.Lock()
+		handlers := t.handlers
+		t.lock.Unlock()
+		for _, h := range handlers {
+			h(rec.MessageType, data)
+		}
+	}
+	return nil
+}
+
+// Done 回放结束（完成或被 Close）后关闭
+func (t *ReplayTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+// rewrite 将响应的 clientMsgId 改写为回放时对应请求的 clientMsgId，必要时等待该请求发出
+func (t *ReplayTransport) rewrite(data []byte) ([]byte, error) {
+	msg := &openapi.ProtoMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil || msg.GetClientMsgId() == "" {
+		return data, nil
+	}
+	req, ok := t.requests[msg.GetClientMsgId()]
+	if !ok {
+		return data, nil
+	}
+	t.lock.Lock()
+	for !t.closed && len(t.sent[req.payloadType]) <= req.index {
+		t.cond.Wait()
+	}
+	if t.closed {
+		t.lock.Unlock()
+		return nil, ErrReplayClosed
+	}
+	msg.ClientMsgId = proto.String(t.sent[req.payloadType][req.index])
+	t.lock.Unlock()
+	return proto.Marshal(msg)
+}
+
+func (t *ReplayTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.closeCh)
+		t.cond.Broadcast()
+	}
+	return nil
+}
+
+var _ Transport = (*ReplayTransport)(nil)
+var _ TransportNotifier = (*ReplayTransport)(nil)
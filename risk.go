@@ -0,0 +1,480 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrRiskRejected 订单被下单前风控拒绝，可用 errors.Is 判断，详细原因见 *RiskError
+var ErrRiskRejected = errors.New("order rejected by pre-trade risk check")
+
+// RiskError 下单前风控拒绝的原因；规则执行出错（如查询持仓失败）时同样拒绝，Err 为原始错误
+type RiskError struct {
+	Rule      string
+	AccountId int64
+	SymbolId  int64
+	Err       error
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("pre-trade risk check %s rejected order (account %d, symbol %d): %v", e.Rule, e.AccountId, e.SymbolId, e.Err)
+}
+
+func (e *RiskError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RiskError) Is(target error) bool {
+	return target == ErrRiskRejected
+}
+
+// RiskRule 下单前风控规则，Check 返回非 nil 错误时拒绝下单
+type RiskRule interface {
+	Name() string
+	Check(ctx context.Context, order *OrderCheck) error
+}
+
+type riskRuleFunc struct {
+	name  string
+	check func(ctx context.Context, order *OrderCheck) error
+}
+
+func (r riskRuleFunc) Name() string { return r.name }
+
+func (r riskRuleFunc) Check(ctx context.Context, order *OrderCheck) error {
+	return r.check(ctx, order)
+}
+
+// NewRiskRule 以函数创建风控规则
+func NewRiskRule(name string, check func(ctx context.Context, order *OrderCheck) error) RiskRule {
+	return riskRuleFunc{name: name, check: check}
+}
+
+// OrderCheck 待检查的订单，持仓、账户等信息在规则首次使用时查询，同一次检查内共享
+type OrderCheck struct {
+	Account *Account
+	// Request 待发送的订单；改单时为按修改后参数合成的订单
+	Request *openapi.ProtoOANewOrderReq
+	// AmendOrderId 改单检查时为被修改的挂单，规则统计挂单量时应排除该订单
+	AmendOrderId int64
+	// Time 检查时间
+	Time time.Time
+
+	reconcile *openapi.ProtoOAReconcileRes
+	trader    *openapi.ProtoOATrader
+}
+
+// Reconcile 返回账户当前的持仓与挂单
+func (o *OrderCheck) Reconcile(ctx context.Context) (*openapi.ProtoOAReconcileRes, error) {
+	if o.reconcile == nil {
+		res, err := o.Account.Trader().Reconcile(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		o.reconcile = res
+	}
+	return o.reconcile, nil
+}
+
+// Trader 返回账户信息（余额、杠杆、金额精度等）
+func (o *OrderCheck) Trader(ctx context.Context) (*openapi.ProtoOATrader, error) {
+	if o.trader == nil {
+		res, err := o.Account.Trader().Trader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		o.trader = res.GetTrader()
+	}
+	return o.trader, nil
+}
+
+// Closing 订单是否用于平掉已有持仓（指定了 positionId 且方向相反）
+func (o *OrderCheck) Closing(ctx context.Context) (bool, error) {
+	positionId := o.Request.GetPositionId()
+	if positionId == 0 {
+		return false, nil
+	}
+	res, err := o.Reconcile(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, pos := range res.GetPosition() {
+		if pos.GetPositionId() == positionId {
+			return pos.GetTradeData().GetTradeSide() != o.Request.GetTradeSide(), nil
+		}
+	}
+	return false, nil
+}
+
+// WithRiskRules 设置下单前风控规则，AccountOrder.NewOrder 与 AmendOrder 在发送前按顺序检查，任一规则拒绝即返回 *RiskError
+func WithRiskRules(rules ...RiskRule) Option {
+	return func(c *clientConfig) {
+		c.riskRules = append(c.riskRules, rules...)
+	}
+}
+
+// AddRiskRules 追加下单前风控规则
+func (c *Client) AddRiskRules(rules ...RiskRule) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.riskRules = append(c.riskRules, rules...)
+}
+
+// SetRiskRules 替换全部下单前风控规则，不传参数表示关闭风控
+func (c *Client) SetRiskRules(rules ...RiskRule) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.riskRules = append([]RiskRule(nil), rules...)
+}
+
+// checkRisk 账户被熔断时直接拒绝，否则按顺序执行风控规则
+func (c *Client) checkRisk(ctx context.Context, account *Account, req *openapi.ProtoOANewOrderReq) error {
+	if reason, ok := account.Halted(); ok {
+		return &RiskError{Rule: "kill-switch", AccountId: account.accountId, SymbolId: req.GetSymbolId(), Err: fmt.Errorf("%w: %s", ErrAccountHalted, reason)}
+	}
+	rules := c.currentRiskRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	return c.runRiskRules(ctx, rules, &OrderCheck{Account: account, Request: req, Time: time.Now()})
+}
+
+// checkAmendRisk 以修改后的订单参数执行风控规则；熔断不限制改单，找不到挂单时交给服务端拒绝
+func (c *Client) checkAmendRisk(ctx context.Context, account *Account, amend *openapi.ProtoOAAmendOrderReq) error {
+	rules := c.currentRiskRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	order := &OrderCheck{Account: account, AmendOrderId: amend.GetOrderId(), Time: time.Now()}
+	res, err := order.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+	for _, o := range res.GetOrder() {
+		if o.GetOrderId() == amend.GetOrderId() {
+			order.Request = amendedOrder(account.accountId, o, amend)
+			return c.runRiskRules(ctx, rules, order)
+		}
+	}
+	return nil
+}
+
+func (c *Client) currentRiskRules() []RiskRule {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.riskRules
+}
+
+func (c *Client) runRiskRules(ctx context.Context, rules []RiskRule, order *OrderCheck) error {
+	accountId, symbolId := order.Account.accountId, order.Request.GetSymbolId()
+	for _, rule := range rules {
+		if err := rule.Check(ctx, order); err != nil {
+			c.log().Warn("ctrago order rejected by risk check", "rule", rule.Name(), "accountId", accountId, "symbolId", symbolId, "orderId", order.AmendOrderId, "error", err)
+			return &RiskError{Rule: rule.Name(), AccountId: accountId, SymbolId: symbolId, Err: err}
+		}
+	}
+	return nil
+}
+
+// amendedOrder 把改单请求中设置的字段合并到挂单上，合成供风控规则检查的下单请求
+func amendedOrder(accountId int64, o *openapi.ProtoOAOrder, amend *openapi.ProtoOAAmendOrderReq) *openapi.ProtoOANewOrderReq {
+	td := o.GetTradeData()
+	req := &openapi.ProtoOANewOrderReq{
+		CtidTraderAccountId: proto.Int64(accountId),
+		SymbolId:            proto.Int64(td.GetSymbolId()),
+		OrderType:           o.OrderType,
+		TradeSide:           td.TradeSide,
+		Volume:              proto.Int64(td.GetVolume()),
+		LimitPrice:          o.LimitPrice,
+		StopPrice:           o.StopPrice,
+		StopLoss:            o.StopLoss,
+		TakeProfit:          o.TakeProfit,
+		RelativeStopLoss:    o.RelativeStopLoss,
+		RelativeTakeProfit:  o.RelativeTakeProfit,
+		PositionId:          o.PositionId,
+		ClientOrderId:       o.ClientOrderId,
+		Label:               td.Label,
+	}
+	if o.GetPositionId() == 0 {
+		req.PositionId = nil
+	}
+	if amend.Volume != nil {
+		req.Volume = amend.Volume
+	}
+	if amend.LimitPrice != nil {
+		req.LimitPrice = amend.LimitPrice
+	}
+	if amend.StopPrice != nil {
+		req.StopPrice = amend.StopPrice
+	}
+	if amend.StopLoss != nil {
+		req.StopLoss = amend.StopLoss
+	}
+	if amend.TakeProfit != nil {
+		req.TakeProfit = amend.TakeProfit
+	}
+	if amend.RelativeStopLoss != nil {
+		req.RelativeStopLoss = amend.RelativeStopLoss
+	}
+	if amend.RelativeTakeProfit != nil {
+		req.RelativeTakeProfit = amend.RelativeTakeProfit
+	}
+	return req
+}
+
+// MaxOrderVolume 限制单笔订单的成交量，perSymbol 中未列出的品种使用 max，max 为 0 表示不限制
+func MaxOrderVolume(max int64, perSymbol map[int64]int64) RiskRule {
+	return NewRiskRule("max-order-volume", func(ctx context.Context, order *OrderCheck) error {
+		limit, ok := perSymbol[order.Request.GetSymbolId()]
+		if !ok {
+			limit = max
+		}
+		if limit > 0 && order.Request.GetVolume() > limit {
+			return fmt.Errorf("volume %d exceeds limit %d", order.Request.GetVolume(), limit)
+		}
+		return nil
+	})
+}
+
+// MaxSymbolVolume 限制单个品种的持仓量：成交后该品种多空持仓与同向挂单的总量不超过上限，平仓单不受限制
+func MaxSymbolVolume(max int64, perSymbol map[int64]int64) RiskRule {
+	return NewRiskRule("max-symbol-volume", func(ctx context.Context, order *OrderCheck) error {
+		symbolId := order.Request.GetSymbolId()
+		limit, ok := perSymbol[symbolId]
+		if !ok {
+			limit = max
+		}
+		if limit <= 0 {
+			return nil
+		}
+		if closing, err := order.Closing(ctx); err != nil || closing {
+			return err
+		}
+		res, err := order.Reconcile(ctx)
+		if err != nil {
+			return err
+		}
+		total := openVolume(res, order.AmendOrderId, func(s int64) bool { return s == symbolId }) + order.Request.GetVolume()
+		if total > limit {
+			return fmt.Errorf("open volume would be %d, limit %d", total, limit)
+		}
+		return nil
+	})
+}
+
+// MaxOpenExposure 限制订单品种的净头寸：多头减空头持仓，加上与订单同向的挂单及订单本身，绝对值不超过 max；
+// 只在同一品种内比较，平仓单不受限制
+func MaxOpenExposure(max int64) RiskRule {
+	return NewRiskRule("max-open-exposure", func(ctx context.Context, order *OrderCheck) error {
+		if closing, err := order.Closing(ctx); err != nil || closing {
+			return err
+		}
+		res, err := order.Reconcile(ctx)
+		if err != nil {
+			return err
+		}
+		symbolId, side := order.Request.GetSymbolId(), order.Request.GetTradeSide()
+		var net int64
+		for _, pos := range res.GetPosition() {
+			if td := pos.GetTradeData(); td.GetSymbolId() == symbolId {
+				net += signedVolume(td.GetTradeSide(), td.GetVolume())
+			}
+		}
+		for _, o := range res.GetOrder() {
+			td := o.GetTradeData()
+			if o.GetOrderId() != order.AmendOrderId && o.GetPositionId() == 0 && td.GetSymbolId() == symbolId && td.GetTradeSide() == side {
+				net += signedVolume(side, td.GetVolume())
+			}
+		}
+		net += signedVolume(side, order.Request.GetVolume())
+		if net > max || -net > max {
+			return fmt.Errorf("net exposure of symbol %d would be %d, limit %d", symbolId, net, max)
+		}
+		return nil
+	})
+}
+
+func signedVolume(side openapi.ProtoOATradeSide, volume int64) int64 {
+	if side == openapi.ProtoOATradeSide_SELL {
+		return -volume
+	}
+	return volume
+}
+
+// openVolume 持仓与开仓挂单的总量，不含 excludeOrderId（改单检查中被修改的挂单）
+func openVolume(res *openapi.ProtoOAReconcileRes, excludeOrderId int64, match func(symbolId int64) bool) int64 {
+	var total int64
+	for _, pos := range res.GetPosition() {
+		if match(pos.GetTradeData().GetSymbolId()) {
+			total += pos.GetTradeData().GetVolume()
+		}
+	}
+	for _, o := range res.GetOrder() {
+		// 平仓挂单不增加持仓
+		if o.GetOrderId() != excludeOrderId && o.GetPositionId() == 0 && match(o.GetTradeData().GetSymbolId()) {
+			total += o.GetTradeData().GetVolume()
+		}
+	}
+	return total
+}
+
+// MaxDailyLoss 当日已实现亏损（含手续费与隔夜利息）达到 maxLoss（存款货币）后只允许平仓单
+//
+// 当日从 loc 时区的零点起算，loc 为 nil 时使用 UTC
+func MaxDailyLoss(maxLoss float64, loc *time.Location) RiskRule {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return NewRiskRule("max-daily-loss", func(ctx context.Context, order *OrderCheck) error {
+		if closing, err := order.Closing(ctx); err != nil || closing {
+			return err
+		}
+		now := order.Time.In(loc)
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		pnl, err := realizedProfit(ctx, order.Account, start, order.Time)
+		if err != nil {
+			return err
+		}
+		if -pnl >= maxLoss {
+			return fmt.Errorf("daily loss %.2f reached limit %.2f", -pnl, maxLoss)
+		}
+		return nil
+	})
+}
+
+// realizedProfit 汇总时间段内成交的已实现盈亏，HasMore 时以最早一笔的时间为终点继续请求，
+// 边界时间上的成交会在相邻两页重复出现，按 dealId 去重
+func realizedProfit(ctx context.Context, account *Account, from, to time.Time) (float64, error) {
+	seen := make(map[int64]struct{})
+	var pnl float64
+	end := to.UnixMilli()
+	for {
+		res, err := account.Trader().DealList(ctx, from.UnixMilli(), end, 0)
+		if err != nil {
+			return 0, err
+		}
+		oldest := end
+		for _, deal := range res.GetDeal() {
+			oldest = min(oldest, deal.GetExecutionTimestamp())
+			if _, ok := seen[deal.GetDealId()]; ok {
+				continue
+			}
+			seen[deal.GetDealId()] = struct{}{}
+			if deal.GetDealStatus() != openapi.ProtoOADealStatus_FILLED && deal.GetDealStatus() != openapi.ProtoOADealStatus_PARTIALLY_FILLED {
+				continue
+			}
+			if detail := deal.GetClosePositionDetail(); detail != nil {
+				pnl += MoneyValue(detail.GetGrossProfit()+detail.GetSwap()+detail.GetCommission()-detail.GetPnlConversionFee(), detail.GetMoneyDigits())
+			} else {
+				pnl += MoneyValue(deal.GetCommission(), deal.GetMoneyDigits())
+			}
+		}
+		if !res.GetHasMore() || oldest >= end {
+			return pnl, nil
+		}
+		end = oldest
+	}
+}
+
+// RequireStopLoss 开仓单必须设置止损（绝对或相对价格），平仓单不受限制
+func RequireStopLoss() RiskRule {
+	return NewRiskRule("require-stop-loss", func(ctx context.Context, order *OrderCheck) error {
+		if order.Request.GetStopLoss() > 0 || order.Request.GetRelativeStopLoss() > 0 {
+			return nil
+		}
+		if closing, err := order.Closing(ctx); err != nil || closing {
+			return err
+		}
+		return errors.New("stop loss is required")
+	})
+}
+
+// AllowedSymbols 只允许交易列出的品种
+func AllowedSymbols(symbolIds ...int64) RiskRule {
+	allowed := make(map[int64]struct{}, len(symbolIds))
+	for _, id := range symbolIds {
+		allowed[id] = struct{}{}
+	}
+	return NewRiskRule("allowed-symbols", func(ctx context.Context, order *OrderCheck) error {
+		if _, ok := allowed[order.Request.GetSymbolId()]; !ok {
+			return fmt.Errorf("symbol %d is not allowed", order.Request.GetSymbolId())
+		}
+		return nil
+	})
+}
+
+// TradingHours 只在 loc 时区每天的 [from, to) 内允许下单，from、to 为距零点的时长，to 小于 from 时跨越午夜；
+// days 为空表示每天
+func TradingHours(loc *time.Location, from, to time.Duration, days ...time.Weekday) RiskRule {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return NewRiskRule("trading-hours", func(ctx context.Context, order *OrderCheck) error {
+		now := order.Time.In(loc)
+		offset := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc))
+		day := now.Weekday()
+		inWindow := offset >= from && offset < to
+		if to < from {
+			// 跨越午夜的时段属于开始的那一天
+			inWindow = offset >= from
+			if offset < to {
+				inWindow = true
+				day = (day + 6) % 7
+			}
+		}
+		if inWindow && len(days) > 0 {
+			inWindow = false
+			for _, d := range days {
+				if d == day {
+					inWindow = true
+				}
+			}
+		}
+		if !inWindow {
+			return fmt.Errorf("outside trading hours at %s", now.Format(time.RFC3339))
+		}
+		return nil
+	})
+}
+
+// MaxMarginUsage 按 ExpectedMargin 预估成交后的占用保证金，超过余额的 ratio 倍（如 0.5）时拒绝，平仓单不受限制
+func MaxMarginUsage(ratio float64) RiskRule {
+	return NewRiskRule("max-margin-usage", func(ctx context.Context, order *OrderCheck) error {
+		if closing, err := order.Closing(ctx); err != nil || closing {
+			return err
+		}
+		trader, err := order.Trader(ctx)
+		if err != nil {
+			return err
+		}
+		res, err := order.Reconcile(ctx)
+		if err != nil {
+			return err
+		}
+		var used float64
+		for _, pos := range res.GetPosition() {
+			used += MoneyValue(int64(pos.GetUsedMargin()), pos.GetMoneyDigits())
+		}
+		expected, err := order.Account.Trader().ExpectedMargin(ctx, order.Request.GetSymbolId(), []int64{order.Request.GetVolume()})
+		if err != nil {
+			return err
+		}
+		for _, m := range expected.GetMargin() {
+			margin := m.GetBuyMargin()
+			if order.Request.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+				margin = m.GetSellMargin()
+			}
+			used += MoneyValue(margin, expected.GetMoneyDigits())
+		}
+		limit := ratio * MoneyValue(trader.GetBalance(), trader.GetMoneyDigits())
+		if used > limit {
+			return fmt.Errorf("projected used margin %.2f exceeds %.2f", used, limit)
+		}
+		return nil
+	})
+}
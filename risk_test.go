@@ -0,0 +1,238 @@
+package ctrago_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago"
+	"github.com/yockii/ctrago/ctragotest"
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func newRiskAccount(t *testing.T, ctx context.Context, srv *ctragotest.Server, rules ...ctrago.RiskRule) *ctrago.Account {
+	t.Helper()
+	client, err := ctrago.New(
+		ctrago.WithTransport(srv.NewTransport()),
+		ctrago.WithCredentials(ctragotest.DefaultClientId, ctragotest.DefaultClientSecret),
+		ctrago.WithAccessToken(ctragotest.DefaultAccessToken),
+		ctrago.WithRiskRules(rules...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.ApplicationAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	account := client.Account(ctragotest.DefaultAccountId)
+	if _, err := account.Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func expectRejected(t *testing.T, err error, rule string) {
+	t.Helper()
+	var riskErr *ctrago.RiskError
+	if !errors.Is(err, ctrago.ErrRiskRejected) || !errors.As(err, &riskErr) || riskErr.Rule != rule {
+		t.Fatalf("expected rejection by %s, got %v", rule, err)
+	}
+}
+
+func TestRiskRules(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	account := newRiskAccount(t, ctx, srv,
+		ctrago.AllowedSymbols(ctragotest.DefaultSymbolId),
+		ctrago.MaxOrderVolume(500000, nil),
+		ctrago.MaxSymbolVolume(300000, nil),
+		ctrago.RequireStopLoss(),
+		ctrago.MaxDailyLoss(10, time.UTC),
+	)
+	orders := account.Order()
+	buy := func(volume int64, opt *ctrago.OrderOption) (*openapi.ProtoOAExecutionEvent, error) {
+		return orders.NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, volume, opt)
+	}
+	withStop := &ctrago.OrderOption{}
+	withStop.WithRelativeStopLoss(500)
+
+	_, err := orders.NewOrder(ctx, 2, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, withStop)
+	expectRejected(t, err, "allowed-symbols")
+	_, err = buy(100000, nil)
+	expectRejected(t, err, "require-stop-loss")
+	_, err = buy(600000, withStop)
+	expectRejected(t, err, "max-order-volume")
+	for _, req := range srv.Requests() {
+		if req.PayloadType == uint32(openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ) {
+			t.Fatal("rejected order reached the server")
+		}
+	}
+
+	if _, err := buy(200000, withStop); err != nil {
+		t.Fatal(err)
+	}
+	_, err = buy(200000, withStop)
+	expectRejected(t, err, "max-symbol-volume")
+
+	// 亏损 20.40 平仓后只允许平仓单
+	var positionId int64
+	for positionId == 0 {
+		res, err := account.Trader().Reconcile(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Position) == 1 {
+			positionId = res.Position[0].GetPositionId()
+		}
+	}
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.0900, 1.0902)
+	if _, err := orders.ClosePosition(ctx, positionId, 100000); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, err = buy(100000, withStop)
+	expectRejected(t, err, "max-daily-loss")
+	closing := &ctrago.OrderOption{}
+	closing.WithPositionId(positionId)
+	if _, err := orders.NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_SELL, 100000, closing); err != nil {
+		t.Fatalf("closing order rejected: %v", err)
+	}
+}
+
+func TestRiskRules_Margin(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	srv.Handle(openapi.ProtoOAPayloadType_PROTO_OA_EXPECTED_MARGIN_REQ, func(sess *ctragotest.Session, req *ctragotest.Request) *ctragotest.Response {
+		r := &openapi.ProtoOAExpectedMarginReq{}
+		req.Decode(r)
+		res := &openapi.ProtoOAExpectedMarginRes{CtidTraderAccountId: r.CtidTraderAccountId, MoneyDigits: proto.Uint32(2)}
+		for _, v := range r.Volume {
+			// 每 1000 单位占用 10.00
+			res.Margin = append(res.Margin, &openapi.ProtoOAExpectedMargin{Volume: proto.Int64(v), BuyMargin: proto.Int64(v / 100), SellMargin: proto.Int64(v / 100)})
+		}
+		return ctragotest.Reply(openapi.ProtoOAPayloadType_PROTO_OA_EXPECTED_MARGIN_RES, res)
+	})
+	account := newRiskAccount(t, ctx, srv, ctrago.MaxMarginUsage(0.01))
+	buy := func(volume int64) error {
+		_, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, volume, nil)
+		return err
+	}
+	// 上限为余额 10000.00 的 1%
+	if err := buy(400000); err != nil {
+		t.Fatal(err)
+	}
+	expectRejected(t, buy(1200000), "max-margin-usage")
+}
+
+func TestTradingHours(t *testing.T) {
+	// 周一至周五 22:00 至次日 06:00
+	rule := ctrago.TradingHours(time.UTC, 22*time.Hour, 6*time.Hour, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday)
+	cases := map[string]bool{
+		"2026-10-19T23:00:00Z": true,  // 周一晚
+		"2026-10-20T05:59:00Z": true,  // 周一开始的时段
+		"2026-10-20T06:00:00Z": false, // 收盘
+		"2026-10-19T02:00:00Z": false, // 周日开始的时段
+		"2026-10-24T01:00:00Z": true,  // 周五开始的时段
+		"2026-10-24T23:00:00Z": false, // 周六
+	}
+	for ts, allowed := range cases {
+		at, _ := time.Parse(time.RFC3339, ts)
+		err := rule.Check(context.Background(), &ctrago.OrderCheck{Request: &openapi.ProtoOANewOrderReq{}, Time: at})
+		if (err == nil) != allowed {
+			t.Errorf("%s: allowed = %v, err = %v", ts, allowed, err)
+		}
+	}
+}
+
+func TestRiskRules_AmendOrder(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	account := newRiskAccount(t, ctx, srv, ctrago.MaxOrderVolume(300000, nil), ctrago.MaxSymbolVolume(300000, nil))
+	orders := account.Order()
+	limit := &ctrago.OrderOption{}
+	limit.WithLimitPrice(1.0900)
+	ev, err := orders.NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOATradeSide_BUY, 200000, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderId := ev.GetOrder().GetOrderId()
+
+	_, err = orders.AmendOrder(ctx, orderId, (&ctrago.AmendOrderOption{}).WithVolume(400000))
+	expectRejected(t, err, "max-order-volume")
+	// 被修改的挂单本身不计入品种持仓量
+	if _, err := orders.AmendOrder(ctx, orderId, (&ctrago.AmendOrderOption{}).WithVolume(300000)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxOpenExposure_PerSymbol(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.AddSymbol(ctragotest.Symbol{Id: 2, Name: "GBPUSD", BaseAssetId: 3, QuoteAssetId: ctragotest.DefaultDepositAssetId, Digits: 5, PipPosition: 4})
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	srv.SetPrice(2, 1.2500, 1.2502)
+	account := newRiskAccount(t, ctx, srv, ctrago.MaxOpenExposure(300000))
+	buy := func(symbolId, volume int64) error {
+		_, err := account.Order().NewOrder(ctx, symbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, volume, nil)
+		return err
+	}
+	if err := buy(ctragotest.DefaultSymbolId, 200000); err != nil {
+		t.Fatal(err)
+	}
+	// 其他品种的头寸不计入
+	if err := buy(2, 200000); err != nil {
+		t.Fatal(err)
+	}
+	expectRejected(t, buy(ctragotest.DefaultSymbolId, 200000), "max-open-exposure")
+}
+
+func TestMaxDailyLoss_DedupesPageBoundary(t *testing.T) {
+	srv := ctragotest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.SetPrice(ctragotest.DefaultSymbolId, 1.1000, 1.1002)
+	now := time.Now().UnixMilli()
+	deal := func(id, ts int64) *openapi.ProtoOADeal {
+		return &openapi.ProtoOADeal{
+			DealId: proto.Int64(id), OrderId: proto.Int64(id), PositionId: proto.Int64(id), Volume: proto.Int64(100000), FilledVolume: proto.Int64(100000),
+			SymbolId: proto.Int64(ctragotest.DefaultSymbolId), CreateTimestamp: proto.Int64(ts), ExecutionTimestamp: proto.Int64(ts),
+			TradeSide: openapi.ProtoOATradeSide_SELL.Enum(), DealStatus: openapi.ProtoOADealStatus_FILLED.Enum(), MoneyDigits: proto.Uint32(2),
+			// 每笔亏损 6.00
+			ClosePositionDetail: &openapi.ProtoOAClosePositionDetail{EntryPrice: proto.Float64(1.1), GrossProfit: proto.Int64(-600), Swap: proto.Int64(0), Commission: proto.Int64(0), Balance: proto.Int64(0), MoneyDigits: proto.Uint32(2)},
+		}
+	}
+	boundary := now - 1000
+	srv.Handle(openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_REQ, func(sess *ctragotest.Session, req *ctragotest.Request) *ctragotest.Response {
+		r := &openapi.ProtoOADealListReq{}
+		req.Decode(r)
+		res := &openapi.ProtoOADealListRes{CtidTraderAccountId: r.CtidTraderAccountId, HasMore: proto.Bool(false)}
+		if r.GetToTimestamp() > boundary {
+			// 第一页截断在 boundary，下一页以 boundary 为终点时会再次返回该笔成交
+			res.Deal = []*openapi.ProtoOADeal{deal(2, now-500), deal(1, boundary)}
+			res.HasMore = proto.Bool(true)
+		} else {
+			res.Deal = []*openapi.ProtoOADeal{deal(1, boundary)}
+		}
+		return ctragotest.Reply(openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_RES, res)
+	})
+	// 实际亏损 12.00，重复计算边界成交会得到 18.00
+	account := newRiskAccount(t, ctx, srv, ctrago.MaxDailyLoss(15, time.UTC))
+	if _, err := account.Order().NewOrder(ctx, ctragotest.DefaultSymbolId, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil); err != nil {
+		t.Fatalf("expected order within the daily loss limit, got %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+// SessionState 账户会话状态
+type SessionState int
+
+const (
+	// SessionPending 尚未鉴权或正在（重新）鉴权
+	SessionPending SessionState = iota
+	// SessionAuthorized 已鉴权
+	SessionAuthorized
+	// SessionFailed 鉴权失败且已放弃重试，Err 返回原因
+	SessionFailed
+	// SessionLoggedOut 已主动注销，不会再重新鉴权
+	SessionLoggedOut
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionPending:
+		return "pending"
+	case SessionAuthorized:
+		return "authorized"
+	case SessionFailed:
+		return "failed"
+	case SessionLoggedOut:
+		return "logged-out"
+	}
+	return "unknown"
+}
+
+// SessionStateHandler 账户会话状态变化回调，err 为导致变化的错误（如有）
+type SessionStateHandler func(accountId int64, from, to SessionState, err error)
+
+// AccountEventHandler 带账户ID的事件回调
+type AccountEventHandler func(accountId int64, msg *openapi.ProtoMessage)
+
+// AccountSession SessionManager 管理的单个账户
+type AccountSession struct {
+	*Account
+	manager *SessionManager
+
+	// 以下字段由 manager.lock 保护
+	state         SessionState
+	err           error
+	reauthorizing bool
+	handlers      map[uint32][]ResponseHandler
+}
+
+// State 返回会话状态
+func (s *AccountSession) State() SessionState {
+	s.manager.lock.Lock()
+	defer s.manager.lock.Unlock()
+	return s.state
+}
+
+// Err 返回最近一次鉴权失败的原因
+func (s *AccountSession) Err() error {
+	s.manager.lock.Lock()
+	defer s.manager.lock.Unlock()
+	return s.err
+}
+
+// OnEvent 注册该账户的事件回调，只会收到 ctidTraderAccountId 为该账户的事件
+func (s *AccountSession) OnEvent(payloadType uint32, handler ResponseHandler) {
+	m := s.manager
+	m.lock.Lock()
+	s.handlers[payloadType] = append(s.handlers[payloadType], handler)
+	m.lock.Unlock()
+	m.route(payloadType)
+}
+
+// SessionManager 在同一连接上管理多个账户会话
+//
+// 负责批量鉴权、跟踪每个账户的鉴权状态、按 ctidTraderAccountId 分发事件；
+// 收到 ProtoOAAccountDisconnectEvent 或 accessToken 失效时按 RetryPolicy 重新鉴权，
+// 断线重连后重新完成应用鉴权并恢复全部账户。Logout 注销的账户不会被重新鉴权
+type SessionManager struct {
+	client *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock          sync.Mutex
+	sessions      map[int64]*AccountSession
+	routed        map[uint32]struct{}
+	handlers      map[uint32][]AccountEventHandler
+	stateHandlers []SessionStateHandler
+	retry         *ReconnectPolicy
+	// stops 注销在 Client 上注册的回调，Close 时调用
+	stops []func()
+}
+
+// DefaultSessionRetryPolicy 账户重新鉴权的默认重试策略：1s 起步、翻倍增长、最长 30s、最多 5 次
+func DefaultSessionRetryPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     30 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxAttempts:     5,
+	}
+}
+
+// NewSessionManager 创建会话管理器，client 需已完成应用鉴权
+func NewSessionManager(client *Client) *SessionManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &SessionManager{
+		client:   client,
+		ctx:      ctx,
+		cancel:   cancel,
+		sessions: make(map[int64]*AccountSession),
+		routed:   make(map[uint32]struct{}),
+		handlers: make(map[uint32][]AccountEventHandler),
+		retry:    DefaultSessionRetryPolicy(),
+	}
+	m.stops = []func(){
+		client.watchDisconnect(m.handleDisconnect),
+		client.watchState(m.handleStateChange),
+	}
+	return m
+}
+
+// SetRetryPolicy 设置重新鉴权的重试策略，NoReconnect() 表示不重试
+func (m *SessionManager) SetRetryPolicy(policy *ReconnectPolicy) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.retry = policy
+}
+
+// OnSessionState 注册账户会话状态变化回调
+func (m *SessionManager) OnSessionState(handler SessionStateHandler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stateHandlers = append(m.stateHandlers, handler)
+}
+
+// OnEvent 注册所有受管账户的事件回调，其他账户的事件不会传入
+func (m *SessionManager) OnEvent(payloadType uint32, handler AccountEventHandler) {
+	m.lock.Lock()
+	m.handlers[payloadType] = append(m.handlers[payloadType], handler)
+	m.lock.Unlock()
+	m.route(payloadType)
+}
+
+// Add 加入并鉴权账户，已加入的账户会重新鉴权；返回所有失败账户的错误
+func (m *SessionManager) Add(ctx context.Context, accountIds ...int64) error {
+	var errs []error
+	for _, accountId := range accountIds {
+		m.lock.Lock()
+		s, ok := m.sessions[accountId]
+		if !ok {
+			s = &AccountSession{
+				Account:  m.client.Account(accountId),
+				manager:  m,
+				handlers: make(map[uint32][]ResponseHandler),
+			}
+			m.sessions[accountId] = s
+		}
+		m.lock.Unlock()
+		if err := m.authorize(ctx, s); err != nil {
+			m.setState(s, SessionFailed, err)
+			errs = append(errs, fmt.Errorf("account %d: %w", accountId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AddAll 通过 GetAccountList 加入访问令牌下的账户，filter 为 nil 时加入全部（如只保留与连接环境一致的 IsLive）
+func (m *SessionManager) AddAll(ctx context.Context, filter func(*openapi.ProtoOACtidTraderAccount) bool) error {
+	res, err := m.client.GetAccountList(ctx)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for _, account := range res.GetCtidTraderAccount() {
+		if filter == nil || filter(account) {
+			ids = append(ids, int64(account.GetCtidTraderAccountId()))
+		}
+	}
+	return m.Add(ctx, ids...)
+}
+
+// Session 返回账户会话，未加入时返回 nil
+func (m *SessionManager) Session(accountId int64) *AccountSession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sessions[accountId]
+}
+
+// Sessions 按账户ID排序返回所有会话
+func (m *SessionManager) Sessions() []*AccountSession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sessions := make([]*AccountSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].accountId < sessions[j].accountId })
+	return sessions
+}
+
+// Logout 注销账户（ProtoOAAccountLogoutReq）并移出管理器；请求失败时会话恢复为已鉴权并保留，可重试
+func (m *SessionManager) Logout(ctx context.Context, accountId int64) error {
+	m.lock.Lock()
+	s, ok := m.sessions[accountId]
+	m.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("account %d is not managed", accountId)
+	}
+	// 先标记为已注销，服务端随后推送的 AccountDisconnectEvent 不会触发重新鉴权
+	prev := m.setState(s, SessionLoggedOut, nil)
+	if prev == SessionAuthorized {
+		if _, err := s.Account.Logout(ctx); err != nil {
+			// 注销失败时恢复为已鉴权，会话仍受管理，可再次调用 Logout
+			m.transition(s, SessionAuthorized, err, true)
+			return err
+		}
+	}
+	m.lock.Lock()
+	delete(m.sessions, accountId)
+	m.lock.Unlock()
+	return nil
+}
+
+// Close 注销所有已鉴权的账户，停止后台重新鉴权并注销在 Client 上的回调，不关闭 Client
+func (m *SessionManager) Close(ctx context.Context) error {
+	m.cancel()
+	var errs []error
+	for _, s := range m.Sessions() {
+		if err := m.Logout(ctx, s.accountId); err != nil {
+			errs = append(errs, fmt.Errorf("account %d: %w", s.accountId, err))
+		}
+	}
+	m.lock.Lock()
+	stops := m.stops
+	m.stops = nil
+	m.lock.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+	return errors.Join(errs...)
+}
+
+// authorize 鉴权成功时置为 SessionAuthorized，失败时由调用方决定状态
+func (m *SessionManager) authorize(ctx context.Context, s *AccountSession) error {
+	if s.State() == SessionLoggedOut {
+		return nil
+	}
+	if _, err := s.Auth(ctx); err != nil {
+		return err
+	}
+	m.setState(s, SessionAuthorized, nil)
+	return nil
+}
+
+// setState 更新状态并通知回调，已注销的会话不再变化；返回原状态
+func (m *SessionManager) setState(s *AccountSession, to SessionState, err error) SessionState {
+	return m.transition(s, to, err, false)
+}
+
+// transition 见 setState，revive 为 true 时允许已注销的会话恢复（注销请求失败）
+func (m *SessionManager) transition(s *AccountSession, to SessionState, err error, revive bool) SessionState {
+	m.lock.Lock()
+	from := s.state
+	if from == SessionLoggedOut && !revive {
+		m.lock.Unlock()
+		return from
+	}
+	s.state = to
+	s.err = err
+	handlers := append([]SessionStateHandler(nil), m.stateHandlers...)
+	m.lock.Unlock()
+	if from == to {
+		return from
+	}
+	if err != nil {
+		m.client.log().Warn("ctrago account session state changed", "accountId", s.accountId, "from", from.String(), "to", to.String(), "error", err)
+	} else {
+		m.client.log().Info("ctrago account session state changed", "accountId", s.accountId, "from", from.String(), "to", to.String())
+	}
+	for _, h := range handlers {
+		h(s.accountId, from, to, err)
+	}
+	return from
+}
+
+// route 确保 payloadType 的事件经过管理器分发
+func (m *SessionManager) route(payloadType uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.routed[payloadType]; ok || m.ctx.Err() != nil {
+		return
+	}
+	m.routed[payloadType] = struct{}{}
+	m.stops = append(m.stops, m.client.WatchEvent(payloadType, m.dispatch))
+}
+
+func (m *SessionManager) dispatch(msg *openapi.ProtoMessage) {
+	accountId, ok := EventAccountId(msg)
+	if !ok {
+		return
+	}
+	m.lock.Lock()
+	s, managed := m.sessions[accountId]
+	if !managed {
+		m.lock.Unlock()
+		return
+	}
+	handlers := append([]AccountEventHandler(nil), m.handlers[msg.GetPayloadType()]...)
+	sessionHandlers := append([]ResponseHandler(nil), s.handlers[msg.GetPayloadType()]...)
+	m.lock.Unlock()
+	for _, h := range handlers {
+		h(accountId, msg)
+	}
+	for _, h := range sessionHandlers {
+		h(msg)
+	}
+}
+
+// handleDisconnect 账户会话断开或令牌失效时在后台重新鉴权
+func (m *SessionManager) handleDisconnect(notice DisconnectNotice) {
+	if notice.Kind == DisconnectClient {
+		// 应用连接已断开，等待传输层重连后由 handleStateChange 恢复
+		m.markPending(notice.AccountIds, errors.New(notice.Reason))
+		return
+	}
+	m.lock.Lock()
+	var sessions []*AccountSession
+	for _, id := range notice.AccountIds {
+		if s, ok := m.sessions[id]; ok && s.state != SessionLoggedOut && !s.reauthorizing {
+			s.reauthorizing = true
+			sessions = append(sessions, s)
+		}
+	}
+	m.lock.Unlock()
+	for _, s := range sessions {
+		m.setState(s, SessionPending, fmt.Errorf("%s: %s", notice.Kind, notice.Reason))
+		// 回调在消息循环中执行，请求需在其他 goroutine 中等待响应
+		go m.reauthorize(s)
+	}
+}
+
+// handleStateChange 断线时把会话置为待鉴权，重连后恢复应用鉴权与全部账户
+func (m *SessionManager) handleStateChange(from, to ConnState) {
+	switch {
+	case to == StateReconnecting:
+		m.markPending(nil, ErrConnectionLost)
+	case to == StateConnected && from == StateReconnecting:
+		go m.restore()
+	}
+}
+
+func (m *SessionManager) markPending(accountIds []int64, err error) {
+	for _, s := range m.Sessions() {
+		if accountIds != nil && !slices.Contains(accountIds, s.accountId) {
+			continue
+		}
+		if s.State() == SessionAuthorized {
+			m.setState(s, SessionPending, err)
+		}
+	}
+}
+
+func (m *SessionManager) restore() {
+	ctx, cancel := context.WithTimeout(m.ctx, time.Minute)
+	defer cancel()
+	if m.client.State() != StateAppAuthorized {
+		_, err := m.client.ApplicationAuth(ctx)
+		var apiErr *APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode == openapi.ProtoOAErrorCode_CH_CLIENT_ALREADY_AUTHENTICATED.String()) {
+			m.client.log().Warn("ctrago session manager application auth failed after reconnect", "error", err)
+			return
+		}
+	}
+	for _, s := range m.Sessions() {
+		m.lock.Lock()
+		skip := s.state == SessionLoggedOut || s.reauthorizing
+		s.reauthorizing = !skip
+		m.lock.Unlock()
+		if !skip {
+			go m.reauthorize(s)
+		}
+	}
+}
+
+// reauthorize 按重试策略重新鉴权，调用前需已置 s.reauthorizing
+func (m *SessionManager) reauthorize(s *AccountSession) {
+	defer func() {
+		m.lock.Lock()
+		s.reauthorizing = false
+		m.lock.Unlock()
+	}()
+	m.lock.Lock()
+	policy := m.retry
+	m.lock.Unlock()
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(m.ctx, time.Minute)
+		err := m.authorize(ctx, s)
+		cancel()
+		if err == nil || m.ctx.Err() != nil {
+			return
+		}
+		if policy == nil || policy.disabled || (policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts) {
+			m.setState(s, SessionFailed, err)
+			return
+		}
+		// 重试期间保持 pending，放弃时才是 failed
+		m.setState(s, SessionPending, err)
+		select {
+		case <-time.After(policy.Delay(attempt)):
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package ctrago
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSessionManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var lock sync.Mutex
+	auths := make(map[int64]int)
+	var logouts []int64
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, msg)
+		switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ:
+			req := &openapi.ProtoOAAccountAuthReq{}
+			proto.Unmarshal(msg.Payload, req)
+			lock.Lock()
+			auths[req.GetCtidTraderAccountId()]++
+			lock.Unlock()
+			if req.GetCtidTraderAccountId() == 9 {
+				mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{
+					ErrorCode: proto.String(openapi.ProtoOAErrorCode_CH_CTID_TRADER_ACCOUNT_NOT_FOUND.String()),
+				}, msg.GetClientMsgId())
+				return nil
+			}
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{CtidTraderAccountId: req.CtidTraderAccountId}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_LOGOUT_REQ:
+			req := &openapi.ProtoOAAccountLogoutReq{}
+			proto.Unmarshal(msg.Payload, req)
+			lock.Lock()
+			logouts = append(logouts, req.GetCtidTraderAccountId())
+			lock.Unlock()
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_LOGOUT_RES, &openapi.ProtoOAAccountLogoutRes{CtidTraderAccountId: req.CtidTraderAccountId}, msg.GetClientMsgId())
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_DISCONNECT_EVENT, &openapi.ProtoOAAccountDisconnectEvent{CtidTraderAccountId: req.CtidTraderAccountId}, "")
+		}
+		return nil
+	}
+	authCount := func(accountId int64) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return auths[accountId]
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	m := NewSessionManager(client)
+	m.SetRetryPolicy(NoReconnect())
+
+	if err := m.Add(ctx, 1, 2, 9); err == nil {
+		t.Fatal("expected error for account 9")
+	}
+	for id, want := range map[int64]SessionState{1: SessionAuthorized, 2: SessionAuthorized, 9: SessionFailed} {
+		if got := m.Session(id).State(); got != want {
+			t.Errorf("account %d state = %s, want %s", id, got, want)
+		}
+	}
+
+	// 事件按 ctidTraderAccountId 分发，未受管的账户 3 不会传入
+	spot := uint32(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT)
+	var all, one []int64
+	m.OnEvent(spot, func(accountId int64, msg *openapi.ProtoMessage) { all = append(all, accountId) })
+	m.Session(1).OnEvent(spot, func(msg *openapi.ProtoMessage) { one = append(one, 1) })
+	for _, id := range []int64{1, 2, 3} {
+		mock.push(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, &openapi.ProtoOASpotEvent{CtidTraderAccountId: proto.Int64(id), SymbolId: proto.Int64(1)}, "")
+	}
+	if len(all) != 2 || all[0] != 1 || all[1] != 2 || len(one) != 1 {
+		t.Fatalf("dispatched all=%v one=%v", all, one)
+	}
+
+	// 服务端断开账户 2 后自动重新鉴权
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_DISCONNECT_EVENT, &openapi.ProtoOAAccountDisconnectEvent{CtidTraderAccountId: proto.Int64(2)}, "")
+	for authCount(2) < 2 || m.Session(2).State() != SessionAuthorized {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("account 2 not re-authorized: %d auths, state %s", authCount(2), m.Session(2).State())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	// 注销后服务端推送的断开事件不会触发重新鉴权
+	if err := m.Logout(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if authCount(1) != 1 || m.Session(1) != nil {
+		t.Fatalf("account 1 re-authorized after logout: %d auths", authCount(1))
+	}
+	if got := client.AuthorizedAccounts(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("authorized accounts = %v", got)
+	}
+
+	if err := m.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.watchers) != 0 {
+		t.Errorf("expected manager callbacks to be removed on Close, %d left", len(client.watchers))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(logouts) != 2 || logouts[0] != 1 || logouts[1] != 2 {
+		t.Fatalf("logouts = %v", logouts)
+	}
+}
+
+func TestSessionManager_LogoutFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, msg)
+		switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ:
+			go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{CtidTraderAccountId: proto.Int64(1)}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_LOGOUT_REQ:
+			go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{ErrorCode: proto.String("INTERNAL_ERROR")}, msg.GetClientMsgId())
+		}
+		return nil
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	m := NewSessionManager(client)
+	if err := m.Add(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Logout(ctx, 1); err == nil {
+		t.Fatal("expected logout error")
+	}
+	// 注销失败的会话仍受管理，可以重试
+	if s := m.Session(1); s == nil || s.State() != SessionAuthorized {
+		t.Fatalf("expected session to stay authorized after a failed logout, got %v", s)
+	}
+}
+
+func TestEventAccountId(t *testing.T) {
+	data, _ := proto.Marshal(&openapi.ProtoOAOrderErrorEvent{CtidTraderAccountId: proto.Int64(42), ErrorCode: proto.String("X")})
+	msg := &openapi.ProtoMessage{PayloadType: proto.Uint32(uint32(openapi.ProtoOAPayloadType_PROTO_OA_ORDER_ERROR_EVENT)), Payload: data}
+	if id, ok := EventAccountId(msg); !ok || id != 42 {
+		t.Fatalf("EventAccountId = %d, %v", id, ok)
+	}
+	if NewPayload(uint32(openapi.ProtoPayloadType_HEARTBEAT_EVENT)) == nil {
+		t.Fatal("expected heartbeat payload")
+	}
+}
@@ -0,0 +1,132 @@
+package ctrago
+
+import (
+	"context"
+	"sync"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// spotRegistry 按账户与品种统计报价订阅的引用，零值可用
+type spotRegistry struct {
+	lock sync.Mutex
+	subs map[spotKey]*spotRef
+}
+
+type spotKey struct {
+	accountId int64
+	symbolId  int64
+}
+
+// spotRef 单个品种的订阅，refs 由 spotRegistry.lock 保护
+type spotRef struct {
+	refs int
+	// owned 订阅由首个引用发起，引用归零时退订；此前已被其他途径订阅的品种不退订
+	owned bool
+	// ready 订阅请求完成后关闭，err 为请求结果
+	ready chan struct{}
+	err   error
+}
+
+// acquire 增加引用，首个引用调用 subscribe 发起订阅；之后的调用方等待该请求，失败时返回相同的错误
+func (r *spotRegistry) acquire(ctx context.Context, key spotKey, subscribe func(ctx context.Context) (owned bool, err error), unsubscribe func()) (release func(), err error) {
+	r.lock.Lock()
+	if r.subs == nil {
+		r.subs = make(map[spotKey]*spotRef)
+	}
+	ref := r.subs[key]
+	first := ref == nil
+	if first {
+		ref = &spotRef{ready: make(chan struct{})}
+		r.subs[key] = ref
+	}
+	ref.refs++
+	r.lock.Unlock()
+
+	if first {
+		owned, err := subscribe(ctx)
+		r.lock.Lock()
+		ref.owned, ref.err = owned, err
+		// 失败的订阅立即移除，之后的调用方重新发起请求
+		if err != nil && r.subs[key] == ref {
+			delete(r.subs, key)
+		}
+		r.lock.Unlock()
+		close(ref.ready)
+	}
+	select {
+	case <-ref.ready:
+		err = ref.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		r.release(key, ref)
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if r.release(key, ref) && ref.owned {
+				unsubscribe()
+			}
+		})
+	}, nil
+}
+
+// release 减少引用，归零时移除并返回 true
+func (r *spotRegistry) release(key spotKey, ref *spotRef) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ref.refs--
+	if ref.refs > 0 {
+		return false
+	}
+	if r.subs[key] == ref {
+		delete(r.subs, key)
+	}
+	return true
+}
+
+func (a *Account) spots() *spotRegistry {
+	if a.pool != nil {
+		return &a.pool.spots
+	}
+	return &a.client.spots
+}
+
+// AcquireSpots 按引用计数订阅品种报价，返回的 release 释放引用，可重复调用
+//
+// 同一 Client（或 ClientPool）上的调用方共享订阅：首个引用发起订阅，最后一个引用释放时退订；
+// 此前已通过其他途径订阅的品种不会被退订。Exposure 与 algo.Engine 均通过它订阅报价
+func (a *Account) AcquireSpots(ctx context.Context, symbolId int64) (release func(), err error) {
+	return a.spots().acquire(ctx, spotKey{accountId: a.accountId, symbolId: symbolId}, func(ctx context.Context) (bool, error) {
+		if a.pool != nil {
+			if a.pool.hasSub(poolSub{accountId: a.accountId, symbolId: symbolId}) {
+				return false, nil
+			}
+			err := a.pool.SubscribeSpots(ctx, a.accountId, symbolId)
+			return err == nil, err
+		}
+		_, err := a.client.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_SUBSCRIBE_SPOTS_REQ), &openapi.ProtoOASubscribeSpotsReq{
+			CtidTraderAccountId: proto.Int64(a.accountId),
+			SymbolId:            []int64{symbolId},
+		})
+		// 已被其他途径订阅时同样能收到报价，但不由引用计数退订
+		if IsErrorCode(err, openapi.ProtoOAErrorCode_ALREADY_SUBSCRIBED) {
+			return false, nil
+		}
+		return err == nil, err
+	}, func() {
+		ctx := context.Background()
+		if a.pool != nil {
+			a.pool.UnsubscribeSpots(ctx, a.accountId, symbolId)
+			return
+		}
+		a.client.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_UNSUBSCRIBE_SPOTS_REQ), &openapi.ProtoOAUnsubscribeSpotsReq{
+			CtidTraderAccountId: proto.Int64(a.accountId),
+			SymbolId:            []int64{symbolId},
+		})
+	})
+}
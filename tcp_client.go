@@ -1,68 +1,90 @@
 package ctrago
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
-	"sync"
-	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// MaxTcpFrameSize TCP 单帧最大长度，超过时视为连接异常
+const MaxTcpFrameSize = 16 << 20
+
 // TcpClient 实现 Transport 接口，支持 cTrader OpenAPI 的 TCP 通信
-// 仅实现最基础的 Send/OnMessage/Listen/Close，心跳可选实现
+// 每帧为 4 字节大端序长度前缀 + ProtoMessage，收到的帧以 websocket.BinaryMessage 回调，与 WsClient 保持一致
 
 type TcpClient struct {
-	conn     net.Conn
-	lock     sync.Mutex
-	handlers []MessageHandler
-	closeCh  chan struct{}
+	streamTransport
+
+	addr string
+	dial DialFunc
 }
 
 func NewTcpClient(addr string) (*TcpClient, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
+	return NewTcpClientWithDialer(addr, nil)
+}
+
+// NewTcpClientWithDialer 使用自定义的 dial 建立连接（如 TLS、代理），重连时同样使用该 dial
+func NewTcpClientWithDialer(addr string, dial DialFunc) (*TcpClient, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	client := &TcpClient{
+		addr: addr,
+		dial: dial,
+	}
+	client.init("tcp", client.openConn)
+	if err := client.connect(context.Background()); err != nil {
 		return nil, err
 	}
-	return &TcpClient{
-		conn:     conn,
-		handlers: make([]MessageHandler, 0),
-		closeCh:  make(chan struct{}),
-	}, nil
+	return client, nil
 }
 
-func (c *TcpClient) Send(messageType int, data []byte) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	if c.conn == nil {
-		return fmt.Errorf("tcp not connected")
+func (c *TcpClient) openConn(ctx context.Context) (wireConn, error) {
+	conn, err := c.dial(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
 	}
-	_, err := c.conn.Write(data)
-	return err
+	return tcpConn{conn}, nil
 }
 
-func (c *TcpClient) OnMessage(handler MessageHandler) {
-	c.handlers = append(c.handlers, handler)
+// tcpConn 按长度前缀分帧读写的 TCP 连接
+type tcpConn struct {
+	net.Conn
 }
 
-func (c *TcpClient) Listen() error {
-	buf := make([]byte, 4096)
-	for {
-		n, err := c.conn.Read(buf)
-		if err != nil {
-			return err
-		}
-		for _, handler := range c.handlers {
-			handler(0, buf[:n])
-		}
-	}
+func (c tcpConn) ReadMessage() (int, []byte, error) {
+	data, err := readFrame(c.Conn)
+	return websocket.BinaryMessage, data, err
 }
 
-func (c *TcpClient) Close() error {
-	close(c.closeCh)
-	return c.conn.Close()
+func (c tcpConn) WriteMessage(_ int, data []byte) error {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := c.Write(frame)
+	return err
 }
 
-func (c *TcpClient) SetHeartbeat(heartbeatInterval time.Duration, heartbeatFn func() (int, []byte)) {
-	// TCP心跳可选实现，暂留空
+// readFrame 读取一帧 ProtoMessage
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxTcpFrameSize {
+		return nil, fmt.Errorf("tcp frame too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 var _ Transport = (*TcpClient)(nil)
+var _ TransportNotifier = (*TcpClient)(nil)
@@ -0,0 +1,146 @@
+package ctrago
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// listenTcpClient 连接 ln 并启动消息循环，收到的帧写入返回的 channel
+func listenTcpClient(t *testing.T, ln net.Listener, dial DialFunc) (*TcpClient, net.Conn, <-chan []byte) {
+	t.Helper()
+	accepted := accept(ln)
+	c, err := NewTcpClientWithDialer(ln.Addr().String(), dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() { server.Close() })
+	frames := make(chan []byte, 10)
+	c.OnMessage(func(messageType int, data []byte) {
+		if messageType != websocket.BinaryMessage {
+			t.Errorf("expected binary message, got %d", messageType)
+		}
+		frames <- data
+	})
+	go c.Listen()
+	return c, server, frames
+}
+
+// accept 接受一个连接，TLS 连接在服务端完成握手，客户端拨号时才能完成 HandshakeContext
+func accept(ln net.Listener) <-chan net.Conn {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			if tlsConn, ok := conn.(*tls.Conn); ok && tlsConn.Handshake() != nil {
+				conn.Close()
+				conn = nil
+			}
+		}
+		accepted <- conn
+	}()
+	return accepted
+}
+
+func frame(data string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	return append(b, data...)
+}
+
+func receiveFrame(t *testing.T, frames <-chan []byte) string {
+	t.Helper()
+	select {
+	case data := <-frames:
+		return string(data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for frame")
+		return ""
+	}
+}
+
+func TestTcpClient_Framing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, server, frames := listenTcpClient(t, ln, nil)
+
+	if err := c.Send(websocket.BinaryMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, 9)
+	if _, err := io.ReadFull(server, got); err != nil || !bytes.Equal(got, frame("hello")) {
+		t.Fatalf("expected length-prefixed frame, got %v, %v", got, err)
+	}
+
+	// 一次写入多帧、一帧分多次写入都按长度前缀切分
+	server.Write(append(frame("a"), frame("bc")...))
+	split := frame("split")
+	server.Write(split[:3])
+	time.Sleep(10 * time.Millisecond)
+	server.Write(split[3:])
+	for _, want := range []string{"a", "bc", "split"} {
+		if got := receiveFrame(t, frames); got != want {
+			t.Fatalf("expected frame %q, got %q", want, got)
+		}
+	}
+}
+
+func TestReadFrame_TooLarge(t *testing.T) {
+	header := binary.BigEndian.AppendUint32(nil, MaxTcpFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(header)); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected frame size error, got %v", err)
+	}
+	if _, err := readFrame(bytes.NewReader(frame("short")[:6])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF for a truncated frame, got %v", err)
+	}
+}
+
+func TestTcpClient_TLS(t *testing.T) {
+	// 借用 httptest 的自签名证书
+	https := httptest.NewTLSServer(nil)
+	defer https.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", https.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+
+	accepted := accept(ln)
+	if _, err := NewTcpClientWithDialer(ln.Addr().String(), tlsDial((&net.Dialer{}).DialContext, nil)); err == nil {
+		t.Fatal("expected untrusted certificate to fail the handshake")
+	}
+	<-accepted
+
+	c, server, frames := listenTcpClient(t, ln, tlsDial((&net.Dialer{}).DialContext, &tls.Config{RootCAs: roots}))
+	server.Write(frame("secure"))
+	if got := receiveFrame(t, frames); got != "secure" {
+		t.Fatalf("expected frame over TLS, got %q", got)
+	}
+	if err := c.Send(websocket.BinaryMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := readFrame(server)
+	if err != nil || string(data) != "ping" {
+		t.Fatalf("expected framed ping over TLS, got %q, %v", data, err)
+	}
+}
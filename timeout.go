@@ -0,0 +1,104 @@
+package ctrago
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+)
+
+const (
+	// DefaultRequestTimeout 默认请求超时
+	DefaultRequestTimeout = 30 * time.Second
+	// DefaultHistoryRequestTimeout 历史数据类请求的默认超时
+	DefaultHistoryRequestTimeout = 2 * time.Minute
+)
+
+// HistoryPayloadTypes 历史数据类请求，数据量大、服务端响应慢，默认使用 DefaultHistoryRequestTimeout
+var HistoryPayloadTypes = []openapi.ProtoOAPayloadType{
+	openapi.ProtoOAPayloadType_PROTO_OA_GET_TRENDBARS_REQ,
+	openapi.ProtoOAPayloadType_PROTO_OA_GET_TICKDATA_REQ,
+	openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_REQ,
+	openapi.ProtoOAPayloadType_PROTO_OA_ORDER_LIST_REQ,
+	openapi.ProtoOAPayloadType_PROTO_OA_CASH_FLOW_HISTORY_LIST_REQ,
+	openapi.ProtoOAPayloadType_PROTO_OA_DEAL_LIST_BY_POSITION_ID_REQ,
+	openapi.ProtoOAPayloadType_PROTO_OA_ORDER_LIST_BY_POSITION_ID_REQ,
+}
+
+// TimeoutError 请求在截止时间前未收到响应
+type TimeoutError struct {
+	PayloadType uint32
+	ClientMsgId string
+	// Timeout 本次请求生效的超时时间，调用方 context 自带截止时间时为 0
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("request %s (clientMsgId=%s) timed out after %s", PayloadTypeName(e.PayloadType), e.ClientMsgId, e.Timeout)
+	}
+	return fmt.Sprintf("request %s (clientMsgId=%s) timed out", PayloadTypeName(e.PayloadType), e.ClientMsgId)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+type requestTimeoutKey struct{}
+
+// ContextWithRequestTimeout 为单次调用指定超时，优先于 Client 的默认值和按请求类型的配置
+//
+// timeout 为 0 表示本次调用不设置超时；ctx 本身已有截止时间时以 ctx 为准
+func ContextWithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+// WithPayloadTimeout 为指定类型的请求设置默认超时，如 WithPayloadTimeout(5*time.Minute, ctrago.HistoryPayloadTypes...)
+func WithPayloadTimeout(timeout time.Duration, payloadTypes ...openapi.ProtoOAPayloadType) Option {
+	return func(c *clientConfig) {
+		if c.payloadTimeouts == nil {
+			c.payloadTimeouts = make(map[uint32]time.Duration)
+		}
+		for _, pt := range payloadTypes {
+			c.payloadTimeouts[uint32(pt)] = timeout
+		}
+	}
+}
+
+// SetRequestTimeout 修改默认请求超时，0 表示不设置超时
+func (c *Client) SetRequestTimeout(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requestTimeout = timeout
+}
+
+// SetPayloadTimeout 修改指定类型请求的默认超时
+func (c *Client) SetPayloadTimeout(timeout time.Duration, payloadTypes ...openapi.ProtoOAPayloadType) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, pt := range payloadTypes {
+		c.payloadTimeouts[uint32(pt)] = timeout
+	}
+}
+
+// requestTimeoutFor 按 单次调用 > 请求类型 > Client 默认值 的优先级确定超时
+func (c *Client) requestTimeoutFor(ctx context.Context, payloadType uint32) time.Duration {
+	if timeout, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if timeout, ok := c.payloadTimeouts[payloadType]; ok {
+		return timeout
+	}
+	return c.requestTimeout
+}
+
+func defaultPayloadTimeouts() map[uint32]time.Duration {
+	timeouts := make(map[uint32]time.Duration, len(HistoryPayloadTypes))
+	for _, pt := range HistoryPayloadTypes {
+		timeouts[uint32(pt)] = DefaultHistoryRequestTimeout
+	}
+	return timeouts
+}
@@ -0,0 +1,356 @@
+package ctrago
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrNoToken TokenStore 中没有保存令牌
+	ErrNoToken = errors.New("no access token available")
+	// ErrNoRefreshToken 令牌需要刷新但没有刷新令牌
+	ErrNoRefreshToken = errors.New("no refresh token available")
+)
+
+const (
+	// DefaultTokenRefreshLeeway 默认在令牌过期前多久刷新
+	DefaultTokenRefreshLeeway = time.Hour
+	// tokenCheckInterval Client 检查令牌是否需要刷新的间隔
+	tokenCheckInterval = time.Minute
+)
+
+// Token 访问令牌与刷新令牌
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Expiry 访问令牌的过期时间，零值表示未知，不会主动刷新
+	Expiry time.Time `json:"expiry"`
+}
+
+// ExpiresWithin 令牌是否将在 d 内过期
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(d).After(t.Expiry)
+}
+
+// TokenSource 提供当前可用的访问令牌，Client 在账户鉴权与查询账户列表时从中获取
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc 以函数实现 TokenSource
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource 始终返回同一个访问令牌
+func StaticTokenSource(accessToken string) TokenSource {
+	token := &Token{AccessToken: accessToken}
+	return TokenSourceFunc(func(context.Context) (*Token, error) {
+		return token, nil
+	})
+}
+
+// TokenStore 持久化令牌，刷新后写回以便进程重启后继续使用
+type TokenStore interface {
+	// LoadToken 读取令牌，没有保存过时返回 ErrNoToken
+	LoadToken() (*Token, error)
+	SaveToken(token *Token) error
+}
+
+// FileTokenStore 以 JSON 文件保存令牌，文件权限为 0600
+type FileTokenStore struct {
+	path string
+}
+
+// NewFileTokenStore 创建文件令牌存储
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+func (s *FileTokenStore) LoadToken() (*Token, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("parse token file %s: %w", s.path, err)
+	}
+	if token.AccessToken == "" {
+		return nil, ErrNoToken
+	}
+	return token, nil
+}
+
+// SaveToken 先写临时文件再重命名，避免中途退出留下损坏的文件
+func (s *FileTokenStore) SaveToken(token *Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// EnvTokenStore 从环境变量读取令牌，SaveToken 只更新当前进程的环境变量
+//
+// 适用于由外部注入令牌的容器环境；需要跨重启保留刷新结果时使用 FileTokenStore 或自定义 TokenStore
+type EnvTokenStore struct {
+	AccessTokenVar  string
+	RefreshTokenVar string
+	// ExpiryVar 过期时间（RFC3339），为空或未设置时视为未知
+	ExpiryVar string
+}
+
+// NewEnvTokenStore 使用 CTRAGO_ACCESS_TOKEN、CTRAGO_REFRESH_TOKEN、CTRAGO_TOKEN_EXPIRY
+func NewEnvTokenStore() *EnvTokenStore {
+	return &EnvTokenStore{
+		AccessTokenVar:  "CTRAGO_ACCESS_TOKEN",
+		RefreshTokenVar: "CTRAGO_REFRESH_TOKEN",
+		ExpiryVar:       "CTRAGO_TOKEN_EXPIRY",
+	}
+}
+
+func (s *EnvTokenStore) LoadToken() (*Token, error) {
+	token := &Token{AccessToken: os.Getenv(s.AccessTokenVar)}
+	if token.AccessToken == "" {
+		return nil, ErrNoToken
+	}
+	if s.RefreshTokenVar != "" {
+		token.RefreshToken = os.Getenv(s.RefreshTokenVar)
+	}
+	if s.ExpiryVar != "" {
+		if v := os.Getenv(s.ExpiryVar); v != "" {
+			expiry, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s.ExpiryVar, err)
+			}
+			token.Expiry = expiry
+		}
+	}
+	return token, nil
+}
+
+func (s *EnvTokenStore) SaveToken(token *Token) error {
+	if err := os.Setenv(s.AccessTokenVar, token.AccessToken); err != nil {
+		return err
+	}
+	if s.RefreshTokenVar != "" {
+		if err := os.Setenv(s.RefreshTokenVar, token.RefreshToken); err != nil {
+			return err
+		}
+	}
+	if s.ExpiryVar != "" && !token.Expiry.IsZero() {
+		return os.Setenv(s.ExpiryVar, token.Expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RefreshFunc 用刷新令牌换取新令牌，如 Client.TokenRefresher 或 oauth.Config.RefreshFunc
+type RefreshFunc func(ctx context.Context, refreshToken string) (*Token, error)
+
+// RefreshingTokenSource 在令牌过期前 leeway 用刷新令牌换取新令牌并写回 TokenStore
+//
+// 多个 goroutine 同时调用 Token 时只会刷新一次
+type RefreshingTokenSource struct {
+	lock    sync.Mutex
+	token   *Token
+	store   TokenStore
+	refresh RefreshFunc
+	leeway  time.Duration
+}
+
+// NewRefreshingTokenSource 创建自动刷新的 TokenSource，首次调用 Token 时从 store 读取令牌
+func NewRefreshingTokenSource(store TokenStore, refresh RefreshFunc) *RefreshingTokenSource {
+	return &RefreshingTokenSource{store: store, refresh: refresh, leeway: DefaultTokenRefreshLeeway}
+}
+
+// SetLeeway 设置提前刷新的时间，默认 DefaultTokenRefreshLeeway
+func (s *RefreshingTokenSource) SetLeeway(leeway time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.leeway = leeway
+}
+
+func (s *RefreshingTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.token == nil {
+		token, err := s.store.LoadToken()
+		if err != nil {
+			return nil, err
+		}
+		s.token = token
+	}
+	if !s.token.ExpiresWithin(s.leeway) {
+		return s.token, nil
+	}
+	if s.token.RefreshToken == "" {
+		return nil, ErrNoRefreshToken
+	}
+	token, err := s.refresh(ctx, s.token.RefreshToken)
+	if err != nil {
+		err = fmt.Errorf("refresh access token: %w", err)
+		if !s.token.ExpiresWithin(0) {
+			// 尚未过期，继续使用旧令牌，下次调用时重试
+			return s.token, err
+		}
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.token.RefreshToken
+	}
+	s.token = token
+	if err := s.store.SaveToken(token); err != nil {
+		// 新令牌已生效，旧的刷新令牌已失效，保存失败也继续使用
+		return token, fmt.Errorf("save refreshed token: %w", err)
+	}
+	return token, nil
+}
+
+// WithTokenSource 由 TokenSource 提供 accessToken，替代 WithAccessToken
+//
+// Client 会定期从 TokenSource 获取令牌，令牌变化时以新令牌重新鉴权已鉴权的账户
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *clientConfig) {
+		c.tokenSource = ts
+	}
+}
+
+// WithTokenStore 从 store 读取令牌，过期前通过当前连接刷新并写回 store，
+// 相当于 WithTokenSource(NewRefreshingTokenSource(store, client.TokenRefresher()))
+func WithTokenStore(store TokenStore) Option {
+	return func(c *clientConfig) {
+		c.tokenStore = store
+	}
+}
+
+// SetTokenSource 设置 TokenSource，效果同 WithTokenSource
+func (c *Client) SetTokenSource(ts TokenSource) {
+	c.lock.Lock()
+	c.tokenSource = ts
+	start := !c.tokenLoopStarted
+	c.tokenLoopStarted = true
+	c.lock.Unlock()
+	if start {
+		go c.tokenLoop(tokenCheckInterval)
+	}
+}
+
+// TokenRefresher 返回通过当前连接（ProtoOARefreshTokenReq）刷新令牌的 RefreshFunc，需在应用鉴权后使用
+func (c *Client) TokenRefresher() RefreshFunc {
+	return func(ctx context.Context, refreshToken string) (*Token, error) {
+		res, err := c.refreshToken(ctx, refreshToken)
+		if err != nil {
+			return nil, err
+		}
+		return tokenFromRefresh(res), nil
+	}
+}
+
+func tokenFromRefresh(res *openapi.ProtoOARefreshTokenRes) *Token {
+	token := &Token{AccessToken: res.GetAccessToken(), RefreshToken: res.GetRefreshToken()}
+	if res.GetExpiresIn() > 0 {
+		token.Expiry = time.Now().Add(time.Duration(res.GetExpiresIn()) * time.Second)
+	}
+	return token
+}
+
+// currentAccessToken 返回当前使用的访问令牌；TokenSource 返回新令牌时重新鉴权已鉴权的账户
+func (c *Client) currentAccessToken(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	ts := c.tokenSource
+	accessToken := c.accessToken
+	c.lock.Unlock()
+	if ts == nil {
+		return accessToken, nil
+	}
+	token, err := ts.Token(ctx)
+	if token == nil {
+		return "", err
+	}
+	if err != nil {
+		c.log().Warn("ctrago token source", "error", err)
+	}
+	if c.swapAccessToken(token.AccessToken) {
+		c.reauthorizeAccounts(ctx, token.AccessToken)
+	}
+	return token.AccessToken, nil
+}
+
+// swapAccessToken 记录正在使用的访问令牌，返回是否发生变化
+func (c *Client) swapAccessToken(accessToken string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.accessToken == accessToken {
+		return false
+	}
+	c.accessToken = accessToken
+	return true
+}
+
+// tokenLoop 定期从 TokenSource 获取令牌，使 RefreshingTokenSource 在无人请求时也能提前刷新
+func (c *Client) tokenLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		switch c.State() {
+		case StateClosed:
+			return
+		case StateAppAuthorized:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if _, err := c.currentAccessToken(ctx); err != nil {
+				c.log().Warn("ctrago token source", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// reauthorizeAccounts 以新的访问令牌重新鉴权已鉴权的账户
+func (c *Client) reauthorizeAccounts(ctx context.Context, accessToken string) {
+	for _, accountId := range c.AuthorizedAccounts() {
+		req := &openapi.ProtoOAAccountAuthReq{
+			CtidTraderAccountId: proto.Int64(accountId),
+			AccessToken:         proto.String(accessToken),
+		}
+		if _, err := c.request(ctx, uint32(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ), req); err != nil {
+			c.log().Warn("ctrago account re-authorization failed", "accountId", accountId, "error", err)
+			continue
+		}
+		c.log().Info("ctrago account re-authorized with refreshed token", "accountId", accountId)
+	}
+}
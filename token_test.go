@@ -0,0 +1,138 @@
+package ctrago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yockii/ctrago/openapi"
+	"google.golang.org/protobuf/proto"
+)
+
+// tokenServer 模拟令牌刷新：每次刷新更换访问令牌，账户鉴权只接受当前令牌
+type tokenServer struct {
+	lock        sync.Mutex
+	accessToken string
+	refreshes   int
+	accountAuth []string
+}
+
+func (s *tokenServer) transport() *notifyingTransport {
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		msg := &openapi.ProtoMessage{}
+		proto.Unmarshal(data, msg)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		switch openapi.ProtoOAPayloadType(msg.GetPayloadType()) {
+		case openapi.ProtoOAPayloadType_PROTO_OA_REFRESH_TOKEN_REQ:
+			s.refreshes++
+			s.accessToken = fmt.Sprintf("access-%d", s.refreshes+1)
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_REFRESH_TOKEN_RES, &openapi.ProtoOARefreshTokenRes{
+				AccessToken:  proto.String(s.accessToken),
+				TokenType:    proto.String("bearer"),
+				ExpiresIn:    proto.Int64(3600 * 24),
+				RefreshToken: proto.String(fmt.Sprintf("refresh-%d", s.refreshes+1)),
+			}, msg.GetClientMsgId())
+		case openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_REQ:
+			req := &openapi.ProtoOAAccountAuthReq{}
+			proto.Unmarshal(msg.Payload, req)
+			s.accountAuth = append(s.accountAuth, req.GetAccessToken())
+			if req.GetAccessToken() != s.accessToken {
+				mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ERROR_RES, &openapi.ProtoOAErrorRes{
+					ErrorCode: proto.String(openapi.ProtoOAErrorCode_CH_ACCESS_TOKEN_INVALID.String()),
+				}, msg.GetClientMsgId())
+				return nil
+			}
+			mock.push(openapi.ProtoOAPayloadType_PROTO_OA_ACCOUNT_AUTH_RES, &openapi.ProtoOAAccountAuthRes{
+				CtidTraderAccountId: req.CtidTraderAccountId,
+			}, msg.GetClientMsgId())
+		}
+		return nil
+	}
+	return mock
+}
+
+func TestRefreshingTokenSource_RefreshesAndReauthorizes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv := &tokenServer{accessToken: "access-1"}
+	client := NewClientWithTransport(srv.transport(), "id", "secret", "")
+	store := NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+	if err := store.SaveToken(&Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(48 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	ts := NewRefreshingTokenSource(store, client.TokenRefresher())
+	client.SetTokenSource(ts)
+
+	if _, err := client.Account(1).Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Account(2).Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if srv.refreshes != 0 {
+		t.Fatalf("token refreshed %d times before expiry", srv.refreshes)
+	}
+
+	// 令牌进入提前刷新窗口：刷新、写回存储并以新令牌重新鉴权两个账户
+	ts.SetLeeway(72 * time.Hour)
+	accessToken, err := client.currentAccessToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "access-2" || srv.refreshes != 1 {
+		t.Fatalf("accessToken = %s after %d refreshes", accessToken, srv.refreshes)
+	}
+	if got := srv.accountAuth[2:]; len(got) != 2 || got[0] != "access-2" || got[1] != "access-2" {
+		t.Fatalf("re-authorization tokens = %v", got)
+	}
+	saved, err := store.LoadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "access-2" || saved.RefreshToken != "refresh-2" || saved.Expiry.IsZero() {
+		t.Fatalf("saved token = %+v", saved)
+	}
+}
+
+func TestRefreshingTokenSource_KeepsValidTokenOnFailure(t *testing.T) {
+	store := NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+	store.SaveToken(&Token{AccessToken: "a", RefreshToken: "r", Expiry: time.Now().Add(time.Minute)})
+	failure := errors.New("offline")
+	ts := NewRefreshingTokenSource(store, func(context.Context, string) (*Token, error) { return nil, failure })
+
+	token, err := ts.Token(context.Background())
+	if !errors.Is(err, failure) || token == nil || token.AccessToken != "a" {
+		t.Fatalf("expected still-valid token with error, got %+v, %v", token, err)
+	}
+
+	store.SaveToken(&Token{AccessToken: "b", RefreshToken: "r", Expiry: time.Now().Add(-time.Minute)})
+	ts = NewRefreshingTokenSource(store, func(context.Context, string) (*Token, error) { return nil, failure })
+	if token, err := ts.Token(context.Background()); token != nil || !errors.Is(err, failure) {
+		t.Fatalf("expected failure for expired token, got %+v, %v", token, err)
+	}
+}
+
+func TestClient_RefreshTokenSwapsAccessToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv := &tokenServer{accessToken: "access-1"}
+	client := NewClientWithTransport(srv.transport(), "id", "secret", "access-1")
+	if _, err := client.Account(1).Auth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RefreshToken(ctx, "refresh-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Account(1).Auth(ctx); err != nil {
+		t.Fatalf("auth after refresh: %v", err)
+	}
+	if got := srv.accountAuth; len(got) != 3 || got[1] != "access-2" || got[2] != "access-2" {
+		t.Fatalf("account auth tokens = %v", got)
+	}
+}
@@ -0,0 +1,247 @@
+package ctrago
+
+import (
+	"container/list"
+	"context"
+
+	"github.com/yockii/ctrago/openapi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const tracerName = "github.com/yockii/ctrago"
+
+// maxTracedOrders 最多同时跟踪的订单/持仓数量，超出时淘汰最早的记录
+const maxTracedOrders = 10000
+
+// 链路追踪的 span 属性
+const (
+	AttrPayloadType   = attribute.Key("ctrago.payload_type")
+	AttrClientMsgId   = attribute.Key("ctrago.client_msg_id")
+	AttrAccountId     = attribute.Key("ctrago.account_id")
+	AttrErrorCode     = attribute.Key("ctrago.error_code")
+	AttrOrderId       = attribute.Key("ctrago.order_id")
+	AttrPositionId    = attribute.Key("ctrago.position_id")
+	AttrExecutionType = attribute.Key("ctrago.execution_type")
+)
+
+// WithTracerProvider 为每个请求创建 span，并将订单后续的 ProtoOAExecutionEvent 关联到下单请求的 span；默认不追踪
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *clientConfig) {
+		c.tracerProvider = provider
+	}
+}
+
+// orderTracer 记录订单、持仓与创建它们的请求 span 的对应关系
+type orderTracer struct {
+	tracer    trace.Tracer
+	enabled   bool
+	orders    *spanIndex
+	positions *spanIndex
+}
+
+// spanIndex 按 id 保存 span，超过 maxTracedOrders 时按加入顺序淘汰最早的记录
+type spanIndex struct {
+	spans map[int64]*list.Element
+	order list.List
+}
+
+type tracedSpan struct {
+	id          int64
+	spanContext trace.SpanContext
+}
+
+func newSpanIndex() *spanIndex {
+	return &spanIndex{spans: make(map[int64]*list.Element)}
+}
+
+func (s *spanIndex) len() int {
+	return len(s.spans)
+}
+
+func (s *spanIndex) get(id int64) (trace.SpanContext, bool) {
+	if e, ok := s.spans[id]; ok {
+		return e.Value.(tracedSpan).spanContext, true
+	}
+	return trace.SpanContext{}, false
+}
+
+// add 记录 id 对应的 span，已存在时保留原记录
+func (s *spanIndex) add(id int64, sc trace.SpanContext) {
+	if _, ok := s.spans[id]; ok {
+		return
+	}
+	s.spans[id] = s.order.PushBack(tracedSpan{id: id, spanContext: sc})
+	if s.order.Len() > maxTracedOrders {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.spans, oldest.Value.(tracedSpan).id)
+	}
+}
+
+func (s *spanIndex) remove(id int64) {
+	if e, ok := s.spans[id]; ok {
+		s.order.Remove(e)
+		delete(s.spans, id)
+	}
+}
+
+func newOrderTracer(provider trace.TracerProvider) *orderTracer {
+	_, isNoop := provider.(noop.TracerProvider)
+	return &orderTracer{
+		tracer:    provider.Tracer(tracerName),
+		enabled:   !isNoop,
+		orders:    newSpanIndex(),
+		positions: newSpanIndex(),
+	}
+}
+
+// SetTracerProvider 设置链路追踪，nil 表示不追踪
+func (c *Client) SetTracerProvider(provider trace.TracerProvider) {
+	if provider == nil {
+		provider = noop.NewTracerProvider()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tracing = newOrderTracer(provider)
+}
+
+func (c *Client) orderTracer() *orderTracer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.tracing
+}
+
+// startRequestSpan 为请求创建 span，未启用追踪时返回 nil
+func (t *orderTracer) startRequestSpan(ctx context.Context, msgId string, payloadType uint32, payload proto.Message) (context.Context, trace.Span) {
+	if !t.enabled {
+		return ctx, nil
+	}
+	name := PayloadTypeName(payloadType)
+	attrs := []attribute.KeyValue{AttrPayloadType.String(name), AttrClientMsgId.String(msgId)}
+	if accountId, ok := accountIdOf(payload); ok {
+		attrs = append(attrs, AttrAccountId.Int64(accountId))
+	}
+	return t.tracer.Start(ctx, "ctrago "+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endRequestSpan 结束请求 span，apiErr 为错误类响应；订单类请求成功时记录订单/持仓与 span 的对应关系
+func (c *Client) endRequestSpan(span trace.Span, resp *openapi.ProtoMessage, err error, apiErr *APIError) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+	if apiErr != nil {
+		span.SetAttributes(AttrErrorCode.String(apiErr.ErrorCode))
+		err = apiErr
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	if resp.GetPayloadType() != uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT) {
+		return
+	}
+	ev := &openapi.ProtoOAExecutionEvent{}
+	if proto.Unmarshal(resp.Payload, ev) != nil {
+		return
+	}
+	span.SetAttributes(executionAttributes(ev)...)
+	if ev.GetErrorCode() != "" {
+		span.SetAttributes(AttrErrorCode.String(ev.GetErrorCode()))
+		span.SetStatus(codes.Error, ev.GetErrorCode())
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tracing.remember(ev, span.SpanContext())
+}
+
+func (t *orderTracer) remember(ev *openapi.ProtoOAExecutionEvent, sc trace.SpanContext) {
+	if orderId := ev.GetOrder().GetOrderId(); orderId != 0 {
+		t.orders.add(orderId, sc)
+	}
+	if positionId := ev.GetPosition().GetPositionId(); positionId != 0 {
+		t.positions.add(positionId, sc)
+	}
+}
+
+// traceExecutionEvent 为推送的 ProtoOAExecutionEvent 创建 span，并链接到创建该订单或持仓的请求 span
+func (c *Client) traceExecutionEvent(msg *openapi.ProtoMessage) {
+	t := c.orderTracer()
+	if !t.enabled || msg.GetPayloadType() != uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT) {
+		return
+	}
+	ev := &openapi.ProtoOAExecutionEvent{}
+	if proto.Unmarshal(msg.Payload, ev) != nil {
+		return
+	}
+	c.lock.Lock()
+	origin, ok := t.orders.get(ev.GetOrder().GetOrderId())
+	if !ok {
+		origin, ok = t.positions.get(ev.GetPosition().GetPositionId())
+	}
+	if isFinalOrderStatus(ev.GetOrder().GetOrderStatus()) {
+		t.orders.remove(ev.GetOrder().GetOrderId())
+	}
+	if ev.GetPosition().GetPositionStatus() == openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED {
+		t.positions.remove(ev.GetPosition().GetPositionId())
+	}
+	c.lock.Unlock()
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(executionAttributes(ev)...),
+	}
+	if ok {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: origin}))
+	}
+	_, span := t.tracer.Start(context.Background(), "ctrago "+PayloadTypeName(msg.GetPayloadType()), opts...)
+	if ev.GetErrorCode() != "" {
+		span.SetAttributes(AttrErrorCode.String(ev.GetErrorCode()))
+		span.SetStatus(codes.Error, ev.GetErrorCode())
+	}
+	span.End()
+}
+
+func executionAttributes(ev *openapi.ProtoOAExecutionEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttrAccountId.Int64(ev.GetCtidTraderAccountId()),
+		AttrExecutionType.String(ev.GetExecutionType().String()),
+	}
+	if orderId := ev.GetOrder().GetOrderId(); orderId != 0 {
+		attrs = append(attrs, AttrOrderId.Int64(orderId))
+	}
+	if positionId := ev.GetPosition().GetPositionId(); positionId != 0 {
+		attrs = append(attrs, AttrPositionId.Int64(positionId))
+	}
+	return attrs
+}
+
+func isFinalOrderStatus(status openapi.ProtoOAOrderStatus) bool {
+	switch status {
+	case openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_EXPIRED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED:
+		return true
+	}
+	return false
+}
+
+// accountIdOf 读取请求中的 ctidTraderAccountId 字段
+func accountIdOf(payload proto.Message) (int64, bool) {
+	if payload == nil {
+		return 0, false
+	}
+	m := payload.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name("ctidTraderAccountId"))
+	if fd == nil || !m.Has(fd) {
+		return 0, false
+	}
+	return m.Get(fd).Int(), true
+}
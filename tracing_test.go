@@ -0,0 +1,97 @@
+package ctrago
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yockii/ctrago/openapi"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracing_LinksExecutionEventsToOrderSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	order := &openapi.ProtoOAOrder{
+		OrderId:     proto.Int64(42),
+		OrderType:   openapi.ProtoOAOrderType_MARKET.Enum(),
+		OrderStatus: openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED.Enum(),
+		TradeData: &openapi.ProtoOATradeData{
+			SymbolId:  proto.Int64(1),
+			Volume:    proto.Int64(100000),
+			TradeSide: openapi.ProtoOATradeSide_BUY.Enum(),
+		},
+	}
+	mock := &notifyingTransport{}
+	mock.sendFn = func(messageType int, data []byte) error {
+		req := &openapi.ProtoMessage{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			t.Fatal(err)
+		}
+		go mock.push(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT, &openapi.ProtoOAExecutionEvent{
+			CtidTraderAccountId: proto.Int64(7),
+			ExecutionType:       openapi.ProtoOAExecutionType_ORDER_ACCEPTED.Enum(),
+			Order:               order,
+		}, req.GetClientMsgId())
+		return nil
+	}
+	client := NewClientWithTransport(mock, "id", "secret", "token")
+	client.SetTracerProvider(provider)
+
+	if _, err := client.Account(7).Order().NewOrder(context.Background(), 1, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOATradeSide_BUY, 100000, nil); err != nil {
+		t.Fatal(err)
+	}
+	filled := proto.Clone(order).(*openapi.ProtoOAOrder)
+	filled.OrderStatus = openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED.Enum()
+	mock.push(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT, &openapi.ProtoOAExecutionEvent{
+		CtidTraderAccountId: proto.Int64(7),
+		ExecutionType:       openapi.ProtoOAExecutionType_ORDER_FILLED.Enum(),
+		Order:               filled,
+	}, "")
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	request, event := spans[0], spans[1]
+	if request.Name() != "ctrago PROTO_OA_NEW_ORDER_REQ" {
+		t.Errorf("unexpected request span name %s", request.Name())
+	}
+	var accountId int64
+	for _, attr := range request.Attributes() {
+		if attr.Key == AttrAccountId {
+			accountId = attr.Value.AsInt64()
+		}
+	}
+	if accountId != 7 {
+		t.Errorf("expected account id attribute 7, got %d", accountId)
+	}
+	if len(event.Links()) != 1 || event.Links()[0].SpanContext.SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("expected execution event span to link to request span")
+	}
+	if client.orderTracer().orders.len() != 0 {
+		t.Errorf("expected filled order to be forgotten")
+	}
+}
+
+func TestSpanIndex_EvictsOldest(t *testing.T) {
+	index := newSpanIndex()
+	for id := int64(1); id <= maxTracedOrders+2; id++ {
+		index.add(id, trace.SpanContext{})
+	}
+	index.remove(5)
+	if index.len() != maxTracedOrders-1 {
+		t.Fatalf("expected %d spans, got %d", maxTracedOrders-1, index.len())
+	}
+	for _, id := range []int64{1, 2, 5} {
+		if _, ok := index.get(id); ok {
+			t.Errorf("span %d should have been removed", id)
+		}
+	}
+	if _, ok := index.get(3); !ok {
+		t.Error("span 3 should be kept")
+	}
+}
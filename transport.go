@@ -0,0 +1,281 @@
+package ctrago
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// wireConn 一条已建立的连接，按消息读写；*websocket.Conn 直接实现，TCP 由 tcpConn 加上长度前缀
+type wireConn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// streamTransport TcpClient 与 WsClient 共用的连接管理：消息循环、断线重连、心跳与连接事件
+type streamTransport struct {
+	lock      sync.Mutex
+	conn      wireConn
+	handlers  []MessageHandler
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	ticker            *time.Ticker
+	heartbeatStop     chan struct{}
+	heartbeatFn       func() (messageType int, data []byte)
+	heartbeatInterval time.Duration
+	listening         bool
+
+	// kind 传输层名称，用于错误信息
+	kind string
+	// open 建立新连接，首次连接与重连均使用
+	open            func(ctx context.Context) (wireConn, error)
+	reconnectPolicy *ReconnectPolicy
+	eventHandlers   []TransportEventHandler
+	logger          *slog.Logger
+}
+
+func (t *streamTransport) init(kind string, open func(ctx context.Context) (wireConn, error)) {
+	t.kind = kind
+	t.open = open
+	t.handlers = make([]MessageHandler, 0)
+	t.closeCh = make(chan struct{})
+	t.reconnectPolicy = DefaultReconnectPolicy()
+	t.logger = discardLogger()
+}
+
+// SetReconnectPolicy 设置断线重连策略，nil 表示使用默认策略
+func (t *streamTransport) SetReconnectPolicy(policy *ReconnectPolicy) {
+	if policy == nil {
+		policy = DefaultReconnectPolicy()
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.reconnectPolicy = policy
+}
+
+// connect 建立连接，ctx 取消或 Close 时中止拨号；拨号期间被 Close 时丢弃新连接并返回 ErrTransportClosed
+func (t *streamTransport) connect(ctx context.Context) error {
+	ctx, cancel := closeContext(ctx, t.closeCh)
+	defer cancel()
+	conn, err := t.open(ctx)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	if t.isClosed() {
+		t.lock.Unlock()
+		conn.Close()
+		return ErrTransportClosed
+	}
+	t.conn = conn
+	t.lock.Unlock()
+	t.log().Info("ctrago transport connected")
+	t.notify(TransportConnected, nil)
+	return nil
+}
+
+func (t *streamTransport) Send(messageType int, data []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn == nil {
+		return fmt.Errorf("%s not connected", t.kind)
+	}
+	return t.conn.WriteMessage(messageType, data)
+}
+
+func (t *streamTransport) OnMessage(handler MessageHandler) {
+	t.handlers = append(t.handlers, handler)
+}
+
+// Listen 启动消息循环，断线后按重连策略重连，直到 Close 或放弃重连
+func (t *streamTransport) Listen() error {
+	return t.ListenContext(context.Background())
+}
+
+// ListenContext 同 Listen，ctx 取消时关闭连接并停止重连
+func (t *streamTransport) ListenContext(ctx context.Context) error {
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				t.Close()
+			case <-t.closeCh:
+			}
+		}()
+	}
+	t.startHeartbeat()
+	var lastErr error
+	for {
+		t.lock.Lock()
+		conn := t.conn
+		policy := t.reconnectPolicy
+		t.lock.Unlock()
+		if conn == nil {
+			// 不允许重连时直接报告关闭，不发出 TransportReconnecting
+			if policy.retries() {
+				t.notify(TransportReconnecting, lastErr)
+			}
+			if err := policy.reconnect(ctx, t.closeCh, lastErr, t.log(), func() error { return t.connect(ctx) }); err != nil {
+				if t.isClosed() {
+					return nil
+				}
+				t.log().Error("ctrago reconnect aborted", "error", err)
+				t.notify(TransportClosed, err)
+				return err
+			}
+			continue
+		}
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				t.lock.Lock()
+				conn.Close()
+				t.conn = nil
+				t.lock.Unlock()
+				if t.isClosed() {
+					return nil
+				}
+				t.log().Warn("ctrago transport disconnected", "error", err)
+				t.notify(TransportDisconnected, err)
+				lastErr = err
+				break // 跳出内层for，重新连接
+			}
+			for _, handler := range t.handlers {
+				handler(messageType, data)
+			}
+		}
+	}
+}
+
+func (t *streamTransport) isClosed() bool {
+	select {
+	case <-t.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *streamTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closeCh)
+		t.lock.Lock()
+		t.restartHeartbeatLocked()
+		if t.conn != nil {
+			err = t.conn.Close()
+		}
+		t.lock.Unlock()
+		t.log().Info("ctrago transport closed")
+		t.notify(TransportClosed, nil)
+	})
+	return err
+}
+
+// OnTransportEvent 注册连接事件回调，已连接时立即回调一次 TransportConnected
+func (t *streamTransport) OnTransportEvent(handler TransportEventHandler) {
+	t.lock.Lock()
+	t.eventHandlers = append(t.eventHandlers, handler)
+	connected := t.conn != nil
+	t.lock.Unlock()
+	if connected {
+		handler(TransportConnected, nil)
+	}
+}
+
+func (t *streamTransport) notify(event TransportEvent, err error) {
+	t.lock.Lock()
+	handlers := append([]TransportEventHandler(nil), t.eventHandlers...)
+	t.lock.Unlock()
+	for _, h := range handlers {
+		h(event, err)
+	}
+}
+
+func (t *streamTransport) log() *slog.Logger {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.logger
+}
+
+func (t *streamTransport) startHeartbeat() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.listening = true
+	t.restartHeartbeatLocked()
+}
+
+// restartHeartbeatLocked 停止当前心跳，Listen 已启动时按最新设置重新开始，调用方需持有 t.lock
+func (t *streamTransport) restartHeartbeatLocked() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		close(t.heartbeatStop)
+		t.ticker, t.heartbeatStop = nil, nil
+	}
+	if !t.listening || t.heartbeatInterval <= 0 || t.heartbeatFn == nil || t.isClosed() {
+		return
+	}
+	ticker := time.NewTicker(t.heartbeatInterval)
+	stop := make(chan struct{})
+	heartbeatFn := t.heartbeatFn
+	t.ticker, t.heartbeatStop = ticker, stop
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				msgType, data := heartbeatFn()
+				if err := t.Send(msgType, data); err != nil {
+					t.log().Debug("ctrago heartbeat send failed", "error", err)
+				}
+			case <-stop:
+				return
+			case <-t.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+// SetHeartbeat 设置心跳，可在 Listen 启动后调用，正在运行的心跳按新设置重新开始；interval 不大于 0 时停止心跳
+func (t *streamTransport) SetHeartbeat(heartbeatInterval time.Duration, heartbeatFn func() (int, []byte)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.heartbeatInterval = heartbeatInterval
+	t.heartbeatFn = heartbeatFn
+	t.restartHeartbeatLocked()
+}
+
+// TransportWrapper 包装另一个传输层的中间层（如 RecordingTransport、paper.Transport）嵌入它，
+// 把 OnTransportEvent、SetReconnectPolicy、SetLogger 转发给被包装的传输层
+type TransportWrapper struct {
+	Transport
+}
+
+// OnTransportEvent 转发被包装传输层的连接事件；被包装的传输层不支持时视为已连接
+func (w TransportWrapper) OnTransportEvent(handler TransportEventHandler) {
+	if notifier, ok := w.Transport.(TransportNotifier); ok {
+		notifier.OnTransportEvent(handler)
+		return
+	}
+	handler(TransportConnected, nil)
+}
+
+// SetReconnectPolicy 转发给被包装的传输层
+func (w TransportWrapper) SetReconnectPolicy(policy *ReconnectPolicy) {
+	if r, ok := w.Transport.(interface{ SetReconnectPolicy(*ReconnectPolicy) }); ok {
+		r.SetReconnectPolicy(policy)
+	}
+}
+
+// SetLogger 转发给被包装的传输层
+func (w TransportWrapper) SetLogger(logger *slog.Logger) {
+	if l, ok := w.Transport.(interface{ SetLogger(*slog.Logger) }); ok {
+		l.SetLogger(logger)
+	}
+}
+
+var _ TransportNotifier = TransportWrapper{}
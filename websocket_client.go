@@ -1,8 +1,8 @@
 package ctrago
 
 import (
+	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,17 +11,10 @@ import (
 type MessageHandler func(messageType int, data []byte)
 
 type WsClient struct {
-	conn     *websocket.Conn
-	lock     sync.Mutex
-	handlers []MessageHandler
+	streamTransport
 
-	ticker            *time.Ticker
-	heartbeatFn       func() (messageType int, data []byte)
-	heartbeatInterval time.Duration
-	reconnect         bool
-	url               string
-	dialer            *websocket.Dialer
-	closeCh           chan struct{}
+	url    string
+	dialer *websocket.Dialer
 }
 
 // NewWsClientWithHeartbeat 支持心跳和重连的构造方法
@@ -30,114 +23,39 @@ func NewWsClientWithHeartbeat(url string, dialer *websocket.Dialer, heartbeatInt
 		dialer = websocket.DefaultDialer
 	}
 	client := &WsClient{
-		url:               url,
-		dialer:            dialer,
-		heartbeatInterval: heartbeatInterval,
-		heartbeatFn:       heartbeatFn,
-		handlers:          make([]MessageHandler, 0),
-		reconnect:         true,
-		closeCh:           make(chan struct{}),
+		url:    url,
+		dialer: dialer,
 	}
-	if err := client.connect(); err != nil {
+	client.init("websocket", client.openConn)
+	client.heartbeatInterval = heartbeatInterval
+	client.heartbeatFn = heartbeatFn
+	if err := client.connect(context.Background()); err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 
+// NewWsClient 使用已建立的连接创建 WsClient，没有 url 因此断线后不重连
 func NewWsClient(conn *websocket.Conn) *WsClient {
-	return &WsClient{
-		conn:     conn,
-		handlers: make([]MessageHandler, 0),
+	client := &WsClient{}
+	client.init("websocket", client.openConn)
+	if conn != nil {
+		client.conn = conn
 	}
+	client.reconnectPolicy = NoReconnect()
+	return client
 }
 
-func (c *WsClient) connect() error {
-	conn, _, err := c.dialer.Dial(c.url, nil)
-	if err != nil {
-		return err
-	}
-	c.lock.Lock()
-	c.conn = conn
-	c.lock.Unlock()
-	return nil
-}
-
-func (c *WsClient) Send(messageType int, data []byte) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	if c.conn == nil {
-		return fmt.Errorf("websocket not connected")
-	}
-	return c.conn.WriteMessage(messageType, data)
-}
-
-func (c *WsClient) OnMessage(handler MessageHandler) {
-	c.handlers = append(c.handlers, handler)
-}
-
-func (c *WsClient) Listen() error {
-	for {
-		if c.conn == nil {
-			if err := c.connect(); err != nil {
-				time.Sleep(2 * time.Second)
-				continue
-			}
-		}
-		if c.heartbeatInterval > 0 && c.heartbeatFn != nil {
-			if c.ticker == nil {
-				c.ticker = time.NewTicker(c.heartbeatInterval)
-				go func() {
-					for {
-						select {
-						case <-c.ticker.C:
-							msgType, data := c.heartbeatFn()
-							c.Send(msgType, data)
-						case <-c.closeCh:
-							return
-						}
-					}
-				}()
-			}
-		}
-		for {
-			messageType, data, err := c.conn.ReadMessage()
-			if err != nil {
-				c.lock.Lock()
-				c.conn.Close()
-				c.conn = nil
-				c.lock.Unlock()
-				if c.reconnect {
-					time.Sleep(2 * time.Second)
-					break // 跳出内层for，重新连接
-				}
-				return err
-			}
-			for _, handler := range c.handlers {
-				handler(messageType, data)
-			}
-		}
-	}
-}
-
-func (c *WsClient) Close() error {
-	close(c.closeCh)
-	if c.ticker != nil {
-		c.ticker.Stop()
+func (c *WsClient) openConn(ctx context.Context) (wireConn, error) {
+	if c.url == "" {
+		return nil, fmt.Errorf("websocket url is empty")
 	}
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	if c.conn != nil {
-		return c.conn.Close()
+	conn, _, err := c.dialer.DialContext(ctx, c.url, nil)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return conn, nil
 }
 
-// WsClient的心跳由Client自动封装时，允许动态设置心跳内容
-func (c *WsClient) SetHeartbeat(heartbeatInterval time.Duration, heartbeatFn func() (int, []byte)) {
-	c.heartbeatInterval = heartbeatInterval
-	c.heartbeatFn = heartbeatFn
-	if c.ticker != nil {
-		c.ticker.Stop()
-		c.ticker = nil
-	}
-}
+var _ Transport = (*WsClient)(nil)
+var _ TransportNotifier = (*WsClient)(nil)